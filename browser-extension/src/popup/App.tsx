@@ -12,6 +12,7 @@ import { Activity, Pause } from "lucide-react";
 
 export default function App() {
   const [paused, setPaused] = useState(false);
+  const [authToken, setAuthToken] = useState("");
 
   useEffect(() => {
     async function init() {
@@ -19,8 +20,10 @@ export default function App() {
         if (!chrome?.storage?.local) {
           throw new Error("Chrome storage API not available");
         }
-        const { paused = false } = await chrome.storage.local.get("paused");
+        const { paused = false, authToken = "" } =
+          await chrome.storage.local.get(["paused", "authToken"]);
         setPaused(paused);
+        setAuthToken(authToken);
       } catch (error) {
         console.error("Failed to initialize popup:", error);
         setPaused(false);
@@ -42,6 +45,20 @@ export default function App() {
     }
   };
 
+  // The daemon writes the token to the auth-token file next to events.db
+  const handleTokenChange = async (value: string) => {
+    const next = value.trim();
+    setAuthToken(next);
+    try {
+      if (!chrome?.storage?.local) {
+        throw new Error("Chrome storage API not available");
+      }
+      await chrome.storage.local.set({ authToken: next });
+    } catch (error) {
+      console.error("Failed to save API token:", error);
+    }
+  };
+
   return (
     <div className="w-[500px] min-h-[350px] flex items-center justify-center p-4">
       <Card className="w-full">
@@ -93,6 +110,24 @@ export default function App() {
               />
             </div>
           </div>
+          <div className="space-y-2 pt-8">
+            <label htmlFor="auth-token" className="text-xl font-medium block">
+              API Token
+            </label>
+            <input
+              id="auth-token"
+              type="password"
+              value={authToken}
+              onChange={(e) => handleTokenChange(e.target.value)}
+              placeholder="Paste the contents of the auth-token file"
+              className="w-full rounded-md border px-3 py-2 text-lg"
+            />
+            <p className="text-lg text-muted-foreground">
+              {authToken
+                ? "Events are sent with this token"
+                : "Events are not sent until a token is set"}
+            </p>
+          </div>
         </CardContent>
       </Card>
     </div>
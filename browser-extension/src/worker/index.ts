@@ -6,6 +6,23 @@ console.log("BrowseTrace background script loaded");
 // Track the last active tab for tab switch detection
 let lastActiveTabId: number | null = null;
 
+// Events as sent to the daemon: the tab and window they came from are added
+// here, since content scripts cannot see them. The daemon records them on the
+// event's session.
+type ForwardedEvent = EventPayload & { tab_id?: number; window_id?: number };
+
+/**
+ * Attach the sender tab's ids to events coming from a content script
+ */
+function withTab(
+  events: EventPayload[],
+  tab: chrome.tabs.Tab | undefined,
+): ForwardedEvent[] {
+  if (tab?.id === undefined) return events;
+  const { id, windowId } = tab;
+  return events.map((event) => ({ ...event, tab_id: id, window_id: windowId }));
+}
+
 // Change this to your local collector (port/path can be anything you run)
 const BASE_URL = "http://127.0.0.1:8123";
 const ENDPOINT = `${BASE_URL}/events`;
@@ -28,13 +45,14 @@ async function checkHealth(): Promise<boolean> {
   }
 
   try {
-    await fetch(HEALTH_ENDPOINT, {
+    const response = await fetch(HEALTH_ENDPOINT, {
       method: "GET",
-      mode: "no-cors",
       signal: AbortSignal.timeout(5000), // 5 second timeout
     });
+    if (!response.ok) {
+      throw new Error(`status ${response.status}`);
+    }
 
-    // With no-cors mode, we can't read the response, but if fetch succeeds, assume healthy
     isHealthy = true;
     lastHealthCheck = now;
     console.log("Health check passed");
@@ -48,12 +66,18 @@ async function checkHealth(): Promise<boolean> {
 
 /**
  * Forward events to your local daemon.
- * - Uses mode: "no-cors" so you don't need to set up CORS on the daemon.
- * - We omit Content-Type so the browser will send an opaque request; the body
- *   is still the JSON string (server should parse it as JSON even if header is missing).
+ * - The daemon requires the API token from its auth-token file, which the
+ *   user pastes into the popup; it is kept in chrome.storage.local.
+ * - The daemon allows this extension's origin through CORS.
  */
-async function sendToLocalhost(payload: { events: EventPayload[] }) {
+async function sendToLocalhost(payload: { events: ForwardedEvent[] }) {
   try {
+    const { authToken = "" } = await chrome.storage.local.get("authToken");
+    if (!authToken) {
+      console.log("No API token set in the popup, skipping event send");
+      return;
+    }
+
     // Check health before sending events
     const healthy = await checkHealth();
     if (!healthy) {
@@ -62,14 +86,21 @@ async function sendToLocalhost(payload: { events: EventPayload[] }) {
     }
 
     console.log(payload);
-    await fetch(ENDPOINT, {
+    const response = await fetch(ENDPOINT, {
       method: "POST",
-      mode: "no-cors",
-      // Don't set non-simple headers with no-cors; just send the JSON string.
+      headers: {
+        Authorization: `Bearer ${authToken}`,
+        "Content-Type": "application/json",
+      },
       body: JSON.stringify(payload),
       // keepalive is ignored in SW, but harmless:
       keepalive: true,
     });
+    if (response.status === 401) {
+      console.log("Server refused the API token; update it in the popup");
+    } else if (!response.ok) {
+      console.log(`Server rejected events: ${response.status}`);
+    }
   } catch (e) {
     console.log(`Failed to send to Local host due to ${e}`);
   }
@@ -82,10 +113,11 @@ chrome.runtime.onConnect.addListener((port) => {
   port.onMessage.addListener((msg) => {
     // Expect either { type: "event", event: {...} } or
     // { type: "batch", events: [...] } — send as-is with a minimal wrapper.
+    const tab = port.sender?.tab;
     if (msg?.type === "batch" && Array.isArray(msg.events)) {
-      sendToLocalhost({ events: msg.events });
+      sendToLocalhost({ events: withTab(msg.events, tab) });
     } else if (msg?.type === "event" && msg.event) {
-      sendToLocalhost({ events: [msg.event] });
+      sendToLocalhost({ events: withTab([msg.event], tab) });
     }
   });
 });
@@ -139,7 +171,9 @@ chrome.tabs.onActivated.addListener(async (activeInfo) => {
 
     // Create a navigation event for the tab switch
     const now = Date.now();
-    const event: EventPayload = {
+    // "tab-switch" marks the event rather than naming a session; the daemon
+    // records no session for it
+    const event: ForwardedEvent = {
       ts_utc: now,
       ts_iso: new Date(now).toISOString(),
       url: toTab.url,
@@ -150,6 +184,8 @@ chrome.tabs.onActivated.addListener(async (activeInfo) => {
         to: toTab.url,
       } as NavigateEventData,
       session_id: "tab-switch",
+      tab_id: toTabId,
+      window_id: toTab.windowId,
     };
 
     // Send the tab switch event
@@ -1,4 +1,6 @@
-import { app, BrowserWindow } from 'electron';
+import { app, BrowserWindow, ipcMain } from 'electron';
+import { readFile } from 'node:fs/promises';
+import os from 'node:os';
 import path from 'node:path';
 import started from 'electron-squirrel-startup';
 
@@ -7,6 +9,35 @@ if (started) {
   app.quit();
 }
 
+/**
+ * The file the Go server writes its API token to, next to events.db in its
+ * application directory, unless BROWSETRACE_TOKEN_FILE points elsewhere
+ */
+function tokenFilePath(): string {
+  if (process.env.BROWSETRACE_TOKEN_FILE) {
+    return process.env.BROWSETRACE_TOKEN_FILE;
+  }
+  const home = os.homedir();
+  switch (process.platform) {
+    case 'darwin':
+      return path.join(home, 'Library', 'Application Support', 'BrowserTrace', 'auth-token');
+    case 'win32':
+      return path.join(home, 'AppData', 'Roaming', 'BrowserTrace', 'auth-token');
+    default:
+      return path.join(home, '.local', 'share', 'BrowserTrace', 'auth-token');
+  }
+}
+
+// The renderer asks for the token on every request rather than once, since
+// the server creates it on its first run, possibly after the app started
+ipcMain.handle('auth-token', async () => {
+  try {
+    return (await readFile(tokenFilePath(), 'utf8')).trim();
+  } catch {
+    return null;
+  }
+});
+
 const createWindow = () => {
   // Create the browser window.
   const mainWindow = new BrowserWindow({
@@ -2,6 +2,18 @@ import type { EventBatch, EventFilter } from '../types/events';
 
 const API_BASE_URL = 'http://127.0.0.1:8123';
 
+/**
+ * Headers for authenticated requests, with the token the server wrote to its
+ * auth-token file
+ */
+async function authHeaders(): Promise<HeadersInit> {
+  const token = await window.browsetrace.getAuthToken();
+  if (!token) {
+    throw new Error('No API token found; start the BrowseTrace server once to create it');
+  }
+  return { Authorization: `Bearer ${token}` };
+}
+
 export async function getEvents(filter: EventFilter = {}): Promise<EventBatch> {
   const params = new URLSearchParams();
 
@@ -21,7 +33,7 @@ export async function getEvents(filter: EventFilter = {}): Promise<EventBatch> {
   const queryString = params.toString();
   const url = queryString ? `${API_BASE_URL}/events?${queryString}` : `${API_BASE_URL}/events`;
 
-  const response = await fetch(url);
+  const response = await fetch(url, { headers: await authHeaders() });
 
   if (!response.ok) {
     throw new Error(`Failed to fetch events: ${response.statusText}`);
@@ -45,8 +57,9 @@ export interface DeleteResponse {
 }
 
 export async function deleteAllEvents(): Promise<DeleteResponse> {
-  const response = await fetch(`${API_BASE_URL}/events`, {
+  const response = await fetch(`${API_BASE_URL}/events?all=true`, {
     method: 'DELETE',
+    headers: await authHeaders(),
   });
 
   if (!response.ok) {
@@ -0,0 +1,6 @@
+// Exposed by src/preload/preload.ts
+interface Window {
+  browsetrace: {
+    getAuthToken: () => Promise<string | null>;
+  };
+}
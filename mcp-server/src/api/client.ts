@@ -1,7 +1,29 @@
+import { readFile } from 'node:fs/promises';
+import os from 'node:os';
+import path from 'node:path';
 import type { Event, EventBatch, EventFilter } from '../types/events.js';
 
 const API_BASE_URL = process.env.API_BASE_URL || 'http://127.0.0.1:8123';
 
+/**
+ * The file the Go server writes its API token to, next to events.db in its
+ * application directory, unless BROWSETRACE_TOKEN_FILE points elsewhere
+ */
+function tokenFilePath(): string {
+  if (process.env.BROWSETRACE_TOKEN_FILE) {
+    return process.env.BROWSETRACE_TOKEN_FILE;
+  }
+  const home = os.homedir();
+  switch (process.platform) {
+    case 'darwin':
+      return path.join(home, 'Library', 'Application Support', 'BrowserTrace', 'auth-token');
+    case 'win32':
+      return path.join(home, 'AppData', 'Roaming', 'BrowserTrace', 'auth-token');
+    default:
+      return path.join(home, '.local', 'share', 'BrowserTrace', 'auth-token');
+  }
+}
+
 export class BrowseTraceAPI {
   private baseUrl: string;
 
@@ -9,6 +31,20 @@ export class BrowseTraceAPI {
     this.baseUrl = baseUrl;
   }
 
+  /**
+   * Headers for authenticated requests. The token file is read on every
+   * request, since the server creates it on its first run.
+   */
+  private async authHeaders(): Promise<Record<string, string>> {
+    let token: string;
+    try {
+      token = (await readFile(tokenFilePath(), 'utf8')).trim();
+    } catch (error) {
+      throw new Error(`Failed to read the BrowseTrace API token: ${error}`);
+    }
+    return { Authorization: `Bearer ${token}` };
+  }
+
   /**
    * Fetch events from the BrowseTrace server
    */
@@ -31,7 +67,7 @@ export class BrowseTraceAPI {
     const queryString = params.toString();
     const url = queryString ? `${this.baseUrl}/events?${queryString}` : `${this.baseUrl}/events`;
 
-    const response = await fetch(url);
+    const response = await fetch(url, { headers: await this.authHeaders() });
 
     if (!response.ok) {
       throw new Error(`Failed to fetch events: ${response.statusText}`);
@@ -57,8 +93,9 @@ export class BrowseTraceAPI {
    * Delete all events
    */
   async deleteAllEvents(): Promise<{ deleted_count: number; message: string }> {
-    const response = await fetch(`${this.baseUrl}/events`, {
+    const response = await fetch(`${this.baseUrl}/events?all=true`, {
       method: 'DELETE',
+      headers: await this.authHeaders(),
     });
 
     if (!response.ok) {
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/vincentbai/browsetrace-server/internal/config"
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/encryption"
+)
+
+// encryptionBatchSize is the number of events re-encrypted per transaction
+const encryptionBatchSize = 500
+
+// loadKey reads a key from the environment, trying <prefix>_KEY (base64),
+// <prefix>_KEY_FILE and <prefix>_PASSPHRASE in that order. A passphrase of
+// "-" is prompted for on the terminal. Returns nil if none is set.
+func loadKey(db *database.Database, prefix string) (*encryption.Key, error) {
+	if encoded := os.Getenv(prefix + "_KEY"); encoded != "" {
+		return encryption.ParseKey(encoded)
+	}
+	if path := os.Getenv(prefix + "_KEY_FILE"); path != "" {
+		return encryption.ReadKeyFile(path)
+	}
+
+	passphrase := os.Getenv(prefix + "_PASSPHRASE")
+	if passphrase == "" {
+		return nil, nil
+	}
+	if passphrase == "-" {
+		var err error
+		passphrase, err = encryption.PromptPassphrase(fmt.Sprintf("Passphrase (%s): ", prefix))
+		if err != nil {
+			return nil, err
+		}
+	}
+	salt, err := db.EncryptionSalt()
+	if err != nil {
+		return nil, err
+	}
+	return encryption.DeriveKey(passphrase, salt)
+}
+
+// setupEncryption enables encryption at rest when a key is configured and
+// encrypts any events stored in plaintext before the server starts. It refuses
+// to continue without a key once the database has been encrypted.
+func setupEncryption(db *database.Database) {
+	key, err := loadKey(db, "BROWSETRACE_ENCRYPTION")
+	if err != nil {
+		log.Fatal("Invalid encryption key: ", err)
+	}
+
+	if key == nil {
+		keyID, err := db.EncryptionKeyID()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if keyID != "" {
+			log.Fatal("Database is encrypted: set BROWSETRACE_ENCRYPTION_KEY, BROWSETRACE_ENCRYPTION_KEY_FILE or BROWSETRACE_ENCRYPTION_PASSPHRASE")
+		}
+		return
+	}
+
+	if err := db.EnableEncryption(key); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Encryption at rest enabled (key %s)", key.ID())
+
+	encrypted, err := db.EncryptEvents(encryptionBatchSize)
+	if err != nil {
+		log.Fatal("Failed to encrypt stored events: ", err)
+	}
+	if encrypted > 0 {
+		log.Printf("Encrypted %d stored events, running VACUUM to remove plaintext copies...", encrypted)
+		if err := db.VacuumDatabase(); err != nil {
+			log.Printf("Warning: VACUUM failed: %v", err)
+		}
+	}
+}
+
+// unlockEncryption makes an encrypted database readable, and has new events
+// encrypted, for commands other than serve. It writes nothing: stored
+// plaintext is left for serve to encrypt, and a key set for a database that
+// isn't encrypted yet is ignored until then.
+func unlockEncryption(db *database.Database) {
+	keyID, err := db.EncryptionKeyID()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keyID == "" {
+		return
+	}
+
+	key, err := loadKey(db, "BROWSETRACE_ENCRYPTION")
+	if err != nil {
+		log.Fatal("Invalid encryption key: ", err)
+	}
+	if key == nil {
+		log.Fatal("Database is encrypted: set BROWSETRACE_ENCRYPTION_KEY, BROWSETRACE_ENCRYPTION_KEY_FILE or BROWSETRACE_ENCRYPTION_PASSPHRASE")
+	}
+	if err := db.UnlockEncryption(key); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generateKey prints a new random key for BROWSETRACE_ENCRYPTION_KEY
+func generateKey(args []string) {
+	flags := flag.NewFlagSet("generate-key", flag.ExitOnError)
+	flags.Parse(args)
+	fmt.Println(encryption.GenerateKey())
+}
+
+// rotateKey re-encrypts the database from the current key to the key in
+// BROWSETRACE_NEW_ENCRYPTION_KEY, _KEY_FILE or _PASSPHRASE
+func rotateKey(args []string) {
+	flags := flag.NewFlagSet("rotate-key", flag.ExitOnError)
+	config.AddDatabaseFlags(flags)
+	flags.Parse(args)
+
+	db := openDatabase(loadConfig(flags), false)
+	defer db.Close()
+
+	currentKey, err := loadKey(db, "BROWSETRACE_ENCRYPTION")
+	if err != nil {
+		log.Fatal("Invalid encryption key: ", err)
+	}
+	if currentKey == nil {
+		log.Fatal("Set the current key in BROWSETRACE_ENCRYPTION_KEY, BROWSETRACE_ENCRYPTION_KEY_FILE or BROWSETRACE_ENCRYPTION_PASSPHRASE")
+	}
+	newKey, err := loadKey(db, "BROWSETRACE_NEW_ENCRYPTION")
+	if err != nil {
+		log.Fatal("Invalid new encryption key: ", err)
+	}
+	if newKey == nil {
+		log.Fatal("Set the new key in BROWSETRACE_NEW_ENCRYPTION_KEY, BROWSETRACE_NEW_ENCRYPTION_KEY_FILE or BROWSETRACE_NEW_ENCRYPTION_PASSPHRASE")
+	}
+
+	if err := db.EnableEncryption(currentKey); err != nil {
+		log.Fatal(err)
+	}
+
+	// Events still in plaintext are encrypted along the way
+	count, err := db.RotateKey(newKey, encryptionBatchSize)
+	if err != nil {
+		log.Fatalf("Key rotation stopped after %d events: %v (run rotate-key again with both keys to finish)", count, err)
+	}
+	log.Printf("Re-encrypted %d events from key %s to key %s", count, currentKey.ID(), newKey.ID())
+
+	if err := db.VacuumDatabase(); err != nil {
+		log.Printf("Warning: VACUUM failed: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/vincentbai/browsetrace-server/internal/config"
+	"github.com/vincentbai/browsetrace-server/internal/export"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+	"github.com/vincentbai/browsetrace-server/internal/server"
+)
+
+// exportEvents writes the events matching the flags to a file or stdout,
+// oldest first, without loading them all into memory
+func exportEvents(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", "ndjson", "ndjson, csv or parquet")
+	output := flags.String("o", "", "output file (default stdout)")
+	filterFromFlags := addFilterFlags(flags)
+	config.AddDatabaseFlags(flags)
+	flags.Parse(args)
+	cfg := loadConfig(flags)
+
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := openDatabase(cfg, true)
+	defer db.Close()
+	unlockEncryption(db)
+
+	file := os.Stdout
+	if *output != "" {
+		if file, err = os.Create(*output); err != nil {
+			log.Fatal("Failed to create export file: ", err)
+		}
+	}
+	buffered := bufio.NewWriter(file)
+
+	exported := 0
+	encoder, err := export.NewEncoder(format, buffered)
+	if err == nil {
+		err = db.EachEvent(filterFromFlags(), func(event models.Event) error {
+			exported++
+			return encoder.Encode(event)
+		})
+	}
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil && file != os.Stdout {
+		err = file.Close()
+	}
+	if err != nil {
+		log.Fatal("Export failed: ", err)
+	}
+	log.Printf("Exported %d events", exported)
+}
+
+// importEvents loads an NDJSON export from a file or stdin through the same
+// validation, domain rules and redaction as the server, and prints the summary
+func importEvents(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		log.Println("Usage: browsetrace-agent import [file] (default stdin)")
+		flags.PrintDefaults()
+	}
+	config.AddFlags(flags)
+	flags.Parse(args)
+	cfg := loadConfig(flags)
+
+	var input io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatal("Failed to open import file: ", err)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	db := openDatabase(cfg, false)
+	defer db.Close()
+	unlockEncryption(db)
+
+	srv := server.NewServer(db, cfg.Address)
+	configureIngestion(srv, cfg)
+	if err := srv.ReloadDomainRules(); err != nil {
+		log.Fatal("Failed to load domain rules: ", err)
+	}
+
+	summary, err := srv.Import(bufio.NewReader(input))
+	if err != nil {
+		log.Fatalf("Import stopped after storing %d events: %v (run it again to resume)", summary.Imported+summary.Upserted, err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(summary); err != nil {
+		log.Fatal(err)
+	}
+}
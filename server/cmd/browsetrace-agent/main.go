@@ -1,52 +1,159 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 
+	"github.com/vincentbai/browsetrace-server/internal/config"
 	"github.com/vincentbai/browsetrace-server/internal/database"
-	"github.com/vincentbai/browsetrace-server/internal/server"
 )
 
+// command is a browsetrace-agent subcommand; run gets the arguments after its name
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
+
+// commands all work on the same events.db through the database package, so
+// history can be inspected and maintained without the server running
+var commands = []command{
+	{"serve", "run the HTTP server (the default without a command)", serve},
+	{"query", "print events matching filters, newest first", queryEvents},
+	{"stats", "print aggregated metrics", printStats},
+	{"export", "write events as NDJSON, CSV or Parquet", exportEvents},
+	{"import", "load an NDJSON export, skipping events already stored", importEvents},
+	{"prune", "apply a retention policy once", pruneEvents},
+	{"vacuum", "reclaim free space in the database file", vacuum},
+	{"backup", "write a consistent copy of the database", backupDatabase},
+	{"restore", "replace the database with a verified backup", restoreDatabase},
+	{"migrate", "bring the database schema up to date", migrateSchema},
+	{"doctor", "check the database and configuration for problems", doctor},
+	{"config", "print the effective configuration (config show)", showConfig},
+	{"generate-key", "print a new random encryption key", generateKey},
+	{"rotate-key", "re-encrypt the database with a new key", rotateKey},
+}
+
 func main() {
-	// app data dir: platform-specific
+	// Without a command the agent runs the server
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			c.run(args)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+func usage(out *os.File) {
+	fmt.Fprintln(out, "Usage: browsetrace-agent [command] [flags]")
+	fmt.Fprintln(out, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-13s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(out, "\nRun browsetrace-agent <command> -h for the flags of a command.")
+}
+
+// applicationDirectory returns the platform-specific data directory holding
+// config.toml and, unless database.path moves them, events.db and the auth
+// token, creating it if needed
+func applicationDirectory() string {
 	homeDirectory, err := os.UserHomeDir()
 	if err != nil {
 		log.Fatal("Failed to get user home directory:", err)
 	}
 
-	var applicationDirectory string
+	var directory string
 	switch runtime.GOOS {
 	case "darwin":
-		applicationDirectory = filepath.Join(homeDirectory, "Library", "Application Support", "BrowserTrace")
+		directory = filepath.Join(homeDirectory, "Library", "Application Support", "BrowserTrace")
 	case "windows":
-		applicationDirectory = filepath.Join(homeDirectory, "AppData", "Roaming", "BrowserTrace")
+		directory = filepath.Join(homeDirectory, "AppData", "Roaming", "BrowserTrace")
 	default: // linux and others
-		applicationDirectory = filepath.Join(homeDirectory, ".local", "share", "BrowserTrace")
+		directory = filepath.Join(homeDirectory, ".local", "share", "BrowserTrace")
 	}
-	if err := os.MkdirAll(applicationDirectory, 0o755); err != nil {
+	if err := os.MkdirAll(directory, 0o755); err != nil {
 		log.Fatal("Failed to create application directory:", err)
 	}
-	databasePath := filepath.Join(applicationDirectory, "events.db")
+	return directory
+}
 
-	// Initialize database
-	db, err := database.NewDatabase(databasePath)
+// loadConfig layers the config file, the environment and the flags, which
+// must have been registered with config.AddFlags or AddDatabaseFlags and parsed
+func loadConfig(flags *flag.FlagSet) *config.Config {
+	cfg, err := config.Load(applicationDirectory(), flags)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
+	return cfg
+}
 
-	// Get server address from environment or use default
-	serverAddress := os.Getenv("BROWSETRACE_ADDRESS")
-	if serverAddress == "" {
-		serverAddress = "127.0.0.1:8123"
+// migrateDatabase opens the configured database and runs pending
+// migrations, which only serve and migrate do
+func migrateDatabase(cfg *config.Config) *database.Database {
+	db, err := database.NewDatabase(cfg.DatabasePath)
+	if err != nil {
+		log.Fatal(err)
 	}
+	applySettings(db, cfg)
+	return db
+}
+
+// openDatabase opens the configured database, whose schema must be up to
+// date. Commands that only inspect it open it read-only and leave the file
+// untouched; the others apply the settings like the server does.
+func openDatabase(cfg *config.Config, readOnly bool) *database.Database {
+	db, err := database.OpenDatabase(cfg.DatabasePath, readOnly)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !readOnly {
+		applySettings(db, cfg)
+	}
+	return db
+}
+
+// applySettings makes page URLs follow pages.keep_fragments and time reports
+// reports.idle_threshold
+func applySettings(db *database.Database, cfg *config.Config) {
+	if err := db.SetPageFragments(cfg.PageFragments); err != nil {
+		log.Fatal(err)
+	}
+	if err := db.SetIdleThreshold(cfg.IdleThreshold); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// showConfig prints the effective configuration with the source of each setting
+func showConfig(args []string) {
+	if len(args) == 0 || args[0] != "show" {
+		log.Fatal("Usage: browsetrace-agent config show [flags]")
+	}
+	flags := flag.NewFlagSet("config show", flag.ExitOnError)
+	config.AddFlags(flags)
+	flags.Parse(args[1:])
 
-	// Initialize and start server
-	srv := server.NewServer(db, serverAddress)
-	if err := srv.Start(); err != nil {
+	cfg := loadConfig(flags)
+	if cfg.File != "" {
+		fmt.Printf("# read from %s\n", cfg.File)
+	}
+	if err := cfg.Show(os.Stdout); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/backup"
+	"github.com/vincentbai/browsetrace-server/internal/config"
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/redaction"
+	"github.com/vincentbai/browsetrace-server/internal/retention"
+	"github.com/vincentbai/browsetrace-server/internal/server"
+	"github.com/vincentbai/browsetrace-server/internal/urlnorm"
+)
+
+// pruneEvents applies a retention policy once, by default the one the server
+// runs with
+func pruneEvents(args []string) {
+	flags := flag.NewFlagSet("prune", flag.ExitOnError)
+	spec := flags.String("policy", "",
+		`retention policy, e.g. "visible_text:max_age=7d;max_db_size=500MB" (default retention.policy)`)
+	config.AddDatabaseFlags(flags)
+	flags.Parse(args)
+	cfg := loadConfig(flags)
+
+	if *spec == "" {
+		*spec = cfg.Retention
+	}
+	policy, err := retention.ParsePolicy(*spec)
+	if err != nil {
+		log.Fatal("Invalid retention policy: ", err)
+	}
+	if policy.Empty() {
+		log.Fatal("No retention policy: pass -policy or configure retention.policy")
+	}
+
+	db := openDatabase(cfg, false)
+	defer db.Close()
+
+	pruned, err := retention.NewPruner(db, policy).PruneOnce()
+	rules := make([]string, 0, len(pruned))
+	var total int64
+	for rule, count := range pruned {
+		rules = append(rules, rule)
+		total += count
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		fmt.Printf("%s\t%d\n", rule, pruned[rule])
+	}
+	if err != nil {
+		log.Fatal("Prune failed: ", err)
+	}
+	log.Printf("Pruned %d events with policy %s", total, policy)
+}
+
+// vacuum rewrites the database file without its free pages
+func vacuum(args []string) {
+	flags := flag.NewFlagSet("vacuum", flag.ExitOnError)
+	config.AddDatabaseFlags(flags)
+	flags.Parse(args)
+
+	db := openDatabase(loadConfig(flags), false)
+	defer db.Close()
+
+	before, err := db.DatabaseSize()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := db.VacuumDatabase(); err != nil {
+		log.Fatal(err)
+	}
+	after, err := db.DatabaseSize()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Vacuumed database from %d to %d bytes", before, after)
+}
+
+// backupDatabase writes a consistent copy of the database, safe to take while the
+// server is writing to it. Without a file it writes to the backup directory
+// and deletes the oldest backups beyond backup.keep.
+func backupDatabase(args []string) {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.Usage = func() {
+		log.Println("Usage: browsetrace-agent backup [file] (default a new file in backup.directory)")
+		flags.PrintDefaults()
+	}
+	list := flags.Bool("list", false, "list the backups in the backup directory instead, newest first")
+	config.AddFlags(flags)
+	flags.Parse(args)
+	cfg := loadConfig(flags)
+
+	if *list {
+		backups, err := backup.NewManager(nil, cfg.BackupDirectory, cfg.BackupKeep).List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, b := range backups {
+			fmt.Fprintf(table, "%s\t%s\t%d bytes\n", b.Name, time.UnixMilli(b.CreatedUTC).Local().Format("2006-01-02 15:04:05"), b.SizeBytes)
+		}
+		table.Flush()
+		return
+	}
+
+	db := openDatabase(cfg, false)
+	defer db.Close()
+
+	if path := flags.Arg(0); path != "" {
+		if err := db.Backup(path); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(path)
+		return
+	}
+	created, err := backup.NewManager(db, cfg.BackupDirectory, cfg.BackupKeep).Create()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(created.Path)
+}
+
+// restoreDatabase replaces the database with a verified backup while the agent is stopped
+func restoreDatabase(args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Usage = func() {
+		log.Println("Usage: browsetrace-agent restore <file or backup name>")
+		flags.PrintDefaults()
+	}
+	check := flags.Bool("check", false, "only verify the backup")
+	force := flags.Bool("force", false, "restore even if something answers on the server address")
+	config.AddFlags(flags)
+	flags.Parse(args)
+	cfg := loadConfig(flags)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	// A bare name refers to a file in the backup directory
+	path := flags.Arg(0)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) && filepath.Base(path) == path {
+		path = filepath.Join(cfg.BackupDirectory, path)
+	}
+
+	if *check {
+		if err := database.VerifyBackup(path); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is intact", path)
+		return
+	}
+
+	// Swapping the file under a running server would lose its writes
+	if connection, err := net.DialTimeout("tcp", cfg.Address, time.Second); err == nil && !*force {
+		connection.Close()
+		log.Fatalf("The agent seems to be running on %s: stop it before restoring, or pass -force", cfg.Address)
+	}
+
+	saved, err := backup.Restore(path, cfg.DatabasePath)
+	if err != nil {
+		log.Fatal("Restore failed: ", err)
+	}
+	if saved != "" {
+		log.Printf("Previous database saved as %s", saved)
+	}
+	log.Printf("Restored %s from %s", cfg.DatabasePath, path)
+}
+
+// migrateSchema brings the database up to the latest schema. serve does this
+// too when it starts; other commands refuse a database with pending
+// migrations.
+func migrateSchema(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	config.AddDatabaseFlags(flags)
+	flags.Parse(args)
+
+	db := migrateDatabase(loadConfig(flags))
+	defer db.Close()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Database schema is at version %d", version)
+}
+
+// doctor checks the configuration and the database and exits with status 1
+// if any check fails
+func doctor(args []string) {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	config.AddFlags(flags)
+	flags.Parse(args)
+
+	var checks []database.Check
+	add := func(name, status, detail string) {
+		checks = append(checks, database.Check{Name: name, Status: status, Detail: detail})
+	}
+
+	directory := applicationDirectory()
+	cfg, err := config.Load(directory, flags)
+	if err != nil {
+		add("config", database.CheckFail, err.Error()+", checking with defaults")
+		cfg = config.Default(directory)
+	} else if cfg.File != "" {
+		add("config", database.CheckOK, cfg.File)
+	} else {
+		add("config", database.CheckOK, "defaults, no "+config.FileName)
+	}
+
+	// The settings the server would refuse to start with
+	settings := []struct {
+		key   string
+		value string
+		parse func(string) error
+	}{
+		{"server.schema_validation", cfg.SchemaValidation, func(value string) error { _, err := server.ParseSchemaValidation(value); return err }},
+		{"server.ingest_mode", cfg.IngestMode, func(value string) error { _, err := server.ParseIngestMode(value); return err }},
+		{"redaction.rules", cfg.Redaction, func(value string) error { _, err := redaction.ParseConfig(value); return err }},
+		{"retention.policy", cfg.Retention, func(value string) error { _, err := retention.ParsePolicy(value); return err }},
+		{"urls.rules", cfg.URLRules, func(value string) error { _, err := urlnorm.ParseRules(value); return err }},
+	}
+	for _, setting := range settings {
+		if err := setting.parse(setting.value); err != nil {
+			add(setting.key, database.CheckFail, err.Error())
+		}
+	}
+
+	// Read-only, so that checking never migrates or otherwise changes the file
+	db, err := database.OpenDatabase(cfg.DatabasePath, true)
+	if err != nil {
+		add("database", database.CheckFail, err.Error())
+	} else {
+		defer db.Close()
+		add("database", database.CheckOK, cfg.DatabasePath)
+		checks = append(checks, db.Diagnose()...)
+		checks = append(checks, checkEncryption(db))
+	}
+
+	tokenPath := cfg.TokenPath()
+	if info, err := os.Stat(tokenPath); errors.Is(err, os.ErrNotExist) {
+		add("auth token", database.CheckWarn, "not created yet, serve creates it")
+	} else if err != nil {
+		add("auth token", database.CheckFail, err.Error())
+	} else if info.Mode().Perm()&0o077 != 0 {
+		add("auth token", database.CheckWarn, fmt.Sprintf("%s is readable by other users (mode %o)", tokenPath, info.Mode().Perm()))
+	} else {
+		add("auth token", database.CheckOK, tokenPath)
+	}
+
+	failed := false
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, check := range checks {
+		fmt.Fprintf(table, "%s\t%s\t%s\n", check.Status, check.Name, check.Detail)
+		failed = failed || check.Status == database.CheckFail
+	}
+	table.Flush()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// checkEncryption reports whether the configured key, if any, opens the
+// database, without enabling it
+func checkEncryption(db *database.Database) database.Check {
+	check := database.Check{Name: "encryption", Status: database.CheckOK}
+	keyID, err := db.EncryptionKeyID()
+	if err != nil {
+		check.Status, check.Detail = database.CheckFail, err.Error()
+		return check
+	}
+	// Deriving a key from a passphrase before encryption is enabled would
+	// store a salt, and doctor opens the database read-only
+	if keyID == "" && os.Getenv("BROWSETRACE_ENCRYPTION_KEY") == "" && os.Getenv("BROWSETRACE_ENCRYPTION_KEY_FILE") == "" && os.Getenv("BROWSETRACE_ENCRYPTION_PASSPHRASE") != "" {
+		check.Detail = "passphrase set, stored events are encrypted when the server starts"
+		return check
+	}
+	key, err := loadKey(db, "BROWSETRACE_ENCRYPTION")
+	switch {
+	case err != nil:
+		check.Status, check.Detail = database.CheckFail, "invalid key: "+err.Error()
+	case keyID == "" && key == nil:
+		check.Detail = "off"
+	case keyID == "":
+		check.Detail = fmt.Sprintf("key %s set, stored events are encrypted when the server starts", key.ID())
+	case key == nil:
+		check.Status, check.Detail = database.CheckFail, fmt.Sprintf("database uses key %s but no key is set", keyID)
+	case key.ID() != keyID:
+		check.Status, check.Detail = database.CheckFail, fmt.Sprintf("database uses key %s, the configured key is %s", keyID, key.ID())
+	default:
+		check.Detail = "key " + keyID
+	}
+	return check
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/config"
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// addFilterFlags registers the GET /events filters as flags and returns a
+// function building the filter once the flags are parsed
+func addFilterFlags(flags *flag.FlagSet) func() database.EventFilter {
+	eventType := flags.String("type", "", "only events of this type")
+	since := flags.Int64("since", 0, "only events at or after this Unix time in milliseconds")
+	until := flags.Int64("until", 0, "only events at or before this Unix time in milliseconds")
+	url := flags.String("url", "", "only events on this exact URL")
+	domain := flags.String("domain", "", "only events on this host or its subdomains")
+	sessionID := flags.String("session", "", "only events of this session")
+
+	return func() database.EventFilter {
+		var filter database.EventFilter
+		if *eventType != "" {
+			filter.EventType = eventType
+		}
+		if *since != 0 {
+			filter.SinceUTC = since
+		}
+		if *until != 0 {
+			filter.UntilUTC = until
+		}
+		if *url != "" {
+			filter.URL = url
+		}
+		if *domain != "" {
+			filter.Domain = domain
+		}
+		if *sessionID != "" {
+			filter.SessionID = sessionID
+		}
+		return filter
+	}
+}
+
+// queryEvents prints the newest events matching the flags as a table, or as
+// NDJSON with -json
+func queryEvents(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	filterFromFlags := addFilterFlags(flags)
+	limit := flags.Int("limit", 20, "most events to print")
+	asJSON := flags.Bool("json", false, "print one JSON event per line")
+	config.AddDatabaseFlags(flags)
+	flags.Parse(args)
+	cfg := loadConfig(flags)
+	if *limit <= 0 {
+		log.Fatal("Invalid -limit: must be positive integer")
+	}
+
+	db := openDatabase(cfg, true)
+	defer db.Close()
+	unlockEncryption(db)
+
+	filter := filterFromFlags()
+	filter.Limit = *limit
+	events, err := db.GetEvents(filter)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, event := range events {
+			if err := encoder.Encode(event); err != nil {
+				log.Fatal(err)
+			}
+		}
+		return
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tTIME\tTYPE\tURL\tDETAIL")
+	for _, event := range events {
+		fmt.Fprintf(table, "%d\t%s\t%s\t%s\t%s\n", event.ID,
+			time.UnixMilli(event.TSUTC).Local().Format("2006-01-02 15:04:05"),
+			event.Type, truncate(event.URL, 60), detail(event))
+	}
+	table.Flush()
+}
+
+// detail picks the most telling data field of an event for one table cell
+func detail(event models.Event) string {
+	for _, field := range []string{"text", "value", "selector"} {
+		if value, ok := event.Data[field].(string); ok && value != "" {
+			return truncate(strings.Join(strings.Fields(value), " "), 60)
+		}
+	}
+	if event.Title != nil {
+		return truncate(*event.Title, 60)
+	}
+	return ""
+}
+
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+// printStats prints the GET /stats metrics for the events matching the flags
+func printStats(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	filterFromFlags := addFilterFlags(flags)
+	topDomains := flags.Int("top", 10, "number of top domains to list")
+	asJSON := flags.Bool("json", false, "print the metrics as JSON")
+	config.AddDatabaseFlags(flags)
+	flags.Parse(args)
+	cfg := loadConfig(flags)
+
+	db := openDatabase(cfg, true)
+	defer db.Close()
+	unlockEncryption(db)
+
+	stats, err := db.GetStats(filterFromFlags(), *topDomains)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(stats); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "Events\t%d\n", stats.TotalEvents)
+	fmt.Fprintf(table, "Sessions\t%d\n", stats.DistinctSessions)
+	if stats.OldestTSUTC != nil && stats.NewestTSUTC != nil {
+		fmt.Fprintf(table, "Span\t%s to %s\n",
+			time.UnixMilli(*stats.OldestTSUTC).Local().Format("2006-01-02 15:04"),
+			time.UnixMilli(*stats.NewestTSUTC).Local().Format("2006-01-02 15:04"))
+	}
+	fmt.Fprintf(table, "Database size\t%.1f MiB\n", float64(stats.DatabaseSizeBytes)/(1<<20))
+
+	types := make([]string, 0, len(stats.EventsByType))
+	for eventType := range stats.EventsByType {
+		types = append(types, eventType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return stats.EventsByType[types[i]] > stats.EventsByType[types[j]]
+	})
+	fmt.Fprintln(table, "\nType\tEvents")
+	for _, eventType := range types {
+		fmt.Fprintf(table, "%s\t%d\n", eventType, stats.EventsByType[eventType])
+	}
+
+	fmt.Fprintln(table, "\nDomain\tEvents")
+	for _, domain := range stats.TopDomains {
+		fmt.Fprintf(table, "%s\t%d\n", domain.Domain, domain.Count)
+	}
+	table.Flush()
+}
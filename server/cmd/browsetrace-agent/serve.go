@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-server/internal/auth"
+	"github.com/vincentbai/browsetrace-server/internal/backup"
+	"github.com/vincentbai/browsetrace-server/internal/config"
+	"github.com/vincentbai/browsetrace-server/internal/redaction"
+	"github.com/vincentbai/browsetrace-server/internal/retention"
+	"github.com/vincentbai/browsetrace-server/internal/server"
+	"github.com/vincentbai/browsetrace-server/internal/urlnorm"
+)
+
+// serve runs the HTTP server until it receives SIGINT or SIGTERM
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	config.AddFlags(flags)
+	flags.Parse(args)
+	cfg := loadConfig(flags)
+
+	db := migrateDatabase(cfg)
+	defer db.Close()
+	setupEncryption(db)
+
+	// Pages and time report rollups marked stale by a migration,
+	// pages.keep_fragments, reports.idle_threshold or encryption are rebuilt
+	// now, since reads never rebuild
+	if err := db.RebuildStale(); err != nil {
+		log.Fatal("Failed to rebuild derived tables: ", err)
+	}
+
+	srv := server.NewServer(db, cfg.Address)
+	srv.SetTimeouts(cfg.ReadTimeout, cfg.WriteTimeout, cfg.ShutdownTimeout)
+	srv.SetDefaultLimit(cfg.DefaultLimit)
+
+	// Clients read the bearer token from the auth-token file next to events.db.
+	// Turning auth off is meant for local development only.
+	if !cfg.AuthEnabled {
+		log.Println("Warning: API authentication is disabled")
+	} else {
+		tokenPath := cfg.TokenPath()
+		token, err := auth.LoadOrCreateToken(tokenPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.SetAuthToken(token)
+		log.Printf("API token: %s", tokenPath)
+	}
+
+	// The BrowseTrace extension and the desktop app by default, e.g.
+	// "chrome-extension://<id>,file://" for an extension built with another key
+	srv.SetAllowedOrigins(auth.ParseOrigins(strings.Join(cfg.AllowedOrigins, ",")))
+
+	configureIngestion(srv, cfg)
+
+	// "partial" stores the valid events of a batch and quarantines the rest;
+	// clients can also choose per request with POST /events?mode=partial
+	ingestMode, err := server.ParseIngestMode(cfg.IngestMode)
+	if err != nil {
+		log.Fatal("Invalid server.ingest_mode: ", err)
+	}
+	srv.SetIngestMode(ingestMode)
+
+	// Optional retention policy, e.g. "visible_text:max_age=7d;@history:max_age=365d"
+	if cfg.Retention != "" {
+		policy, err := retention.ParsePolicy(cfg.Retention)
+		if err != nil {
+			log.Fatal("Invalid retention.policy: ", err)
+		}
+		if !policy.Empty() {
+			log.Printf("Retention policy: %s", policy)
+			srv.SetPruner(retention.NewPruner(db, policy))
+		}
+	}
+
+	// POST /admin/backup, plus a rotating backup every backup.interval if set
+	srv.SetBackups(backup.NewManager(db, cfg.BackupDirectory, cfg.BackupKeep), cfg.BackupInterval)
+	if cfg.BackupInterval > 0 {
+		log.Printf("Backing up every %s to %s, keeping %d", cfg.BackupInterval, cfg.BackupDirectory, cfg.BackupKeep)
+	}
+
+	if err := srv.Start(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// configureIngestion applies the settings events pass through on their way
+// into the database, which the server and the import command share
+func configureIngestion(srv *server.Server, cfg *config.Config) {
+	// Event data is checked against the registered schemas: "strict" (default)
+	// rejects bad batches, "lenient" stores and flags them, "off" skips the check
+	schemaValidation, err := server.ParseSchemaValidation(cfg.SchemaValidation)
+	if err != nil {
+		log.Fatal("Invalid server.schema_validation: ", err)
+	}
+	srv.SetSchemaValidation(schemaValidation)
+
+	// Larger batches get a 413; the byte limit applies after decompression
+	srv.SetBodyLimits(cfg.MaxBodyBytes, cfg.MaxBatchEvents)
+
+	// Redaction is on by default; e.g. "email=hash;visible_text:phone=keep", or "off"
+	redactionConfig, err := redaction.ParseConfig(cfg.Redaction)
+	if err != nil {
+		log.Fatal("Invalid redaction.rules: ", err)
+	}
+	if !redactionConfig.Disabled() {
+		// Digests of the hash action are keyed with a per-install secret kept
+		// next to events.db, so they can't be reversed by hashing guesses
+		key, err := auth.LoadOrCreateSecret(cfg.RedactionKeyPath())
+		if err != nil {
+			log.Fatal(err)
+		}
+		redactionConfig.HashKey = []byte(key)
+		srv.SetRedactor(redaction.NewRedactor(redactionConfig))
+	}
+
+	// URLs are canonicalized by default: tracking parameters and secrets
+	// removed, with the captured URL kept, secrets masked, in raw_url
+	if cfg.CanonicalURLs {
+		rules, err := urlnorm.ParseRules(cfg.URLRules)
+		if err != nil {
+			log.Fatal("Invalid urls.rules: ", err)
+		}
+		srv.SetCanonicalizer(urlnorm.NewCanonicalizer(urlnorm.Config{
+			Strip:   cfg.StripParams,
+			Secrets: cfg.SecretParams,
+			Rules:   rules,
+		}))
+	}
+}
@@ -2,7 +2,14 @@ module github.com/vincentbai/browsetrace-server
 
 go 1.24.0
 
-require modernc.org/sqlite v1.39.1
+require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/klauspost/compress v1.18.0
+	github.com/parquet-go/parquet-go v0.25.1
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	golang.org/x/term v0.36.0
+	modernc.org/sqlite v1.39.1
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// TokenFileName is the well-known file next to events.db that clients read
+// the API token from
+const TokenFileName = "auth-token"
+
+// ExtensionID is the id of the BrowseTrace browser extension, fixed by the
+// public key in its manifest
+const ExtensionID = "cdofbopmknncfjpafogfbamakflihjdd"
+
+// DefaultOrigins are the browser origins allowed to call the API: the browser
+// extension, the packaged Electron app (file://) and its Vite dev server
+var DefaultOrigins = []string{"chrome-extension://" + ExtensionID, "file://", "http://localhost:5173"}
+
+// LoadOrCreateToken returns the token stored at path, generating and saving
+// a new one on first run. The file is readable by the current user only.
+func LoadOrCreateToken(path string) (string, error) {
+	return LoadOrCreateSecret(path)
+}
+
+// LoadOrCreateSecret returns the hex secret stored at path like
+// LoadOrCreateToken, for other per-install secrets
+func LoadOrCreateSecret(path string) (string, error) {
+	contents, err := os.ReadFile(path)
+	if err == nil {
+		if secret := strings.TrimSpace(string(contents)); secret != "" {
+			return secret, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+
+	random := make([]byte, 32)
+	rand.Read(random)
+	secret := hex.EncodeToString(random)
+
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return secret, nil
+}
+
+// Authorized reports whether req carries "Authorization: Bearer <token>"
+func Authorized(req *http.Request, token string) bool {
+	provided, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && matches(provided, token)
+}
+
+// AuthorizedByQuery reports whether req carries the token in its access_token
+// query parameter. Only meant for browser EventSource connections, which cannot
+// set headers, since URLs end up in logs and browser history.
+func AuthorizedByQuery(req *http.Request, token string) bool {
+	return matches(req.URL.Query().Get("access_token"), token)
+}
+
+func matches(provided, token string) bool {
+	provided = strings.TrimSpace(provided)
+	return provided != "" && subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
+
+// OriginAllowed reports whether origin matches one of patterns. A pattern
+// ending in "*" matches any origin with that prefix.
+func OriginAllowed(origin string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(origin, prefix) {
+				return true
+			}
+		} else if origin == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseOrigins reads a comma-separated list of origin patterns
+func ParseOrigins(spec string) []string {
+	var origins []string
+	for _, origin := range strings.Split(spec, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	return origins
+}
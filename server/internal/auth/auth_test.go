@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadOrCreateToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), TokenFileName)
+
+	token, err := LoadOrCreateToken(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateToken failed: %v", err)
+	}
+	if len(token) != 64 {
+		t.Errorf("Expected a 64 character token, got %q", token)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected token file to exist: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	again, err := LoadOrCreateToken(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateToken failed: %v", err)
+	}
+	if again != token {
+		t.Errorf("Expected the stored token to be reused, got %q and %q", token, again)
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"Bearer secret", true},
+		{"Bearer wrong", false},
+		{"secret", false},
+		{"Basic secret", false},
+		{"", false},
+		{"?access_token=secret", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/events", nil)
+		if query, ok := strings.CutPrefix(tt.header, "?"); ok {
+			req = httptest.NewRequest("GET", "/events?"+query, nil)
+		} else if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		if got := Authorized(req, "secret"); got != tt.want {
+			t.Errorf("Authorized(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+
+	query := []struct {
+		url  string
+		want bool
+	}{
+		{"/events/stream?access_token=secret", true},
+		{"/events/stream?access_token=wrong", false},
+		{"/events/stream?access_token=", false},
+	}
+	for _, tt := range query {
+		if got := AuthorizedByQuery(httptest.NewRequest("GET", tt.url, nil), "secret"); got != tt.want {
+			t.Errorf("AuthorizedByQuery(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"chrome-extension://" + ExtensionID, true},
+		{"chrome-extension://abcdefghijklmnop", false},
+		{"file://", true},
+		{"http://localhost:5173", true},
+		{"http://localhost:5174", false},
+		{"https://evil.example", false},
+		{"null", false},
+	}
+
+	for _, tt := range tests {
+		if got := OriginAllowed(tt.origin, DefaultOrigins); got != tt.want {
+			t.Errorf("OriginAllowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	origins := ParseOrigins(" chrome-extension://abc/ , ,http://localhost:3000")
+	if len(origins) != 2 || origins[0] != "chrome-extension://abc" || origins[1] != "http://localhost:3000" {
+		t.Errorf("Unexpected parsed origins: %q", origins)
+	}
+	if OriginAllowed("chrome-extension://xyz", origins) {
+		t.Error("Expected a pinned extension id to refuse other extensions")
+	}
+}
@@ -0,0 +1,232 @@
+// Package backup takes consistent copies of the database while the agent
+// keeps writing to it, keeps the newest few of them, and restores one in
+// place of the database once it has been verified.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+const (
+	filePrefix = "events-"
+	fileSuffix = ".db"
+	// timeFormat names backup files so that they sort oldest first
+	timeFormat = "20060102T150405.000Z"
+)
+
+// Manager writes backups to a directory and deletes all but the newest keep
+type Manager struct {
+	db        *database.Database
+	directory string
+	keep      int // 0 keeps every backup
+	now       func() time.Time
+
+	mu sync.Mutex // one backup at a time
+}
+
+func NewManager(db *database.Database, directory string, keep int) *Manager {
+	return &Manager{db: db, directory: directory, keep: keep, now: time.Now}
+}
+
+// Directory is where the manager writes backups
+func (m *Manager) Directory() string {
+	return m.directory
+}
+
+// Create backs up the database to a new file in the backup directory and
+// then deletes the oldest backups beyond the keep limit
+func (m *Manager) Create() (models.Backup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(m.directory, 0o700); err != nil {
+		return models.Backup{}, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	name := filePrefix + m.now().UTC().Format(timeFormat) + fileSuffix
+	path := filepath.Join(m.directory, name)
+	if err := m.db.Backup(path); err != nil {
+		return models.Backup{}, err
+	}
+	backup, ok := describe(m.directory, name)
+	if !ok {
+		return models.Backup{}, fmt.Errorf("backup %s disappeared", path)
+	}
+
+	if err := m.rotate(); err != nil {
+		return backup, err
+	}
+	return backup, nil
+}
+
+// List returns the backups in the directory, newest first
+func (m *Manager) List() ([]models.Backup, error) {
+	entries, err := os.ReadDir(m.directory)
+	if errors.Is(err, os.ErrNotExist) {
+		return []models.Backup{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	backups := []models.Backup{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			if backup, ok := describe(m.directory, entry.Name()); ok {
+				backups = append(backups, backup)
+			}
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name > backups[j].Name
+	})
+	return backups, nil
+}
+
+// rotate deletes the oldest backups beyond the keep limit
+func (m *Manager) rotate() error {
+	if m.keep <= 0 {
+		return nil
+	}
+	backups, err := m.List()
+	if err != nil {
+		return err
+	}
+	for _, backup := range backups[min(m.keep, len(backups)):] {
+		if err := os.Remove(backup.Path); err != nil {
+			return fmt.Errorf("failed to delete old backup: %w", err)
+		}
+		log.Printf("Backup: deleted %s", backup.Name)
+	}
+	return nil
+}
+
+// describe returns the backup named name, if name is one of the manager's files
+func describe(directory, name string) (models.Backup, bool) {
+	stamp, ok := strings.CutPrefix(name, filePrefix)
+	if !ok {
+		return models.Backup{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, fileSuffix)
+	if !ok {
+		return models.Backup{}, false
+	}
+	created, err := time.Parse(timeFormat, stamp)
+	if err != nil {
+		return models.Backup{}, false
+	}
+	path := filepath.Join(directory, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		return models.Backup{}, false
+	}
+	return models.Backup{Name: name, Path: path, SizeBytes: info.Size(), CreatedUTC: created.UnixMilli()}, true
+}
+
+// Run creates a backup every interval until ctx is cancelled
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		backup, err := m.Create()
+		if err != nil {
+			log.Printf("Backup failed: %v", err)
+			continue
+		}
+		log.Printf("Backup: wrote %s (%d bytes)", backup.Name, backup.SizeBytes)
+	}
+}
+
+// Restore replaces the database at databasePath with the backup at
+// backupPath. The backup is copied next to the database and verified before
+// anything is swapped; the replaced database, with its WAL files, is kept as
+// <databasePath>.pre-restore-<time> and its path returned. The agent must not
+// have the database open.
+func Restore(backupPath, databasePath string) (string, error) {
+	if err := database.VerifyBackup(backupPath); err != nil {
+		return "", err
+	}
+
+	// A copy on the same filesystem can be renamed into place atomically
+	staging := databasePath + ".restore"
+	if err := copyFile(backupPath, staging); err != nil {
+		os.Remove(staging)
+		return "", err
+	}
+	if err := database.VerifyBackup(staging); err != nil {
+		os.Remove(staging)
+		return "", fmt.Errorf("copy of the backup failed verification: %w", err)
+	}
+
+	saved := ""
+	if _, err := os.Stat(databasePath); err == nil {
+		saved = databasePath + ".pre-restore-" + time.Now().UTC().Format("20060102T150405Z")
+		// A stale WAL next to the restored file would be replayed into it,
+		// so the WAL files move along with the database they belong to
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			err := os.Rename(databasePath+suffix, saved+suffix)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				undoRename(saved, databasePath)
+				os.Remove(staging)
+				return "", fmt.Errorf("failed to move the current database aside: %w", err)
+			}
+		}
+	}
+
+	if err := os.Rename(staging, databasePath); err != nil {
+		if saved != "" {
+			undoRename(saved, databasePath)
+		}
+		os.Remove(staging)
+		return "", fmt.Errorf("failed to move the backup into place: %w", err)
+	}
+	return saved, nil
+}
+
+// undoRename moves a database and its WAL files back to their original path
+func undoRename(saved, databasePath string) {
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		os.Rename(saved+suffix, databasePath+suffix)
+	}
+}
+
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create restore file: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func setupDatabase(t *testing.T, path string, urls ...string) *database.Database {
+	t.Helper()
+	db, err := database.NewDatabase(path)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	var events []models.Event
+	for i, url := range urls {
+		events = append(events, models.Event{
+			TSUTC: int64(1000 + i), TSISO: "1970-01-01T00:00:01Z", URL: url, Type: "navigate",
+			Data: map[string]any{"from": nil, "to": url},
+		})
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	return db
+}
+
+func TestCreateRotates(t *testing.T) {
+	directory := t.TempDir()
+	db := setupDatabase(t, filepath.Join(directory, "events.db"), "https://example.com")
+	defer db.Close()
+
+	manager := NewManager(db, filepath.Join(directory, "backups"), 2)
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return clock }
+
+	for i := 0; i < 3; i++ {
+		created, err := manager.Create()
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if created.CreatedUTC != clock.UnixMilli() || created.SizeBytes == 0 {
+			t.Errorf("Unexpected backup: %+v", created)
+		}
+		clock = clock.Add(time.Hour)
+	}
+
+	backups, err := manager.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups after rotation, got %d", len(backups))
+	}
+	if backups[0].Name != "events-20240101T020000.000Z.db" || backups[1].Name != "events-20240101T010000.000Z.db" {
+		t.Errorf("Expected the newest backups newest first, got %s and %s", backups[0].Name, backups[1].Name)
+	}
+
+	// Files the manager did not write are neither listed nor rotated away
+	other := filepath.Join(manager.Directory(), "notes.txt")
+	if err := os.WriteFile(other, []byte("keep"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := manager.Create(); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected unrelated files to survive rotation: %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	directory := t.TempDir()
+	databasePath := filepath.Join(directory, "events.db")
+
+	db := setupDatabase(t, databasePath, "https://before.example")
+	backupPath := filepath.Join(directory, "backup.db")
+	if err := db.Backup(backupPath); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := db.InsertEvents([]models.Event{{
+		TSUTC: 5000, TSISO: "1970-01-01T00:00:05Z", URL: "https://after.example", Type: "navigate",
+		Data: map[string]any{"from": nil, "to": "https://after.example"},
+	}}); err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+	db.Close()
+
+	saved, err := Restore(backupPath, databasePath)
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(saved); err != nil {
+		t.Errorf("Expected the replaced database at %s: %v", saved, err)
+	}
+	if _, err := os.Stat(databasePath + ".restore"); !os.IsNotExist(err) {
+		t.Errorf("Expected the staging copy to be gone, got %v", err)
+	}
+
+	restored, err := database.NewDatabase(databasePath)
+	if err != nil {
+		t.Fatalf("Failed to open restored database: %v", err)
+	}
+	defer restored.Close()
+	count, err := restored.CountEvents(database.EventFilter{})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected the 1 event of the backup, got %d", count)
+	}
+
+	previous, err := database.NewDatabase(saved)
+	if err != nil {
+		t.Fatalf("Failed to open saved database: %v", err)
+	}
+	defer previous.Close()
+	if count, _ := previous.CountEvents(database.EventFilter{}); count != 2 {
+		t.Errorf("Expected the saved database to keep its 2 events, got %d", count)
+	}
+}
+
+func TestRestoreRejectsBadBackups(t *testing.T) {
+	directory := t.TempDir()
+	databasePath := filepath.Join(directory, "events.db")
+	db := setupDatabase(t, databasePath, "https://example.com")
+	db.Close()
+
+	garbage := filepath.Join(directory, "garbage.db")
+	if err := os.WriteFile(garbage, []byte("not a database, just some text padding it out"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	for _, path := range []string{garbage, filepath.Join(directory, "missing.db")} {
+		if _, err := Restore(path, databasePath); err == nil {
+			t.Errorf("Expected %s to be rejected", filepath.Base(path))
+		}
+	}
+
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		t.Fatalf("Failed to list directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "events.db" && entry.Name() != "garbage.db" && entry.Name() != "events.db-wal" && entry.Name() != "events.db-shm" {
+			t.Errorf("Expected a rejected restore to leave nothing behind, found %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,439 @@
+// Package config layers the agent's settings: built-in defaults, then the
+// config.toml file in the application directory, then BROWSETRACE_*
+// environment variables, then command-line flags. Later layers win.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/vincentbai/browsetrace-server/internal/auth"
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/redaction"
+	"github.com/vincentbai/browsetrace-server/internal/server"
+	"github.com/vincentbai/browsetrace-server/internal/urlnorm"
+)
+
+// FileName is the config file looked up in the application directory
+const FileName = "config.toml"
+
+// Config holds every setting of the agent
+type Config struct {
+	Address          string
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration
+	ShutdownTimeout  time.Duration
+	DefaultLimit     int // events returned by GET /events without a limit parameter
+	MaxBodyBytes     int64
+	MaxBatchEvents   int
+	IngestMode       string
+	SchemaValidation string
+
+	DatabasePath string
+
+	AuthEnabled    bool
+	AllowedOrigins []string
+
+	Retention string // retention policy, see retention.ParsePolicy; empty keeps everything
+	Redaction string // redaction rules, see redaction.ParseConfig; "off" disables it
+
+	BackupDirectory string
+	BackupInterval  time.Duration // scheduled backups while serving, 0 for none
+	BackupKeep      int           // newest backups kept by rotation, 0 for all
+
+	PageFragments bool // page URLs keep their fragment, see database.SetPageFragments
+
+	IdleThreshold time.Duration // longest gap counted as active time, see database.SetIdleThreshold
+
+	CanonicalURLs bool     // canonicalize event URLs on ingestion, see urlnorm.Canonicalizer
+	StripParams   []string // query parameters removed from canonical URLs
+	SecretParams  []string // query parameters removed from canonical URLs and masked in captured ones
+	URLRules      string   // per-domain canonicalization rules, see urlnorm.ParseRules
+
+	// File is the config file that was read, empty when there was none
+	File string
+	// sources records which layer set each setting, keyed like the file
+	sources map[string]string
+}
+
+// setting ties a Config field to its key in the file, its environment
+// variable and its flag
+type setting struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	field func(c *Config) any // pointer to the field
+}
+
+var settings = []setting{
+	{"server.address", "BROWSETRACE_ADDRESS", "address", "address the HTTP server listens on",
+		func(c *Config) any { return &c.Address }},
+	{"server.read_timeout", "BROWSETRACE_READ_TIMEOUT", "read-timeout", "time allowed to read a request, 0 for none",
+		func(c *Config) any { return &c.ReadTimeout }},
+	{"server.write_timeout", "BROWSETRACE_WRITE_TIMEOUT", "write-timeout", "time allowed to write a response, 0 for none",
+		func(c *Config) any { return &c.WriteTimeout }},
+	{"server.shutdown_timeout", "BROWSETRACE_SHUTDOWN_TIMEOUT", "shutdown-timeout", "time requests get to finish on shutdown",
+		func(c *Config) any { return &c.ShutdownTimeout }},
+	{"server.default_limit", "BROWSETRACE_DEFAULT_LIMIT", "default-limit", "events returned by GET /events without a limit",
+		func(c *Config) any { return &c.DefaultLimit }},
+	{"server.max_body_bytes", "BROWSETRACE_MAX_BODY_BYTES", "max-body-bytes", "largest POST /events body after decompression",
+		func(c *Config) any { return &c.MaxBodyBytes }},
+	{"server.max_batch_events", "BROWSETRACE_MAX_BATCH_EVENTS", "max-batch-events", "most events in one posted batch",
+		func(c *Config) any { return &c.MaxBatchEvents }},
+	{"server.ingest_mode", "BROWSETRACE_INGEST_MODE", "ingest-mode", "atomic or partial",
+		func(c *Config) any { return &c.IngestMode }},
+	{"server.schema_validation", "BROWSETRACE_SCHEMA_VALIDATION", "schema-validation", "strict, lenient or off",
+		func(c *Config) any { return &c.SchemaValidation }},
+	{"database.path", "BROWSETRACE_DB", "db", "path of the events database",
+		func(c *Config) any { return &c.DatabasePath }},
+	{"auth.enabled", "BROWSETRACE_AUTH", "auth", "require the bearer token from the auth-token file",
+		func(c *Config) any { return &c.AuthEnabled }},
+	{"auth.allowed_origins", "BROWSETRACE_ALLOWED_ORIGINS", "allowed-origins", "comma-separated browser origins allowed to call the API",
+		func(c *Config) any { return &c.AllowedOrigins }},
+	{"retention.policy", "BROWSETRACE_RETENTION", "retention", `retention policy, e.g. "visible_text:max_age=7d"`,
+		func(c *Config) any { return &c.Retention }},
+	{"redaction.rules", "BROWSETRACE_REDACTION", "redaction", `redaction rules, e.g. "email=hash", or "off"`,
+		func(c *Config) any { return &c.Redaction }},
+	{"backup.directory", "BROWSETRACE_BACKUP_DIR", "backup-dir", "where backups are written",
+		func(c *Config) any { return &c.BackupDirectory }},
+	{"backup.interval", "BROWSETRACE_BACKUP_INTERVAL", "backup-interval", "time between scheduled backups while serving, 0 for none",
+		func(c *Config) any { return &c.BackupInterval }},
+	{"backup.keep", "BROWSETRACE_BACKUP_KEEP", "backup-keep", "newest backups to keep, 0 for all",
+		func(c *Config) any { return &c.BackupKeep }},
+	{"pages.keep_fragments", "BROWSETRACE_PAGE_FRAGMENTS", "page-fragments", "count URLs that differ only in their #fragment as different pages",
+		func(c *Config) any { return &c.PageFragments }},
+	{"reports.idle_threshold", "BROWSETRACE_IDLE_THRESHOLD", "idle-threshold", "longest gap between clicks, keystrokes or navigations counted as active time",
+		func(c *Config) any { return &c.IdleThreshold }},
+	{"urls.canonicalize", "BROWSETRACE_CANONICALIZE_URLS", "canonicalize-urls", "store event URLs in canonical form, keeping the captured URL alongside",
+		func(c *Config) any { return &c.CanonicalURLs }},
+	{"urls.strip_params", "BROWSETRACE_STRIP_PARAMS", "strip-params", `comma-separated query parameters removed from canonical URLs, e.g. "utm_*,fbclid"`,
+		func(c *Config) any { return &c.StripParams }},
+	{"urls.secret_params", "BROWSETRACE_SECRET_PARAMS", "secret-params", "comma-separated query parameters whose values are masked in stored URLs",
+		func(c *Config) any { return &c.SecretParams }},
+	{"urls.rules", "BROWSETRACE_URL_RULES", "url-rules", `per-domain URL rules, e.g. "youtube.com keep=v,t; app.example.com fragment"`,
+		func(c *Config) any { return &c.URLRules }},
+}
+
+// Default returns the built-in settings for an application directory
+func Default(directory string) *Config {
+	c := &Config{
+		Address:          "127.0.0.1:8123",
+		ReadTimeout:      5 * time.Second,
+		WriteTimeout:     5 * time.Second,
+		ShutdownTimeout:  30 * time.Second,
+		DefaultLimit:     server.DefaultLimit,
+		MaxBodyBytes:     server.DefaultMaxBodyBytes,
+		MaxBatchEvents:   server.DefaultMaxBatchEvents,
+		IngestMode:       "atomic",
+		SchemaValidation: "strict",
+		DatabasePath:     filepath.Join(directory, "events.db"),
+		AuthEnabled:      true,
+		AllowedOrigins:   append([]string(nil), auth.DefaultOrigins...),
+		BackupDirectory:  filepath.Join(directory, "backups"),
+		BackupKeep:       7,
+		IdleThreshold:    database.DefaultIdleThreshold,
+		CanonicalURLs:    true,
+		StripParams:      append([]string(nil), urlnorm.TrackingParams...),
+		SecretParams:     append([]string(nil), urlnorm.SecretParams...),
+		sources:          map[string]string{},
+	}
+	for _, s := range settings {
+		c.sources[s.key] = "default"
+	}
+	return c
+}
+
+// AddFlags registers -config and a flag for every setting
+func AddFlags(flags *flag.FlagSet) {
+	flags.String("config", "", "config file (default "+FileName+" in the application directory, or BROWSETRACE_CONFIG)")
+	for _, s := range settings {
+		flags.String(s.flag, "", s.usage)
+	}
+}
+
+// AddDatabaseFlags registers -config and -db, for commands that only need the database
+func AddDatabaseFlags(flags *flag.FlagSet) {
+	flags.String("config", "", "config file (default "+FileName+" in the application directory, or BROWSETRACE_CONFIG)")
+	for _, s := range settings {
+		if s.key == "database.path" {
+			flags.String(s.flag, "", s.usage)
+		}
+	}
+}
+
+// Load layers the defaults for directory, the config file, the environment
+// and the flags set on flags, which must have been parsed. flags may be nil.
+// The default config file may be missing; one named by -config or
+// BROWSETRACE_CONFIG may not.
+func Load(directory string, flags *flag.FlagSet) (*Config, error) {
+	c := Default(directory)
+
+	setFlags := map[string]string{}
+	if flags != nil {
+		flags.Visit(func(f *flag.Flag) {
+			setFlags[f.Name] = f.Value.String()
+		})
+	}
+
+	path, explicit := setFlags["config"], true
+	if path == "" {
+		path = os.Getenv("BROWSETRACE_CONFIG")
+	}
+	if path == "" {
+		path, explicit = filepath.Join(directory, FileName), false
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := c.applyFile(path, data); err != nil {
+			return nil, err
+		}
+		c.File = path
+	case errors.Is(err, os.ErrNotExist) && !explicit:
+	default:
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok && value != "" {
+			if err := setString(s.field(c), value); err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+			c.sources[s.key] = s.env
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := setFlags[s.flag]; ok {
+			if err := setString(s.field(c), value); err != nil {
+				return nil, fmt.Errorf("invalid -%s: %w", s.flag, err)
+			}
+			c.sources[s.key] = "-" + s.flag
+		}
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) applyFile(path string, data []byte) error {
+	var document map[string]any
+	if _, err := toml.Decode(string(data), &document); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	values := map[string]any{}
+	flatten("", document, values)
+
+	known := map[string]setting{}
+	for _, s := range settings {
+		known[s.key] = s
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s, ok := known[key]
+		if !ok {
+			return fmt.Errorf("invalid config file %s: unknown setting %s", path, key)
+		}
+		if err := setValue(s.field(c), values[key]); err != nil {
+			return fmt.Errorf("invalid config file %s: %s: %w", path, key, err)
+		}
+		c.sources[key] = path
+	}
+	return nil
+}
+
+// flatten collects the values of a decoded TOML document keyed like
+// "table.key", the way settings name them
+func flatten(prefix string, table map[string]any, values map[string]any) {
+	for key, value := range table {
+		if nested, ok := value.(map[string]any); ok {
+			flatten(prefix+key+".", nested, values)
+			continue
+		}
+		values[prefix+key] = value
+	}
+}
+
+// setValue stores a value read from the config file in a field
+func setValue(field any, value any) error {
+	switch field := field.(type) {
+	case *[]string:
+		items, ok := value.([]any)
+		if !ok {
+			if text, isString := value.(string); isString {
+				return setString(field, text)
+			}
+			return fmt.Errorf("expected an array of strings")
+		}
+		*field = []string{}
+		for _, item := range items {
+			text, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("expected an array of strings")
+			}
+			*field = append(*field, text)
+		}
+		return nil
+	case *string, *time.Duration:
+		text, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("expected a quoted string")
+		}
+		return setString(field, text)
+	case *int, *int64:
+		number, ok := value.(int64)
+		if !ok {
+			return fmt.Errorf("expected an integer")
+		}
+		return setString(field, strconv.FormatInt(number, 10))
+	case *bool:
+		flag, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected true or false")
+		}
+		*field = flag
+		return nil
+	}
+	return fmt.Errorf("unsupported setting type %T", field)
+}
+
+// setString stores a value given as text, by an environment variable or a flag
+func setString(field any, value string) error {
+	switch field := field.(type) {
+	case *string:
+		*field = value
+	case *[]string:
+		*field = []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, item)
+			}
+		}
+	case *time.Duration:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("expected a duration such as 5s or 1m30s")
+		}
+		*field = duration
+	case *int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		*field = number
+	case *int64:
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		*field = number
+	case *bool:
+		switch strings.ToLower(value) {
+		case "true", "on", "1", "yes":
+			*field = true
+		case "false", "off", "0", "no":
+			*field = false
+		default:
+			return fmt.Errorf("expected on or off")
+		}
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	switch {
+	case c.Address == "":
+		return errors.New("invalid server.address: must not be empty")
+	case c.DatabasePath == "":
+		return errors.New("invalid database.path: must not be empty")
+	case c.ReadTimeout < 0 || c.WriteTimeout < 0:
+		return errors.New("invalid server timeouts: must not be negative")
+	case c.ShutdownTimeout <= 0:
+		return errors.New("invalid server.shutdown_timeout: must be positive")
+	case c.DefaultLimit <= 0:
+		return errors.New("invalid server.default_limit: must be positive integer")
+	case c.MaxBodyBytes <= 0:
+		return errors.New("invalid server.max_body_bytes: must be a positive number of bytes")
+	case c.MaxBatchEvents <= 0:
+		return errors.New("invalid server.max_batch_events: must be positive integer")
+	case c.BackupDirectory == "":
+		return errors.New("invalid backup.directory: must not be empty")
+	case c.BackupInterval < 0:
+		return errors.New("invalid backup.interval: must not be negative")
+	case c.BackupKeep < 0:
+		return errors.New("invalid backup.keep: must not be negative")
+	case c.IdleThreshold <= 0:
+		return errors.New("invalid reports.idle_threshold: must be positive")
+	}
+	return nil
+}
+
+// TokenPath is the auth token file, which clients find next to events.db
+func (c *Config) TokenPath() string {
+	return filepath.Join(filepath.Dir(c.DatabasePath), auth.TokenFileName)
+}
+
+// RedactionKeyPath is the file holding the key of the redaction hash action
+func (c *Config) RedactionKeyPath() string {
+	return filepath.Join(filepath.Dir(c.DatabasePath), redaction.KeyFileName)
+}
+
+// Source names the layer a setting came from: "default", the config file's
+// path, an environment variable or a flag
+func (c *Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// Show writes the effective configuration as a config file, with the source
+// of each setting as a comment
+func (c *Config) Show(w io.Writer) error {
+	table := ""
+	for _, s := range settings {
+		section, name, _ := strings.Cut(s.key, ".")
+		if section != table {
+			if table != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "[%s]\n", section)
+			table = section
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s # %s\n", name, format(s.field(c)), c.sources[s.key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// format writes a field as a TOML value
+func format(field any) string {
+	value := reflect.ValueOf(field).Elem().Interface()
+	if duration, ok := value.(time.Duration); ok {
+		value = duration.String()
+	}
+	var out bytes.Buffer
+	if err := toml.NewEncoder(&out).Encode(map[string]any{"v": value}); err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(out.String(), "v = "))
+}
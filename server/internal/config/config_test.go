@@ -0,0 +1,160 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, directory, contents string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(directory, FileName), []byte(contents), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+}
+
+func TestLoadDefaults(t *testing.T) {
+	directory := t.TempDir()
+
+	cfg, err := Load(directory, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Address != "127.0.0.1:8123" || cfg.ReadTimeout != 5*time.Second || cfg.DefaultLimit != 100 || !cfg.AuthEnabled {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+	if cfg.DatabasePath != filepath.Join(directory, "events.db") {
+		t.Errorf("Expected events.db in the application directory, got %s", cfg.DatabasePath)
+	}
+	if cfg.File != "" || cfg.Source("server.address") != "default" {
+		t.Errorf("Expected no config file, got %q with source %q", cfg.File, cfg.Source("server.address"))
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	directory := t.TempDir()
+	writeConfig(t, directory, `
+# Settings for the test
+[server]
+address = "127.0.0.1:9000"
+read_timeout = "10s"   # slow disks
+default_limit = 50
+max_body_bytes = 1_048_576
+
+[auth]
+enabled = false
+allowed_origins = ["chrome-extension://abc", 'file://']
+
+[redaction]
+rules = "email=hash"
+`)
+	t.Setenv("BROWSETRACE_DEFAULT_LIMIT", "70")
+	t.Setenv("BROWSETRACE_AUTH", "on")
+	t.Setenv("BROWSETRACE_RETENTION", "click:max_age=7d")
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	AddFlags(flags)
+	if err := flags.Parse([]string{"-default-limit", "90", "-write-timeout", "1m"}); err != nil {
+		t.Fatalf("Failed to parse flags: %v", err)
+	}
+
+	cfg, err := Load(directory, flags)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		key    string
+		got    any
+		want   any
+		source string
+	}{
+		{"server.address", cfg.Address, "127.0.0.1:9000", filepath.Join(directory, FileName)},
+		{"server.read_timeout", cfg.ReadTimeout, 10 * time.Second, filepath.Join(directory, FileName)},
+		{"server.write_timeout", cfg.WriteTimeout, time.Minute, "-write-timeout"},
+		{"server.shutdown_timeout", cfg.ShutdownTimeout, 30 * time.Second, "default"},
+		{"server.default_limit", cfg.DefaultLimit, 90, "-default-limit"},
+		{"server.max_body_bytes", cfg.MaxBodyBytes, int64(1 << 20), filepath.Join(directory, FileName)},
+		{"auth.enabled", cfg.AuthEnabled, true, "BROWSETRACE_AUTH"},
+		{"auth.allowed_origins", strings.Join(cfg.AllowedOrigins, ","), "chrome-extension://abc,file://", filepath.Join(directory, FileName)},
+		{"retention.policy", cfg.Retention, "click:max_age=7d", "BROWSETRACE_RETENTION"},
+		{"redaction.rules", cfg.Redaction, "email=hash", filepath.Join(directory, FileName)},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.key, tt.want, tt.got)
+		}
+		if source := cfg.Source(tt.key); source != tt.source {
+			t.Errorf("%s: expected source %s, got %s", tt.key, tt.source, source)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		message string
+	}{
+		{"unknown key", "[server]\nport = 80\n", nil, nil, "unknown setting server.port"},
+		{"wrong type", "[server]\ndefault_limit = \"ten\"\n", nil, nil, "expected an integer"},
+		{"syntax", "[server\n", nil, nil, "line 2: expected '.' or ']'"},
+		{"float", "[server]\ndefault_limit = 1.5\n", nil, nil, "server.default_limit: expected an integer"},
+		{"duplicate", "[server]\naddress = \"a\"\naddress = \"b\"\n", nil, nil, "has already been defined"},
+		{"bad env", "", map[string]string{"BROWSETRACE_READ_TIMEOUT": "soon"}, nil, "invalid BROWSETRACE_READ_TIMEOUT"},
+		{"bad flag", "", nil, []string{"-auth", "maybe"}, "invalid -auth"},
+		{"out of range", "", nil, []string{"-default-limit", "0"}, "server.default_limit"},
+		{"missing explicit file", "", nil, []string{"-config", "/nonexistent/config.toml"}, "failed to read config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory := t.TempDir()
+			if tt.file != "" {
+				writeConfig(t, directory, tt.file)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			flags := flag.NewFlagSet("test", flag.ContinueOnError)
+			AddFlags(flags)
+			if err := flags.Parse(tt.args); err != nil {
+				t.Fatalf("Failed to parse flags: %v", err)
+			}
+
+			_, err := Load(directory, flags)
+			if err == nil || !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("Expected error containing %q, got %v", tt.message, err)
+			}
+		})
+	}
+}
+
+func TestShowRoundTrips(t *testing.T) {
+	directory := t.TempDir()
+	writeConfig(t, directory, "[redaction]\nrules = \"email=hash;\\\"quoted\\\"\"\n[auth]\nallowed_origins = []\n")
+
+	cfg, err := Load(directory, nil)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	var shown strings.Builder
+	if err := cfg.Show(&shown); err != nil {
+		t.Fatalf("Show failed: %v", err)
+	}
+
+	// What config show prints is a valid config file giving the same settings
+	other := t.TempDir()
+	writeConfig(t, other, shown.String())
+	reloaded, err := Load(other, nil)
+	if err != nil {
+		t.Fatalf("Failed to load shown config: %v\n%s", err, shown.String())
+	}
+	if reloaded.Redaction != `email=hash;"quoted"` || len(reloaded.AllowedOrigins) != 0 || reloaded.DatabasePath != cfg.DatabasePath {
+		t.Errorf("Shown config did not round-trip: %+v", reloaded)
+	}
+}
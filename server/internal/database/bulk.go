@@ -0,0 +1,193 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// EachEvent calls fn for every event matching filter, oldest first. Rows are
+// read one at a time, so memory stays flat however many events match.
+// Cursor is ignored; Limit applies when set. An error from fn stops the walk
+// and is returned as is.
+func (d *Database) EachEvent(filter EventFilter, fn func(models.Event) error) error {
+	filter.Cursor = nil
+	where, args, err := d.whereClause(filter)
+	if err != nil {
+		return err
+	}
+
+	query := "SELECT " + eventColumns + " FROM events" + where + " ORDER BY ts_utc, id"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+	return nil
+}
+
+// ImportResult counts what ImportEvents did with the events it was given
+type ImportResult struct {
+	Imported   int // stored, including upserts
+	Upserted   int // imported events that replaced a stored one through their dedup key
+	Duplicates int // skipped because the same event is already stored
+
+	Events []models.Event // the stored events, with their ids
+}
+
+// ImportEvents stores events in one transaction like InsertEvents, except
+// that an event already stored is skipped, so that importing the same export
+// twice adds nothing. Two events are the same when they share timestamp,
+// type, URL, session and data; ids are not compared, since they differ
+// between databases. An event whose dedup key already holds an event at least as
+// recent is skipped too, so an import never rolls a field back to an older value.
+func (d *Database) ImportEvents(events []models.Event) (ImportResult, error) {
+	var result ImportResult
+
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return result, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	var fresh []models.Event
+	batch := make(map[string]int64, len(events)) // identity to newest timestamp in this call
+	for _, event := range events {
+		if err := d.ValidateEvent(event); err != nil {
+			return result, fmt.Errorf("invalid event: %w", err)
+		}
+		eventType, _ := d.lookupEventType(event.Type)
+		url := d.sealURL(event.URL)
+		key := dedupKey(eventType.DedupKey, url, event.SessionID, event.FieldID)
+
+		var stored bool
+		var identity string
+		if key != nil {
+			err = transaction.QueryRow(
+				"SELECT EXISTS(SELECT 1 FROM events WHERE type = ? AND dedup_key = ? AND ts_utc >= ?)",
+				event.Type, *key, event.TSUTC,
+			).Scan(&stored)
+			if err != nil {
+				return result, fmt.Errorf("failed to look up event: %w", err)
+			}
+			identity = event.Type + "\x1f" + *key
+		} else {
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				return result, fmt.Errorf("failed to marshal event data: %w", err)
+			}
+			hash, err := dataHash(string(data))
+			if err != nil {
+				return result, err
+			}
+			if stored, err = storedWithData(transaction, event, url, hash); err != nil {
+				return result, err
+			}
+			identity = importKey(event, hash)
+		}
+		if newest, ok := batch[identity]; ok && (key == nil || newest >= event.TSUTC) {
+			stored = true
+		}
+		if stored {
+			result.Duplicates++
+			continue
+		}
+		batch[identity] = event.TSUTC
+		event.ID = 0
+		fresh = append(fresh, event)
+	}
+
+	if result.Upserted, err = d.insertEvents(transaction, fresh); err != nil {
+		return ImportResult{}, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return ImportResult{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	result.Imported = len(fresh)
+	result.Events = fresh
+	return result, nil
+}
+
+// importKey identifies an event without a dedup key within one import call
+func importKey(event models.Event, hash string) string {
+	session := "\x00"
+	if event.SessionID != nil {
+		session = *event.SessionID
+	}
+	return fmt.Sprintf("%d\x1f%s\x1f%s\x1f%s\x1f%s", event.TSUTC, event.Type, event.URL, session, hash)
+}
+
+// storedWithData reports whether an event sharing timestamp, type, URL and
+// session with event, and whose data hashes to hash, is stored. Encrypted
+// data uses random nonces, so the data of each candidate is compared after
+// decryption.
+func storedWithData(transaction *sql.Tx, event models.Event, url, hash string) (bool, error) {
+	rows, err := transaction.Query(
+		"SELECT data_json FROM events WHERE ts_utc = ? AND type = ? AND url = ? AND session_id IS ?",
+		event.TSUTC, event.Type, url, event.SessionID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up event: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stored string
+		if err := rows.Scan(&stored); err != nil {
+			return false, fmt.Errorf("failed to scan row: %w", err)
+		}
+		plain, err := openData(stored)
+		if err != nil {
+			return false, fmt.Errorf("failed to decrypt stored event: %w", err)
+		}
+		storedHash, err := dataHash(plain)
+		if err != nil {
+			return false, err
+		}
+		if storedHash == hash {
+			return true, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return false, nil
+}
+
+// dataHash hashes event data in a canonical form, so that the same data
+// hashes the same way however its JSON was spaced or its keys ordered
+func dataHash(dataJSON string) (string, error) {
+	var data any
+	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
+		return "", fmt.Errorf("failed to parse event data: %w", err)
+	}
+	canonical, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal event data: %w", err)
+	}
+	digest := sha256.Sum256(canonical)
+	return hex.EncodeToString(digest[:]), nil
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestEachEvent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://example.com", Type: "click", Data: map[string]any{}},
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "click", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://other.org", Type: "click", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	var seen []int64
+	collect := func(event models.Event) error {
+		seen = append(seen, event.TSUTC)
+		return nil
+	}
+	if err := db.EachEvent(EventFilter{}, collect); err != nil {
+		t.Fatalf("EachEvent failed: %v", err)
+	}
+	if len(seen) != 3 || seen[0] != 1000 || seen[2] != 3000 {
+		t.Errorf("Expected all events oldest first, got %v", seen)
+	}
+
+	seen = nil
+	domain := "example.com"
+	if err := db.EachEvent(EventFilter{Domain: &domain, Limit: 1}, collect); err != nil {
+		t.Fatalf("EachEvent failed: %v", err)
+	}
+	if len(seen) != 1 || seen[0] != 1000 {
+		t.Errorf("Expected the oldest example.com event, got %v", seen)
+	}
+
+	stop := errors.New("stop")
+	if err := db.EachEvent(EventFilter{}, func(models.Event) error { return stop }); !errors.Is(err, stop) {
+		t.Errorf("Expected the callback error, got %v", err)
+	}
+}
+
+func TestImportEventsIdempotent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	session, field := "s1", "#q"
+	events := []models.Event{
+		{ID: 41, TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "click", Data: map[string]any{}, SessionID: &session},
+		{ID: 42, TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com", Type: "input", Data: map[string]any{"value": "a"}, SessionID: &session, FieldID: &field},
+		{ID: 43, TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://example.com", Type: "input", Data: map[string]any{"value": "ab"}, SessionID: &session, FieldID: &field},
+	}
+
+	result, err := db.ImportEvents(events)
+	if err != nil {
+		t.Fatalf("ImportEvents failed: %v", err)
+	}
+	if result.Imported != 3 || result.Upserted != 1 || result.Duplicates != 0 {
+		t.Errorf("Unexpected first import: %+v", result)
+	}
+
+	// Every event is already stored, and the click also repeats within the batch
+	again := append(events, events[0])
+	result, err = db.ImportEvents(again)
+	if err != nil {
+		t.Fatalf("ImportEvents failed: %v", err)
+	}
+	if result.Imported != 0 || result.Duplicates != 4 {
+		t.Errorf("Expected only duplicates on re-import, got %+v", result)
+	}
+
+	count, err := db.CountEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected the click and the upserted input, got %d events", count)
+	}
+
+	// The older input of the re-import must not roll the field back
+	input := "input"
+	stored, err := db.GetEvents(EventFilter{EventType: &input})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Data["value"] != "ab" {
+		t.Errorf("Expected the newest input value, got %+v", stored)
+	}
+}
+
+func TestImportEventsComparesData(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Two clicks in the same millisecond on the same page differ only in data
+	session := "s1"
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "click", Data: map[string]any{"selector": "#a", "text": "A"}, SessionID: &session},
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "click", Data: map[string]any{"selector": "#b", "text": "B"}, SessionID: &session},
+	}
+	result, err := db.ImportEvents(events[:1])
+	if err != nil {
+		t.Fatalf("ImportEvents failed: %v", err)
+	}
+	if result.Imported != 1 {
+		t.Errorf("Expected the first click imported, got %+v", result)
+	}
+
+	result, err = db.ImportEvents(append(events, events[1]))
+	if err != nil {
+		t.Fatalf("ImportEvents failed: %v", err)
+	}
+	if result.Imported != 1 || result.Duplicates != 2 {
+		t.Errorf("Expected only the second click imported, got %+v", result)
+	}
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Cursor marks a position in the (ts_utc DESC, id DESC) ordering of events.
+// Paging on both columns keeps events that share a timestamp from being
+// skipped or repeated across pages.
+type Cursor struct {
+	TSUTC int64
+	ID    int64
+}
+
+// Encode returns the opaque string form handed to API clients
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%d", c.TSUTC, c.ID)))
+}
+
+// DecodeCursor parses a string produced by Cursor.Encode
+func DecodeCursor(encoded string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor encoding: %w", err)
+	}
+
+	tsPart, idPart, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return Cursor{}, fmt.Errorf("invalid cursor format")
+	}
+
+	tsUTC, err := strconv.ParseInt(tsPart, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor timestamp: %w", err)
+	}
+	id, err := strconv.ParseInt(idPart, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor id: %w", err)
+	}
+
+	return Cursor{TSUTC: tsUTC, ID: id}, nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := Cursor{TSUTC: 1700000000000, ID: 42}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	if err != nil {
+		t.Fatalf("DecodeCursor failed: %v", err)
+	}
+	if decoded != cursor {
+		t.Errorf("Expected %+v, got %+v", cursor, decoded)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, encoded := range []string{"", "!!!", "bm9jb2xvbg", "YWJjOjEy", "MTI6YWJj"} {
+		if _, err := DecodeCursor(encoded); err == nil {
+			t.Errorf("Expected error for cursor %q", encoded)
+		}
+	}
+}
+
+func TestGetEventsPageWalksAllEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Several events share a timestamp, which since/until paging cannot split
+	var events []models.Event
+	for i := 0; i < 7; i++ {
+		events = append(events, models.Event{
+			TSUTC: int64(1000 + (i/3)*1000),
+			TSISO: "1970-01-01T00:00:01Z",
+			URL:   "https://example.com",
+			Type:  "click",
+			Data:  map[string]any{"i": i},
+		})
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	seen := map[int64]bool{}
+	filter := EventFilter{Limit: 3}
+	pages := 0
+	for {
+		page, next, err := db.GetEventsPage(filter)
+		if err != nil {
+			t.Fatalf("GetEventsPage failed: %v", err)
+		}
+		pages++
+		for _, event := range page {
+			if seen[event.ID] {
+				t.Errorf("Event %d returned twice", event.ID)
+			}
+			seen[event.ID] = true
+		}
+		if next == nil {
+			break
+		}
+		filter.Cursor = next
+	}
+
+	if len(seen) != len(events) {
+		t.Errorf("Expected to see %d events, saw %d", len(events), len(seen))
+	}
+	if pages != 3 {
+		t.Errorf("Expected 3 pages, got %d", pages)
+	}
+}
+
+func TestGetEventsPageNoNextCursorOnExactFit(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "click", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com", Type: "click", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	page, next, err := db.GetEventsPage(EventFilter{Limit: 2})
+	if err != nil {
+		t.Fatalf("GetEventsPage failed: %v", err)
+	}
+	if len(page) != 2 {
+		t.Errorf("Expected 2 events, got %d", len(page))
+	}
+	if next != nil {
+		t.Errorf("Expected no next cursor, got %+v", next)
+	}
+}
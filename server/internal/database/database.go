@@ -3,43 +3,88 @@ package database
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 
+	"github.com/vincentbai/browsetrace-server/internal/encryption"
 	"github.com/vincentbai/browsetrace-server/internal/models"
 	_ "modernc.org/sqlite" // CGO-free SQLite
 )
 
 type Database struct {
-	db              *sql.DB
-	validEventTypes map[string]bool
+	db                *sql.DB
+	eventTypes        atomic.Pointer[eventTypeRegistry] // registry cache, see loadEventTypes
+	eventTypesChecked atomic.Int64                      // unix milliseconds, see registeredTypes
+	key               *encryption.Key                   // nil unless encryption at rest is enabled
+
+	pageFragments bool  // page URLs keep their fragment, see SetPageFragments
+	idleThreshold int64 // milliseconds, see SetIdleThreshold
 }
 
+// NewDatabase opens the database at databasePath, creating it if needed, and
+// brings its schema up to date
 func NewDatabase(databasePath string) (*Database, error) {
-	// WAL + busy timeout to avoid "database is locked"
-	db, err := sql.Open("sqlite", databasePath+"?_journal_mode=WAL&_busy_timeout=5000")
+	// WAL + busy timeout to avoid "database is locked"; incremental auto_vacuum
+	// takes effect for new files and lets retention return freed pages cheaply
+	db, err := sql.Open("sqlite", databasePath+"?_pragma=auto_vacuum(2)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	if err := createTables(db); err != nil {
+	if err := migrate(db, databasePath, migrations); err != nil {
 		db.Close()
 		return nil, err
 	}
+	return newDatabase(db)
+}
 
-	return &Database{
-		db: db,
-		validEventTypes: map[string]bool{
-			"navigate":     true,
-			"visible_text": true,
-			"click":        true,
-			"input":        true,
-			"focus":        true,
-		},
-	}, nil
+// OpenDatabase opens the database at databasePath without migrating it, so
+// its schema must already be up to date. A read-only handle never writes to
+// the file: settings, encryption and stale tables are left as they are.
+func OpenDatabase(databasePath string, readOnly bool) (*Database, error) {
+	dsn := databasePath + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	if readOnly {
+		dsn = "file:" + uriEscaper.Replace(filepath.ToSlash(databasePath)) + "?mode=ro&_pragma=busy_timeout(5000)"
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	switch latest := LatestSchemaVersion(); {
+	case version > latest:
+		db.Close()
+		return nil, fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, version, latest)
+	case version < latest:
+		db.Close()
+		return nil, fmt.Errorf("%w: database is at version %d, latest is %d", ErrSchemaOutdated, version, latest)
+	}
+	return newDatabase(db)
+}
+
+// uriEscaper quotes the characters a path can't hold as is in a file: URI
+var uriEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+func newDatabase(db *sql.DB) (*Database, error) {
+	d := &Database{db: db, idleThreshold: DefaultIdleThreshold.Milliseconds()}
+	if err := d.loadEventTypes(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
 }
 
-func createTables(db *sql.DB) error {
-	_, err := db.Exec(`
+func createTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
 	CREATE TABLE IF NOT EXISTS events(
 	  id         INTEGER PRIMARY KEY,
 	  ts_utc     INTEGER NOT NULL,
@@ -75,10 +120,57 @@ func createTables(db *sql.DB) error {
 	return nil
 }
 
+// addRawURLColumn adds raw_url, the URL an event was captured with when
+// ingestion canonicalized it to something else. Events stored before keep
+// their URL as captured and a NULL raw_url.
+func addRawURLColumn(tx *sql.Tx) error {
+	// ADD COLUMN has no IF NOT EXISTS
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info('events') WHERE name = 'raw_url')").Scan(&exists); err != nil {
+		return fmt.Errorf("failed to query events columns: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := tx.Exec("ALTER TABLE events ADD COLUMN raw_url TEXT"); err != nil {
+		return fmt.Errorf("failed to add raw URL column: %w", err)
+	}
+	return nil
+}
+
 func (d *Database) Close() error {
 	return d.db.Close()
 }
 
+// RebuildStale rebuilds the tables derived from events that a migration, a
+// change of settings or of the encryption key marked stale. It reads events,
+// so it runs once encryption is set up, and before they are served, so that
+// reads never rebuild.
+func (d *Database) RebuildStale() error {
+	stale, err := getMeta(d.db, metaPagesStale)
+	if err != nil {
+		return err
+	}
+	if stale != "" {
+		log.Println("Rebuilding pages...")
+		if err := d.rebuildPages(); err != nil {
+			return err
+		}
+	}
+
+	stale, err = getMeta(d.db, metaActivityStale)
+	if err != nil {
+		return err
+	}
+	if stale != "" {
+		log.Println("Rebuilding time report rollups...")
+		if err := d.rebuildActivity(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *Database) ValidateEvent(event models.Event) error {
 	if event.URL == "" {
 		return fmt.Errorf("URL cannot be empty")
@@ -86,7 +178,7 @@ func (d *Database) ValidateEvent(event models.Event) error {
 	if event.Type == "" {
 		return fmt.Errorf("Type cannot be empty")
 	}
-	if !d.validEventTypes[event.Type] {
+	if _, ok := d.lookupEventType(event.Type); !ok {
 		return fmt.Errorf("invalid event type: %s", event.Type)
 	}
 	if event.TSUTC <= 0 {
@@ -95,175 +187,445 @@ func (d *Database) ValidateEvent(event models.Event) error {
 	return nil
 }
 
+// InsertEvents stores events in one transaction and sets ID on each of them
 func (d *Database) InsertEvents(events []models.Event) error {
-	transaction, err := d.db.Begin()
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
-	}
-
-	// Prepare statement for regular INSERT (non-input events)
-	insertStmt, err := transaction.Prepare(`INSERT INTO events(ts_utc, ts_iso, url, title, type, data_json, session_id, field_id) VALUES(?,?,?,?,?,json(?),?,?)`)
-	if err != nil {
-		_ = transaction.Rollback()
-		return fmt.Errorf("failed to prepare insert statement: %w", err)
-	}
-	defer insertStmt.Close()
+	_, err := d.StoreBatch(events, nil)
+	return err
+}
 
-	// Prepare statement for UPSERT (input events)
-	upsertInputStmt, err := transaction.Prepare(`
-		INSERT INTO events(ts_utc, ts_iso, url, title, type, data_json, session_id, field_id)
-		VALUES(?,?,?,?,?,json(?),?,?)
-		ON CONFLICT(url, field_id, session_id)
-		DO UPDATE SET
-			ts_utc = excluded.ts_utc,
-			ts_iso = excluded.ts_iso,
-			title = excluded.title,
-			data_json = excluded.data_json
-	`)
-	if err != nil {
-		_ = transaction.Rollback()
-		return fmt.Errorf("failed to prepare input upsert statement: %w", err)
+// insertEvents stores events within transaction, sets ID on each of them and
+// returns how many replaced an event stored before
+func (d *Database) insertEvents(transaction *sql.Tx, events []models.Event) (int, error) {
+	// Rowids of new rows are always above the current maximum, so a returned id
+	// at or below it, or one seen earlier in the batch, means an upsert
+	var maxID int64
+	if err := transaction.QueryRow("SELECT coalesce(max(id), 0) FROM events").Scan(&maxID); err != nil {
+		return 0, fmt.Errorf("failed to query max event id: %w", err)
 	}
-	defer upsertInputStmt.Close()
 
-	// Prepare statement for UPSERT (visible_text events)
-	upsertVisibleTextStmt, err := transaction.Prepare(`
-		INSERT INTO events(ts_utc, ts_iso, url, title, type, data_json, session_id, field_id)
-		VALUES(?,?,?,?,?,json(?),?,?)
-		ON CONFLICT(url, session_id) WHERE type = 'visible_text'
+	// Events whose type declares a dedup key replace the stored event with the
+	// same key; the rest get a NULL key, which never conflicts
+	stmt, err := transaction.Prepare(`
+		INSERT INTO events(ts_utc, ts_iso, url, raw_url, title, type, data_json, session_id, field_id, dedup_key, schema_violations)
+		VALUES(?,?,?,?,?,?,json(?),?,?,?,?)
+		ON CONFLICT(type, dedup_key) WHERE dedup_key IS NOT NULL
 		DO UPDATE SET
 			ts_utc = excluded.ts_utc,
 			ts_iso = excluded.ts_iso,
+			raw_url = excluded.raw_url,
 			title = excluded.title,
-			data_json = excluded.data_json
+			data_json = excluded.data_json,
+			schema_violations = excluded.schema_violations
+		RETURNING id
 	`)
 	if err != nil {
-		_ = transaction.Rollback()
-		return fmt.Errorf("failed to prepare visible_text upsert statement: %w", err)
+		return 0, fmt.Errorf("failed to prepare insert statement: %w", err)
 	}
-	defer upsertVisibleTextStmt.Close()
+	defer stmt.Close()
 
-	for _, event := range events {
+	upserted := 0
+	seen := make(map[int64]bool, len(events))
+	for i, event := range events {
 		if err := d.ValidateEvent(event); err != nil {
-			_ = transaction.Rollback()
-			return fmt.Errorf("invalid event: %w", err)
+			return 0, fmt.Errorf("invalid event: %w", err)
 		}
+		eventType, _ := d.lookupEventType(event.Type)
 
 		jsonData, err := json.Marshal(event.Data)
 		if err != nil {
-			_ = transaction.Rollback()
-			return fmt.Errorf("failed to marshal event data: %w", err)
+			return 0, fmt.Errorf("failed to marshal event data: %w", err)
 		}
 
-		// Use UPSERT for input and visible_text events, regular INSERT for others
-		var stmt *sql.Stmt
-		if event.Type == "input" && event.FieldID != nil && event.SessionID != nil {
-			stmt = upsertInputStmt
-		} else if event.Type == "visible_text" && event.SessionID != nil {
-			stmt = upsertVisibleTextStmt
-		} else {
-			stmt = insertStmt
+		var violations *string
+		if len(event.SchemaViolations) > 0 {
+			encoded, err := json.Marshal(event.SchemaViolations)
+			if err != nil {
+				return 0, fmt.Errorf("failed to marshal schema violations: %w", err)
+			}
+			violationsJSON := string(encoded)
+			violations = &violationsJSON
 		}
 
-		if _, err := stmt.Exec(event.TSUTC, event.TSISO, event.URL, event.Title, event.Type, string(jsonData), event.SessionID, event.FieldID); err != nil {
-			_ = transaction.Rollback()
-			return fmt.Errorf("failed to execute statement: %w", err)
+		// The key is built from the stored URL, so that it stays comparable when encrypted
+		url := d.sealURL(event.URL)
+		key := dedupKey(eventType.DedupKey, url, event.SessionID, event.FieldID)
+		replaced, err := storedActivity(transaction, event.Type, key)
+		if err != nil {
+			return 0, err
 		}
-	}
 
-	if err := transaction.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		// RETURNING gives the id of the new row, or of the existing row on upsert
+		row := stmt.QueryRow(event.TSUTC, event.TSISO, url, d.sealRawURL(event.RawURL), d.sealTitle(event.Title), event.Type, d.sealData(string(jsonData)), event.SessionID, event.FieldID, key, violations)
+		if err := row.Scan(&events[i].ID); err != nil {
+			return 0, fmt.Errorf("failed to execute statement: %w", err)
+		}
+		if events[i].ID <= maxID || seen[events[i].ID] {
+			upserted++
+		}
+		seen[events[i].ID] = true
+
+		if event.Type == "navigate" {
+			if err := d.recordPageVisit(transaction, events[i]); err != nil {
+				return 0, err
+			}
+		}
+		if activityTypes[event.Type] {
+			if err := d.recordActivity(transaction, events[i], replaced); err != nil {
+				return 0, err
+			}
+		}
 	}
-	return nil
+	if err := recordSessionTabs(transaction, events); err != nil {
+		return 0, err
+	}
+	if err := updateSessionDwell(transaction, events); err != nil {
+		return 0, err
+	}
+	return upserted, nil
 }
 
 type EventFilter struct {
 	EventType *string
 	SinceUTC  *int64
 	UntilUTC  *int64
+	URL       *string // exact URL match
+	URLAlias  *string // matched as well as URL, e.g. the URL as given when URL is its canonical form
+	Domain    *string // host match, including subdomains
+	SessionID *string
+	Flagged   *bool   // true for events stored with schema violations, false for the rest
+	Cursor    *Cursor // only events strictly after this position in DESC order
 	Limit     int
 }
 
-func (d *Database) GetEvents(filter EventFilter) ([]models.Event, error) {
-	query := "SELECT id, ts_utc, ts_iso, url, title, type, data_json, session_id, field_id FROM events WHERE 1=1"
+// HasConditions reports whether the filter narrows the set of events.
+// Cursor and Limit only page through results and do not count.
+func (f EventFilter) HasConditions() bool {
+	return f.EventType != nil || f.SinceUTC != nil || f.UntilUTC != nil ||
+		f.URL != nil || f.Domain != nil || f.SessionID != nil || f.Flagged != nil
+}
+
+// whereClause builds the WHERE clause and its arguments for the given filter.
+// Limit is not part of the clause and is left to the caller.
+func (d *Database) whereClause(filter EventFilter) (string, []any, error) {
+	clause := " WHERE 1=1"
 	args := []any{}
 
 	if filter.EventType != nil {
-		if !d.validEventTypes[*filter.EventType] {
-			return nil, fmt.Errorf("invalid event type: %s", *filter.EventType)
+		if _, ok := d.lookupEventType(*filter.EventType); !ok {
+			return "", nil, fmt.Errorf("invalid event type: %s", *filter.EventType)
 		}
-		query += " AND type = ?"
+		clause += " AND type = ?"
 		args = append(args, *filter.EventType)
 	}
 
 	if filter.SinceUTC != nil {
-		query += " AND ts_utc >= ?"
+		clause += " AND ts_utc >= ?"
 		args = append(args, *filter.SinceUTC)
 	}
 
 	if filter.UntilUTC != nil {
-		query += " AND ts_utc <= ?"
+		clause += " AND ts_utc <= ?"
 		args = append(args, *filter.UntilUTC)
 	}
 
-	query += " ORDER BY ts_utc DESC"
+	if filter.URL != nil && filter.URLAlias != nil && *filter.URLAlias != *filter.URL {
+		clause += " AND url IN (?, ?)"
+		args = append(args, d.sealURL(*filter.URL), d.sealURL(*filter.URLAlias))
+	} else if filter.URL != nil {
+		clause += " AND url = ?"
+		args = append(args, d.sealURL(*filter.URL))
+	}
+
+	if filter.Domain != nil {
+		domain := strings.ToLower(*filter.Domain)
+		clause += " AND (url_host(url) = ? OR url_host(url) LIKE ?)"
+		args = append(args, domain, "%."+domain)
+	}
+
+	if filter.SessionID != nil {
+		clause += " AND session_id = ?"
+		args = append(args, *filter.SessionID)
+	}
+
+	if filter.Flagged != nil {
+		if *filter.Flagged {
+			clause += " AND schema_violations IS NOT NULL"
+		} else {
+			clause += " AND schema_violations IS NULL"
+		}
+	}
+
+	if filter.Cursor != nil {
+		clause += " AND (events.ts_utc < ? OR (events.ts_utc = ? AND events.id < ?))"
+		args = append(args, filter.Cursor.TSUTC, filter.Cursor.TSUTC, filter.Cursor.ID)
+	}
+
+	return clause, args, nil
+}
+
+func (d *Database) GetEvents(filter EventFilter) ([]models.Event, error) {
+	events, _, err := d.GetEventsPage(filter)
+	return events, err
+}
+
+// GetEventsPage returns events newest first along with the cursor for the next
+// page. The cursor is nil when there are no further events to fetch.
+func (d *Database) GetEventsPage(filter EventFilter) ([]models.Event, *Cursor, error) {
+	where, args, err := d.whereClause(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := "SELECT " + eventColumns + " FROM events" + where
+	query += " ORDER BY ts_utc DESC, id DESC"
 
 	if filter.Limit > 0 {
+		// Fetch one extra row to learn whether another page exists
 		query += " LIMIT ?"
-		args = append(args, filter.Limit)
+		args = append(args, filter.Limit+1)
 	}
 
 	rows, err := d.db.Query(query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query events: %w", err)
+		return nil, nil, fmt.Errorf("failed to query events: %w", err)
 	}
 	defer rows.Close()
 
 	var events []models.Event
 	for rows.Next() {
-		var (
-			id        int64
-			tsUTC     int64
-			tsISO     string
-			url       string
-			title     *string
-			typeName  string
-			dataJSON  string
-			sessionID *string
-			fieldID   *string
-		)
-
-		if err := rows.Scan(&id, &tsUTC, &tsISO, &url, &title, &typeName, &dataJSON, &sessionID, &fieldID); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, nil, err
 		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating rows: %w", err)
+	}
 
-		var data map[string]any
-		if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal event data: %w", err)
+	var next *Cursor
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+		last := events[len(events)-1]
+		next = &Cursor{TSUTC: last.TSUTC, ID: last.ID}
+	}
+
+	return events, next, nil
+}
+
+// eventColumns is the column list scanEvent expects, in order
+const eventColumns = "events.id, events.ts_utc, events.ts_iso, events.url, events.raw_url, events.title, events.type, events.data_json, events.session_id, events.field_id, events.schema_violations"
+
+// scanEvent reads one row selected with eventColumns. Any extra destinations
+// are scanned from the columns that follow eventColumns in the select list.
+func scanEvent(rows *sql.Rows, extra ...any) (models.Event, error) {
+	var (
+		id        int64
+		tsUTC     int64
+		tsISO     string
+		url       string
+		rawURL    *string
+		title     *string
+		typeName  string
+		dataJSON  string
+		sessionID *string
+		fieldID   *string
+		flags     *string
+	)
+
+	dest := append([]any{&id, &tsUTC, &tsISO, &url, &rawURL, &title, &typeName, &dataJSON, &sessionID, &fieldID, &flags}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return models.Event{}, fmt.Errorf("failed to scan row: %w", err)
+	}
+
+	url, title, dataJSON, err := openFields(url, title, dataJSON)
+	if err != nil {
+		return models.Event{}, fmt.Errorf("failed to decrypt event %d: %w", id, err)
+	}
+	if rawURL, err = openRawURL(rawURL); err != nil {
+		return models.Event{}, fmt.Errorf("failed to decrypt event %d: %w", id, err)
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
+		return models.Event{}, fmt.Errorf("failed to unmarshal event data: %w", err)
+	}
+
+	var violations []string
+	if flags != nil {
+		if err := json.Unmarshal([]byte(*flags), &violations); err != nil {
+			return models.Event{}, fmt.Errorf("failed to unmarshal schema violations: %w", err)
 		}
+	}
+
+	return models.Event{
+		ID:               id,
+		TSUTC:            tsUTC,
+		TSISO:            tsISO,
+		URL:              url,
+		RawURL:           rawURL,
+		Title:            title,
+		Type:             typeName,
+		Data:             data,
+		SessionID:        sessionID,
+		FieldID:          fieldID,
+		SchemaViolations: violations,
+	}, nil
+}
+
+// ErrEventNotFound is returned when no event has the requested id
+var ErrEventNotFound = errors.New("event not found")
 
-		events = append(events, models.Event{
-			TSUTC:     tsUTC,
-			TSISO:     tsISO,
-			URL:       url,
-			Title:     title,
-			Type:      typeName,
-			Data:      data,
-			SessionID: sessionID,
-			FieldID:   fieldID,
-		})
+// GetEvent returns the event with the given id
+func (d *Database) GetEvent(id int64) (*models.Event, error) {
+	rows, err := d.db.Query("SELECT "+eventColumns+" FROM events WHERE id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event: %w", err)
 	}
+	defer rows.Close()
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating rows: %w", err)
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating rows: %w", err)
+		}
+		return nil, ErrEventNotFound
 	}
 
-	return events, nil
+	event, err := scanEvent(rows)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
 }
 
-// DeleteAllEvents removes all events from the database and returns the count of deleted rows
+// DeleteEvent removes the event with the given id, and the time an activity
+// event added to the time reports
+func (d *Database) DeleteEvent(id int64) error {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	count, err := d.deleteActivityEvents(transaction, " WHERE id = ?", []any{id})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		result, err := transaction.Exec("DELETE FROM events WHERE id = ?", id)
+		if err != nil {
+			return fmt.Errorf("failed to delete event: %w", err)
+		}
+		if count, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to get affected rows: %w", err)
+		}
+	}
+	if count == 0 {
+		return ErrEventNotFound
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// PatchEventData applies a JSON merge patch (RFC 7396) to an event's data:
+// keys set to null are removed and all other keys are replaced. This lets
+// callers redact individual fields without resending the whole payload.
+func (d *Database) PatchEventData(id int64, patch map[string]any) (*models.Event, error) {
+	patchJSON, err := json.Marshal(patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal data patch: %w", err)
+	}
+
+	if d.key != nil {
+		return d.patchEncryptedEventData(id, string(patchJSON))
+	}
+
+	result, err := d.db.Exec("UPDATE events SET data_json = json_patch(data_json, ?) WHERE id = ?", string(patchJSON), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update event: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrEventNotFound
+	}
+
+	return d.GetEvent(id)
+}
+
+// CountEvents returns how many events match the filter, ignoring Cursor and Limit
+func (d *Database) CountEvents(filter EventFilter) (int64, error) {
+	filter.Cursor = nil
+	where, args, err := d.whereClause(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM events"+where, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count events: %w", err)
+	}
+	return count, nil
+}
+
+// DeleteEvents removes the events matching the filter, ignoring Cursor and
+// Limit, and returns the count of deleted rows. Deleting a domain's events
+// also forgets the time reported for it, see forgetsActivity; otherwise the
+// deleted activity events take back the time they added.
+func (d *Database) DeleteEvents(filter EventFilter) (int64, error) {
+	filter.Cursor = nil
+	where, args, err := d.whereClause(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	var count int64
+	if forgetsActivity(filter) {
+		if err := forgetActivity(transaction, filter); err != nil {
+			return 0, err
+		}
+	} else if count, err = d.deleteActivityEvents(transaction, where, args); err != nil {
+		return 0, err
+	}
+
+	result, err := transaction.Exec("DELETE FROM events"+where, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete events: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	count += deleted
+
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return count, nil
+}
+
+// DeleteAllEvents removes all events from the database, along with the time
+// reports built from them, and returns the count of deleted rows
 func (d *Database) DeleteAllEvents() (int64, error) {
-	result, err := d.db.Exec("DELETE FROM events")
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	result, err := transaction.Exec("DELETE FROM events")
 	if err != nil {
 		return 0, fmt.Errorf("failed to delete events: %w", err)
 	}
@@ -273,6 +635,12 @@ func (d *Database) DeleteAllEvents() (int64, error) {
 		return 0, fmt.Errorf("failed to get affected rows: %w", err)
 	}
 
+	if _, err := transaction.Exec("DELETE FROM activity_hours"); err != nil {
+		return 0, fmt.Errorf("failed to delete activity: %w", err)
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return count, nil
 }
 
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -1316,3 +1317,226 @@ func TestDeleteAndVacuumIntegration(t *testing.T) {
 		t.Errorf("Expected 0 events, got %d", len(retrievedEvents))
 	}
 }
+
+func TestGetEventByID(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{
+			TSUTC: 1234567890,
+			TSISO: "2009-02-13T23:31:30Z",
+			URL:   "https://example.com",
+			Type:  "navigate",
+			Data:  map[string]any{"to": "https://example.com"},
+		},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	listed, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID == 0 {
+		t.Fatalf("Expected 1 event with an id, got %+v", listed)
+	}
+
+	event, err := db.GetEvent(listed[0].ID)
+	if err != nil {
+		t.Fatalf("GetEvent failed: %v", err)
+	}
+	if event.URL != "https://example.com" || event.ID != listed[0].ID {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+
+	if _, err := db.GetEvent(listed[0].ID + 1); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("Expected ErrEventNotFound, got %v", err)
+	}
+}
+
+func TestDeleteEvent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://keep.example.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://forget.example.com", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	listed, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	target := listed[0] // newest first
+	if target.URL != "https://forget.example.com" {
+		t.Fatalf("Unexpected ordering: %+v", listed)
+	}
+
+	if err := db.DeleteEvent(target.ID); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+	if err := db.DeleteEvent(target.ID); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("Expected ErrEventNotFound on second delete, got %v", err)
+	}
+
+	remaining, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].URL != "https://keep.example.com" {
+		t.Errorf("Unexpected remaining events: %+v", remaining)
+	}
+}
+
+func TestPatchEventData(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{
+			TSUTC: 1000,
+			TSISO: "1970-01-01T00:00:01Z",
+			URL:   "https://example.com",
+			Type:  "input",
+			Data:  map[string]any{"selector": "#ssn", "value": "123-45-6789"},
+		},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+	listed, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+
+	patched, err := db.PatchEventData(listed[0].ID, map[string]any{"value": "[redacted]", "selector": nil})
+	if err != nil {
+		t.Fatalf("PatchEventData failed: %v", err)
+	}
+	if patched.Data["value"] != "[redacted]" {
+		t.Errorf("Expected redacted value, got %v", patched.Data["value"])
+	}
+	if _, ok := patched.Data["selector"]; ok {
+		t.Error("Expected selector to be removed by null patch")
+	}
+
+	if _, err := db.PatchEventData(listed[0].ID+1, map[string]any{}); !errors.Is(err, ErrEventNotFound) {
+		t.Errorf("Expected ErrEventNotFound, got %v", err)
+	}
+}
+
+func TestDeleteEventsWithFilter(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	session1 := "session-1"
+	session2 := "session-2"
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://bank.example.com/login", Type: "navigate", Data: map[string]any{}, SessionID: &session1},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://www.bank.example.com/", Type: "click", Data: map[string]any{}, SessionID: &session1},
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://example.com/", Type: "navigate", Data: map[string]any{}, SessionID: &session2},
+		{TSUTC: 4000, TSISO: "1970-01-01T00:00:04Z", URL: "https://notbank.example.org/", Type: "navigate", Data: map[string]any{}, SessionID: &session2},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	domain := "BANK.example.com"
+	count, err := db.CountEvents(EventFilter{Domain: &domain})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 events on domain, got %d", count)
+	}
+
+	deleted, err := db.DeleteEvents(EventFilter{Domain: &domain})
+	if err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("Expected 2 deleted events, got %d", deleted)
+	}
+
+	since := int64(3500)
+	deleted, err = db.DeleteEvents(EventFilter{SinceUTC: &since, SessionID: &session2})
+	if err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("Expected 1 deleted event, got %d", deleted)
+	}
+
+	remaining, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("Failed to get events: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].URL != "https://example.com/" {
+		t.Errorf("Unexpected remaining events: %+v", remaining)
+	}
+}
+
+func TestGetEventsByURLAndSession(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	session1 := "session-1"
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com/a", Type: "navigate", Data: map[string]any{}, SessionID: &session1},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com/b", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	url := "https://example.com/b"
+	byURL, err := db.GetEvents(EventFilter{URL: &url})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(byURL) != 1 || byURL[0].URL != url {
+		t.Errorf("Unexpected events for url: %+v", byURL)
+	}
+
+	bySession, err := db.GetEvents(EventFilter{SessionID: &session1})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(bySession) != 1 || bySession[0].URL != "https://example.com/a" {
+		t.Errorf("Unexpected events for session: %+v", bySession)
+	}
+}
+
+func TestInsertEventsSetsIDs(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	session := "session-1"
+	field := "email"
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com", Type: "input", Data: map[string]any{"value": "a"}, SessionID: &session, FieldID: &field},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	if events[0].ID == 0 || events[1].ID == 0 || events[0].ID == events[1].ID {
+		t.Fatalf("Expected distinct ids, got %d and %d", events[0].ID, events[1].ID)
+	}
+
+	// An upsert reports the id of the row it updated
+	upsert := []models.Event{
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://example.com", Type: "input", Data: map[string]any{"value": "ab"}, SessionID: &session, FieldID: &field},
+	}
+	if err := db.InsertEvents(upsert); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	if upsert[0].ID != events[1].ID {
+		t.Errorf("Expected upsert to keep id %d, got %d", events[1].ID, upsert[0].ID)
+	}
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// ErrDomainRuleNotFound is returned when no domain rule has the requested id
+var ErrDomainRuleNotFound = errors.New("domain rule not found")
+
+func createDomainRulesTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS domain_rules(
+	  id          INTEGER PRIMARY KEY,
+	  pattern     TEXT    NOT NULL,
+	  match_type  TEXT    NOT NULL CHECK (match_type IN ('glob','regex')),
+	  action      TEXT    NOT NULL CHECK (action IN ('allow','drop','navigate_only','strip_data')),
+	  priority    INTEGER NOT NULL DEFAULT 0,
+	  created_utc INTEGER NOT NULL
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create domain rules table: %w", err)
+	}
+	return nil
+}
+
+const domainRuleColumns = "id, pattern, match_type, action, priority, created_utc"
+
+func scanDomainRule(row interface{ Scan(...any) error }) (models.DomainRule, error) {
+	var rule models.DomainRule
+	err := row.Scan(&rule.ID, &rule.Pattern, &rule.Match, &rule.Action, &rule.Priority, &rule.CreatedUTC)
+	return rule, err
+}
+
+// ListDomainRules returns every domain rule in evaluation order
+func (d *Database) ListDomainRules() ([]models.DomainRule, error) {
+	rows, err := d.db.Query("SELECT " + domainRuleColumns + " FROM domain_rules ORDER BY priority, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domain rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []models.DomainRule
+	for rows.Next() {
+		rule, err := scanDomainRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return rules, nil
+}
+
+// GetDomainRule returns the domain rule with the given id
+func (d *Database) GetDomainRule(id int64) (*models.DomainRule, error) {
+	rule, err := scanDomainRule(d.db.QueryRow("SELECT "+domainRuleColumns+" FROM domain_rules WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrDomainRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query domain rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// CreateDomainRule stores a new rule and returns it with its id and creation time set.
+// Patterns are not validated here; callers compile them first.
+func (d *Database) CreateDomainRule(rule models.DomainRule) (*models.DomainRule, error) {
+	rule.CreatedUTC = time.Now().UnixMilli()
+	result, err := d.db.Exec(
+		"INSERT INTO domain_rules(pattern, match_type, action, priority, created_utc) VALUES(?,?,?,?,?)",
+		rule.Pattern, rule.Match, rule.Action, rule.Priority, rule.CreatedUTC,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert domain rule: %w", err)
+	}
+
+	rule.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain rule id: %w", err)
+	}
+	return &rule, nil
+}
+
+// UpdateDomainRule replaces the pattern, match, action and priority of an existing rule
+func (d *Database) UpdateDomainRule(rule models.DomainRule) (*models.DomainRule, error) {
+	result, err := d.db.Exec(
+		"UPDATE domain_rules SET pattern = ?, match_type = ?, action = ?, priority = ? WHERE id = ?",
+		rule.Pattern, rule.Match, rule.Action, rule.Priority, rule.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update domain rule: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrDomainRuleNotFound
+	}
+	return d.GetDomainRule(rule.ID)
+}
+
+// DeleteDomainRule removes the domain rule with the given id
+func (d *Database) DeleteDomainRule(id int64) error {
+	result, err := d.db.Exec("DELETE FROM domain_rules WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete domain rule: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if count == 0 {
+		return ErrDomainRuleNotFound
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestDomainRulesCRUD(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	rules, err := db.ListDomainRules()
+	if err != nil {
+		t.Fatalf("ListDomainRules failed: %v", err)
+	}
+	if len(rules) != 0 {
+		t.Fatalf("Expected no rules, got %d", len(rules))
+	}
+
+	drop, err := db.CreateDomainRule(models.DomainRule{Pattern: "*.bank.com", Match: "glob", Action: "drop", Priority: 10})
+	if err != nil {
+		t.Fatalf("CreateDomainRule failed: %v", err)
+	}
+	if drop.ID == 0 || drop.CreatedUTC == 0 {
+		t.Errorf("Expected id and creation time to be set, got %+v", drop)
+	}
+	allow, err := db.CreateDomainRule(models.DomainRule{Pattern: "rates.bank.com", Match: "glob", Action: "allow", Priority: 1})
+	if err != nil {
+		t.Fatalf("CreateDomainRule failed: %v", err)
+	}
+
+	// Lower priority comes first
+	rules, err = db.ListDomainRules()
+	if err != nil {
+		t.Fatalf("ListDomainRules failed: %v", err)
+	}
+	if len(rules) != 2 || rules[0].ID != allow.ID || rules[1].ID != drop.ID {
+		t.Errorf("Unexpected rule order: %+v", rules)
+	}
+
+	drop.Action = "strip_data"
+	updated, err := db.UpdateDomainRule(*drop)
+	if err != nil {
+		t.Fatalf("UpdateDomainRule failed: %v", err)
+	}
+	if updated.Action != "strip_data" || updated.CreatedUTC != drop.CreatedUTC {
+		t.Errorf("Unexpected updated rule: %+v", updated)
+	}
+
+	if err := db.DeleteDomainRule(allow.ID); err != nil {
+		t.Fatalf("DeleteDomainRule failed: %v", err)
+	}
+	if _, err := db.GetDomainRule(allow.ID); !errors.Is(err, ErrDomainRuleNotFound) {
+		t.Errorf("Expected ErrDomainRuleNotFound, got %v", err)
+	}
+	if err := db.DeleteDomainRule(allow.ID); !errors.Is(err, ErrDomainRuleNotFound) {
+		t.Errorf("Expected ErrDomainRuleNotFound on second delete, got %v", err)
+	}
+	if _, err := db.UpdateDomainRule(models.DomainRule{ID: 999, Pattern: "x", Match: "glob", Action: "drop"}); !errors.Is(err, ErrDomainRuleNotFound) {
+		t.Errorf("Expected ErrDomainRuleNotFound on update, got %v", err)
+	}
+}
@@ -0,0 +1,449 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-server/internal/encryption"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Encryption at rest covers the url, raw_url, title and data_json columns, the
+// payloads of quarantined events, the start URLs of sessions, the URLs of
+// pages and the domains of time reports. Timestamps, types, session
+// and field ids stay in plaintext so that filtering by type and time,
+// deduplication and retention keep using the indexes. URLs are encrypted
+// deterministically so exact URL lookups and the dedup unique indexes still
+// work; raw URLs, titles and data use random nonces.
+//
+// The full-text search index would hold page text in plaintext, so it is
+// dropped while encryption is enabled.
+
+// keyring holds every key loaded in this process. It is package level because
+// the url_host SQL function needs it to read encrypted URLs.
+var keyring encryption.Keyring
+
+// Field names, also bound to each ciphertext as associated data
+const (
+	fieldURL   = "url"
+	fieldTitle = "title"
+	fieldData  = "data"
+
+	fieldRejected = "rejected_event" // the raw payload of a quarantined event
+	fieldRawURL   = "raw_url"        // the URL of an event as captured, before canonicalization
+)
+
+// Keys in the meta table
+const (
+	metaEncryptionKeyID = "encryption_key_id"
+	metaEncryptionSalt  = "encryption_salt"
+)
+
+var (
+	// ErrWrongKey is returned when enabling encryption with a key other than the one the database was encrypted with
+	ErrWrongKey = errors.New("encryption key does not match the database")
+	// ErrSearchUnavailable is returned by SearchEvents while encryption is enabled
+	ErrSearchUnavailable = errors.New("search is unavailable while encryption at rest is enabled")
+)
+
+func createMetaTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS meta(
+	  key   TEXT PRIMARY KEY,
+	  value TEXT NOT NULL
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create meta table: %w", err)
+	}
+	return nil
+}
+
+// getMeta returns the stored value for key, or "" if it is not set
+func getMeta(db queryRower, key string) (string, error) {
+	var value string
+	err := db.QueryRow("SELECT value FROM meta WHERE key = ?", key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func (d *Database) setMeta(key, value string) error {
+	_, err := d.db.Exec("INSERT INTO meta(key, value) VALUES(?, ?) ON CONFLICT(key) DO UPDATE SET value = excluded.value", key, value)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	return nil
+}
+
+// EncryptionKeyID returns the id of the key the database is encrypted with,
+// or "" if encryption has never been enabled
+func (d *Database) EncryptionKeyID() (string, error) {
+	return getMeta(d.db, metaEncryptionKeyID)
+}
+
+// EncryptionSalt returns the salt for passphrase-derived keys, creating it on first use
+func (d *Database) EncryptionSalt() ([]byte, error) {
+	value, err := getMeta(d.db, metaEncryptionSalt)
+	if err != nil {
+		return nil, err
+	}
+	if value != "" {
+		return hex.DecodeString(value)
+	}
+
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	if err := d.setMeta(metaEncryptionSalt, hex.EncodeToString(salt)); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// EnableEncryption makes key the active key: new events are encrypted with it
+// and stored events encrypted with it become readable. Events stored before
+// encryption was enabled stay readable and are encrypted by EncryptEvents.
+func (d *Database) EnableEncryption(key *encryption.Key) error {
+	keyID, err := d.EncryptionKeyID()
+	if err != nil {
+		return err
+	}
+	if keyID != "" && keyID != key.ID() {
+		return fmt.Errorf("%w: database uses key %s, got %s", ErrWrongKey, keyID, key.ID())
+	}
+
+	keyring.Add(key)
+	if err := dropSearchIndex(d.db); err != nil {
+		return err
+	}
+	if keyID == "" {
+		if err := d.setMeta(metaEncryptionKeyID, key.ID()); err != nil {
+			return err
+		}
+		// Pages are keyed by their stored URL, which is about to change
+		if err := d.setMeta(metaPagesStale, "1"); err != nil {
+			return err
+		}
+	}
+	d.key = key
+	return nil
+}
+
+// UnlockEncryption makes events encrypted with key readable, and encrypts new
+// events with it, without writing to the database, so it works on read-only
+// handles. Unlike EnableEncryption, the database must already use key.
+func (d *Database) UnlockEncryption(key *encryption.Key) error {
+	keyID, err := d.EncryptionKeyID()
+	if err != nil {
+		return err
+	}
+	if keyID != key.ID() {
+		return fmt.Errorf("%w: database uses key %s, got %s", ErrWrongKey, keyID, key.ID())
+	}
+	keyring.Add(key)
+	d.key = key
+	return nil
+}
+
+// EncryptEvents encrypts every event not yet encrypted with the active key,
+// batchSize rows per transaction, and returns the count of rewritten rows.
+// It migrates plaintext databases and finishes key rotations. Callers should
+// VACUUM afterwards so old copies do not linger in free pages.
+func (d *Database) EncryptEvents(batchSize int) (int64, error) {
+	if d.key == nil {
+		return 0, fmt.Errorf("encryption is not enabled")
+	}
+
+	prefix := d.key.Prefix()
+	var total int64
+	for _, encryptBatch := range []func(string, int) (int64, error){d.encryptBatch, d.encryptRejectedBatch} {
+		for {
+			count, err := encryptBatch(prefix, batchSize)
+			total += count
+			if err != nil {
+				return total, err
+			}
+			if count == 0 {
+				break
+			}
+		}
+	}
+	if total > 0 {
+		// Pages are rebuilt with the active key by RebuildStale
+		if err := d.setMeta(metaPagesStale, "1"); err != nil {
+			return total, err
+		}
+	}
+	// Time reports outlive the events they were built from, so their
+	// domains are encrypted in place
+	if err := d.encryptActivity(prefix); err != nil {
+		return total, err
+	}
+	return total, nil
+}
+
+func (d *Database) encryptBatch(prefix string, batchSize int) (int64, error) {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	rows, err := transaction.Query(
+		"SELECT id, type, url, raw_url, title, data_json, session_id, field_id FROM events WHERE url NOT LIKE ? OR raw_url NOT LIKE ? OR title NOT LIKE ? OR data_json NOT LIKE ? ORDER BY id LIMIT ?",
+		prefix+"%", prefix+"%", prefix+"%", `"`+prefix+"%", batchSize,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query events to encrypt: %w", err)
+	}
+
+	type row struct {
+		id        int64
+		eventType string
+		url       string
+		rawURL    *string
+		title     *string
+		dataJSON  string
+		sessionID *string
+		fieldID   *string
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.eventType, &r.url, &r.rawURL, &r.title, &r.dataJSON, &r.sessionID, &r.fieldID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	for _, r := range batch {
+		url, title, dataJSON, err := openFields(r.url, r.title, r.dataJSON)
+		if err != nil {
+			return 0, fmt.Errorf("event %d: %w", r.id, err)
+		}
+		rawURL, err := openRawURL(r.rawURL)
+		if err != nil {
+			return 0, fmt.Errorf("event %d: %w", r.id, err)
+		}
+		// The dedup key embeds the stored URL, so it changes with the ciphertext
+		sealedURL := d.sealURL(url)
+		eventType, _ := d.lookupEventType(r.eventType)
+		key := dedupKey(eventType.DedupKey, sealedURL, r.sessionID, r.fieldID)
+		_, err = transaction.Exec(
+			"UPDATE events SET url = ?, raw_url = ?, title = ?, data_json = json(?), dedup_key = ? WHERE id = ?",
+			sealedURL, d.sealRawURL(rawURL), d.sealTitle(title), d.sealData(dataJSON), key, r.id,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt event %d: %w", r.id, err)
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int64(len(batch)), nil
+}
+
+// encryptRejectedBatch does for quarantined payloads what encryptBatch does for events
+func (d *Database) encryptRejectedBatch(prefix string, batchSize int) (int64, error) {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	rows, err := transaction.Query("SELECT id, payload FROM rejected_events WHERE payload NOT LIKE ? ORDER BY id LIMIT ?", prefix+"%", batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query rejected events to encrypt: %w", err)
+	}
+
+	payloads := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		payloads[id] = payload
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	for id, payload := range payloads {
+		plain, err := keyring.Decrypt(fieldRejected, payload)
+		if err != nil {
+			return 0, fmt.Errorf("rejected event %d: %w", id, err)
+		}
+		if _, err := transaction.Exec("UPDATE rejected_events SET payload = ? WHERE id = ?", d.sealRejected(plain), id); err != nil {
+			return 0, fmt.Errorf("failed to encrypt rejected event %d: %w", id, err)
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return int64(len(payloads)), nil
+}
+
+// RotateKey re-encrypts every event with newKey and makes it the active key.
+// The current key must have been enabled first. If rotation is interrupted,
+// running it again with both keys completes it.
+func (d *Database) RotateKey(newKey *encryption.Key, batchSize int) (int64, error) {
+	if d.key == nil {
+		return 0, fmt.Errorf("encryption is not enabled")
+	}
+
+	keyring.Add(newKey)
+	d.key = newKey
+	count, err := d.EncryptEvents(batchSize)
+	if err != nil {
+		return count, err
+	}
+	return count, d.setMeta(metaEncryptionKeyID, newKey.ID())
+}
+
+// sealURL, sealRawURL, sealTitle and sealData encrypt a field with the active key and
+// return it unchanged when encryption is disabled
+func (d *Database) sealURL(url string) string {
+	if d.key == nil {
+		return url
+	}
+	return d.key.EncryptDeterministic(fieldURL, []byte(url))
+}
+
+func (d *Database) sealTitle(title *string) *string {
+	if d.key == nil || title == nil {
+		return title
+	}
+	sealed := d.key.Encrypt(fieldTitle, []byte(*title))
+	return &sealed
+}
+
+func (d *Database) sealRawURL(rawURL *string) *string {
+	if d.key == nil || rawURL == nil {
+		return rawURL
+	}
+	sealed := d.key.Encrypt(fieldRawURL, []byte(*rawURL))
+	return &sealed
+}
+
+// sealData stores encrypted data as a JSON string so data_json stays valid JSON
+func (d *Database) sealData(dataJSON string) string {
+	if d.key == nil {
+		return dataJSON
+	}
+	return `"` + d.key.Encrypt(fieldData, []byte(dataJSON)) + `"`
+}
+
+// sealRejected encrypts the payload of a quarantined event, which may hold
+// anything the event did, including fields that failed validation
+func (d *Database) sealRejected(payload string) string {
+	if d.key == nil {
+		return payload
+	}
+	return d.key.Encrypt(fieldRejected, []byte(payload))
+}
+
+// openURL returns the plaintext of a stored url, which may or may not be encrypted
+func openURL(url string) (string, error) {
+	return keyring.Decrypt(fieldURL, url)
+}
+
+// openRawURL returns the plaintext of a stored raw_url, which may be NULL
+func openRawURL(rawURL *string) (*string, error) {
+	if rawURL == nil {
+		return nil, nil
+	}
+	plain, err := keyring.Decrypt(fieldRawURL, *rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &plain, nil
+}
+
+// openFields returns the plaintext of stored url, title and data_json values
+func openFields(url string, title *string, dataJSON string) (string, *string, string, error) {
+	url, err := openURL(url)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	if title != nil {
+		plain, err := keyring.Decrypt(fieldTitle, *title)
+		if err != nil {
+			return "", nil, "", err
+		}
+		title = &plain
+	}
+
+	dataJSON, err = openData(dataJSON)
+	if err != nil {
+		return "", nil, "", err
+	}
+
+	return url, title, dataJSON, nil
+}
+
+// openData returns the plaintext JSON of a stored data_json value
+func openData(dataJSON string) (string, error) {
+	sealed, ok := strings.CutPrefix(dataJSON, `"`)
+	if !ok || !encryption.IsEncrypted(sealed) {
+		return dataJSON, nil
+	}
+	return keyring.Decrypt(fieldData, strings.TrimSuffix(sealed, `"`))
+}
+
+// patchEncryptedEventData is PatchEventData for encrypted rows: the data is
+// decrypted, patched with SQLite's json_patch so the semantics match the
+// plaintext path, and encrypted again
+func (d *Database) patchEncryptedEventData(id int64, patchJSON string) (*models.Event, error) {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	var stored string
+	err = transaction.QueryRow("SELECT data_json FROM events WHERE id = ?", id).Scan(&stored)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEventNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query event: %w", err)
+	}
+
+	dataJSON, err := openData(stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt event %d: %w", id, err)
+	}
+
+	var patched string
+	if err := transaction.QueryRow("SELECT json_patch(?, ?)", dataJSON, patchJSON).Scan(&patched); err != nil {
+		return nil, fmt.Errorf("failed to patch event data: %w", err)
+	}
+
+	if _, err := transaction.Exec("UPDATE events SET data_json = json(?) WHERE id = ?", d.sealData(patched), id); err != nil {
+		return nil, fmt.Errorf("failed to update event: %w", err)
+	}
+	if err := transaction.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return d.GetEvent(id)
+}
@@ -0,0 +1,292 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/encryption"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func testEncryptionKey(t *testing.T, fill byte) *encryption.Key {
+	t.Helper()
+	key, err := encryption.NewKey(bytes.Repeat([]byte{fill}, encryption.KeySize))
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	return key
+}
+
+// rawContains reports whether any stored url, title or data_json contains text
+func rawContains(t *testing.T, db *Database, text string) bool {
+	t.Helper()
+	var count int
+	err := db.db.QueryRow(
+		"SELECT COUNT(*) FROM events WHERE instr(url, ?) OR instr(coalesce(title, ''), ?) OR instr(data_json, ?)",
+		text, text, text,
+	).Scan(&count)
+	if err != nil {
+		t.Fatalf("Failed to query raw rows: %v", err)
+	}
+	return count > 0
+}
+
+func encryptionTestEvents() []models.Event {
+	title := "Secret Project"
+	session := "session-1"
+	field := "comment"
+	return []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://docs.example.com/plan", Title: &title, Type: "navigate", Data: map[string]any{}, SessionID: &session},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://docs.example.com/plan", Title: &title, Type: "input", Data: map[string]any{"value": "launch in may"}, SessionID: &session, FieldID: &field},
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://other.org/", Type: "click", Data: map[string]any{"text": "Buy"}, SessionID: &session},
+	}
+}
+
+func TestEncryptedEventsRoundTrip(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.EnableEncryption(testEncryptionKey(t, 1)); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	if err := db.InsertEvents(encryptionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	for _, text := range []string{"docs.example.com", "Secret Project", "launch in may"} {
+		if rawContains(t, db, text) {
+			t.Errorf("Expected %q not to be stored in plaintext", text)
+		}
+	}
+
+	events, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	if events[1].URL != "https://docs.example.com/plan" || *events[1].Title != "Secret Project" || events[1].Data["value"] != "launch in may" {
+		t.Errorf("Unexpected decrypted event: %+v", events[1])
+	}
+
+	// Input upserts still deduplicate on the encrypted url
+	update := encryptionTestEvents()[1:2]
+	update[0].TSUTC = 2500
+	update[0].Data = map[string]any{"value": "launch in june"}
+	if err := db.InsertEvents(update); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	url := "https://docs.example.com/plan"
+	domain := "example.com"
+	eventType := "input"
+	tests := []struct {
+		name   string
+		filter EventFilter
+		want   int
+	}{
+		{"url", EventFilter{URL: &url}, 2},
+		{"domain", EventFilter{Domain: &domain}, 2},
+		{"type", EventFilter{EventType: &eventType}, 1},
+	}
+	for _, tt := range tests {
+		events, err := db.GetEvents(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: GetEvents failed: %v", tt.name, err)
+		}
+		if len(events) != tt.want {
+			t.Errorf("%s: expected %d events, got %d", tt.name, tt.want, len(events))
+		}
+	}
+
+	inputs, _ := db.GetEvents(EventFilter{EventType: &eventType})
+	if inputs[0].Data["value"] != "launch in june" {
+		t.Errorf("Expected upserted value, got %v", inputs[0].Data["value"])
+	}
+
+	patched, err := db.PatchEventData(inputs[0].ID, map[string]any{"value": nil, "note": "redacted"})
+	if err != nil {
+		t.Fatalf("PatchEventData failed: %v", err)
+	}
+	if _, ok := patched.Data["value"]; ok || patched.Data["note"] != "redacted" {
+		t.Errorf("Unexpected patched data: %v", patched.Data)
+	}
+	if rawContains(t, db, "redacted") {
+		t.Error("Expected patched data to be stored encrypted")
+	}
+
+	stats, err := db.GetStats(EventFilter{}, 10)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if len(stats.TopDomains) != 2 || stats.TopDomains[0].Domain != "docs.example.com" {
+		t.Errorf("Unexpected top domains: %+v", stats.TopDomains)
+	}
+
+	if _, err := db.SearchEvents("launch", EventFilter{}); !errors.Is(err, ErrSearchUnavailable) {
+		t.Errorf("Expected ErrSearchUnavailable, got %v", err)
+	}
+}
+
+func TestEncryptedRawURL(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.EnableEncryption(testEncryptionKey(t, 1)); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	event := encryptionTestEvents()[0]
+	captured := "https://docs.example.com/plan?utm_source=mail"
+	event.RawURL = &captured
+	if err := db.InsertEvents([]models.Event{event}); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	var plaintext int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM events WHERE instr(raw_url, 'utm_source')").Scan(&plaintext); err != nil {
+		t.Fatalf("Failed to query raw rows: %v", err)
+	}
+	if plaintext != 0 {
+		t.Error("Expected raw_url not to be stored in plaintext")
+	}
+
+	events, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].RawURL == nil || *events[0].RawURL != captured {
+		t.Errorf("Expected the captured URL to read back, got %+v", events)
+	}
+}
+
+func TestEncryptExistingEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(encryptionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	if err := db.EnableEncryption(testEncryptionKey(t, 1)); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+
+	// Plaintext rows stay readable until they are migrated
+	events, err := db.GetEvents(EventFilter{})
+	if err != nil || len(events) != 3 {
+		t.Fatalf("Expected 3 readable events before migration, got %d, %v", len(events), err)
+	}
+
+	count, err := db.EncryptEvents(2)
+	if err != nil {
+		t.Fatalf("EncryptEvents failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 encrypted events, got %d", count)
+	}
+	if rawContains(t, db, "launch in may") || rawContains(t, db, "other.org") {
+		t.Error("Expected no plaintext after migration")
+	}
+	if count, _ := db.EncryptEvents(2); count != 0 {
+		t.Errorf("Expected nothing left to encrypt, got %d", count)
+	}
+
+	events, err = db.GetEvents(EventFilter{})
+	if err != nil || len(events) != 3 || events[0].Data["text"] != "Buy" {
+		t.Errorf("Expected migrated events to read back, got %+v, %v", events, err)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	oldKey := testEncryptionKey(t, 1)
+	newKey := testEncryptionKey(t, 2)
+
+	if err := db.EnableEncryption(oldKey); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	if err := db.InsertEvents(encryptionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	count, err := db.RotateKey(newKey, 1)
+	if err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 re-encrypted events, got %d", count)
+	}
+	if rawContains(t, db, oldKey.Prefix()) {
+		t.Error("Expected no values left under the old key")
+	}
+
+	keyID, err := db.EncryptionKeyID()
+	if err != nil || keyID != newKey.ID() {
+		t.Errorf("Expected key id %s, got %s, %v", newKey.ID(), keyID, err)
+	}
+
+	url := "https://docs.example.com/plan"
+	events, err := db.GetEvents(EventFilter{URL: &url})
+	if err != nil || len(events) != 2 {
+		t.Errorf("Expected url lookups under the new key, got %d, %v", len(events), err)
+	}
+
+	// Dedup keys follow the re-encrypted URL, so the input still upserts
+	if err := db.InsertEvents(encryptionTestEvents()[1:2]); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	if total, err := db.CountEvents(EventFilter{}); err != nil || total != 3 {
+		t.Errorf("Expected the input to be updated in place, got %d events, %v", total, err)
+	}
+
+	if err := db.EnableEncryption(oldKey); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Expected ErrWrongKey for the old key, got %v", err)
+	}
+}
+
+func TestEncryptedDatabaseSkipsSearchIndex(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.EnableEncryption(testEncryptionKey(t, 1)); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+
+	reopened, err := NewDatabase(databaseFile(t, db))
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	defer reopened.Close()
+
+	var tables int
+	if err := reopened.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'events_fts'").Scan(&tables); err != nil {
+		t.Fatalf("Failed to query schema: %v", err)
+	}
+	if tables != 0 {
+		t.Error("Expected no search index on an encrypted database")
+	}
+
+	salt, err := reopened.EncryptionSalt()
+	if err != nil || len(salt) == 0 {
+		t.Fatalf("EncryptionSalt failed: %v", err)
+	}
+	again, _ := db.EncryptionSalt()
+	if !bytes.Equal(salt, again) {
+		t.Error("Expected the salt to be stored once")
+	}
+}
+
+// databaseFile returns the path of the main database file
+func databaseFile(t *testing.T, db *Database) string {
+	t.Helper()
+	var seq int
+	var name, file string
+	if err := db.db.QueryRow("PRAGMA database_list").Scan(&seq, &name, &file); err != nil {
+		t.Fatalf("Failed to query database file: %v", err)
+	}
+	return file
+}
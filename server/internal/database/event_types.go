@@ -0,0 +1,528 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/jsonschema"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+var (
+	// ErrEventTypeNotFound is returned when no event type has the requested name
+	ErrEventTypeNotFound = errors.New("event type not found")
+	// ErrEventTypeExists is returned when registering a name that is already taken
+	ErrEventTypeExists = errors.New("event type already exists")
+	// ErrBuiltinEventType is returned when deleting one of the built-in types
+	ErrBuiltinEventType = errors.New("built-in event types cannot be deleted")
+	// ErrInvalidEventType wraps every validation failure of an event type definition
+	ErrInvalidEventType = errors.New("invalid event type")
+)
+
+// DefaultRetentionClass is used for event types registered without one
+const DefaultRetentionClass = "standard"
+
+// metaEventTypesVersion counts the changes to event_types, so that processes
+// sharing the database notice when another one changed the registry
+const metaEventTypesVersion = "event_types_version"
+
+// eventTypesCheckInterval bounds how often lookups compare the cached registry
+// with the stored version
+const eventTypesCheckInterval = time.Second
+
+// dedupFields are the event columns a dedup key may be built from. Title and
+// data are left out: they are encrypted non-deterministically at rest, so equal
+// values would not compare equal.
+var dedupFields = []string{"url", "session_id", "field_id"}
+
+var identifierPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// builtinEventTypes are the types the extension has always sent. They are
+// seeded by the migration that introduced the registry.
+var builtinEventTypes = []models.EventType{
+	{Name: "navigate", RetentionClass: "history"},
+	{Name: "visible_text", DedupKey: []string{"url", "session_id"}, RetentionClass: "content"},
+	{Name: "click", RetentionClass: "interaction"},
+	{Name: "input", DedupKey: []string{"url", "field_id", "session_id"}, RetentionClass: "content"},
+	{Name: "focus", RetentionClass: "interaction"},
+}
+
+// createEventTypesTable adds the registry and rebuilds events without the
+// CHECK constraint that limited type to the built-in names. The per-type
+// unique indexes used for upserts are replaced by one index on dedup_key,
+// which InsertEvents fills from the type's DedupKey.
+func createEventTypesTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS event_types(
+	  name            TEXT    PRIMARY KEY,
+	  schema_json     TEXT    NOT NULL CHECK (json_valid(schema_json)),
+	  dedup_key       TEXT    NOT NULL DEFAULT '',
+	  retention_class TEXT    NOT NULL,
+	  builtin         INTEGER NOT NULL DEFAULT 0,
+	  created_utc     INTEGER NOT NULL
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create event types table: %w", err)
+	}
+
+	now := time.Now().UnixMilli()
+	for _, eventType := range builtinEventTypes {
+		_, err := tx.Exec(
+			"INSERT OR IGNORE INTO event_types(name, schema_json, dedup_key, retention_class, builtin, created_utc) VALUES(?,?,?,?,1,?)",
+			eventType.Name, `{"type":"object"}`, strings.Join(eventType.DedupKey, ","), eventType.RetentionClass, now,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to register event type %s: %w", eventType.Name, err)
+		}
+	}
+
+	// Dropping events also drops its search triggers; createSearchIndex
+	// recreates them below, and events_fts keeps its rows since ids are copied
+	_, err = tx.Exec(`
+	CREATE TABLE events_new(
+	  id         INTEGER PRIMARY KEY,
+	  ts_utc     INTEGER NOT NULL,
+	  ts_iso     TEXT    NOT NULL,
+	  url        TEXT    NOT NULL,
+	  title      TEXT,
+	  type       TEXT    NOT NULL,
+	  data_json  TEXT    NOT NULL CHECK (json_valid(data_json)),
+	  session_id TEXT,
+	  field_id   TEXT,
+	  dedup_key  TEXT
+	);
+	INSERT INTO events_new(id, ts_utc, ts_iso, url, title, type, data_json, session_id, field_id)
+	SELECT id, ts_utc, ts_iso, url, title, type, data_json, session_id, field_id FROM events;
+	DROP TABLE events;
+	ALTER TABLE events_new RENAME TO events;
+
+	CREATE INDEX idx_events_ts   ON events(ts_utc);
+	CREATE INDEX idx_events_type ON events(type);
+	CREATE INDEX idx_events_url  ON events(url);
+
+	-- Partial index for faster input event queries
+	CREATE INDEX idx_input_lookup
+	ON events(session_id, field_id)
+	WHERE type = 'input';
+
+	-- One row per type and dedup key; events without a key are always inserted
+	CREATE UNIQUE INDEX idx_events_dedup
+	ON events(type, dedup_key)
+	WHERE dedup_key IS NOT NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild events table: %w", err)
+	}
+
+	if err := backfillDedupKeys(tx); err != nil {
+		return err
+	}
+	return createSearchIndex(tx)
+}
+
+// backfillDedupKeys sets dedup_key on events stored before the registry, so
+// that later upserts find them. The old unique indexes guarantee there are no
+// duplicate keys among them.
+func backfillDedupKeys(tx *sql.Tx) error {
+	for _, eventType := range builtinEventTypes {
+		if len(eventType.DedupKey) == 0 {
+			continue
+		}
+
+		rows, err := tx.Query("SELECT id, url, session_id, field_id FROM events WHERE type = ?", eventType.Name)
+		if err != nil {
+			return fmt.Errorf("failed to query events: %w", err)
+		}
+		keys := map[int64]string{}
+		for rows.Next() {
+			var id int64
+			var url string
+			var sessionID, fieldID *string
+			if err := rows.Scan(&id, &url, &sessionID, &fieldID); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			if key := dedupKey(eventType.DedupKey, url, sessionID, fieldID); key != nil {
+				keys[id] = *key
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+
+		for id, key := range keys {
+			if _, err := tx.Exec("UPDATE events SET dedup_key = ? WHERE id = ?", key, id); err != nil {
+				return fmt.Errorf("failed to set dedup key for event %d: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// builtinSchemas mirror the data interfaces in the extension's
+// src/shared/types.ts. Extra properties are allowed so that the extension can
+// add fields before the schemas are updated.
+var builtinSchemas = map[string]string{
+	"navigate":     `{"type":"object","required":["from","to"],"properties":{"from":{"type":["string","null"]},"to":{"type":"string"}}}`,
+	"click":        `{"type":"object","required":["selector","text"],"properties":{"selector":{"type":"string"},"text":{"type":"string"}}}`,
+	"input":        `{"type":"object","required":["selector","value"],"properties":{"selector":{"type":"string"},"value":{"type":"string"}}}`,
+	"focus":        `{"type":"object","required":["selector","value"],"properties":{"selector":{"type":"string"},"value":{"type":"string"}}}`,
+	"visible_text": `{"type":"object","required":["text"],"properties":{"text":{"type":"string"}}}`,
+}
+
+// addEventDataSchemas gives the built-in types their real schemas, unless a
+// schema was already changed through the API, and adds the column where
+// lenient validation records what an event got wrong
+func addEventDataSchemas(tx *sql.Tx) error {
+	for name, schema := range builtinSchemas {
+		_, err := tx.Exec(
+			`UPDATE event_types SET schema_json = ? WHERE name = ? AND builtin = 1 AND schema_json = '{"type":"object"}'`,
+			schema, name,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to set schema of event type %s: %w", name, err)
+		}
+	}
+
+	if _, err := tx.Exec("ALTER TABLE events ADD COLUMN schema_violations TEXT"); err != nil {
+		return fmt.Errorf("failed to add schema violations column: %w", err)
+	}
+	return nil
+}
+
+// CheckEventData validates event.Data against the schema of its type and
+// returns the violations, or nil if the data matches or the type is unknown
+func (d *Database) CheckEventData(event models.Event) []jsonschema.Violation {
+	eventType, ok := d.lookupEventType(event.Type)
+	if !ok {
+		return nil
+	}
+
+	// Round-trip so that numbers and nested values have the types a decoded
+	// request body would have
+	encoded, err := json.Marshal(event.Data)
+	if err != nil {
+		return []jsonschema.Violation{{Message: "data cannot be encoded as JSON"}}
+	}
+	var data any
+	if err := json.Unmarshal(encoded, &data); err != nil {
+		return []jsonschema.Violation{{Message: "data cannot be encoded as JSON"}}
+	}
+	if data == nil {
+		// A missing data field is checked as an empty object
+		data = map[string]any{}
+	}
+	return eventType.schema.Validate(data)
+}
+
+// dedupKey joins the stored values of the given fields into the value of the
+// dedup_key column. It returns nil, meaning plain insert, when there are no
+// fields or any of them is unset. url is the stored (possibly encrypted) form.
+func dedupKey(fields []string, url string, sessionID, fieldID *string) *string {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "url":
+			values = append(values, url)
+		case "session_id":
+			if sessionID == nil {
+				return nil
+			}
+			values = append(values, *sessionID)
+		case "field_id":
+			if fieldID == nil {
+				return nil
+			}
+			values = append(values, *fieldID)
+		default:
+			return nil
+		}
+	}
+
+	// A JSON array keeps values containing separators unambiguous
+	encoded, _ := json.Marshal(values)
+	key := string(encoded)
+	return &key
+}
+
+// NormalizeEventType fills in defaults and validates an event type definition.
+// Errors wrap ErrInvalidEventType.
+func NormalizeEventType(eventType models.EventType) (models.EventType, error) {
+	if !identifierPattern.MatchString(eventType.Name) {
+		return eventType, fmt.Errorf("%w: name must be lowercase letters, digits and underscores, starting with a letter", ErrInvalidEventType)
+	}
+
+	if len(eventType.Schema) == 0 {
+		eventType.Schema = json.RawMessage(`{}`)
+	}
+	if _, err := jsonschema.Compile(eventType.Schema); err != nil {
+		return eventType, fmt.Errorf("%w: %v", ErrInvalidEventType, err)
+	}
+
+	for i, field := range eventType.DedupKey {
+		if !slices.Contains(dedupFields, field) {
+			return eventType, fmt.Errorf("%w: dedup_key field %q must be one of %s", ErrInvalidEventType, field, strings.Join(dedupFields, ", "))
+		}
+		if slices.Contains(eventType.DedupKey[:i], field) {
+			return eventType, fmt.Errorf("%w: dedup_key lists %q twice", ErrInvalidEventType, field)
+		}
+	}
+
+	if eventType.RetentionClass == "" {
+		eventType.RetentionClass = DefaultRetentionClass
+	}
+	if !identifierPattern.MatchString(eventType.RetentionClass) {
+		return eventType, fmt.Errorf("%w: retention_class must be lowercase letters, digits and underscores, starting with a letter", ErrInvalidEventType)
+	}
+	return eventType, nil
+}
+
+const eventTypeColumns = "name, schema_json, dedup_key, retention_class, builtin, created_utc"
+
+func scanEventType(row interface{ Scan(...any) error }) (models.EventType, error) {
+	var eventType models.EventType
+	var schema, dedup string
+	err := row.Scan(&eventType.Name, &schema, &dedup, &eventType.RetentionClass, &eventType.Builtin, &eventType.CreatedUTC)
+	eventType.Schema = json.RawMessage(schema)
+	if dedup != "" {
+		eventType.DedupKey = strings.Split(dedup, ",")
+	}
+	return eventType, err
+}
+
+// registeredType is a registry entry with its schema compiled
+type registeredType struct {
+	models.EventType
+	schema *jsonschema.Schema
+}
+
+// eventTypeRegistry is the cached registry and the stored version it was read at
+type eventTypeRegistry struct {
+	types   map[string]registeredType
+	version string
+}
+
+// loadEventTypes refreshes the in-memory registry that ValidateEvent,
+// CheckEventData and InsertEvents read, so they never query the table per event
+func (d *Database) loadEventTypes() error {
+	// Read before the types: a change in between only causes another reload
+	version, err := getMeta(d.db, metaEventTypesVersion)
+	if err != nil {
+		return err
+	}
+
+	rows, err := d.db.Query("SELECT " + eventTypeColumns + " FROM event_types")
+	if err != nil {
+		return fmt.Errorf("failed to query event types: %w", err)
+	}
+	defer rows.Close()
+
+	types := map[string]registeredType{}
+	for rows.Next() {
+		eventType, err := scanEventType(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		schema, err := jsonschema.Compile(eventType.Schema)
+		if err != nil {
+			return fmt.Errorf("failed to compile schema of event type %s: %w", eventType.Name, err)
+		}
+		types[eventType.Name] = registeredType{EventType: eventType, schema: schema}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+	d.eventTypes.Store(&eventTypeRegistry{types: types, version: version})
+	d.eventTypesChecked.Store(time.Now().UnixMilli())
+	return nil
+}
+
+// registeredTypes returns the cached registry, reloaded first if another
+// process changed it. The stored version is read at most once per
+// eventTypesCheckInterval; until then lookups stay in memory.
+func (d *Database) registeredTypes() map[string]registeredType {
+	now := time.Now().UnixMilli()
+	checked := d.eventTypesChecked.Load()
+	if now-checked >= eventTypesCheckInterval.Milliseconds() && d.eventTypesChecked.CompareAndSwap(checked, now) {
+		version, err := getMeta(d.db, metaEventTypesVersion)
+		if err != nil {
+			log.Printf("Failed to check event types: %v", err)
+		} else if version != d.eventTypes.Load().version {
+			if err := d.loadEventTypes(); err != nil {
+				log.Printf("Failed to reload event types: %v", err)
+			}
+		}
+	}
+	return d.eventTypes.Load().types
+}
+
+// bumpEventTypesVersion records a change to event_types within transaction
+func bumpEventTypesVersion(transaction *sql.Tx) error {
+	_, err := transaction.Exec(
+		"INSERT INTO meta(key, value) VALUES(?, '1') ON CONFLICT(key) DO UPDATE SET value = CAST(value AS INTEGER) + 1",
+		metaEventTypesVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update event types version: %w", err)
+	}
+	return nil
+}
+
+// changeEventTypes runs statement in a transaction that also bumps the
+// registry version, and returns how many rows it affected
+func (d *Database) changeEventTypes(statement string, args ...any) (int64, error) {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	result, err := transaction.Exec(statement, args...)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if count == 0 {
+		return 0, nil
+	}
+
+	if err := bumpEventTypesVersion(transaction); err != nil {
+		return 0, err
+	}
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return count, nil
+}
+
+// lookupEventType returns the registered type with the given name
+func (d *Database) lookupEventType(name string) (registeredType, bool) {
+	eventType, ok := d.registeredTypes()[name]
+	return eventType, ok
+}
+
+// ListEventTypes returns every registered event type ordered by name
+func (d *Database) ListEventTypes() []models.EventType {
+	types := d.registeredTypes()
+	list := make([]models.EventType, 0, len(types))
+	for _, eventType := range types {
+		list = append(list, eventType.EventType)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// GetEventType returns the registered event type with the given name
+func (d *Database) GetEventType(name string) (*models.EventType, error) {
+	eventType, ok := d.lookupEventType(name)
+	if !ok {
+		return nil, ErrEventTypeNotFound
+	}
+	return &eventType.EventType, nil
+}
+
+// EventTypesInClass returns the names of the types in a retention class, sorted
+func (d *Database) EventTypesInClass(class string) []string {
+	var names []string
+	for _, eventType := range d.ListEventTypes() {
+		if eventType.RetentionClass == class {
+			names = append(names, eventType.Name)
+		}
+	}
+	return names
+}
+
+// CreateEventType registers a new event type. Events of that type are accepted
+// as soon as it returns. A name taken meanwhile, by a concurrent request or
+// another process, also gives ErrEventTypeExists.
+func (d *Database) CreateEventType(eventType models.EventType) (*models.EventType, error) {
+	eventType, err := NormalizeEventType(eventType)
+	if err != nil {
+		return nil, err
+	}
+	if _, exists := d.lookupEventType(eventType.Name); exists {
+		return nil, ErrEventTypeExists
+	}
+
+	eventType.Builtin = false
+	eventType.CreatedUTC = time.Now().UnixMilli()
+	count, err := d.changeEventTypes(
+		"INSERT INTO event_types("+eventTypeColumns+") VALUES(?,?,?,?,0,?) ON CONFLICT(name) DO NOTHING",
+		eventType.Name, string(eventType.Schema), strings.Join(eventType.DedupKey, ","), eventType.RetentionClass, eventType.CreatedUTC,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert event type: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrEventTypeExists
+	}
+
+	if err := d.loadEventTypes(); err != nil {
+		return nil, err
+	}
+	return &eventType, nil
+}
+
+// UpdateEventType replaces the schema, dedup key and retention class of a
+// registered type. A changed dedup key only applies to events stored afterwards.
+func (d *Database) UpdateEventType(eventType models.EventType) (*models.EventType, error) {
+	eventType, err := NormalizeEventType(eventType)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := d.changeEventTypes(
+		"UPDATE event_types SET schema_json = ?, dedup_key = ?, retention_class = ? WHERE name = ?",
+		string(eventType.Schema), strings.Join(eventType.DedupKey, ","), eventType.RetentionClass, eventType.Name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update event type: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrEventTypeNotFound
+	}
+
+	if err := d.loadEventTypes(); err != nil {
+		return nil, err
+	}
+	return d.GetEventType(eventType.Name)
+}
+
+// DeleteEventType unregisters a type so that new events of it are rejected.
+// Stored events are kept.
+func (d *Database) DeleteEventType(name string) error {
+	eventType, ok := d.lookupEventType(name)
+	if !ok {
+		return ErrEventTypeNotFound
+	}
+	if eventType.Builtin {
+		return ErrBuiltinEventType
+	}
+
+	count, err := d.changeEventTypes("DELETE FROM event_types WHERE name = ?", name)
+	if err != nil {
+		return fmt.Errorf("failed to delete event type: %w", err)
+	}
+	if count == 0 {
+		return ErrEventTypeNotFound
+	}
+	return d.loadEventTypes()
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestBuiltinEventTypes(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	types := db.ListEventTypes()
+	if len(types) != len(builtinEventTypes) {
+		t.Fatalf("Expected %d built-in types, got %d", len(builtinEventTypes), len(types))
+	}
+	for _, eventType := range types {
+		if !eventType.Builtin {
+			t.Errorf("Expected %s to be built-in", eventType.Name)
+		}
+	}
+
+	input, err := db.GetEventType("input")
+	if err != nil {
+		t.Fatalf("GetEventType failed: %v", err)
+	}
+	if len(input.DedupKey) != 3 || input.RetentionClass != "content" {
+		t.Errorf("Unexpected input type: %+v", input)
+	}
+
+	if err := db.DeleteEventType("navigate"); !errors.Is(err, ErrBuiltinEventType) {
+		t.Errorf("Expected ErrBuiltinEventType, got %v", err)
+	}
+}
+
+func TestRegisteredEventTypeLifecycle(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionID := "s1"
+	event := models.Event{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "tab_switch", Data: map[string]any{}, SessionID: &sessionID}
+	if err := db.InsertEvents([]models.Event{event}); err == nil {
+		t.Fatal("Expected unregistered type to be rejected")
+	}
+
+	created, err := db.CreateEventType(models.EventType{Name: "tab_switch", DedupKey: []string{"url", "session_id"}})
+	if err != nil {
+		t.Fatalf("CreateEventType failed: %v", err)
+	}
+	if created.Builtin || created.RetentionClass != DefaultRetentionClass || string(created.Schema) != "{}" {
+		t.Errorf("Unexpected defaults: %+v", created)
+	}
+	if _, err := db.CreateEventType(models.EventType{Name: "tab_switch"}); !errors.Is(err, ErrEventTypeExists) {
+		t.Errorf("Expected ErrEventTypeExists, got %v", err)
+	}
+
+	// Two events with the same url and session collapse into one row
+	second := event
+	second.TSUTC = 2000
+	if err := db.InsertEvents([]models.Event{event, second}); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	// Without a session the key is incomplete, so the event is a new row
+	noSession := event
+	noSession.SessionID = nil
+	if err := db.InsertEvents([]models.Event{noSession}); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	eventType := "tab_switch"
+	count, err := db.CountEvents(EventFilter{EventType: &eventType})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 stored tab_switch events, got %d", count)
+	}
+
+	updated, err := db.UpdateEventType(models.EventType{Name: "tab_switch", RetentionClass: "interaction"})
+	if err != nil {
+		t.Fatalf("UpdateEventType failed: %v", err)
+	}
+	if len(updated.DedupKey) != 0 || updated.RetentionClass != "interaction" {
+		t.Errorf("Unexpected updated type: %+v", updated)
+	}
+	if names := db.EventTypesInClass("interaction"); len(names) != 3 || names[2] != "tab_switch" {
+		t.Errorf("Unexpected interaction class: %v", names)
+	}
+
+	if err := db.DeleteEventType("tab_switch"); err != nil {
+		t.Fatalf("DeleteEventType failed: %v", err)
+	}
+	if err := db.InsertEvents([]models.Event{event}); err == nil {
+		t.Error("Expected deleted type to be rejected")
+	}
+	if _, err := db.UpdateEventType(models.EventType{Name: "tab_switch"}); !errors.Is(err, ErrEventTypeNotFound) {
+		t.Errorf("Expected ErrEventTypeNotFound, got %v", err)
+	}
+}
+
+func TestEventTypesSharedBetweenProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	server, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	defer server.Close()
+	other, err := OpenDatabase(path, false)
+	if err != nil {
+		t.Fatalf("OpenDatabase failed: %v", err)
+	}
+	defer other.Close()
+
+	if _, err := other.CreateEventType(models.EventType{Name: "tab_switch"}); err != nil {
+		t.Fatalf("CreateEventType failed: %v", err)
+	}
+
+	// The server's cache predates the type; creating it there must still
+	// conflict instead of failing on the primary key
+	if _, err := server.CreateEventType(models.EventType{Name: "tab_switch"}); !errors.Is(err, ErrEventTypeExists) {
+		t.Errorf("Expected ErrEventTypeExists, got %v", err)
+	}
+
+	// Once the check interval has passed, the server sees the new type
+	server.eventTypesChecked.Store(0)
+	if _, err := server.GetEventType("tab_switch"); err != nil {
+		t.Errorf("Expected the type created elsewhere, got %v", err)
+	}
+
+	if err := other.DeleteEventType("tab_switch"); err != nil {
+		t.Fatalf("DeleteEventType failed: %v", err)
+	}
+	server.eventTypesChecked.Store(0)
+	if _, err := server.GetEventType("tab_switch"); !errors.Is(err, ErrEventTypeNotFound) {
+		t.Errorf("Expected the deleted type to be gone, got %v", err)
+	}
+}
+
+func TestNormalizeEventTypeRejectsInvalid(t *testing.T) {
+	tests := []models.EventType{
+		{Name: ""},
+		{Name: "Tab-Switch"},
+		{Name: "tab_switch", Schema: json.RawMessage(`[1,2]`)},
+		{Name: "tab_switch", DedupKey: []string{"title"}},
+		{Name: "tab_switch", DedupKey: []string{"url", "url"}},
+		{Name: "tab_switch", RetentionClass: "Short Term"},
+	}
+
+	for _, eventType := range tests {
+		if _, err := NormalizeEventType(eventType); !errors.Is(err, ErrInvalidEventType) {
+			t.Errorf("Expected ErrInvalidEventType for %+v, got %v", eventType, err)
+		}
+	}
+}
+
+func TestMigrateBackfillsDedupKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+
+	// A database from before the registry, holding one input event
+	raw := openRawDB(t, path)
+	if err := migrate(raw, path, migrations[:4]); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+	_, err := raw.Exec(`INSERT INTO events(ts_utc, ts_iso, url, type, data_json, session_id, field_id)
+	VALUES (1000, '1970-01-01T00:00:01Z', 'https://example.com', 'input', '{"value":"a"}', 's1', '#name')`)
+	raw.Close()
+	if err != nil {
+		t.Fatalf("Failed to insert legacy event: %v", err)
+	}
+
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	defer db.Close()
+
+	sessionID, fieldID := "s1", "#name"
+	events := []models.Event{{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com", Type: "input", Data: map[string]any{"value": "ab"}, SessionID: &sessionID, FieldID: &fieldID}}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	stored, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(stored) != 1 || stored[0].Data["value"] != "ab" {
+		t.Errorf("Expected the legacy input to be updated in place, got %+v", stored)
+	}
+}
+
+func TestCheckEventData(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	tests := []struct {
+		name       string
+		event      models.Event
+		violations int
+	}{
+		{"valid navigate", models.Event{Type: "navigate", Data: map[string]any{"from": nil, "to": "https://example.com"}}, 0},
+		{"navigate without to", models.Event{Type: "navigate", Data: map[string]any{"from": nil}}, 1},
+		{"click without selector", models.Event{Type: "click", Data: map[string]any{"text": "Buy"}}, 1},
+		{"input with numeric value", models.Event{Type: "input", Data: map[string]any{"selector": "#q", "value": 42}}, 1},
+		{"visible_text without data", models.Event{Type: "visible_text"}, 1},
+		{"unknown type", models.Event{Type: "tab_switch", Data: map[string]any{}}, 0},
+	}
+
+	for _, tt := range tests {
+		if violations := db.CheckEventData(tt.event); len(violations) != tt.violations {
+			t.Errorf("%s: expected %d violations, got %v", tt.name, tt.violations, violations)
+		}
+	}
+}
+
+func TestFlaggedFilter(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "click", Data: map[string]any{}, SchemaViolations: []string{"/selector: is required"}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com", Type: "click", Data: map[string]any{"selector": "#a", "text": "A"}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	flagged := true
+	stored, err := db.GetEvents(EventFilter{Flagged: &flagged})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(stored) != 1 || len(stored[0].SchemaViolations) != 1 || stored[0].TSUTC != 1000 {
+		t.Errorf("Expected the flagged click, got %+v", stored)
+	}
+
+	flagged = false
+	if count, err := db.CountEvents(EventFilter{Flagged: &flagged}); err != nil || count != 1 {
+		t.Errorf("Expected 1 clean event, got %d, %v", count, err)
+	}
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"database/sql/driver"
+
+	"modernc.org/sqlite"
+)
+
+// SQL helper functions registered on every connection.
+func init() {
+	// url_host(url) returns the lowercase host of a URL, for domain filters.
+	// It is not deterministic: an encrypted URL has no host until its key is
+	// added to the keyring, so it must not be used in indexes or constraints.
+	sqlite.MustRegisterScalarFunction("url_host", 1, func(_ *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		rawURL, ok := args[0].(string)
+		if !ok {
+			return nil, nil
+		}
+		// Encrypted URLs are readable once their key is in the keyring
+		rawURL, err := openURL(rawURL)
+		if err != nil {
+			return nil, nil
+		}
+		return hostOf(rawURL), nil
+	})
+}
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Backup writes a consistent copy of the database to path with VACUUM INTO,
+// which is safe while other connections keep writing. It refuses to
+// overwrite an existing file.
+func (d *Database) Backup(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup file already exists: %s", path)
+	}
+	if _, err := d.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// VerifyBackup checks that the file at path is an intact database this
+// version of the agent can open, without modifying it
+func VerifyBackup(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+	if info.IsDir() || info.Size() == 0 {
+		return fmt.Errorf("%s is not a database backup", path)
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer db.Close()
+
+	backup := &Database{db: db}
+	problems, err := backup.integrityProblems()
+	if err != nil {
+		return fmt.Errorf("%s is not a readable database: %w", path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("backup is corrupt: %s", strings.Join(problems, "; "))
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("%w: backup is at version %d, latest known is %d", ErrSchemaTooNew, version, LatestSchemaVersion())
+	}
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'events'").Scan(&tables); err != nil {
+		return fmt.Errorf("failed to inspect backup: %w", err)
+	}
+	if tables == 0 {
+		return fmt.Errorf("%s holds no events table", path)
+	}
+	return nil
+}
+
+// Check statuses reported by Diagnose
+const (
+	CheckOK   = "ok"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+// Check is the outcome of one health check
+type Check struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+// Diagnose checks the database file and schema for problems the agent
+// cannot fix on its own. Checks that cannot run are reported as failed.
+func (d *Database) Diagnose() []Check {
+	var checks []Check
+	add := func(name, status, detail string) {
+		checks = append(checks, Check{Name: name, Status: status, Detail: detail})
+	}
+
+	problems, err := d.integrityProblems()
+	switch {
+	case err != nil:
+		add("integrity", CheckFail, err.Error())
+	case len(problems) > 0:
+		add("integrity", CheckFail, strings.Join(problems, "; "))
+	default:
+		add("integrity", CheckOK, "no corruption found")
+	}
+
+	version, err := d.SchemaVersion()
+	switch {
+	case err != nil:
+		add("schema", CheckFail, err.Error())
+	case version != LatestSchemaVersion():
+		add("schema", CheckFail, fmt.Sprintf("at version %d, expected %d", version, LatestSchemaVersion()))
+	default:
+		add("schema", CheckOK, fmt.Sprintf("version %d", version))
+	}
+
+	var journalMode string
+	if err := d.db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		add("journal mode", CheckFail, err.Error())
+	} else if journalMode != "wal" {
+		add("journal mode", CheckWarn, journalMode+": concurrent readers will block writes")
+	} else {
+		add("journal mode", CheckOK, journalMode)
+	}
+
+	var autoVacuum int
+	if err := d.db.QueryRow("PRAGMA auto_vacuum").Scan(&autoVacuum); err != nil {
+		add("auto vacuum", CheckFail, err.Error())
+	} else if autoVacuum != autoVacuumIncremental {
+		add("auto vacuum", CheckWarn, "not incremental: pruned space is only returned by a full vacuum")
+	} else {
+		add("auto vacuum", CheckOK, "incremental")
+	}
+
+	keyID, err := d.EncryptionKeyID()
+	switch {
+	case err != nil:
+		add("search index", CheckFail, err.Error())
+	case keyID != "":
+		add("search index", CheckOK, "disabled on encrypted databases")
+	default:
+		// Missing or extra rows compared with the triggers' view of events,
+		// read without writing so it works on read-only handles
+		var mismatched int64
+		err := d.db.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM events WHERE type IN ` + searchableTypes + ` AND id NOT IN (SELECT rowid FROM events_fts))
+		     + (SELECT COUNT(*) FROM events_fts WHERE rowid NOT IN (SELECT id FROM events WHERE type IN ` + searchableTypes + `))
+		`).Scan(&mismatched)
+		switch {
+		case err != nil:
+			add("search index", CheckFail, err.Error())
+		case mismatched > 0:
+			add("search index", CheckFail, fmt.Sprintf("%d events missing or left over: rebuild it with INSERT INTO events_fts(events_fts) VALUES ('rebuild')", mismatched))
+		default:
+			add("search index", CheckOK, "consistent")
+		}
+	}
+
+	var rejected int64
+	if err := d.db.QueryRow("SELECT COUNT(*) FROM rejected_events").Scan(&rejected); err != nil {
+		add("quarantine", CheckFail, err.Error())
+	} else if rejected > 0 {
+		add("quarantine", CheckWarn, fmt.Sprintf("%d rejected events, see GET /rejected-events", rejected))
+	} else {
+		add("quarantine", CheckOK, "empty")
+	}
+
+	return checks
+}
+
+// integrityProblems runs PRAGMA integrity_check and returns what it found
+func (d *Database) integrityProblems() ([]string, error) {
+	rows, err := d.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return nil, fmt.Errorf("failed to run integrity check: %w", err)
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, fmt.Errorf("failed to scan integrity check: %w", err)
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return problems, nil
+}
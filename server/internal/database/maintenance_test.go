@@ -0,0 +1,69 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBackup(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	insertTimeline(t, db, "click", 1000, 2000, 3000)
+
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := db.Backup(path); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := db.Backup(path); err == nil {
+		t.Error("Expected an error when the backup file exists")
+	}
+
+	if err := VerifyBackup(path); err != nil {
+		t.Errorf("Expected the backup to verify, got %v", err)
+	}
+
+	copied, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("Failed to open backup: %v", err)
+	}
+	defer copied.Close()
+	count, err := copied.CountEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 events in the backup, got %d", count)
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	insertTimeline(t, db, "click", 1000)
+
+	statuses := map[string]string{}
+	for _, check := range db.Diagnose() {
+		statuses[check.Name] = check.Status
+	}
+	for _, name := range []string{"integrity", "schema", "journal mode", "auto vacuum", "search index", "quarantine"} {
+		if statuses[name] != CheckOK {
+			t.Errorf("Expected check %q to pass, got %q", name, statuses[name])
+		}
+	}
+
+	if _, err := db.db.Exec("INSERT INTO rejected_events(received_utc, event_index, reason, payload) VALUES (1, 0, 'bad', '{}')"); err != nil {
+		t.Fatalf("Failed to quarantine an event: %v", err)
+	}
+	if _, err := db.db.Exec("PRAGMA user_version = 1"); err != nil {
+		t.Fatalf("Failed to set schema version: %v", err)
+	}
+	statuses = map[string]string{}
+	for _, check := range db.Diagnose() {
+		statuses[check.Name] = check.Status
+	}
+	if statuses["quarantine"] != CheckWarn || statuses["schema"] != CheckFail {
+		t.Errorf("Expected a quarantine warning and a schema failure, got %v", statuses)
+	}
+}
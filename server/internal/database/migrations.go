@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// migration is one step of the schema history. Migrations run in order, each
+// in its own transaction together with the bump of PRAGMA user_version, so a
+// failed step leaves the database at the previous version.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+// migrations is the schema history. Append new steps with the next version;
+// never edit or reorder steps that have shipped. Databases created before
+// versioning are at version 0, which is why the early steps use IF NOT EXISTS.
+var migrations = []migration{
+	{1, "create events table", createTables},
+	{2, "create meta table", createMetaTable},
+	{3, "create search index", createSearchIndex},
+	{4, "create domain rules table", createDomainRulesTable},
+	{5, "create event types registry", createEventTypesTable},
+	{6, "add event data schemas", addEventDataSchemas},
+	{7, "create rejected events table", createRejectedEventsTable},
+	{8, "create sessions table", createSessionsTable},
+	{9, "create pages tables", createPagesTables},
+	{10, "create activity table", createActivityTable},
+	{11, "add raw URL column", addRawURLColumn},
+}
+
+var (
+	// ErrSchemaTooNew is returned when the database was written by a newer version of the agent
+	ErrSchemaTooNew = errors.New("database schema is newer than this version of the agent supports")
+	// ErrSchemaOutdated is returned by OpenDatabase when migrations are pending
+	ErrSchemaOutdated = errors.New("database schema is out of date: run browsetrace-agent migrate")
+)
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func schemaVersion(db queryRower) (int, error) {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// LatestSchemaVersion is the version migrations bring a database to
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the migration version the database is at
+func (d *Database) SchemaVersion() (int, error) {
+	return schemaVersion(d.db)
+}
+
+// migrate brings the database at databasePath up to the latest migration.
+// Existing databases are backed up next to the original file first.
+func migrate(db *sql.DB, databasePath string, migrations []migration) error {
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].version
+	}
+	if current > latest {
+		return fmt.Errorf("%w: database is at version %d, latest known is %d", ErrSchemaTooNew, current, latest)
+	}
+	if current == latest {
+		return nil
+	}
+
+	existing, err := backupBeforeMigration(db, databasePath, current)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := runMigration(db, m); err != nil {
+			return err
+		}
+		if existing {
+			log.Printf("Applied migration %d: %s", m.version, m.name)
+		}
+	}
+	return nil
+}
+
+func runMigration(db *sql.DB, m migration) error {
+	transaction, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	if err := m.up(transaction); err != nil {
+		return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+	}
+	if _, err := transaction.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version)); err != nil {
+		return fmt.Errorf("failed to set schema version: %w", err)
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// MigrationBackupsKept is how many pre-migration backups are kept per schema
+// version. They stay after a successful upgrade so it can be undone, and may
+// hold events deleted since, or stored before encryption was turned on.
+const MigrationBackupsKept = 3
+
+// backupBeforeMigration copies a database that already holds tables to
+// <path>.v<version>-<timestamp>.bak and reports whether it did, then deletes
+// all but the MigrationBackupsKept newest backups from that version. New,
+// empty databases are not backed up.
+func backupBeforeMigration(db *sql.DB, databasePath string, version int) (bool, error) {
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tables); err != nil {
+		return false, fmt.Errorf("failed to inspect database: %w", err)
+	}
+	if tables == 0 {
+		return false, nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d-%s.bak", databasePath, version, time.Now().UTC().Format("20060102T150405Z"))
+	if _, err := os.Stat(backupPath); err == nil {
+		return false, fmt.Errorf("backup file already exists: %s", backupPath)
+	}
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return false, fmt.Errorf("failed to back up database before migration: %w", err)
+	}
+	log.Printf("Backed up database to %s before migrating from version %d", backupPath, version)
+
+	if err := pruneMigrationBackups(databasePath, version); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// pruneMigrationBackups deletes all but the MigrationBackupsKept newest
+// backups taken before migrating from version
+func pruneMigrationBackups(databasePath string, version int) error {
+	directory := filepath.Dir(databasePath)
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return fmt.Errorf("failed to list migration backups: %w", err)
+	}
+	prefix := fmt.Sprintf("%s.v%d-", filepath.Base(databasePath), version)
+	var backups []string
+	for _, entry := range entries {
+		if name := entry.Name(); strings.HasPrefix(name, prefix) && strings.HasSuffix(name, ".bak") {
+			backups = append(backups, name)
+		}
+	}
+
+	// Timestamps sort by name, oldest first
+	sort.Strings(backups)
+	for len(backups) > MigrationBackupsKept {
+		if err := os.Remove(filepath.Join(directory, backups[0])); err != nil {
+			return fmt.Errorf("failed to remove old migration backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
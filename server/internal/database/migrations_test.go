@@ -0,0 +1,257 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func openRawDB(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	return db
+}
+
+func TestNewDatabaseAtLatestVersion(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if latest := migrations[len(migrations)-1].version; version != latest {
+		t.Errorf("Expected version %d, got %d", latest, version)
+	}
+
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d", i, i+1, m.version)
+		}
+	}
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.db")
+
+	// The original unversioned schema, with one event in it
+	raw := openRawDB(t, path)
+	_, err := raw.Exec(`
+	CREATE TABLE events(
+	  id         INTEGER PRIMARY KEY,
+	  ts_utc     INTEGER NOT NULL,
+	  ts_iso     TEXT    NOT NULL,
+	  url        TEXT    NOT NULL,
+	  title      TEXT,
+	  type       TEXT    NOT NULL CHECK (type IN ('navigate','visible_text','click','input','focus')),
+	  data_json  TEXT    NOT NULL CHECK (json_valid(data_json)),
+	  session_id TEXT,
+	  field_id   TEXT
+	);
+	INSERT INTO events(ts_utc, ts_iso, url, type, data_json) VALUES (1000, '1970-01-01T00:00:01Z', 'https://example.com', 'visible_text', '{"text":"old page"}');
+	`)
+	raw.Close()
+	if err != nil {
+		t.Fatalf("Failed to create legacy schema: %v", err)
+	}
+
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	defer db.Close()
+
+	events, err := db.GetEvents(EventFilter{})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected the legacy event to survive, got %d, %v", len(events), err)
+	}
+	results, err := db.SearchEvents("old", EventFilter{})
+	if err != nil || len(results) != 1 {
+		t.Errorf("Expected the legacy event to be searchable, got %d, %v", len(results), err)
+	}
+
+	backups, _ := filepath.Glob(path + ".v0-*.bak")
+	if len(backups) != 1 {
+		t.Fatalf("Expected one backup, got %v", backups)
+	}
+	backup := openRawDB(t, backups[0])
+	defer backup.Close()
+	var count int
+	if err := backup.QueryRow("SELECT COUNT(*) FROM events").Scan(&count); err != nil || count != 1 {
+		t.Errorf("Expected the backup to hold the legacy event, got %d, %v", count, err)
+	}
+	var version int
+	if err := backup.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != 0 {
+		t.Errorf("Expected the backup to stay at version 0, got %d, %v", version, err)
+	}
+}
+
+func TestMigrationBackupsKeptPerVersion(t *testing.T) {
+	directory := t.TempDir()
+	path := filepath.Join(directory, "events.db")
+	db := openRawDB(t, path)
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE existing(x)"); err != nil {
+		t.Fatalf("Failed to prepare database: %v", err)
+	}
+
+	// Older backups from this version, and one from another version
+	old := []string{"events.db.v0-20240101T000000Z.bak", "events.db.v0-20240102T000000Z.bak",
+		"events.db.v0-20240103T000000Z.bak", "events.db.v1-20240101T000000Z.bak"}
+	for _, name := range old {
+		if err := os.WriteFile(filepath.Join(directory, name), nil, 0o600); err != nil {
+			t.Fatalf("Failed to create %s: %v", name, err)
+		}
+	}
+
+	steps := []migration{{1, "create a", func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE TABLE a(x)")
+		return err
+	}}}
+	if err := migrate(db, path, steps); err != nil {
+		t.Fatalf("migrate failed: %v", err)
+	}
+
+	backups, _ := filepath.Glob(path + ".v0-*.bak")
+	if len(backups) != MigrationBackupsKept {
+		t.Fatalf("Expected %d backups from version 0, got %v", MigrationBackupsKept, backups)
+	}
+	if filepath.Base(backups[0]) != "events.db.v0-20240102T000000Z.bak" {
+		t.Errorf("Expected the oldest backup to go, got %v", backups)
+	}
+	if _, err := os.Stat(filepath.Join(directory, "events.db.v1-20240101T000000Z.bak")); err != nil {
+		t.Errorf("Expected backups from other versions to stay: %v", err)
+	}
+}
+
+func TestNewDatabaseRefusesNewerSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+
+	raw := openRawDB(t, path)
+	_, err := raw.Exec(fmt.Sprintf("CREATE TABLE future(x); PRAGMA user_version = %d", len(migrations)+1))
+	raw.Close()
+	if err != nil {
+		t.Fatalf("Failed to prepare database: %v", err)
+	}
+
+	if _, err := NewDatabase(path); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("Expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestOpenDatabaseDoesNotMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+
+	raw := openRawDB(t, path)
+	_, err := raw.Exec(fmt.Sprintf("CREATE TABLE past(x); PRAGMA user_version = %d", len(migrations)-1))
+	raw.Close()
+	if err != nil {
+		t.Fatalf("Failed to prepare database: %v", err)
+	}
+
+	for _, readOnly := range []bool{true, false} {
+		if _, err := OpenDatabase(path, readOnly); !errors.Is(err, ErrSchemaOutdated) {
+			t.Errorf("Expected ErrSchemaOutdated (read-only %v), got %v", readOnly, err)
+		}
+	}
+
+	raw = openRawDB(t, path)
+	defer raw.Close()
+	var version int
+	if err := raw.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatalf("Failed to read version: %v", err)
+	}
+	if version != len(migrations)-1 {
+		t.Errorf("Expected version %d to be left alone, got %d", len(migrations)-1, version)
+	}
+	if matches, _ := filepath.Glob(path + ".v*.bak"); len(matches) != 0 {
+		t.Errorf("Expected no backup, got %v", matches)
+	}
+}
+
+func TestOpenDatabaseReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	db, err := NewDatabase(path)
+	if err != nil {
+		t.Fatalf("NewDatabase failed: %v", err)
+	}
+	event := models.Event{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "click", Data: map[string]any{}}
+	if err := db.InsertEvents([]models.Event{event}); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	db.Close()
+
+	readOnly, err := OpenDatabase(path, true)
+	if err != nil {
+		t.Fatalf("OpenDatabase failed: %v", err)
+	}
+	defer readOnly.Close()
+
+	events, err := readOnly.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected 1 event, got %d", len(events))
+	}
+	if err := readOnly.InsertEvents([]models.Event{event}); err == nil {
+		t.Error("Expected a read-only database to refuse writes")
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	db := openRawDB(t, path)
+	defer db.Close()
+
+	steps := []migration{
+		{1, "create a", func(tx *sql.Tx) error {
+			_, err := tx.Exec("CREATE TABLE a(x)")
+			return err
+		}},
+		{2, "create b then fail", func(tx *sql.Tx) error {
+			if _, err := tx.Exec("CREATE TABLE b(x)"); err != nil {
+				return err
+			}
+			return errors.New("boom")
+		}},
+	}
+
+	if err := migrate(db, path, steps); err == nil {
+		t.Fatal("Expected the failing migration to return an error")
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil || version != 1 {
+		t.Errorf("Expected version 1 after the failure, got %d, %v", version, err)
+	}
+	var tables int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'b'").Scan(&tables)
+	if tables != 0 {
+		t.Error("Expected the failed migration's changes to be rolled back")
+	}
+
+	// Fixing the step lets the next open finish the job
+	steps[1].up = func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE TABLE b(x)")
+		return err
+	}
+	if err := migrate(db, path, steps); err != nil {
+		t.Fatalf("Expected the retry to succeed: %v", err)
+	}
+	if version, _ := schemaVersion(db); version != 2 {
+		t.Errorf("Expected version 2, got %d", version)
+	}
+	if backups, _ := filepath.Glob(path + ".v1-*.bak"); len(backups) != 1 {
+		t.Errorf("Expected a backup before retrying from version 1, got %v", backups)
+	}
+}
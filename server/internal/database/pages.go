@@ -0,0 +1,431 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+	"github.com/vincentbai/browsetrace-server/internal/urlnorm"
+)
+
+// Pages aggregate navigate events by normalized URL. Each navigate event is a
+// visit in page_visits, credited with the time until the next navigate event
+// of its session, or until the session's last event for the session's last
+// visit. pages holds one row per URL; the titles a page has had are read from
+// the events of its visits, so deleting an event forgets its title too.
+
+// maxPageDwell caps the time one visit is credited with, so that a page left
+// open overnight does not count as a day spent on it
+const maxPageDwell = 30 * 60 * 1000 // milliseconds
+
+// maxPageTitles bounds the title history returned per page; the titles seen
+// least recently are left out
+const maxPageTitles = 20
+
+// pageRebuildBatch is how many navigate events rebuildPages reads at a time
+const pageRebuildBatch = 1000
+
+// Keys in the meta table
+const (
+	metaPagesStale    = "pages_stale"    // "1" when pages must be rebuilt before they are read
+	metaPageFragments = "page_fragments" // "keep" when page URLs keep their fragment
+)
+
+// PageSort orders the pages returned by ListPages, most first
+type PageSort string
+
+const (
+	SortByTime   PageSort = "time"   // dwell time
+	SortByVisits PageSort = "visits" // visit count
+	SortByRecent PageSort = "recent" // last visit
+)
+
+var pageOrders = map[PageSort]string{
+	SortByTime:   "dwell_ms DESC",
+	SortByVisits: "visit_count DESC",
+	SortByRecent: "last_visit_utc DESC",
+}
+
+type PageFilter struct {
+	SinceUTC *int64  // only visits at or after this time
+	UntilUTC *int64  // only visits at or before this time
+	Domain   *string // host match, including subdomains
+	Sort     PageSort
+	Limit    int
+}
+
+// createPagesTables sets up pages and page_visits. Deleting a navigate event
+// deletes its visit, and the page with its last visit. Filling them needs URLs
+// normalized, and possibly decrypted, in Go, so existing databases are marked
+// stale here and RebuildStale builds them.
+func createPagesTables(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS pages(
+	  id  INTEGER PRIMARY KEY,
+	  url TEXT    NOT NULL UNIQUE
+	);
+
+	CREATE TABLE IF NOT EXISTS page_visits(
+	  event_id   INTEGER PRIMARY KEY,
+	  page_id    INTEGER NOT NULL,
+	  session_id TEXT,
+	  ts_utc     INTEGER NOT NULL,
+	  dwell_ms   INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX IF NOT EXISTS idx_page_visits_page    ON page_visits(page_id);
+	CREATE INDEX IF NOT EXISTS idx_page_visits_session ON page_visits(session_id, ts_utc);
+	CREATE INDEX IF NOT EXISTS idx_page_visits_ts      ON page_visits(ts_utc);
+
+	CREATE TRIGGER IF NOT EXISTS page_visits_delete AFTER DELETE ON events
+	WHEN old.type = 'navigate'
+	BEGIN
+		DELETE FROM pages WHERE id = (SELECT page_id FROM page_visits WHERE event_id = old.id)
+			AND (SELECT COUNT(*) FROM page_visits WHERE page_id = pages.id) = 1;
+		DELETE FROM page_visits WHERE event_id = old.id;
+	END;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create pages tables: %w", err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO meta(key, value) SELECT ?, '1'
+	WHERE EXISTS (SELECT 1 FROM events WHERE type = 'navigate')
+	ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, metaPagesStale)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", metaPagesStale, err)
+	}
+	return nil
+}
+
+// SetPageFragments sets whether page URLs keep their fragment, so that for
+// example https://mail.example.com/#inbox and #sent count as different pages.
+// Pages are marked stale for RebuildStale when the setting changes.
+func (d *Database) SetPageFragments(keep bool) error {
+	d.pageFragments = keep
+
+	want := "drop"
+	if keep {
+		want = "keep"
+	}
+	current, err := getMeta(d.db, metaPageFragments)
+	if err != nil {
+		return err
+	}
+	if current == want || (current == "" && !keep) {
+		return nil
+	}
+	if err := d.setMeta(metaPageFragments, want); err != nil {
+		return err
+	}
+	return d.setMeta(metaPagesStale, "1")
+}
+
+// recordPageVisit adds a navigate event stored within transaction to its
+// page. Tab switches return to a page rather than visit it, so they are left out.
+func (d *Database) recordPageVisit(transaction *sql.Tx, event models.Event) error {
+	if event.SessionID != nil && *event.SessionID == TabSwitchSessionID {
+		return nil
+	}
+
+	var pageID int64
+	err := transaction.QueryRow(
+		"INSERT INTO pages(url) VALUES(?) ON CONFLICT(url) DO UPDATE SET url = excluded.url RETURNING id",
+		d.sealURL(d.pageURL(event)),
+	).Scan(&pageID)
+	if err != nil {
+		return fmt.Errorf("failed to store page: %w", err)
+	}
+
+	_, err = transaction.Exec(`
+		INSERT INTO page_visits(event_id, page_id, session_id, ts_utc) VALUES(?,?,?,?)
+		ON CONFLICT(event_id) DO UPDATE SET
+			page_id = excluded.page_id,
+			session_id = excluded.session_id,
+			ts_utc = excluded.ts_utc
+	`, event.ID, pageID, event.SessionID, event.TSUTC)
+	if err != nil {
+		return fmt.Errorf("failed to store page visit: %w", err)
+	}
+	return nil
+}
+
+// pageURL returns the normalized URL of the page event visits. Canonical URLs
+// usually have no fragment, so a kept fragment comes from the URL as captured.
+func (d *Database) pageURL(event models.Event) string {
+	if d.pageFragments && event.RawURL != nil && !strings.Contains(event.URL, "#") {
+		if _, fragment, ok := strings.Cut(*event.RawURL, "#"); ok {
+			return urlnorm.Normalize(event.URL+"#"+fragment, true)
+		}
+	}
+	return urlnorm.Normalize(event.URL, d.pageFragments)
+}
+
+// updateDwell recomputes the time credited to the visits of one session, or
+// of every session when sessionID is nil. A visit lasts until the next visit
+// of its session, or until the session's last event, capped at maxPageDwell.
+func updateDwell(transaction *sql.Tx, sessionID *string) error {
+	condition := ""
+	args := []any{}
+	if sessionID != nil {
+		condition = " AND session_id = ?"
+		args = append(args, *sessionID)
+	}
+	args = append(args, maxPageDwell)
+
+	_, err := transaction.Exec(`
+	WITH ordered AS (
+	  SELECT event_id, session_id, ts_utc,
+	    lead(ts_utc) OVER (PARTITION BY session_id ORDER BY ts_utc, event_id) AS next_utc
+	  FROM page_visits
+	  WHERE session_id IS NOT NULL`+condition+`
+	)
+	UPDATE page_visits
+	SET dwell_ms = max(0, min(?, coalesce(
+	  ordered.next_utc,
+	  (SELECT last_seen_utc FROM sessions WHERE id = ordered.session_id),
+	  ordered.ts_utc
+	) - ordered.ts_utc))
+	FROM ordered
+	WHERE page_visits.event_id = ordered.event_id
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update page dwell time: %w", err)
+	}
+	return nil
+}
+
+// updateSessionDwell recomputes the dwell time of the sessions events belong
+// to, since any event may move the end of its session's last visit
+func updateSessionDwell(transaction *sql.Tx, events []models.Event) error {
+	done := map[string]bool{}
+	for _, event := range events {
+		if event.SessionID == nil || done[*event.SessionID] || *event.SessionID == TabSwitchSessionID {
+			continue
+		}
+		done[*event.SessionID] = true
+		if err := updateDwell(transaction, event.SessionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildPages recomputes pages and their visits from the navigate events,
+// for when the way page URLs are stored has changed: after the migration that
+// added them, a change of SetPageFragments, or of the encryption key
+func (d *Database) rebuildPages() error {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	if _, err := transaction.Exec("DELETE FROM page_visits; DELETE FROM pages"); err != nil {
+		return fmt.Errorf("failed to clear pages: %w", err)
+	}
+
+	var lastID int64
+	for {
+		rows, err := transaction.Query(
+			"SELECT "+eventColumns+" FROM events WHERE type = 'navigate' AND id > ? ORDER BY id LIMIT ?",
+			lastID, pageRebuildBatch,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to query navigate events: %w", err)
+		}
+		var batch []models.Event
+		for rows.Next() {
+			event, err := scanEvent(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, event)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, event := range batch {
+			if err := d.recordPageVisit(transaction, event); err != nil {
+				return err
+			}
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+
+	if err := updateDwell(transaction, nil); err != nil {
+		return err
+	}
+	if _, err := transaction.Exec("DELETE FROM meta WHERE key = ?", metaPagesStale); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", metaPagesStale, err)
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// pageTitles returns the title history of each of pages, from the titles of
+// the navigate events of their visits, ordered by when each was first seen
+func (d *Database) pageTitles(pages []int64) (map[int64][]models.PageTitle, error) {
+	titles := map[int64][]models.PageTitle{}
+	if len(pages) == 0 {
+		return titles, nil
+	}
+
+	args := make([]any, len(pages))
+	for i, id := range pages {
+		args[i] = id
+	}
+	// Encrypted titles use random nonces, so equal titles only group together
+	// once decrypted
+	rows, err := d.db.Query(`
+	SELECT v.page_id, events.title, min(v.ts_utc), max(v.ts_utc)
+	FROM page_visits AS v JOIN events ON events.id = v.event_id
+	WHERE v.page_id IN (?`+strings.Repeat(",?", len(pages)-1)+`) AND events.title IS NOT NULL
+	GROUP BY v.page_id, events.title
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query page titles: %w", err)
+	}
+	defer rows.Close()
+
+	seen := map[int64]map[string]*models.PageTitle{}
+	for rows.Next() {
+		var pageID, firstSeen, lastSeen int64
+		var title string
+		if err := rows.Scan(&pageID, &title, &firstSeen, &lastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if title, err = keyring.Decrypt(fieldTitle, title); err != nil {
+			return nil, fmt.Errorf("failed to decrypt page title: %w", err)
+		}
+		if title == "" {
+			continue
+		}
+		if seen[pageID] == nil {
+			seen[pageID] = map[string]*models.PageTitle{}
+		}
+		if existing := seen[pageID][title]; existing != nil {
+			existing.FirstSeenUTC = min(existing.FirstSeenUTC, firstSeen)
+			existing.LastSeenUTC = max(existing.LastSeenUTC, lastSeen)
+		} else {
+			seen[pageID][title] = &models.PageTitle{Title: title, FirstSeenUTC: firstSeen, LastSeenUTC: lastSeen}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	for pageID, byTitle := range seen {
+		history := make([]models.PageTitle, 0, len(byTitle))
+		for _, title := range byTitle {
+			history = append(history, *title)
+		}
+		if len(history) > maxPageTitles {
+			sort.Slice(history, func(i, j int) bool { return history[i].LastSeenUTC > history[j].LastSeenUTC })
+			history = history[:maxPageTitles]
+		}
+		sort.Slice(history, func(i, j int) bool {
+			if history[i].FirstSeenUTC != history[j].FirstSeenUTC {
+				return history[i].FirstSeenUTC < history[j].FirstSeenUTC
+			}
+			return history[i].Title < history[j].Title
+		})
+		titles[pageID] = history
+	}
+	return titles, nil
+}
+
+// ListPages returns up to filter.Limit pages visited in the filter's time
+// window, with the visit counts and dwell time of that window. A visit
+// counts in full toward the window it starts in.
+func (d *Database) ListPages(filter PageFilter) ([]models.Page, error) {
+	order, ok := pageOrders[filter.Sort]
+	if filter.Sort == "" {
+		order, ok = pageOrders[SortByTime], true
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid page sort: %s", filter.Sort)
+	}
+
+	query := `
+	SELECT pages.id, pages.url, min(v.ts_utc) AS first_visit_utc, max(v.ts_utc) AS last_visit_utc,
+	  COUNT(*) AS visit_count, sum(v.dwell_ms) AS dwell_ms
+	FROM page_visits AS v JOIN pages ON pages.id = v.page_id
+	WHERE 1=1`
+	args := []any{}
+
+	if filter.SinceUTC != nil {
+		query += " AND v.ts_utc >= ?"
+		args = append(args, *filter.SinceUTC)
+	}
+	if filter.UntilUTC != nil {
+		query += " AND v.ts_utc <= ?"
+		args = append(args, *filter.UntilUTC)
+	}
+	if filter.Domain != nil {
+		domain := strings.ToLower(*filter.Domain)
+		query += " AND (url_host(pages.url) = ? OR url_host(pages.url) LIKE ?)"
+		args = append(args, domain, "%."+domain)
+	}
+
+	query += " GROUP BY pages.id ORDER BY " + order + ", pages.id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pages: %w", err)
+	}
+	defer rows.Close()
+
+	var pages []models.Page
+	var ids []int64
+	for rows.Next() {
+		var page models.Page
+		var id int64
+		if err := rows.Scan(&id, &page.URL, &page.FirstVisitUTC, &page.LastVisitUTC, &page.VisitCount, &page.DwellMS); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if page.URL, err = openURL(page.URL); err != nil {
+			return nil, fmt.Errorf("failed to decrypt page: %w", err)
+		}
+		pages = append(pages, page)
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	rows.Close()
+
+	titles, err := d.pageTitles(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pages {
+		pages[i].Titles = titles[ids[i]]
+		if pages[i].Titles == nil {
+			pages[i].Titles = []models.PageTitle{}
+		}
+		var latest int64
+		for j, title := range pages[i].Titles {
+			if pages[i].Title == nil || title.LastSeenUTC >= latest {
+				pages[i].Title, latest = &pages[i].Titles[j].Title, title.LastSeenUTC
+			}
+		}
+	}
+	return pages, nil
+}
@@ -0,0 +1,280 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func navigateEvent(tsUTC int64, url, title, session string) models.Event {
+	event := models.Event{TSUTC: tsUTC, TSISO: "1970-01-01T00:00:00Z", URL: url, Type: "navigate", Data: map[string]any{"from": nil, "to": url}}
+	if title != "" {
+		event.Title = &title
+	}
+	if session != "" {
+		event.SessionID = &session
+	}
+	return event
+}
+
+func clickEvent(tsUTC int64, url, session string) models.Event {
+	return models.Event{TSUTC: tsUTC, TSISO: "1970-01-01T00:00:00Z", URL: url, Type: "click", Data: map[string]any{"selector": "a", "text": "A"}, SessionID: &session}
+}
+
+func pageTestEvents() []models.Event {
+	return []models.Event{
+		navigateEvent(1000, "https://docs.example.com/guide", "Guide", "s1"),
+		clickEvent(2000, "https://docs.example.com/guide", "s1"),
+		// A client-side navigation to the same page, arriving through a campaign link
+		navigateEvent(61000, "https://docs.example.com/guide?utm_source=mail#install", "Guide v2", "s1"),
+		clickEvent(100000, "https://docs.example.com/guide", "s1"),
+		// Open for 40 minutes, credited with 30
+		navigateEvent(5000, "https://news.example.org/", "News", "s2"),
+		clickEvent(5000+40*60*1000, "https://news.example.org/", "s2"),
+		navigateEvent(200000, "https://docs.example.com/guide", "", TabSwitchSessionID),
+	}
+}
+
+func pagesByURL(pages []models.Page) map[string]models.Page {
+	byURL := map[string]models.Page{}
+	for _, page := range pages {
+		byURL[page.URL] = page
+	}
+	return byURL
+}
+
+func TestPagesMaintainedOnIngestion(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(pageTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	pages, err := db.ListPages(PageFilter{})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("Expected 2 pages, got %+v", pages)
+	}
+	if pages[0].URL != "https://news.example.org/" {
+		t.Errorf("Expected the page with the most time first, got %s", pages[0].URL)
+	}
+
+	guide := pagesByURL(pages)["https://docs.example.com/guide"]
+	if guide.VisitCount != 2 {
+		t.Errorf("Expected 2 visits without the tab switch, got %d", guide.VisitCount)
+	}
+	if guide.DwellMS != 99000 {
+		t.Errorf("Expected 99000ms until the next visit and the session's last event, got %d", guide.DwellMS)
+	}
+	if guide.FirstVisitUTC != 1000 || guide.LastVisitUTC != 61000 {
+		t.Errorf("Expected visits from 1000 to 61000, got %d to %d", guide.FirstVisitUTC, guide.LastVisitUTC)
+	}
+	if len(guide.Titles) != 2 || guide.Title == nil || *guide.Title != "Guide v2" {
+		t.Errorf("Expected two titles with Guide v2 the latest, got %v", guide.Titles)
+	}
+
+	news := pagesByURL(pages)["https://news.example.org/"]
+	if news.DwellMS != maxPageDwell {
+		t.Errorf("Expected dwell capped at %d, got %d", maxPageDwell, news.DwellMS)
+	}
+
+	pages, err = db.ListPages(PageFilter{Sort: SortByVisits, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	if len(pages) != 1 || pages[0].URL != "https://docs.example.com/guide" {
+		t.Errorf("Expected the most visited page, got %+v", pages)
+	}
+}
+
+func TestListPagesFilters(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(pageTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	since := int64(50000)
+	pages, err := db.ListPages(PageFilter{SinceUTC: &since})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	if len(pages) != 1 || pages[0].VisitCount != 1 || pages[0].DwellMS != 39000 {
+		t.Errorf("Expected only the second guide visit since 50000, got %+v", pages)
+	}
+
+	domain := "example.org"
+	pages, err = db.ListPages(PageFilter{Domain: &domain})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	if len(pages) != 1 || pages[0].URL != "https://news.example.org/" {
+		t.Errorf("Expected only the news page, got %+v", pages)
+	}
+
+	if _, err := db.ListPages(PageFilter{Sort: "size"}); err == nil {
+		t.Error("Expected an error for an unknown sort")
+	}
+}
+
+func TestPagesFollowDeletedEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(pageTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	domain := "news.example.org"
+	if _, err := db.DeleteEvents(EventFilter{Domain: &domain}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+	pages, err := db.ListPages(PageFilter{})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	if len(pages) != 1 || pages[0].URL != "https://docs.example.com/guide" {
+		t.Errorf("Expected the news page to go with its events, got %+v", pages)
+	}
+
+	var count int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM pages").Scan(&count); err != nil {
+		t.Fatalf("Failed to count pages: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 stored page, got %d", count)
+	}
+}
+
+func TestSetPageFragmentsRebuildsPages(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// A canonicalized URL keeps its fragment only in the URL as captured
+	sent := navigateEvent(2000, "https://mail.example.com/", "Sent", "s1")
+	captured := "https://mail.example.com/#sent"
+	sent.RawURL = &captured
+	err := db.InsertEvents([]models.Event{
+		navigateEvent(1000, "https://mail.example.com/#inbox", "Inbox", "s1"),
+		sent,
+	})
+	if err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	pages, err := db.ListPages(PageFilter{})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	if len(pages) != 1 || pages[0].VisitCount != 2 {
+		t.Fatalf("Expected one page without fragments, got %+v", pages)
+	}
+
+	if err := db.SetPageFragments(true); err != nil {
+		t.Fatalf("SetPageFragments failed: %v", err)
+	}
+	if err := db.RebuildStale(); err != nil {
+		t.Fatalf("RebuildStale failed: %v", err)
+	}
+	pages, err = db.ListPages(PageFilter{})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	byURL := pagesByURL(pages)
+	if len(pages) != 2 || byURL["https://mail.example.com/#inbox"].DwellMS != 1000 || byURL["https://mail.example.com/#sent"].VisitCount != 1 {
+		t.Errorf("Expected a page per fragment after the rebuild, got %+v", pages)
+	}
+}
+
+func TestPagesForgetDeletedTitles(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(pageTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	// The page keeps its first visit, but not the title of the deleted one
+	since, until := int64(61000), int64(61000)
+	if _, err := db.DeleteEvents(EventFilter{SinceUTC: &since, UntilUTC: &until}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+	pages, err := db.ListPages(PageFilter{})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	guide := pagesByURL(pages)["https://docs.example.com/guide"]
+	if guide.VisitCount != 1 || len(guide.Titles) != 1 || guide.Title == nil || *guide.Title != "Guide" {
+		t.Errorf("Expected only the remaining visit's title, got %+v", guide)
+	}
+}
+
+func TestPagesBackfilled(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := db.InsertEvents(pageTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	// Simulate a database created before pages existed
+	if _, err := db.db.Exec("DROP TABLE pages; DROP TABLE page_visits; DROP TRIGGER page_visits_delete; PRAGMA user_version = 8"); err != nil {
+		t.Fatalf("Failed to drop pages tables: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	if err := db.RebuildStale(); err != nil {
+		t.Fatalf("RebuildStale failed: %v", err)
+	}
+
+	pages, err := db.ListPages(PageFilter{})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	guide := pagesByURL(pages)["https://docs.example.com/guide"]
+	if len(pages) != 2 || guide.VisitCount != 2 || guide.DwellMS != 99000 {
+		t.Errorf("Expected backfilled pages to match ingestion, got %+v", pages)
+	}
+}
+
+func TestPagesEncrypted(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.EnableEncryption(testEncryptionKey(t, 6)); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	if err := db.InsertEvents(pageTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	pages, err := db.ListPages(PageFilter{})
+	if err != nil {
+		t.Fatalf("ListPages failed: %v", err)
+	}
+	guide := pagesByURL(pages)["https://docs.example.com/guide"]
+	if guide.Title == nil || *guide.Title != "Guide v2" {
+		t.Errorf("Expected decrypted page, got %+v", pages)
+	}
+
+	var plaintext int
+	err = db.db.QueryRow("SELECT COUNT(*) FROM pages WHERE instr(url, 'example')").Scan(&plaintext)
+	if err != nil {
+		t.Fatalf("Failed to query raw pages: %v", err)
+	}
+	if plaintext != 0 {
+		t.Errorf("Expected pages not to be stored in plaintext, found %d", plaintext)
+	}
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// maxRejectedEvents bounds the quarantine, so that a page which keeps sending
+// malformed events cannot grow it without limit. The oldest rows go first.
+const maxRejectedEvents = 10000
+
+func createRejectedEventsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS rejected_events(
+	  id           INTEGER PRIMARY KEY,
+	  received_utc INTEGER NOT NULL,
+	  event_index  INTEGER NOT NULL,
+	  reason       TEXT    NOT NULL,
+	  payload      TEXT    NOT NULL
+	);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create rejected events table: %w", err)
+	}
+	return nil
+}
+
+// StoreBatch stores events and quarantines rejected ones in one transaction.
+// It sets ID on each stored event and returns how many of them replaced an
+// already stored event through their type's dedup key.
+func (d *Database) StoreBatch(events []models.Event, rejected []models.RejectedEvent) (int, error) {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	upserted, err := d.insertEvents(transaction, events)
+	if err != nil {
+		return 0, err
+	}
+	if err := d.insertRejectedEvents(transaction, rejected); err != nil {
+		return 0, err
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return upserted, nil
+}
+
+func (d *Database) insertRejectedEvents(transaction *sql.Tx, rejected []models.RejectedEvent) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	receivedUTC := time.Now().UnixMilli()
+	for _, event := range rejected {
+		_, err := transaction.Exec(
+			"INSERT INTO rejected_events(received_utc, event_index, reason, payload) VALUES(?,?,?,?)",
+			receivedUTC, event.EventIndex, event.Reason, d.sealRejected(string(event.Event)),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to quarantine event %d: %w", event.EventIndex, err)
+		}
+	}
+
+	_, err := transaction.Exec(
+		"DELETE FROM rejected_events WHERE id <= (SELECT id FROM rejected_events ORDER BY id DESC LIMIT 1 OFFSET ?)",
+		maxRejectedEvents,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to trim rejected events: %w", err)
+	}
+	return nil
+}
+
+// ListRejectedEvents returns up to limit quarantined events, newest first
+func (d *Database) ListRejectedEvents(limit int) ([]models.RejectedEvent, error) {
+	rows, err := d.db.Query(
+		"SELECT id, received_utc, event_index, reason, payload FROM rejected_events ORDER BY id DESC LIMIT ?", limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rejected events: %w", err)
+	}
+	defer rows.Close()
+
+	var rejected []models.RejectedEvent
+	for rows.Next() {
+		var event models.RejectedEvent
+		var payload string
+		if err := rows.Scan(&event.ID, &event.ReceivedUTC, &event.EventIndex, &event.Reason, &payload); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		payload, err = keyring.Decrypt(fieldRejected, payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt rejected event %d: %w", event.ID, err)
+		}
+		event.Event = []byte(payload)
+		rejected = append(rejected, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return rejected, nil
+}
+
+// DeleteRejectedEvents empties the quarantine and returns the count of deleted rows
+func (d *Database) DeleteRejectedEvents() (int64, error) {
+	result, err := d.db.Exec("DELETE FROM rejected_events")
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete rejected events: %w", err)
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestStoreBatch(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	session, field := "s1", "#q"
+	input := func(ts int64, value string) models.Event {
+		return models.Event{TSUTC: ts, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "input", Data: map[string]any{"selector": "#q", "value": value}, SessionID: &session, FieldID: &field}
+	}
+	rejected := []models.RejectedEvent{{EventIndex: 2, Reason: "invalid event type: tab_switch", Event: json.RawMessage(`{"type":"tab_switch"}`)}}
+
+	upserted, err := db.StoreBatch([]models.Event{input(1000, "h"), input(2000, "he")}, rejected)
+	if err != nil {
+		t.Fatalf("StoreBatch failed: %v", err)
+	}
+	if upserted != 1 {
+		t.Errorf("Expected 1 upsert within the batch, got %d", upserted)
+	}
+	if upserted, err = db.StoreBatch([]models.Event{input(3000, "hel")}, nil); err != nil || upserted != 1 {
+		t.Errorf("Expected 1 upsert of the stored event, got %d, %v", upserted, err)
+	}
+
+	stored, err := db.ListRejectedEvents(10)
+	if err != nil {
+		t.Fatalf("ListRejectedEvents failed: %v", err)
+	}
+	if len(stored) != 1 || stored[0].EventIndex != 2 || stored[0].Reason != rejected[0].Reason || string(stored[0].Event) != `{"type":"tab_switch"}` {
+		t.Errorf("Unexpected rejected events: %+v", stored)
+	}
+
+	count, err := db.DeleteRejectedEvents()
+	if err != nil || count != 1 {
+		t.Errorf("Expected 1 deleted rejected event, got %d, %v", count, err)
+	}
+}
+
+func TestStoreBatchRollsBackOnInvalidEvent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com", Type: "tab_switch", Data: map[string]any{}}}
+	rejected := []models.RejectedEvent{{EventIndex: 1, Reason: "invalid JSON", Event: json.RawMessage("null")}}
+	if _, err := db.StoreBatch(events, rejected); err == nil {
+		t.Fatal("Expected unregistered type to be rejected")
+	}
+
+	if stored, err := db.ListRejectedEvents(10); err != nil || len(stored) != 0 {
+		t.Errorf("Expected no quarantined events after rollback, got %d, %v", len(stored), err)
+	}
+}
+
+func TestRejectedEventsEncrypted(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Quarantined before encryption was enabled
+	before := []models.RejectedEvent{{EventIndex: 0, Reason: "bad", Event: json.RawMessage(`{"value":"first secret"}`)}}
+	if _, err := db.StoreBatch(nil, before); err != nil {
+		t.Fatalf("StoreBatch failed: %v", err)
+	}
+
+	if err := db.EnableEncryption(testEncryptionKey(t, 1)); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	after := []models.RejectedEvent{{EventIndex: 1, Reason: "bad", Event: json.RawMessage(`{"value":"second secret"}`)}}
+	if _, err := db.StoreBatch(nil, after); err != nil {
+		t.Fatalf("StoreBatch failed: %v", err)
+	}
+	if _, err := db.EncryptEvents(100); err != nil {
+		t.Fatalf("EncryptEvents failed: %v", err)
+	}
+
+	var count int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM rejected_events WHERE instr(payload, 'secret')").Scan(&count); err != nil {
+		t.Fatalf("Failed to query raw rows: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no plaintext payloads, got %d", count)
+	}
+
+	stored, err := db.ListRejectedEvents(10)
+	if err != nil {
+		t.Fatalf("ListRejectedEvents failed: %v", err)
+	}
+	if len(stored) != 2 || string(stored[0].Event) != `{"value":"second secret"}` || string(stored[1].Event) != `{"value":"first secret"}` {
+		t.Errorf("Unexpected rejected events: %+v", stored)
+	}
+}
@@ -0,0 +1,658 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Time reports roll activity up into activity_hours: per UTC hour and domain,
+// the time spent active and the count of activity events. Navigate, click,
+// input and focus events mark the user as active. The time between two
+// consecutive activity events, across all tabs, is credited to the domain of
+// the first one, unless it exceeds the idle threshold: then the user is
+// taken to have been away and the gap counts for nothing.
+//
+// Rollups are kept up to date as events are stored and are left alone when
+// retention prunes events, so reports reach back further than the events do.
+// Deleting all events, or a domain's events, forgets the time reported for
+// them; deleting any other activity event takes back what it added, as if it
+// had never been stored.
+
+// DefaultIdleThreshold is the longest gap between activity events counted as
+// active time until SetIdleThreshold says otherwise
+const DefaultIdleThreshold = 5 * time.Minute
+
+const hourMS = int64(time.Hour / time.Millisecond)
+
+// activityRebuildBatch is how many activity events rebuildActivity reads at a time
+const activityRebuildBatch = 1000
+
+// Keys in the meta table
+const (
+	metaActivityStale = "activity_stale"    // "1" when activity_hours must be rebuilt before it is read
+	metaIdleThreshold = "idle_threshold_ms" // the threshold activity_hours was computed with
+)
+
+// activityTypes are the event types showing that the user is at the browser.
+// visible_text is captured without any action of the user and is left out.
+var activityTypes = map[string]bool{"navigate": true, "click": true, "input": true, "focus": true}
+
+const activityTypesSQL = "type IN ('navigate','click','input','focus')"
+
+// activityEvents selects the activity events in time order. Without table
+// statistics the planner prefers idx_events_type, which has them all to sort.
+const activityEvents = "events INDEXED BY idx_events_activity WHERE " + activityTypesSQL
+
+// ReportBucket is the length of the periods a time report is split into
+type ReportBucket string
+
+const (
+	BucketHour ReportBucket = "hour"
+	BucketDay  ReportBucket = "day"
+	BucketWeek ReportBucket = "week" // starting on Monday
+)
+
+// bucketSizes holds the length of each bucket and the offset of its first
+// start from the Unix epoch, a Thursday
+var bucketSizes = map[ReportBucket]struct{ size, origin int64 }{
+	BucketHour: {hourMS, 0},
+	BucketDay:  {24 * hourMS, 0},
+	BucketWeek: {7 * 24 * hourMS, 4 * 24 * hourMS},
+}
+
+type ReportFilter struct {
+	SinceUTC *int64  // only hours ending after this time
+	UntilUTC *int64  // only hours starting at or before this time
+	Domain   *string // host match, including subdomains
+	Bucket   ReportBucket
+	ByDomain bool // a row per domain and bucket rather than per bucket
+	// UTCOffset shifts day and week buckets to start at local midnight.
+	// Rollups are hourly, so with a half-hour offset each hour counts toward
+	// the day it starts in.
+	UTCOffset time.Duration
+}
+
+// activityPoint is an activity event: when it happened and on which domain
+type activityPoint struct {
+	id     int64 // set when read back from events
+	tsUTC  int64
+	domain string
+}
+
+// createActivityTable sets up activity_hours and the index used to find the
+// activity events around a new one. Existing databases are marked stale so
+// RebuildStale builds the rollups from their events.
+func createActivityTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS activity_hours(
+	  hour_utc    INTEGER NOT NULL,
+	  domain      TEXT    NOT NULL,
+	  active_ms   INTEGER NOT NULL DEFAULT 0,
+	  event_count INTEGER NOT NULL DEFAULT 0,
+	  PRIMARY KEY(hour_utc, domain)
+	) WITHOUT ROWID;
+
+	CREATE INDEX IF NOT EXISTS idx_events_activity ON events(ts_utc) WHERE ` + activityTypesSQL + `;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create activity table: %w", err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO meta(key, value) SELECT ?, '1'
+	WHERE EXISTS (SELECT 1 FROM events WHERE `+activityTypesSQL+`)
+	ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, metaActivityStale)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", metaActivityStale, err)
+	}
+	return nil
+}
+
+// SetIdleThreshold sets the longest gap between activity events that still
+// counts as active time. When it changes, the rollups are marked stale for
+// RebuildStale, which rebuilds them from the events still stored and so drops
+// the time of pruned events.
+func (d *Database) SetIdleThreshold(threshold time.Duration) error {
+	if threshold <= 0 {
+		return fmt.Errorf("idle threshold must be positive")
+	}
+	d.idleThreshold = threshold.Milliseconds()
+
+	want := strconv.FormatInt(d.idleThreshold, 10)
+	current, err := getMeta(d.db, metaIdleThreshold)
+	if err != nil {
+		return err
+	}
+	if current == want || (current == "" && threshold == DefaultIdleThreshold) {
+		return nil
+	}
+	if err := d.setMeta(metaIdleThreshold, want); err != nil {
+		return err
+	}
+	return d.setMeta(metaActivityStale, "1")
+}
+
+// IdleThreshold returns the longest gap between activity events counted as active time
+func (d *Database) IdleThreshold() time.Duration {
+	return time.Duration(d.idleThreshold) * time.Millisecond
+}
+
+// storedActivity returns where the stored event sharing event's dedup key
+// was, before event replaces it, or nil if there is none
+func storedActivity(transaction *sql.Tx, eventType string, key *string) (*activityPoint, error) {
+	if key == nil || !activityTypes[eventType] {
+		return nil, nil
+	}
+	var point activityPoint
+	var url string
+	err := transaction.QueryRow("SELECT ts_utc, url FROM events WHERE type = ? AND dedup_key = ?", eventType, *key).Scan(&point.tsUTC, &url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored event: %w", err)
+	}
+	if url, err = openURL(url); err != nil {
+		return nil, fmt.Errorf("failed to decrypt stored event: %w", err)
+	}
+	point.domain = hostOf(url)
+	return &point, nil
+}
+
+// recordActivity adds an activity event stored within transaction to the
+// rollups. The event splits the gap between the activity events before and
+// after it in two. replaced is where the event was before an upsert moved it:
+// that activity happened too, so it stands in for the event before when it
+// is closer.
+func (d *Database) recordActivity(transaction *sql.Tx, event models.Event, replaced *activityPoint) error {
+	point := activityPoint{tsUTC: event.TSUTC, domain: hostOf(event.URL)}
+
+	previous, err := adjacentActivity(transaction, event, "<", "DESC")
+	if err != nil {
+		return err
+	}
+	next, err := adjacentActivity(transaction, event, ">", "ASC")
+	if err != nil {
+		return err
+	}
+	if replaced != nil && replaced.tsUTC <= point.tsUTC && (previous == nil || replaced.tsUTC >= previous.tsUTC) {
+		previous = replaced
+	}
+
+	if previous != nil && next != nil {
+		if err := d.creditActivity(transaction, previous.domain, previous.tsUTC, next.tsUTC, -1); err != nil {
+			return err
+		}
+	}
+	if previous != nil {
+		if err := d.creditActivity(transaction, previous.domain, previous.tsUTC, point.tsUTC, 1); err != nil {
+			return err
+		}
+	}
+	if next != nil {
+		if err := d.creditActivity(transaction, point.domain, point.tsUTC, next.tsUTC, 1); err != nil {
+			return err
+		}
+	}
+	return d.addActivity(transaction, point.tsUTC-point.tsUTC%hourMS, point.domain, 0, 1)
+}
+
+// adjacentActivity returns the activity event closest to event in the
+// direction given by comparison and order, or nil if there is none
+func adjacentActivity(transaction *sql.Tx, event models.Event, comparison, order string) (*activityPoint, error) {
+	var point activityPoint
+	var url string
+	err := transaction.QueryRow(
+		"SELECT ts_utc, url FROM "+activityEvents+" AND (ts_utc, id) "+comparison+" (?, ?)"+
+			" ORDER BY ts_utc "+order+", id "+order+" LIMIT 1",
+		event.TSUTC, event.ID,
+	).Scan(&point.tsUTC, &url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query activity events: %w", err)
+	}
+	if url, err = openURL(url); err != nil {
+		return nil, fmt.Errorf("failed to decrypt activity event: %w", err)
+	}
+	point.domain = hostOf(url)
+	return &point, nil
+}
+
+// creditActivity adds sign times the time from fromUTC to toUTC to domain,
+// split across the hours it spans. A gap above the idle threshold is idle
+// time and credits nothing.
+func (d *Database) creditActivity(transaction *sql.Tx, domain string, fromUTC, toUTC int64, sign int64) error {
+	deltas := activityDeltas{}
+	d.creditGap(deltas, domain, fromUTC, toUTC, sign)
+	return d.applyActivity(transaction, deltas)
+}
+
+// activityHour is a row of activity_hours, by its primary key
+type activityHour struct {
+	hourUTC int64
+	domain  string
+}
+
+// activityDeltas collects changes to activity_hours before applyActivity
+// writes them, as [active_ms, event_count] to add per row
+type activityDeltas map[activityHour][2]int64
+
+// creditGap is creditActivity collecting into deltas
+func (d *Database) creditGap(deltas activityDeltas, domain string, fromUTC, toUTC int64, sign int64) {
+	if toUTC <= fromUTC || toUTC-fromUTC > d.idleThreshold {
+		return
+	}
+	for start := fromUTC; start < toUTC; {
+		hour := start - start%hourMS
+		end := min(toUTC, hour+hourMS)
+		delta := deltas[activityHour{hour, domain}]
+		delta[0] += sign * (end - start)
+		deltas[activityHour{hour, domain}] = delta
+		start = end
+	}
+}
+
+// countEvent adds sign to the event count of point's hour and domain
+func (deltas activityDeltas) countEvent(point activityPoint, sign int64) {
+	key := activityHour{point.tsUTC - point.tsUTC%hourMS, point.domain}
+	delta := deltas[key]
+	delta[1] += sign
+	deltas[key] = delta
+}
+
+// applyActivity writes deltas within transaction, one statement per row
+func (d *Database) applyActivity(transaction *sql.Tx, deltas activityDeltas) error {
+	for key, delta := range deltas {
+		if delta == [2]int64{} {
+			continue
+		}
+		if err := d.addActivity(transaction, key.hourUTC, key.domain, delta[0], delta[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addActivity adds to the rollup of domain for the hour starting at hourUTC.
+// Domains are sealed like URLs, so that url_host reads them for domain filters.
+// The update adds the parameters rather than excluded.*, which holds the
+// clamped value, so that negative amounts are taken back.
+func (d *Database) addActivity(transaction *sql.Tx, hourUTC int64, domain string, activeMS, eventCount int64) error {
+	_, err := transaction.Exec(`
+		INSERT INTO activity_hours(hour_utc, domain, active_ms, event_count) VALUES(?1, ?2, max(0, ?3), ?4)
+		ON CONFLICT(hour_utc, domain) DO UPDATE SET
+			active_ms = max(0, active_ms + ?3),
+			event_count = event_count + ?4
+	`, hourUTC, d.sealURL(domain), activeMS, eventCount)
+	if err != nil {
+		return fmt.Errorf("failed to update activity: %w", err)
+	}
+	return nil
+}
+
+// rebuildActivity recomputes activity_hours from the activity events, after
+// the migration that added it or a change of SetIdleThreshold
+func (d *Database) rebuildActivity() error {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	if _, err := transaction.Exec("DELETE FROM activity_hours"); err != nil {
+		return fmt.Errorf("failed to clear activity: %w", err)
+	}
+
+	var previous *activityPoint
+	var lastID int64
+	for {
+		cursor := int64(-1)
+		if previous != nil {
+			cursor = previous.tsUTC
+		}
+		rows, err := transaction.Query(
+			"SELECT id, ts_utc, url FROM "+activityEvents+" AND (ts_utc, id) > (?, ?) ORDER BY ts_utc, id LIMIT ?",
+			cursor, lastID, activityRebuildBatch,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to query activity events: %w", err)
+		}
+		var batch []activityPoint
+		for rows.Next() {
+			var point activityPoint
+			var url string
+			if err := rows.Scan(&lastID, &point.tsUTC, &url); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan row: %w", err)
+			}
+			if url, err = openURL(url); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to decrypt event %d: %w", lastID, err)
+			}
+			point.domain = hostOf(url)
+			batch = append(batch, point)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error iterating rows: %w", err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for i := range batch {
+			point := batch[i]
+			if previous != nil {
+				if err := d.creditActivity(transaction, previous.domain, previous.tsUTC, point.tsUTC, 1); err != nil {
+					return err
+				}
+			}
+			if err := d.addActivity(transaction, point.tsUTC-point.tsUTC%hourMS, point.domain, 0, 1); err != nil {
+				return err
+			}
+			previous = &point
+		}
+	}
+
+	if _, err := transaction.Exec("DELETE FROM meta WHERE key = ?", metaActivityStale); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", metaActivityStale, err)
+	}
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// encryptActivity seals the domains of rollups not yet encrypted with the
+// active key. Rows are merged with any already sealed for the same hour.
+func (d *Database) encryptActivity(prefix string) error {
+	transaction, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer transaction.Rollback()
+
+	rows, err := transaction.Query("SELECT hour_utc, domain, active_ms, event_count FROM activity_hours WHERE domain NOT LIKE ?", prefix+"%")
+	if err != nil {
+		return fmt.Errorf("failed to query activity to encrypt: %w", err)
+	}
+	type row struct {
+		hourUTC, activeMS, eventCount int64
+		domain                        string
+	}
+	var batch []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.hourUTC, &r.domain, &r.activeMS, &r.eventCount); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan row: %w", err)
+		}
+		batch = append(batch, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	for _, r := range batch {
+		if _, err := transaction.Exec("DELETE FROM activity_hours WHERE hour_utc = ? AND domain = ?", r.hourUTC, r.domain); err != nil {
+			return fmt.Errorf("failed to encrypt activity: %w", err)
+		}
+		domain, err := openURL(r.domain)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt activity: %w", err)
+		}
+		if err := d.addActivity(transaction, r.hourUTC, domain, r.activeMS, r.eventCount); err != nil {
+			return err
+		}
+	}
+
+	if err := transaction.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// deleteActivityEvents deletes within transaction the activity events among
+// those matching where and args, and takes back from the rollups what they
+// added, as if they had never been stored. It returns how many it deleted.
+//
+// Deleted events come in runs between the activity events that survive: a
+// run gives back the gaps between its events and to the survivors on either
+// side, and the survivors' gap is credited instead. The changes are summed
+// per hour and domain and written once each.
+func (d *Database) deleteActivityEvents(transaction *sql.Tx, where string, args []any) (int64, error) {
+	deleted, err := queryActivity(transaction, "SELECT id, ts_utc, url FROM events"+where+" AND "+activityTypesSQL+" ORDER BY ts_utc, id", args...)
+	if err != nil || len(deleted) == 0 {
+		return 0, err
+	}
+	first, last := deleted[0], deleted[len(deleted)-1]
+
+	// The activity events from the first deleted one to the last, survivors
+	// included, and the survivors just outside them
+	span, err := queryActivity(transaction,
+		"SELECT id, ts_utc, url FROM "+activityEvents+" AND (ts_utc, id) >= (?, ?) AND (ts_utc, id) <= (?, ?) ORDER BY ts_utc, id",
+		first.tsUTC, first.id, last.tsUTC, last.id)
+	if err != nil {
+		return 0, err
+	}
+	before, err := adjacentActivity(transaction, models.Event{ID: first.id, TSUTC: first.tsUTC}, "<", "DESC")
+	if err != nil {
+		return 0, err
+	}
+	after, err := adjacentActivity(transaction, models.Event{ID: last.id, TSUTC: last.tsUTC}, ">", "ASC")
+	if err != nil {
+		return 0, err
+	}
+
+	isDeleted := make(map[int64]bool, len(deleted))
+	for _, point := range deleted {
+		isDeleted[point.id] = true
+	}
+	deltas := activityDeltas{}
+	survivor := before
+	var run []activityPoint
+	endRun := func(next *activityPoint) {
+		if len(run) == 0 {
+			return
+		}
+		previous := survivor
+		for i := range run {
+			if previous != nil {
+				d.creditGap(deltas, previous.domain, previous.tsUTC, run[i].tsUTC, -1)
+			}
+			deltas.countEvent(run[i], -1)
+			previous = &run[i]
+		}
+		if next != nil {
+			d.creditGap(deltas, previous.domain, previous.tsUTC, next.tsUTC, -1)
+			if survivor != nil {
+				d.creditGap(deltas, survivor.domain, survivor.tsUTC, next.tsUTC, 1)
+			}
+		}
+		run = nil
+	}
+	for i := range span {
+		if isDeleted[span[i].id] {
+			run = append(run, span[i])
+			continue
+		}
+		endRun(&span[i])
+		survivor = &span[i]
+	}
+	endRun(after)
+
+	result, err := transaction.Exec("DELETE FROM events"+where+" AND "+activityTypesSQL, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete events: %w", err)
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted events: %w", err)
+	}
+	if err := d.applyActivity(transaction, deltas); err != nil {
+		return 0, err
+	}
+	if _, err := transaction.Exec("DELETE FROM activity_hours WHERE active_ms = 0 AND event_count <= 0"); err != nil {
+		return 0, fmt.Errorf("failed to delete activity: %w", err)
+	}
+	return count, nil
+}
+
+// queryActivity returns the activity events selected as id, ts_utc and url
+func queryActivity(transaction *sql.Tx, query string, args ...any) ([]activityPoint, error) {
+	rows, err := transaction.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query activity events: %w", err)
+	}
+	defer rows.Close()
+
+	var points []activityPoint
+	for rows.Next() {
+		var point activityPoint
+		var url string
+		if err := rows.Scan(&point.id, &point.tsUTC, &url); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if url, err = openURL(url); err != nil {
+			return nil, fmt.Errorf("failed to decrypt event %d: %w", point.id, err)
+		}
+		point.domain = hostOf(url)
+		points = append(points, point)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+	return points, nil
+}
+
+// forgetsActivity reports whether deleting the events matching filter
+// deletes the reported time of its domain: when the filter selects a
+// domain's events, possibly within a time window, and nothing narrower
+func forgetsActivity(filter EventFilter) bool {
+	return filter.Domain != nil && filter.EventType == nil && filter.URL == nil &&
+		filter.SessionID == nil && filter.Flagged == nil
+}
+
+// forgetActivity deletes within transaction the rollups of the domain and
+// hours selected by filter
+func forgetActivity(transaction *sql.Tx, filter EventFilter) error {
+	domain := strings.ToLower(*filter.Domain)
+	query := "DELETE FROM activity_hours WHERE (url_host(domain) = ? OR url_host(domain) LIKE ?)"
+	args := []any{domain, "%." + domain}
+	if filter.SinceUTC != nil {
+		query += " AND hour_utc > ?"
+		args = append(args, *filter.SinceUTC-hourMS)
+	}
+	if filter.UntilUTC != nil {
+		query += " AND hour_utc <= ?"
+		args = append(args, *filter.UntilUTC)
+	}
+	if _, err := transaction.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to delete activity: %w", err)
+	}
+	return nil
+}
+
+// TimeReport returns the active time in each bucket of the filter's window
+// holding any activity, oldest first, and within a bucket the domain with the
+// most time first when grouped by domain
+func (d *Database) TimeReport(filter ReportFilter) ([]models.TimeReportRow, error) {
+	bucket, ok := bucketSizes[filter.Bucket]
+	if filter.Bucket == "" {
+		bucket, ok = bucketSizes[BucketDay], true
+	}
+	if !ok {
+		return nil, fmt.Errorf("invalid report bucket: %s", filter.Bucket)
+	}
+	if filter.Bucket != BucketHour {
+		bucket.origin -= filter.UTCOffset.Milliseconds()
+	}
+
+	query := `
+	SELECT hour_utc - ((hour_utc - ?) % ? + ?) % ? AS bucket_utc, domain, sum(active_ms), sum(event_count)
+	FROM activity_hours
+	WHERE 1=1`
+	args := []any{bucket.origin, bucket.size, bucket.size, bucket.size}
+
+	if filter.SinceUTC != nil {
+		query += " AND hour_utc > ?"
+		args = append(args, *filter.SinceUTC-hourMS)
+	}
+	if filter.UntilUTC != nil {
+		query += " AND hour_utc <= ?"
+		args = append(args, *filter.UntilUTC)
+	}
+	if filter.Domain != nil {
+		domain := strings.ToLower(*filter.Domain)
+		query += " AND (url_host(domain) = ? OR url_host(domain) LIKE ?)"
+		args = append(args, domain, "%."+domain)
+	}
+	query += " GROUP BY bucket_utc, domain"
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query activity: %w", err)
+	}
+	defer rows.Close()
+
+	// Rows are merged after decryption, since a domain may be stored both in
+	// plaintext and sealed while a database is being encrypted
+	type key struct {
+		bucketUTC int64
+		domain    string
+	}
+	merged := map[key]*models.TimeReportRow{}
+	var report []models.TimeReportRow
+	for rows.Next() {
+		var k key
+		var activeMS, eventCount int64
+		if err := rows.Scan(&k.bucketUTC, &k.domain, &activeMS, &eventCount); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		if !filter.ByDomain {
+			k.domain = ""
+		} else if k.domain, err = openURL(k.domain); err != nil {
+			return nil, fmt.Errorf("failed to decrypt activity: %w", err)
+		}
+
+		if row, ok := merged[k]; ok {
+			row.ActiveMS += activeMS
+			row.EventCount += eventCount
+			continue
+		}
+		row := &models.TimeReportRow{BucketStartUTC: k.bucketUTC, ActiveMS: activeMS, EventCount: eventCount}
+		if filter.ByDomain {
+			row.Domain = &k.domain
+		}
+		merged[k] = row
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	for _, row := range merged {
+		report = append(report, *row)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		a, b := report[i], report[j]
+		if a.BucketStartUTC != b.BucketStartUTC {
+			return a.BucketStartUTC < b.BucketStartUTC
+		}
+		if a.ActiveMS != b.ActiveMS {
+			return a.ActiveMS > b.ActiveMS
+		}
+		return a.Domain != nil && b.Domain != nil && *a.Domain < *b.Domain
+	})
+	return report, nil
+}
@@ -0,0 +1,374 @@
+package database
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// reportBase is midnight UTC on Sunday 1970-01-11
+const reportBase = 10 * 24 * hourMS
+
+func inputEvent(tsUTC int64, url, fieldID, session string) models.Event {
+	return models.Event{TSUTC: tsUTC, TSISO: "1970-01-01T00:00:00Z", URL: url, Type: "input", Data: map[string]any{"selector": "#" + fieldID, "value": "x"}, SessionID: &session, FieldID: &fieldID}
+}
+
+func reportTestEvents() []models.Event {
+	text := "s1"
+	return []models.Event{
+		navigateEvent(reportBase, "https://a.example.com/", "A", "s1"),
+		clickEvent(reportBase+60000, "https://a.example.com/", "s1"),
+		clickEvent(reportBase+120000, "https://b.example.org/", "s2"),
+		// Captured without the user doing anything, so it does not split the gap
+		{TSUTC: reportBase + 150000, TSISO: "1970-01-01T00:00:00Z", URL: "https://c.example.net/", Type: "visible_text", Data: map[string]any{"text": "C"}, SessionID: &text},
+		inputEvent(reportBase+180000, "https://b.example.org/", "q", "s2"),
+		// Ten minutes away
+		clickEvent(reportBase+780000, "https://a.example.com/", "s1"),
+		navigateEvent(reportBase+hourMS-30000, "https://a.example.com/next", "Next", "s1"),
+		// A minute split across two hours
+		clickEvent(reportBase+hourMS+30000, "https://a.example.com/next", "s1"),
+	}
+}
+
+// reportByDomain indexes the rows of a report by bucket and domain
+func reportByDomain(rows []models.TimeReportRow) map[int64]map[string]models.TimeReportRow {
+	byBucket := map[int64]map[string]models.TimeReportRow{}
+	for _, row := range rows {
+		if byBucket[row.BucketStartUTC] == nil {
+			byBucket[row.BucketStartUTC] = map[string]models.TimeReportRow{}
+		}
+		domain := ""
+		if row.Domain != nil {
+			domain = *row.Domain
+		}
+		byBucket[row.BucketStartUTC][domain] = row
+	}
+	return byBucket
+}
+
+func checkHourlyReport(t *testing.T, db *Database) {
+	t.Helper()
+	rows, err := db.TimeReport(ReportFilter{Bucket: BucketHour, ByDomain: true})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	byBucket := reportByDomain(rows)
+	expected := []struct {
+		hour       int64
+		domain     string
+		activeMS   int64
+		eventCount int64
+	}{
+		{reportBase, "a.example.com", 150000, 4},
+		{reportBase, "b.example.org", 60000, 2},
+		{reportBase + hourMS, "a.example.com", 30000, 1},
+	}
+	if len(rows) != len(expected) {
+		t.Fatalf("Expected %d rows, got %+v", len(expected), rows)
+	}
+	for _, want := range expected {
+		got := byBucket[want.hour][want.domain]
+		if got.ActiveMS != want.activeMS || got.EventCount != want.eventCount {
+			t.Errorf("Expected %dms and %d events for %s at %d, got %+v", want.activeMS, want.eventCount, want.domain, want.hour, got)
+		}
+	}
+	if rows[0].Domain == nil || *rows[0].Domain != "a.example.com" {
+		t.Errorf("Expected the domain with the most time first, got %+v", rows[0])
+	}
+}
+
+func TestTimeReportMaintainedOnIngestion(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(reportTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	checkHourlyReport(t, db)
+
+	rows, err := db.TimeReport(ReportFilter{Bucket: BucketDay})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0].BucketStartUTC != reportBase || rows[0].ActiveMS != 240000 || rows[0].Domain != nil {
+		t.Errorf("Expected one day with 240000ms in total, got %+v", rows)
+	}
+
+	rows, err = db.TimeReport(ReportFilter{Bucket: BucketWeek})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0].BucketStartUTC != reportBase-6*24*hourMS {
+		t.Errorf("Expected a week starting on Monday 1970-01-05, got %+v", rows)
+	}
+}
+
+func TestTimeReportOrderIndependent(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Batches arriving late and out of order add up to the same rollups
+	events := reportTestEvents()
+	rand.New(rand.NewSource(1)).Shuffle(len(events), func(i, j int) { events[i], events[j] = events[j], events[i] })
+	for _, event := range events {
+		if err := db.InsertEvents([]models.Event{event}); err != nil {
+			t.Fatalf("InsertEvents failed: %v", err)
+		}
+	}
+	checkHourlyReport(t, db)
+
+	// And so does a rebuild
+	if err := db.setMeta(metaActivityStale, "1"); err != nil {
+		t.Fatalf("setMeta failed: %v", err)
+	}
+	checkHourlyReport(t, db)
+}
+
+func TestTimeReportCountsUpsertedInput(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Typing into one field for seven minutes keeps a single input event,
+	// moved forward on every batch, without ever going idle
+	batches := [][]models.Event{
+		{clickEvent(reportBase, "https://a.example.com/", "s1")},
+		{inputEvent(reportBase+60000, "https://a.example.com/", "q", "s1")},
+		{inputEvent(reportBase+240000, "https://a.example.com/", "q", "s1")},
+		{inputEvent(reportBase+420000, "https://a.example.com/", "q", "s1")},
+	}
+	for _, batch := range batches {
+		if err := db.InsertEvents(batch); err != nil {
+			t.Fatalf("InsertEvents failed: %v", err)
+		}
+	}
+
+	rows, err := db.TimeReport(ReportFilter{})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0].ActiveMS != 420000 || rows[0].EventCount != 4 {
+		t.Errorf("Expected 420000ms over 4 events, got %+v", rows)
+	}
+}
+
+func TestTimeReportFilters(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(reportTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	domain := "example.org"
+	rows, err := db.TimeReport(ReportFilter{Bucket: BucketHour, Domain: &domain, ByDomain: true})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(rows) != 1 || *rows[0].Domain != "b.example.org" {
+		t.Errorf("Expected only b.example.org, got %+v", rows)
+	}
+
+	since := reportBase + hourMS + 1
+	rows, err = db.TimeReport(ReportFilter{Bucket: BucketHour, SinceUTC: &since})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0].BucketStartUTC != reportBase+hourMS || rows[0].ActiveMS != 30000 {
+		t.Errorf("Expected only the second hour, got %+v", rows)
+	}
+
+	rows, err = db.TimeReport(ReportFilter{UTCOffset: -time.Hour})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(rows) != 2 || rows[0].BucketStartUTC != reportBase-23*hourMS || rows[1].BucketStartUTC != reportBase+hourMS {
+		t.Errorf("Expected the hours on two local days, got %+v", rows)
+	}
+
+	if _, err := db.TimeReport(ReportFilter{Bucket: "month"}); err == nil {
+		t.Error("Expected an error for an unknown bucket")
+	}
+}
+
+func TestSetIdleThresholdRebuildsReports(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(reportTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	if err := db.SetIdleThreshold(30 * time.Second); err != nil {
+		t.Fatalf("SetIdleThreshold failed: %v", err)
+	}
+	if err := db.RebuildStale(); err != nil {
+		t.Fatalf("RebuildStale failed: %v", err)
+	}
+
+	rows, err := db.TimeReport(ReportFilter{})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(rows) != 1 || rows[0].ActiveMS != 0 || rows[0].EventCount != 7 {
+		t.Errorf("Expected every gap idle, got %+v", rows)
+	}
+
+	if err := db.SetIdleThreshold(0); err == nil {
+		t.Error("Expected an error for a zero threshold")
+	}
+}
+
+func TestTimeReportOutlivesPrunedEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(reportTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	if _, err := db.PruneOlderThan([]string{"click"}, reportBase+2*hourMS, 100); err != nil {
+		t.Fatalf("PruneOlderThan failed: %v", err)
+	}
+	checkHourlyReport(t, db)
+
+	domain := "example.org"
+	if _, err := db.DeleteEvents(EventFilter{Domain: &domain}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+	rows, err := db.TimeReport(ReportFilter{Bucket: BucketHour, ByDomain: true})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(rows) != 2 || reportByDomain(rows)[reportBase]["b.example.org"].EventCount != 0 {
+		t.Errorf("Expected the deleted domain forgotten, got %+v", rows)
+	}
+
+	if _, err := db.DeleteAllEvents(); err != nil {
+		t.Fatalf("DeleteAllEvents failed: %v", err)
+	}
+	if rows, err = db.TimeReport(ReportFilter{}); err != nil || len(rows) != 0 {
+		t.Errorf("Expected no report after deleting everything, got %+v, %v", rows, err)
+	}
+}
+
+func TestTimeReportForgetsDeletedEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := reportTestEvents()
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	// Deletes by type, by time range and by id each take back what the
+	// deleted activity events added
+	eventType := "click"
+	if _, err := db.DeleteEvents(EventFilter{EventType: &eventType}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+	since, until := reportBase+170000, reportBase+190000
+	if count, err := db.DeleteEvents(EventFilter{SinceUTC: &since, UntilUTC: &until}); err != nil || count != 1 {
+		t.Fatalf("Expected the input deleted, got %d, %v", count, err)
+	}
+	last, err := db.GetEvents(EventFilter{Limit: 1})
+	if err != nil || len(last) != 1 {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if err := db.DeleteEvent(last[0].ID); err != nil {
+		t.Fatalf("DeleteEvent failed: %v", err)
+	}
+
+	// The report matches one built from the events left
+	fresh, cleanupFresh := setupTestDB(t)
+	defer cleanupFresh()
+	remaining, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	for i := range remaining {
+		remaining[i].ID = 0
+	}
+	if err := fresh.InsertEvents(remaining); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	got, err := db.TimeReport(ReportFilter{Bucket: BucketHour, ByDomain: true})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	want, err := fresh.TimeReport(ReportFilter{Bucket: BucketHour, ByDomain: true})
+	if err != nil {
+		t.Fatalf("TimeReport failed: %v", err)
+	}
+	if len(got) != len(want) || len(want) == 0 {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	gotByDomain, wantByDomain := reportByDomain(got), reportByDomain(want)
+	for bucket, domains := range wantByDomain {
+		for domain, row := range domains {
+			if other := gotByDomain[bucket][domain]; other.ActiveMS != row.ActiveMS || other.EventCount != row.EventCount {
+				t.Errorf("Expected %+v for %s at %d, got %+v", row, domain, bucket, other)
+			}
+		}
+	}
+}
+
+func TestTimeReportEncrypted(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := reportTestEvents()
+	if err := db.InsertEvents(events[:4]); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	if err := db.EnableEncryption(testEncryptionKey(t, 7)); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	if err := db.InsertEvents(events[4:]); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	// Plaintext and sealed rollups of one domain are merged while both exist
+	checkHourlyReport(t, db)
+
+	if _, err := db.EncryptEvents(100); err != nil {
+		t.Fatalf("EncryptEvents failed: %v", err)
+	}
+	checkHourlyReport(t, db)
+
+	var plaintext int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM activity_hours WHERE instr(domain, 'example')").Scan(&plaintext); err != nil {
+		t.Fatalf("Failed to query raw activity: %v", err)
+	}
+	if plaintext != 0 {
+		t.Errorf("Expected domains not to be stored in plaintext, found %d", plaintext)
+	}
+}
+
+func TestTimeReportBackfilled(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := db.InsertEvents(reportTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	// Simulate a database created before time reports existed
+	if _, err := db.db.Exec("DROP TABLE activity_hours; DROP INDEX idx_events_activity; PRAGMA user_version = 9"); err != nil {
+		t.Fatalf("Failed to drop activity table: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	if err := db.RebuildStale(); err != nil {
+		t.Fatalf("RebuildStale failed: %v", err)
+	}
+	checkHourlyReport(t, db)
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// autoVacuumIncremental is the PRAGMA auto_vacuum value for INCREMENTAL mode
+const autoVacuumIncremental = 2
+
+// Retention deletes run in batches so that each statement holds the write lock
+// only briefly and ingestion is not stalled behind a large prune.
+
+// PruneOlderThan deletes events with ts_utc before beforeUTC, optionally
+// restricted to some event types, and returns the count of deleted rows
+func (d *Database) PruneOlderThan(eventTypes []string, beforeUTC int64, batchSize int) (int64, error) {
+	condition, typeArgs := typeCondition(eventTypes)
+	selectIDs := "SELECT id FROM events WHERE ts_utc < ?" + condition + " LIMIT ?"
+	args := append([]any{beforeUTC}, typeArgs...)
+
+	return d.deleteInBatches(selectIDs, args, batchSize)
+}
+
+// PruneExcessRows deletes the oldest events until at most keep remain,
+// optionally restricted to some event types, and returns the count of deleted rows.
+// With several types the limit applies to all of them together.
+func (d *Database) PruneExcessRows(eventTypes []string, keep int64, batchSize int) (int64, error) {
+	condition, args := typeCondition(eventTypes)
+
+	// The OFFSET skips the newest keep rows, leaving only the excess
+	selectIDs := "SELECT id FROM events WHERE 1=1" + condition + " ORDER BY ts_utc DESC, id DESC LIMIT ? OFFSET " + fmt.Sprint(keep)
+
+	return d.deleteInBatches(selectIDs, args, batchSize)
+}
+
+// eventBytes is the SQL expression for the bytes an events row stores in its
+// fields, leaving out indexes and the search index
+const eventBytes = "length(CAST(url AS BLOB)) + coalesce(length(CAST(raw_url AS BLOB)), 0)" +
+	" + coalesce(length(CAST(title AS BLOB)), 0) + length(CAST(data_json AS BLOB))"
+
+// PruneExcessBytes deletes the oldest events until the fields of those that
+// remain hold at most maxBytes, optionally restricted to some event types, and
+// returns the count of deleted rows. With several types the limit applies to
+// all of them together.
+func (d *Database) PruneExcessBytes(eventTypes []string, maxBytes int64, batchSize int) (int64, error) {
+	condition, args := typeCondition(eventTypes)
+
+	// Rows are kept newest first while their running total fits in maxBytes
+	selectIDs := "SELECT id FROM (SELECT id, SUM(" + eventBytes + ") OVER (ORDER BY ts_utc DESC, id DESC) AS kept" +
+		" FROM events WHERE 1=1" + condition + ") WHERE kept > ? LIMIT ?"
+	args = append(args, maxBytes)
+
+	return d.deleteInBatches(selectIDs, args, batchSize)
+}
+
+// typeCondition restricts a query to eventTypes; nil means every type.
+// Types need not be registered, so events of deleted types can still be pruned.
+func typeCondition(eventTypes []string) (string, []any) {
+	if eventTypes == nil {
+		return "", nil
+	}
+	if len(eventTypes) == 0 {
+		return " AND 0", nil
+	}
+
+	args := make([]any, len(eventTypes))
+	for i, eventType := range eventTypes {
+		args[i] = eventType
+	}
+	return " AND type IN (?" + strings.Repeat(",?", len(eventTypes)-1) + ")", args
+}
+
+// PruneToSize deletes the oldest events, whatever their type, until the space
+// used by live pages is at most maxBytes. Freed pages are returned to the filesystem
+// with an incremental vacuum after each batch.
+func (d *Database) PruneToSize(maxBytes int64, batchSize int) (int64, error) {
+	var total int64
+	for {
+		used, err := d.usedBytes()
+		if err != nil {
+			return total, err
+		}
+		if used <= maxBytes {
+			return total, nil
+		}
+
+		result, err := d.db.Exec(
+			"DELETE FROM events WHERE id IN (SELECT id FROM events ORDER BY ts_utc ASC, id ASC LIMIT ?)", batchSize,
+		)
+		if err != nil {
+			return total, fmt.Errorf("failed to prune events: %w", err)
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		if count == 0 {
+			// Nothing left to delete; the remaining size is schema and indexes
+			return total, nil
+		}
+		total += count
+
+		if err := d.IncrementalVacuum(); err != nil {
+			return total, err
+		}
+	}
+}
+
+// IncrementalVacuum returns free pages to the filesystem without rewriting the
+// whole file. It requires auto_vacuum=INCREMENTAL; see EnableIncrementalVacuum.
+func (d *Database) IncrementalVacuum() error {
+	// The pragma frees one page per step, so the rows must be drained
+	rows, err := d.db.Query("PRAGMA incremental_vacuum")
+	if err != nil {
+		return fmt.Errorf("failed to run incremental vacuum: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to run incremental vacuum: %w", err)
+	}
+	return nil
+}
+
+// EnableIncrementalVacuum switches databases created before incremental vacuum
+// was the default. Changing auto_vacuum on an existing file needs a full VACUUM,
+// so this is a no-op when the mode is already set.
+func (d *Database) EnableIncrementalVacuum() error {
+	var mode int
+	if err := d.db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return fmt.Errorf("failed to query auto_vacuum mode: %w", err)
+	}
+	if mode == autoVacuumIncremental {
+		return nil
+	}
+
+	if _, err := d.db.Exec(fmt.Sprintf("PRAGMA auto_vacuum = %d", autoVacuumIncremental)); err != nil {
+		return fmt.Errorf("failed to set auto_vacuum mode: %w", err)
+	}
+	return d.VacuumDatabase()
+}
+
+// usedBytes is the database size excluding pages on the freelist
+func (d *Database) usedBytes() (int64, error) {
+	size, err := d.DatabaseSize()
+	if err != nil {
+		return 0, err
+	}
+
+	var freePages, pageSize int64
+	if err := d.db.QueryRow("PRAGMA freelist_count").Scan(&freePages); err != nil {
+		return 0, fmt.Errorf("failed to query freelist count: %w", err)
+	}
+	if err := d.db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to query page size: %w", err)
+	}
+	return size - freePages*pageSize, nil
+}
+
+// deleteInBatches repeatedly deletes the ids returned by selectIDs, whose
+// LIMIT placeholder is bound to batchSize, until a batch comes back short
+func (d *Database) deleteInBatches(selectIDs string, args []any, batchSize int) (int64, error) {
+	args = append(append([]any{}, args...), batchSize)
+
+	var total int64
+	for {
+		result, err := d.db.Exec("DELETE FROM events WHERE id IN ("+selectIDs+")", args...)
+		if err != nil {
+			return total, fmt.Errorf("failed to prune events: %w", err)
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return total, fmt.Errorf("failed to get affected rows: %w", err)
+		}
+		total += count
+
+		if count < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func insertTimeline(t *testing.T, db *Database, eventType string, timestamps ...int64) {
+	t.Helper()
+
+	var events []models.Event
+	for _, ts := range timestamps {
+		events = append(events, models.Event{
+			TSUTC: ts,
+			TSISO: "1970-01-01T00:00:00Z",
+			URL:   "https://example.com",
+			Type:  eventType,
+			Data:  map[string]any{},
+		})
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+}
+
+func TestPruneOlderThan(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	insertTimeline(t, db, "click", 1000, 2000, 3000, 4000, 5000)
+	insertTimeline(t, db, "navigate", 1000, 2000)
+
+	count, err := db.PruneOlderThan([]string{"click"}, 3500, 2)
+	if err != nil {
+		t.Fatalf("PruneOlderThan failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 pruned clicks, got %d", count)
+	}
+
+	remaining, err := db.CountEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if remaining != 4 {
+		t.Errorf("Expected 4 remaining events, got %d", remaining)
+	}
+
+	count, err = db.PruneOlderThan(nil, 1500, 100)
+	if err != nil {
+		t.Fatalf("PruneOlderThan failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 pruned navigate, got %d", count)
+	}
+}
+
+func TestPruneExcessRows(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	insertTimeline(t, db, "click", 1000, 2000, 3000, 4000, 5000, 6000, 7000)
+
+	count, err := db.PruneExcessRows(nil, 3, 2)
+	if err != nil {
+		t.Fatalf("PruneExcessRows failed: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 pruned events, got %d", count)
+	}
+
+	events, err := db.GetEvents(EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(events) != 3 || events[len(events)-1].TSUTC != 5000 {
+		t.Errorf("Expected the 3 newest events to remain, got %+v", events)
+	}
+}
+
+func TestPruneToSize(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var events []models.Event
+	for i := 0; i < 500; i++ {
+		events = append(events, models.Event{
+			TSUTC: int64(1000 + i),
+			TSISO: "1970-01-01T00:00:01Z",
+			URL:   "https://example.com",
+			Type:  "click",
+			Data:  map[string]any{"text": strings.Repeat("x", 1000)},
+		})
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	before, err := db.DatabaseSize()
+	if err != nil {
+		t.Fatalf("DatabaseSize failed: %v", err)
+	}
+
+	target := before / 2
+	count, err := db.PruneToSize(target, 50)
+	if err != nil {
+		t.Fatalf("PruneToSize failed: %v", err)
+	}
+	if count == 0 {
+		t.Fatal("Expected some events to be pruned")
+	}
+
+	after, err := db.DatabaseSize()
+	if err != nil {
+		t.Fatalf("DatabaseSize failed: %v", err)
+	}
+	if after > target {
+		t.Errorf("Expected file to shrink to %d bytes, got %d", target, after)
+	}
+
+	// The oldest events go first
+	events, err = db.GetEvents(EventFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(events) != 1 || events[0].TSUTC != 1499 {
+		t.Errorf("Expected newest event to survive, got %+v", events)
+	}
+}
+
+func TestEnableIncrementalVacuumOnLegacyDatabase(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "legacy.db")
+
+	// A file created without auto_vacuum, like databases from older versions
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	if _, err := legacy.Exec("CREATE TABLE placeholder(x)"); err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+	legacy.Close()
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.EnableIncrementalVacuum(); err != nil {
+		t.Fatalf("EnableIncrementalVacuum failed: %v", err)
+	}
+
+	var mode int
+	if err := db.db.QueryRow("PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		t.Fatalf("Failed to query auto_vacuum: %v", err)
+	}
+	if mode != autoVacuumIncremental {
+		t.Errorf("Expected auto_vacuum %d, got %d", autoVacuumIncremental, mode)
+	}
+}
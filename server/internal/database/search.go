@@ -0,0 +1,163 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// searchableTypes lists the event types whose text is indexed for search
+const searchableTypes = "('visible_text','click','input')"
+
+// searchBody is the SQL expression that extracts indexed text from an events row
+const searchBody = "coalesce(json_extract(%[1]s.data_json, '$.text'), '') || ' ' || coalesce(json_extract(%[1]s.data_json, '$.value'), '')"
+
+// createSearchIndex sets up the events_fts table and the triggers that keep it
+// in sync with events. Inserts, upserts (which fire UPDATE) and deletes are all
+// covered, so InsertEvents needs no special handling. Events stored before the
+// index existed are backfilled the first time it is created. Encrypted
+// databases are skipped, since the index would hold their text in plaintext.
+func createSearchIndex(tx *sql.Tx) error {
+	keyID, err := getMeta(tx, metaEncryptionKeyID)
+	if err != nil {
+		return err
+	}
+	if keyID != "" {
+		return nil
+	}
+
+	var exists int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'events_fts'").Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check search index: %w", err)
+	}
+
+	newBody := fmt.Sprintf(searchBody, "new")
+	_, err = tx.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS events_fts USING fts5(title, body, tokenize = 'unicode61 remove_diacritics 2');
+
+	CREATE TRIGGER IF NOT EXISTS events_fts_insert AFTER INSERT ON events
+	WHEN new.type IN ` + searchableTypes + `
+	BEGIN
+		INSERT INTO events_fts(rowid, title, body) VALUES (new.id, coalesce(new.title, ''), ` + newBody + `);
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS events_fts_update AFTER UPDATE ON events
+	BEGIN
+		DELETE FROM events_fts WHERE rowid = old.id;
+		INSERT INTO events_fts(rowid, title, body)
+		SELECT new.id, coalesce(new.title, ''), ` + newBody + `
+		WHERE new.type IN ` + searchableTypes + `;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS events_fts_delete AFTER DELETE ON events
+	BEGIN
+		DELETE FROM events_fts WHERE rowid = old.id;
+	END;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	if exists == 0 {
+		_, err := tx.Exec(`
+		INSERT INTO events_fts(rowid, title, body)
+		SELECT id, coalesce(title, ''), ` + fmt.Sprintf(searchBody, "events") + `
+		FROM events WHERE type IN ` + searchableTypes)
+		if err != nil {
+			return fmt.Errorf("failed to backfill search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// dropSearchIndex removes the search index and its triggers, since the index
+// would keep page text in plaintext next to encrypted events
+func dropSearchIndex(db *sql.DB) error {
+	_, err := db.Exec(`
+	DROP TRIGGER IF EXISTS events_fts_insert;
+	DROP TRIGGER IF EXISTS events_fts_update;
+	DROP TRIGGER IF EXISTS events_fts_delete;
+	DROP TABLE IF EXISTS events_fts;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to drop search index: %w", err)
+	}
+	return nil
+}
+
+// SearchEvents runs a full-text query over page titles, visible text, click
+// text and input values, returning the best matches first. Each result carries
+// a snippet with matches wrapped in <mark></mark> and the rest of its text
+// HTML-escaped, so that it can be rendered as HTML.
+func (d *Database) SearchEvents(text string, filter EventFilter) ([]models.SearchResult, error) {
+	if d.key != nil {
+		return nil, ErrSearchUnavailable
+	}
+
+	match := ftsQuery(text)
+	if match == "" {
+		return nil, fmt.Errorf("search query cannot be empty")
+	}
+
+	where, args, err := d.whereClause(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + eventColumns + ", snippet(events_fts, -1, char(1), char(2), '…', 16), bm25(events_fts)" +
+		" FROM events_fts JOIN events ON events.id = events_fts.rowid" +
+		where + " AND events_fts MATCH ?" +
+		" ORDER BY bm25(events_fts)"
+	args = append(args, match)
+
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search events: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.SearchResult
+	for rows.Next() {
+		var result models.SearchResult
+		event, err := scanEvent(rows, &result.Snippet, &result.Rank)
+		if err != nil {
+			return nil, err
+		}
+		result.Event = event
+		result.Snippet = markSnippet(result.Snippet)
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	return results, nil
+}
+
+// snippetMarks turns the control characters snippet() puts around matches
+// into <mark> tags once the text between them is escaped
+var snippetMarks = strings.NewReplacer("\x01", "<mark>", "\x02", "</mark>")
+
+// markSnippet escapes the text of an FTS snippet as HTML and wraps its matches in <mark></mark>
+func markSnippet(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+// ftsQuery turns free text into an FTS5 query that matches every word, quoting
+// each word so punctuation in user input cannot be parsed as query syntax
+func ftsQuery(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestSearchEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	title := "Gardening Blog"
+	events := []models.Event{
+		{
+			TSUTC: 1000,
+			TSISO: "1970-01-01T00:00:01Z",
+			URL:   "https://garden.example.com",
+			Title: &title,
+			Type:  "visible_text",
+			Data:  map[string]any{"text": "How to grow tomatoes on a balcony"},
+		},
+		{
+			TSUTC: 2000,
+			TSISO: "1970-01-01T00:00:02Z",
+			URL:   "https://shop.example.com",
+			Type:  "click",
+			Data:  map[string]any{"selector": "#buy", "text": "Buy tomato seeds"},
+		},
+		{
+			TSUTC: 3000,
+			TSISO: "1970-01-01T00:00:03Z",
+			URL:   "https://news.example.com",
+			Type:  "navigate",
+			Data:  map[string]any{"from": nil, "to": "https://news.example.com/tomatoes"},
+		},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	results, err := db.SearchEvents("tomatoes", EventFilter{})
+	if err != nil {
+		t.Fatalf("SearchEvents failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	if results[0].Type != "visible_text" {
+		t.Errorf("Expected visible_text result, got %s", results[0].Type)
+	}
+	if !strings.Contains(results[0].Snippet, "<mark>tomatoes</mark>") {
+		t.Errorf("Expected highlighted snippet, got %q", results[0].Snippet)
+	}
+
+	// Title matches count too
+	results, err = db.SearchEvents("gardening", EventFilter{})
+	if err != nil {
+		t.Fatalf("SearchEvents failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected 1 title match, got %d", len(results))
+	}
+
+	// Filters apply on top of the match
+	clickType := "click"
+	results, err = db.SearchEvents("seeds", EventFilter{EventType: &clickType})
+	if err != nil {
+		t.Fatalf("SearchEvents failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Type != "click" {
+		t.Errorf("Expected 1 click result, got %+v", results)
+	}
+}
+
+func TestSearchSnippetsEscapeHTML(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	err := db.InsertEvents([]models.Event{{
+		TSUTC: 1000,
+		TSISO: "1970-01-01T00:00:01Z",
+		URL:   "https://example.com",
+		Type:  "visible_text",
+		Data:  map[string]any{"text": `<script>alert("x")</script> tomatoes & <b>basil</b>`},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+
+	results, err := db.SearchEvents("tomatoes", EventFilter{})
+	if err != nil || len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d, %v", len(results), err)
+	}
+	want := `&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt; <mark>tomatoes</mark> &amp; &lt;b&gt;basil&lt;/b&gt;`
+	if strings.TrimSpace(results[0].Snippet) != want {
+		t.Errorf("Expected %q, got %q", want, results[0].Snippet)
+	}
+}
+
+func TestSearchEventsFollowsUpserts(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	sessionID := "session-1"
+	fieldID := "#search"
+	insert := func(value string) {
+		t.Helper()
+		err := db.InsertEvents([]models.Event{{
+			TSUTC:     1000,
+			TSISO:     "1970-01-01T00:00:01Z",
+			URL:       "https://example.com",
+			Type:      "input",
+			Data:      map[string]any{"selector": fieldID, "value": value},
+			SessionID: &sessionID,
+			FieldID:   &fieldID,
+		}})
+		if err != nil {
+			t.Fatalf("Failed to insert event: %v", err)
+		}
+	}
+
+	insert("first draft")
+	insert("final answer")
+
+	results, err := db.SearchEvents("draft", EventFilter{})
+	if err != nil {
+		t.Fatalf("SearchEvents failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected stale value to be gone from index, got %d results", len(results))
+	}
+
+	results, err = db.SearchEvents("answer", EventFilter{})
+	if err != nil {
+		t.Fatalf("SearchEvents failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected upserted value to be indexed, got %d results", len(results))
+	}
+
+	if _, err := db.DeleteAllEvents(); err != nil {
+		t.Fatalf("Failed to delete events: %v", err)
+	}
+	var indexed int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM events_fts").Scan(&indexed); err != nil {
+		t.Fatalf("Failed to count index rows: %v", err)
+	}
+	if indexed != 0 {
+		t.Errorf("Expected empty index after delete, got %d rows", indexed)
+	}
+}
+
+func TestSearchEventsQuotesInput(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	err := db.InsertEvents([]models.Event{{
+		TSUTC: 1000,
+		TSISO: "1970-01-01T00:00:01Z",
+		URL:   "https://example.com",
+		Type:  "visible_text",
+		Data:  map[string]any{"text": "state-of-the-art AND \"quoted\" text"},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+
+	for _, query := range []string{"state-of-the-art", `"quoted`, "AND", "text*"} {
+		if _, err := db.SearchEvents(query, EventFilter{}); err != nil {
+			t.Errorf("SearchEvents(%q) failed: %v", query, err)
+		}
+	}
+
+	if _, err := db.SearchEvents("   ", EventFilter{}); err == nil {
+		t.Error("Expected error for empty query")
+	}
+}
+
+func TestSearchIndexBackfill(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "browsetrace-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	dbPath := filepath.Join(tmpDir, "test.db")
+
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	err = db.InsertEvents([]models.Event{{
+		TSUTC: 1000,
+		TSISO: "1970-01-01T00:00:01Z",
+		URL:   "https://example.com",
+		Type:  "visible_text",
+		Data:  map[string]any{"text": "existing history"},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to insert event: %v", err)
+	}
+
+	// Simulate a database created before search existed
+	if _, err := db.db.Exec("DROP TABLE events_fts; DROP TRIGGER events_fts_insert; DROP TRIGGER events_fts_update; DROP TRIGGER events_fts_delete; PRAGMA user_version = 0"); err != nil {
+		t.Fatalf("Failed to drop search index: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	results, err := db.SearchEvents("history", EventFilter{})
+	if err != nil {
+		t.Fatalf("SearchEvents failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected backfilled event to be searchable, got %d results", len(results))
+	}
+}
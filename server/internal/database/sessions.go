@@ -0,0 +1,210 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// TabSwitchSessionID is the session_id the extension gives the navigate events
+// it emits on tab switches. It marks the event rather than naming a session,
+// so no session is recorded for it.
+const TabSwitchSessionID = "tab-switch"
+
+// ErrSessionNotFound is returned when no session has the requested id
+var ErrSessionNotFound = errors.New("session not found")
+
+// createSessionsTable sets up the sessions table and the triggers that keep it
+// in step with events, like the search index: an insert counts the event and
+// widens the session's time span, an upsert (which fires UPDATE) only moves
+// last_seen, and a delete uncounts it, dropping the session with its last
+// event. Deleting the first or last event recomputes the span and start_url
+// from the events left, so a deleted page's URL doesn't linger on its
+// session. start_url holds the stored URL, so it is encrypted whenever events
+// are, and the update trigger carries it along when events are re-encrypted.
+// Sessions of events stored before the table existed are backfilled.
+func createSessionsTable(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+	CREATE TABLE IF NOT EXISTS sessions(
+	  id             TEXT    PRIMARY KEY,
+	  first_seen_utc INTEGER NOT NULL,
+	  last_seen_utc  INTEGER NOT NULL,
+	  start_url      TEXT    NOT NULL,
+	  event_count    INTEGER NOT NULL,
+	  tab_id         INTEGER,
+	  window_id      INTEGER
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_last_seen ON sessions(last_seen_utc);
+	CREATE INDEX IF NOT EXISTS idx_events_session ON events(session_id);
+
+	CREATE TRIGGER IF NOT EXISTS sessions_insert AFTER INSERT ON events
+	WHEN new.session_id IS NOT NULL AND new.session_id != '` + TabSwitchSessionID + `'
+	BEGIN
+		INSERT INTO sessions(id, first_seen_utc, last_seen_utc, start_url, event_count)
+		VALUES (new.session_id, new.ts_utc, new.ts_utc, new.url, 1)
+		ON CONFLICT(id) DO UPDATE SET
+			start_url = CASE WHEN excluded.first_seen_utc < first_seen_utc THEN excluded.start_url ELSE start_url END,
+			first_seen_utc = min(first_seen_utc, excluded.first_seen_utc),
+			last_seen_utc = max(last_seen_utc, excluded.last_seen_utc),
+			event_count = event_count + 1;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS sessions_update AFTER UPDATE OF ts_utc, url ON events
+	WHEN new.session_id IS NOT NULL
+	BEGIN
+		UPDATE sessions SET
+			last_seen_utc = max(last_seen_utc, new.ts_utc),
+			start_url = CASE WHEN start_url = old.url THEN new.url ELSE start_url END
+		WHERE id = new.session_id;
+	END;
+
+	CREATE TRIGGER IF NOT EXISTS sessions_delete AFTER DELETE ON events
+	WHEN old.session_id IS NOT NULL
+	BEGIN
+		UPDATE sessions SET event_count = event_count - 1 WHERE id = old.session_id;
+		DELETE FROM sessions WHERE id = old.session_id AND event_count <= 0;
+		UPDATE sessions SET
+			first_seen_utc = (SELECT min(ts_utc) FROM events WHERE session_id = old.session_id),
+			last_seen_utc = (SELECT max(ts_utc) FROM events WHERE session_id = old.session_id),
+			start_url = (SELECT url FROM events WHERE session_id = old.session_id ORDER BY ts_utc, id LIMIT 1)
+		WHERE id = old.session_id AND (old.ts_utc <= first_seen_utc OR old.ts_utc >= last_seen_utc);
+	END;
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create sessions table: %w", err)
+	}
+
+	_, err = tx.Exec(`
+	INSERT OR IGNORE INTO sessions(id, first_seen_utc, last_seen_utc, start_url, event_count)
+	SELECT session_id, min(ts_utc), max(ts_utc),
+	  (SELECT url FROM events AS first WHERE first.session_id = events.session_id ORDER BY ts_utc, id LIMIT 1),
+	  count(*)
+	FROM events
+	WHERE session_id IS NOT NULL AND session_id != ?
+	GROUP BY session_id
+	`, TabSwitchSessionID)
+	if err != nil {
+		return fmt.Errorf("failed to backfill sessions: %w", err)
+	}
+	return nil
+}
+
+// recordSessionTabs stores the tab and window the extension reported for
+// events just inserted within transaction. Events carry them only on the way
+// in; they are kept on the session, not on each event.
+func recordSessionTabs(transaction *sql.Tx, events []models.Event) error {
+	for _, event := range events {
+		if event.SessionID == nil || (event.TabID == nil && event.WindowID == nil) {
+			continue
+		}
+		_, err := transaction.Exec(
+			"UPDATE sessions SET tab_id = coalesce(?, tab_id), window_id = coalesce(?, window_id) WHERE id = ?",
+			event.TabID, event.WindowID, *event.SessionID,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to record session tab: %w", err)
+		}
+	}
+	return nil
+}
+
+type SessionFilter struct {
+	SinceUTC *int64  // sessions still active at or after this time
+	UntilUTC *int64  // sessions started at or before this time
+	Cursor   *Cursor // only sessions strictly after this position in (last_seen_utc DESC, rowid DESC) order
+	Limit    int
+}
+
+// sessionColumns is the column list scanSession expects, in order
+const sessionColumns = "id, first_seen_utc, last_seen_utc, start_url, event_count, tab_id, window_id"
+
+// scanSession reads one row selected with sessionColumns from a *sql.Row or
+// *sql.Rows. Any extra destinations are scanned from the columns that follow.
+func scanSession(row interface{ Scan(...any) error }, extra ...any) (models.Session, error) {
+	var session models.Session
+	var startURL string
+	dest := append([]any{&session.ID, &session.FirstSeenUTC, &session.LastSeenUTC, &startURL,
+		&session.EventCount, &session.TabID, &session.WindowID}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return models.Session{}, fmt.Errorf("failed to scan row: %w", err)
+	}
+	var err error
+	session.StartURL, err = openURL(startURL)
+	if err != nil {
+		return models.Session{}, fmt.Errorf("failed to decrypt session %s: %w", session.ID, err)
+	}
+	return session, nil
+}
+
+// ListSessions returns sessions most recently active first along with the
+// cursor for the next page. The cursor is nil when there are no further
+// sessions to fetch.
+func (d *Database) ListSessions(filter SessionFilter) ([]models.Session, *Cursor, error) {
+	query := "SELECT " + sessionColumns + ", rowid FROM sessions WHERE 1=1"
+	args := []any{}
+
+	if filter.SinceUTC != nil {
+		query += " AND last_seen_utc >= ?"
+		args = append(args, *filter.SinceUTC)
+	}
+	if filter.UntilUTC != nil {
+		query += " AND first_seen_utc <= ?"
+		args = append(args, *filter.UntilUTC)
+	}
+	if filter.Cursor != nil {
+		query += " AND (last_seen_utc < ? OR (last_seen_utc = ? AND rowid < ?))"
+		args = append(args, filter.Cursor.TSUTC, filter.Cursor.TSUTC, filter.Cursor.ID)
+	}
+
+	query += " ORDER BY last_seen_utc DESC, rowid DESC"
+	if filter.Limit > 0 {
+		// Fetch one extra row to learn whether another page exists
+		query += " LIMIT ?"
+		args = append(args, filter.Limit+1)
+	}
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	var rowids []int64
+	for rows.Next() {
+		var rowid int64
+		session, err := scanSession(rows, &rowid)
+		if err != nil {
+			return nil, nil, err
+		}
+		sessions = append(sessions, session)
+		rowids = append(rowids, rowid)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	var next *Cursor
+	if filter.Limit > 0 && len(sessions) > filter.Limit {
+		sessions = sessions[:filter.Limit]
+		last := filter.Limit - 1
+		next = &Cursor{TSUTC: sessions[last].LastSeenUTC, ID: rowids[last]}
+	}
+
+	return sessions, next, nil
+}
+
+// GetSession returns the session with the given id
+func (d *Database) GetSession(id string) (*models.Session, error) {
+	session, err := scanSession(d.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
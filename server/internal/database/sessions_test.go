@@ -0,0 +1,262 @@
+package database
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func sessionTestEvents() []models.Event {
+	session := "session-1"
+	other := "session-2"
+	tabSwitch := TabSwitchSessionID
+	tab, window := int64(42), int64(7)
+	return []models.Event{
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://example.com/next", Type: "click", Data: map[string]any{"selector": "a", "text": "Next"}, SessionID: &session},
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://example.com/", Type: "navigate", Data: map[string]any{"from": nil, "to": "https://example.com/"}, SessionID: &session, TabID: &tab, WindowID: &window},
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://example.com/next", Type: "visible_text", Data: map[string]any{"text": "first"}, SessionID: &session},
+		{TSUTC: 5000, TSISO: "1970-01-01T00:00:05Z", URL: "https://other.org/", Type: "navigate", Data: map[string]any{"from": nil, "to": "https://other.org/"}, SessionID: &other},
+		{TSUTC: 6000, TSISO: "1970-01-01T00:00:06Z", URL: "https://other.org/", Type: "navigate", Data: map[string]any{"from": "https://example.com/", "to": "https://other.org/"}, SessionID: &tabSwitch},
+	}
+}
+
+func TestSessionsMaintainedOnIngestion(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(sessionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	// An upsert replaces the visible_text event, so it moves last_seen but is not counted
+	session := "session-1"
+	err := db.InsertEvents([]models.Event{
+		{TSUTC: 4000, TSISO: "1970-01-01T00:00:04Z", URL: "https://example.com/next", Type: "visible_text", Data: map[string]any{"text": "second"}, SessionID: &session},
+	})
+	if err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	sessions, next, err := db.ListSessions(SessionFilter{})
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if next != nil {
+		t.Errorf("Expected no next cursor without a limit, got %+v", next)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions without the tab switch marker, got %d", len(sessions))
+	}
+	if sessions[0].ID != "session-2" || sessions[1].ID != "session-1" {
+		t.Errorf("Expected sessions most recently active first, got %s, %s", sessions[0].ID, sessions[1].ID)
+	}
+
+	got := sessions[1]
+	if got.FirstSeenUTC != 1000 || got.LastSeenUTC != 4000 {
+		t.Errorf("Expected session-1 to span 1000-4000, got %d-%d", got.FirstSeenUTC, got.LastSeenUTC)
+	}
+	if got.StartURL != "https://example.com/" {
+		t.Errorf("Expected start URL of the earliest event, got %s", got.StartURL)
+	}
+	if got.EventCount != 3 {
+		t.Errorf("Expected 3 events, got %d", got.EventCount)
+	}
+	if got.TabID == nil || *got.TabID != 42 || got.WindowID == nil || *got.WindowID != 7 {
+		t.Errorf("Expected tab 42 in window 7, got %v, %v", got.TabID, got.WindowID)
+	}
+	if sessions[0].TabID != nil {
+		t.Errorf("Expected no tab for a session that never reported one, got %d", *sessions[0].TabID)
+	}
+
+	if _, err := db.GetSession(TabSwitchSessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound for the tab switch marker, got %v", err)
+	}
+}
+
+func TestListSessionsFilterAndPaging(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(sessionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	since := int64(4000)
+	sessions, _, err := db.ListSessions(SessionFilter{SinceUTC: &since})
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "session-2" {
+		t.Errorf("Expected only session-2 active since 4000, got %+v", sessions)
+	}
+
+	until := int64(1500)
+	sessions, _, err = db.ListSessions(SessionFilter{UntilUTC: &until})
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "session-1" {
+		t.Errorf("Expected only session-1 started by 1500, got %+v", sessions)
+	}
+
+	first, next, err := db.ListSessions(SessionFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(first) != 1 || next == nil {
+		t.Fatalf("Expected one session and a next cursor, got %d and %v", len(first), next)
+	}
+	second, next, err := db.ListSessions(SessionFilter{Limit: 1, Cursor: next})
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(second) != 1 || second[0].ID == first[0].ID || next != nil {
+		t.Errorf("Expected the other session on the last page, got %+v and %v", second, next)
+	}
+}
+
+func TestSessionsFollowDeletedEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(sessionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	if _, err := db.PruneOlderThan(nil, 2500, 100); err != nil {
+		t.Fatalf("PruneOlderThan failed: %v", err)
+	}
+	session, err := db.GetSession("session-1")
+	if err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+	if session.EventCount != 1 {
+		t.Errorf("Expected 1 event left in session-1, got %d", session.EventCount)
+	}
+
+	domain := "other.org"
+	if _, err := db.DeleteEvents(EventFilter{Domain: &domain}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+	if _, err := db.GetSession("session-2"); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected session-2 to go with its last event, got %v", err)
+	}
+
+	if _, err := db.DeleteAllEvents(); err != nil {
+		t.Fatalf("DeleteAllEvents failed: %v", err)
+	}
+	sessions, _, err := db.ListSessions(SessionFilter{})
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("Expected no sessions after deleting all events, got %d", len(sessions))
+	}
+}
+
+func TestSessionsForgetDeletedFirstAndLastEvents(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	if err := db.InsertEvents(sessionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	// The navigate event at 1000 started session-1
+	if _, err := db.PruneOlderThan(nil, 1500, 100); err != nil {
+		t.Fatalf("PruneOlderThan failed: %v", err)
+	}
+	session, err := db.GetSession("session-1")
+	if err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+	if session.FirstSeenUTC != 2000 || session.LastSeenUTC != 3000 {
+		t.Errorf("Expected session-1 to span 2000-3000, got %d-%d", session.FirstSeenUTC, session.LastSeenUTC)
+	}
+	if session.StartURL != "https://example.com/next" {
+		t.Errorf("Expected the deleted start URL to be replaced, got %s", session.StartURL)
+	}
+
+	eventType := "visible_text"
+	if _, err := db.DeleteEvents(EventFilter{EventType: &eventType}); err != nil {
+		t.Fatalf("DeleteEvents failed: %v", err)
+	}
+	session, err = db.GetSession("session-1")
+	if err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+	if session.FirstSeenUTC != 2000 || session.LastSeenUTC != 2000 || session.EventCount != 1 {
+		t.Errorf("Expected session-1 to hold only its click at 2000, got %+v", session)
+	}
+}
+
+func TestSessionsBackfilled(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	db, err := NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if err := db.InsertEvents(sessionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+
+	// Simulate a database created before sessions existed
+	if _, err := db.db.Exec("DROP TABLE sessions; DROP TRIGGER sessions_insert; DROP TRIGGER sessions_update; DROP TRIGGER sessions_delete; PRAGMA user_version = 7"); err != nil {
+		t.Fatalf("Failed to drop sessions table: %v", err)
+	}
+	db.Close()
+
+	db, err = NewDatabase(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to reopen database: %v", err)
+	}
+	defer db.Close()
+
+	session, err := db.GetSession("session-1")
+	if err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+	if session.FirstSeenUTC != 1000 || session.LastSeenUTC != 3000 || session.EventCount != 3 {
+		t.Errorf("Expected backfilled session-1 to span 1000-3000 with 3 events, got %+v", session)
+	}
+	if session.StartURL != "https://example.com/" {
+		t.Errorf("Expected start URL of the earliest event, got %s", session.StartURL)
+	}
+	if _, err := db.GetSession(TabSwitchSessionID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected no session backfilled for the tab switch marker, got %v", err)
+	}
+}
+
+func TestSessionStartURLEncrypted(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	// Stored in plaintext first, so that EncryptEvents has to carry the session along
+	if err := db.InsertEvents(sessionTestEvents()); err != nil {
+		t.Fatalf("InsertEvents failed: %v", err)
+	}
+	if err := db.EnableEncryption(testEncryptionKey(t, 5)); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+	if _, err := db.EncryptEvents(100); err != nil {
+		t.Fatalf("EncryptEvents failed: %v", err)
+	}
+
+	var plaintext int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM sessions WHERE instr(start_url, 'example.com')").Scan(&plaintext); err != nil {
+		t.Fatalf("Failed to query raw sessions: %v", err)
+	}
+	if plaintext != 0 {
+		t.Errorf("Expected start URLs not to be stored in plaintext, found %d", plaintext)
+	}
+
+	session, err := db.GetSession("session-1")
+	if err != nil {
+		t.Fatalf("GetSession failed: %v", err)
+	}
+	if session.StartURL != "https://example.com/" {
+		t.Errorf("Expected decrypted start URL, got %s", session.StartURL)
+	}
+}
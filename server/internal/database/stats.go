@@ -0,0 +1,159 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// GetStats computes aggregate metrics over the events matching the filter.
+// topDomains caps the number of entries in TopDomains; Limit on the filter is ignored.
+func (d *Database) GetStats(filter EventFilter, topDomains int) (*models.Stats, error) {
+	where, args, err := d.whereClause(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &models.Stats{
+		EventsByType: map[string]int64{},
+		EventsByDay:  []models.DayCount{},
+		TopDomains:   []models.DomainCount{},
+	}
+
+	var oldest, newest *int64
+	if err := d.db.QueryRow(
+		"SELECT COUNT(*), COUNT(DISTINCT session_id), MIN(ts_utc), MAX(ts_utc) FROM events"+where, args...,
+	).Scan(&stats.TotalEvents, &stats.DistinctSessions, &oldest, &newest); err != nil {
+		return nil, fmt.Errorf("failed to query event totals: %w", err)
+	}
+	stats.OldestTSUTC = oldest
+	stats.NewestTSUTC = newest
+
+	if err := d.countByType(stats, where, args); err != nil {
+		return nil, err
+	}
+	if err := d.countByDay(stats, where, args); err != nil {
+		return nil, err
+	}
+	if err := d.countByDomain(stats, where, args, topDomains); err != nil {
+		return nil, err
+	}
+
+	size, err := d.DatabaseSize()
+	if err != nil {
+		return nil, err
+	}
+	stats.DatabaseSizeBytes = size
+
+	return stats, nil
+}
+
+// DatabaseSize returns the size of the main database file in bytes
+func (d *Database) DatabaseSize() (int64, error) {
+	var pageCount, pageSize int64
+	if err := d.db.QueryRow("PRAGMA page_count").Scan(&pageCount); err != nil {
+		return 0, fmt.Errorf("failed to query page count: %w", err)
+	}
+	if err := d.db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("failed to query page size: %w", err)
+	}
+	return pageCount * pageSize, nil
+}
+
+func (d *Database) countByType(stats *models.Stats, where string, args []any) error {
+	rows, err := d.db.Query("SELECT type, COUNT(*) FROM events"+where+" GROUP BY type", args...)
+	if err != nil {
+		return fmt.Errorf("failed to count events by type: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var typeName string
+		var count int64
+		if err := rows.Scan(&typeName, &count); err != nil {
+			return fmt.Errorf("failed to scan type count: %w", err)
+		}
+		stats.EventsByType[typeName] = count
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) countByDay(stats *models.Stats, where string, args []any) error {
+	rows, err := d.db.Query(
+		"SELECT strftime('%Y-%m-%d', ts_utc / 1000, 'unixepoch') AS day, COUNT(*) FROM events"+where+" GROUP BY day ORDER BY day",
+		args...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to count events by day: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day models.DayCount
+		if err := rows.Scan(&day.Day, &day.Count); err != nil {
+			return fmt.Errorf("failed to scan day count: %w", err)
+		}
+		stats.EventsByDay = append(stats.EventsByDay, day)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+	return nil
+}
+
+// countByDomain groups by URL in SQL and folds URLs into hosts in Go,
+// since SQLite has no built-in URL parsing. Encrypted URLs group the same way
+// because they are encrypted deterministically.
+func (d *Database) countByDomain(stats *models.Stats, where string, args []any, limit int) error {
+	rows, err := d.db.Query("SELECT url, COUNT(*) FROM events"+where+" GROUP BY url", args...)
+	if err != nil {
+		return fmt.Errorf("failed to count events by url: %w", err)
+	}
+	defer rows.Close()
+
+	domains := map[string]int64{}
+	for rows.Next() {
+		var rawURL string
+		var count int64
+		if err := rows.Scan(&rawURL, &count); err != nil {
+			return fmt.Errorf("failed to scan url count: %w", err)
+		}
+		rawURL, err := openURL(rawURL)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt url: %w", err)
+		}
+		domains[hostOf(rawURL)] += count
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating rows: %w", err)
+	}
+
+	for domain, count := range domains {
+		stats.TopDomains = append(stats.TopDomains, models.DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(stats.TopDomains, func(i, j int) bool {
+		if stats.TopDomains[i].Count != stats.TopDomains[j].Count {
+			return stats.TopDomains[i].Count > stats.TopDomains[j].Count
+		}
+		return stats.TopDomains[i].Domain < stats.TopDomains[j].Domain
+	})
+	if limit > 0 && len(stats.TopDomains) > limit {
+		stats.TopDomains = stats.TopDomains[:limit]
+	}
+	return nil
+}
+
+// hostOf returns the lowercase host of rawURL, or rawURL itself if it has no host
+func hostOf(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return rawURL
+	}
+	return strings.ToLower(parsed.Hostname())
+}
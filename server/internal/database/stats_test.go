@@ -0,0 +1,125 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestGetStats(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	session1 := "session-1"
+	session2 := "session-2"
+	events := []models.Event{
+		{
+			TSUTC:     1700000000000, // 2023-11-14
+			TSISO:     "2023-11-14T22:13:20Z",
+			URL:       "https://Example.com/a",
+			Type:      "navigate",
+			Data:      map[string]any{},
+			SessionID: &session1,
+		},
+		{
+			TSUTC:     1700000001000,
+			TSISO:     "2023-11-14T22:13:21Z",
+			URL:       "https://example.com/b",
+			Type:      "click",
+			Data:      map[string]any{},
+			SessionID: &session1,
+		},
+		{
+			TSUTC:     1700100000000, // 2023-11-16
+			TSISO:     "2023-11-16T02:00:00Z",
+			URL:       "https://other.org/",
+			Type:      "click",
+			Data:      map[string]any{},
+			SessionID: &session2,
+		},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	stats, err := db.GetStats(EventFilter{}, 10)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+
+	if stats.TotalEvents != 3 {
+		t.Errorf("Expected 3 total events, got %d", stats.TotalEvents)
+	}
+	if stats.EventsByType["click"] != 2 || stats.EventsByType["navigate"] != 1 {
+		t.Errorf("Unexpected counts by type: %v", stats.EventsByType)
+	}
+	if len(stats.EventsByDay) != 2 {
+		t.Fatalf("Expected 2 days, got %d", len(stats.EventsByDay))
+	}
+	if stats.EventsByDay[0].Day != "2023-11-14" || stats.EventsByDay[0].Count != 2 {
+		t.Errorf("Unexpected first day: %+v", stats.EventsByDay[0])
+	}
+	if len(stats.TopDomains) != 2 || stats.TopDomains[0].Domain != "example.com" || stats.TopDomains[0].Count != 2 {
+		t.Errorf("Unexpected top domains: %+v", stats.TopDomains)
+	}
+	if stats.DistinctSessions != 2 {
+		t.Errorf("Expected 2 distinct sessions, got %d", stats.DistinctSessions)
+	}
+	if stats.DatabaseSizeBytes <= 0 {
+		t.Errorf("Expected positive database size, got %d", stats.DatabaseSizeBytes)
+	}
+	if stats.OldestTSUTC == nil || *stats.OldestTSUTC != 1700000000000 {
+		t.Errorf("Unexpected oldest timestamp: %v", stats.OldestTSUTC)
+	}
+	if stats.NewestTSUTC == nil || *stats.NewestTSUTC != 1700100000000 {
+		t.Errorf("Unexpected newest timestamp: %v", stats.NewestTSUTC)
+	}
+}
+
+func TestGetStatsTimeWindow(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	events := []models.Event{
+		{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://a.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://b.com", Type: "navigate", Data: map[string]any{}},
+		{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://c.com", Type: "navigate", Data: map[string]any{}},
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	since := int64(1500)
+	until := int64(2500)
+	stats, err := db.GetStats(EventFilter{SinceUTC: &since, UntilUTC: &until}, 10)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+
+	if stats.TotalEvents != 1 {
+		t.Errorf("Expected 1 event in window, got %d", stats.TotalEvents)
+	}
+	if len(stats.TopDomains) != 1 || stats.TopDomains[0].Domain != "b.com" {
+		t.Errorf("Unexpected top domains: %+v", stats.TopDomains)
+	}
+}
+
+func TestGetStatsEmpty(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	stats, err := db.GetStats(EventFilter{}, 10)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+
+	if stats.TotalEvents != 0 {
+		t.Errorf("Expected 0 events, got %d", stats.TotalEvents)
+	}
+	if stats.OldestTSUTC != nil || stats.NewestTSUTC != nil {
+		t.Error("Expected nil timestamps for empty database")
+	}
+	if stats.TopDomains == nil || stats.EventsByDay == nil {
+		t.Error("Expected non-nil slices for JSON encoding")
+	}
+}
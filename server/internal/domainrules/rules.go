@@ -0,0 +1,168 @@
+package domainrules
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Match kinds
+const (
+	MatchGlob  = "glob"
+	MatchRegex = "regex"
+)
+
+// Actions
+const (
+	ActionAllow        = "allow"         // store as usual; lets a narrow rule exempt part of a broader one
+	ActionDrop         = "drop"          // store nothing
+	ActionNavigateOnly = "navigate_only" // store navigate events only
+	ActionStripData    = "strip_data"    // store events with empty data and no title
+)
+
+type compiledRule struct {
+	rule    models.DomainRule
+	pattern *regexp.Regexp
+}
+
+// Normalize fills in defaults and checks that the rule can be compiled.
+// The returned error message is safe to send to the client.
+func Normalize(rule models.DomainRule) (models.DomainRule, error) {
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	if rule.Match == "" {
+		rule.Match = MatchGlob
+	}
+	_, err := compile(rule)
+	return rule, err
+}
+
+// compile turns a rule into a case-insensitive regexp on the host name.
+// Globs must match the whole host: "*" matches any run of characters,
+// including dots, and "?" a single character, so "*.bank.com" matches
+// "www.bank.com" and "a.b.bank.com" but not "bank.com". Regexes are used
+// as written and may match any part of the host unless anchored.
+func compile(rule models.DomainRule) (compiledRule, error) {
+	if rule.Pattern == "" {
+		return compiledRule{}, fmt.Errorf("pattern cannot be empty")
+	}
+	switch rule.Action {
+	case ActionAllow, ActionDrop, ActionNavigateOnly, ActionStripData:
+	default:
+		return compiledRule{}, fmt.Errorf("invalid action %q: must be allow, drop, navigate_only or strip_data", rule.Action)
+	}
+
+	var expression string
+	switch rule.Match {
+	case MatchGlob:
+		var builder strings.Builder
+		builder.WriteString("^")
+		for _, r := range strings.ToLower(rule.Pattern) {
+			switch r {
+			case '*':
+				builder.WriteString(".*")
+			case '?':
+				builder.WriteString(".")
+			default:
+				builder.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		builder.WriteString("$")
+		expression = builder.String()
+	case MatchRegex:
+		expression = "(?i)" + rule.Pattern
+	default:
+		return compiledRule{}, fmt.Errorf("invalid match %q: must be glob or regex", rule.Match)
+	}
+
+	pattern, err := regexp.Compile(expression)
+	if err != nil {
+		return compiledRule{}, fmt.Errorf("invalid pattern %q: %v", rule.Pattern, err)
+	}
+	return compiledRule{rule: rule, pattern: pattern}, nil
+}
+
+// Filter applies domain rules to incoming events. The rule set is swapped
+// atomically, so it can be reloaded while events are being ingested.
+type Filter struct {
+	rules atomic.Pointer[[]compiledRule]
+}
+
+func NewFilter() *Filter {
+	return &Filter{}
+}
+
+// Load replaces the active rules. Rules are evaluated in the order given.
+// Nothing changes if any rule fails to compile.
+func (f *Filter) Load(rules []models.DomainRule) error {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		c, err := compile(rule)
+		if err != nil {
+			return fmt.Errorf("domain rule %d: %w", rule.ID, err)
+		}
+		compiled = append(compiled, c)
+	}
+	f.rules.Store(&compiled)
+	return nil
+}
+
+// Match returns the first rule whose pattern matches the host of rawURL
+func (f *Filter) Match(rawURL string) (models.DomainRule, bool) {
+	rules := f.rules.Load()
+	if rules == nil || len(*rules) == 0 {
+		return models.DomainRule{}, false
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return models.DomainRule{}, false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "" {
+		return models.DomainRule{}, false
+	}
+
+	for _, c := range *rules {
+		if c.pattern.MatchString(host) {
+			return c.rule, true
+		}
+	}
+	return models.DomainRule{}, false
+}
+
+// Apply enforces the matching rule on event in place and returns false if
+// the event must not be stored. Navigate events also name the pages they
+// came from and went to; those on dropped hosts are blanked, so a kept
+// navigation doesn't record a visit to them.
+func (f *Filter) Apply(event *models.Event) bool {
+	if event.Type == "navigate" {
+		for _, key := range []string{"from", "to"} {
+			if value, ok := event.Data[key].(string); ok {
+				if rule, ok := f.Match(value); ok && rule.Action == ActionDrop {
+					event.Data[key] = ""
+				}
+			}
+		}
+	}
+
+	rule, ok := f.Match(event.URL)
+	if !ok {
+		return true
+	}
+
+	switch rule.Action {
+	case ActionDrop:
+		return false
+	case ActionNavigateOnly:
+		return event.Type == "navigate"
+	case ActionStripData:
+		event.Data = map[string]any{}
+		event.FieldID = nil
+		event.Title = nil
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package domainrules
+
+import (
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestFilterMatch(t *testing.T) {
+	filter := NewFilter()
+	err := filter.Load([]models.DomainRule{
+		{ID: 1, Pattern: "rates.mybank.com", Match: MatchGlob, Action: ActionAllow},
+		{ID: 2, Pattern: "*.mybank.com", Match: MatchGlob, Action: ActionDrop},
+		{ID: 3, Pattern: `^hr\.(corp|intra)\.example$`, Match: MatchRegex, Action: ActionNavigateOnly},
+		{ID: 4, Pattern: "health?.org", Match: MatchGlob, Action: ActionStripData},
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	tests := []struct {
+		url  string
+		want int64 // matching rule id, 0 for none
+	}{
+		{"https://rates.mybank.com/today", 1},
+		{"https://WWW.MyBank.com/login", 2},
+		{"https://a.b.mybank.com:8443/", 2},
+		{"https://mybank.com/", 0},
+		{"https://notmybank.com/", 0},
+		{"https://hr.intra.example/payroll", 3},
+		{"https://hr.intra.example.com/", 0},
+		{"https://health1.org/", 4},
+		{"https://health.org/", 0},
+		{"not a url", 0},
+	}
+
+	for _, tt := range tests {
+		rule, ok := filter.Match(tt.url)
+		if tt.want == 0 {
+			if ok {
+				t.Errorf("Match(%q) = rule %d, want no match", tt.url, rule.ID)
+			}
+			continue
+		}
+		if !ok || rule.ID != tt.want {
+			t.Errorf("Match(%q) = %d, %v, want rule %d", tt.url, rule.ID, ok, tt.want)
+		}
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	filter := NewFilter()
+
+	event := models.Event{URL: "https://bank.com", Type: "click", Data: map[string]any{"text": "Pay"}}
+	if !filter.Apply(&event) {
+		t.Error("Expected events to be kept before any rules are loaded")
+	}
+
+	err := filter.Load([]models.DomainRule{
+		{Pattern: "bank.com", Action: ActionDrop, Match: MatchGlob},
+		{Pattern: "hr.example", Action: ActionNavigateOnly, Match: MatchGlob},
+		{Pattern: "clinic.example", Action: ActionStripData, Match: MatchGlob},
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if filter.Apply(&event) {
+		t.Error("Expected bank.com event to be dropped")
+	}
+
+	navigate := models.Event{URL: "https://hr.example/me", Type: "navigate", Data: map[string]any{"referrer": "x"}}
+	click := models.Event{URL: "https://hr.example/me", Type: "click", Data: map[string]any{"text": "Salary"}}
+	if !filter.Apply(&navigate) || filter.Apply(&click) {
+		t.Error("Expected only navigate events to be kept for hr.example")
+	}
+	if navigate.Data["referrer"] != "x" {
+		t.Error("Expected navigate data to be kept")
+	}
+
+	fieldID := "diagnosis"
+	title := "Flu treatment - Clinic"
+	input := models.Event{URL: "https://clinic.example/form", Type: "input", Data: map[string]any{"value": "flu"}, FieldID: &fieldID, Title: &title}
+	if !filter.Apply(&input) {
+		t.Fatal("Expected clinic.example event to be kept")
+	}
+	if len(input.Data) != 0 || input.FieldID != nil || input.Title != nil {
+		t.Errorf("Expected data and title to be stripped, got %v, field %v, title %v", input.Data, input.FieldID, input.Title)
+	}
+
+	// Leaving or reaching a dropped host keeps the navigation but not the host
+	away := models.Event{URL: "https://news.example/", Type: "navigate",
+		Data: map[string]any{"from": "https://bank.com/accounts", "to": "https://news.example/"}}
+	if !filter.Apply(&away) {
+		t.Fatal("Expected the navigate event on news.example to be kept")
+	}
+	if away.Data["from"] != "" || away.Data["to"] != "https://news.example/" {
+		t.Errorf("Expected only the dropped host to be blanked, got %v", away.Data)
+	}
+	toward := models.Event{URL: "https://news.example/", Type: "navigate",
+		Data: map[string]any{"from": "https://news.example/", "to": "https://bank.com/login"}}
+	filter.Apply(&toward)
+	if toward.Data["to"] != "" || toward.Data["from"] != "https://news.example/" {
+		t.Errorf("Expected the dropped destination to be blanked, got %v", toward.Data)
+	}
+}
+
+func TestFilterLoadInvalidKeepsRules(t *testing.T) {
+	filter := NewFilter()
+	if err := filter.Load([]models.DomainRule{{Pattern: "bank.com", Match: MatchGlob, Action: ActionDrop}}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if err := filter.Load([]models.DomainRule{{Pattern: "(", Match: MatchRegex, Action: ActionDrop}}); err == nil {
+		t.Fatal("Expected error for invalid regex")
+	}
+	if _, ok := filter.Match("https://bank.com"); !ok {
+		t.Error("Expected previous rules to stay active after a failed load")
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	rule, err := Normalize(models.DomainRule{Pattern: "  *.bank.com ", Action: ActionDrop})
+	if err != nil {
+		t.Fatalf("Normalize failed: %v", err)
+	}
+	if rule.Pattern != "*.bank.com" || rule.Match != MatchGlob {
+		t.Errorf("Unexpected normalized rule: %+v", rule)
+	}
+
+	invalid := []models.DomainRule{
+		{Pattern: "", Action: ActionDrop},
+		{Pattern: "bank.com", Action: "block"},
+		{Pattern: "bank.com", Action: ActionDrop, Match: "exact"},
+		{Pattern: "[", Action: ActionDrop, Match: MatchRegex},
+	}
+	for _, rule := range invalid {
+		if _, err := Normalize(rule); err == nil {
+			t.Errorf("Expected error for %+v", rule)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// prefix marks an encrypted value: enc:v1:<key id>:<base64url nonce+ciphertext>
+const prefix = "enc:v1:"
+
+var (
+	// ErrUnknownKey is returned when a value was encrypted with a key that has not been loaded
+	ErrUnknownKey = errors.New("value was encrypted with a key that is not loaded")
+	// ErrMalformed is returned for values that look encrypted but cannot be parsed
+	ErrMalformed = errors.New("malformed encrypted value")
+)
+
+// Encrypt seals plaintext with a random nonce. field is bound to the
+// ciphertext as associated data, so a value cannot be moved to another column.
+func (k *Key) Encrypt(field string, plaintext []byte) string {
+	nonce := make([]byte, k.aead.NonceSize())
+	rand.Read(nonce)
+	return k.seal(field, plaintext, nonce)
+}
+
+// EncryptDeterministic seals plaintext with a nonce derived from it, so equal
+// plaintexts give equal ciphertexts. This keeps equality lookups and unique
+// indexes working, at the cost of revealing which values are equal.
+func (k *Key) EncryptDeterministic(field string, plaintext []byte) string {
+	mac := hmac.New(sha256.New, k.mac)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write(plaintext)
+	return k.seal(field, plaintext, mac.Sum(nil)[:k.aead.NonceSize()])
+}
+
+func (k *Key) seal(field string, plaintext, nonce []byte) string {
+	sealed := k.aead.Seal(nonce, nonce, plaintext, []byte(field))
+	return prefix + k.id + ":" + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func (k *Key) open(field, encoded string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	plaintext, err := k.aead.Open(nil, nonce, ciphertext, []byte(field))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", field, err)
+	}
+	return plaintext, nil
+}
+
+// Prefix is the start shared by every value encrypted with this key
+func (k *Key) Prefix() string {
+	return prefix + k.id + ":"
+}
+
+// IsEncrypted reports whether value was produced by Encrypt or EncryptDeterministic
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// KeyIDOf returns the id of the key value was encrypted with
+func KeyIDOf(value string) (string, bool) {
+	if !IsEncrypted(value) {
+		return "", false
+	}
+	id, _, ok := strings.Cut(value[len(prefix):], ":")
+	return id, ok
+}
+
+// Keyring holds every key that may be needed to read stored values, so data
+// written under an old key stays readable until it has been re-encrypted
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+}
+
+func (r *Keyring) Add(key *Key) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.keys == nil {
+		r.keys = map[string]*Key{}
+	}
+	r.keys[key.id] = key
+}
+
+// Decrypt returns the plaintext of value, or value itself if it is not encrypted
+func (r *Keyring) Decrypt(field, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	id, encoded, ok := strings.Cut(value[len(prefix):], ":")
+	if !ok {
+		return "", ErrMalformed
+	}
+
+	r.mu.RLock()
+	key := r.keys[id]
+	r.mu.RUnlock()
+	if key == nil {
+		return "", fmt.Errorf("%w (key id %s)", ErrUnknownKey, id)
+	}
+
+	plaintext, err := key.open(field, encoded)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,132 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T, fill byte) *Key {
+	t.Helper()
+	key, err := NewKey(bytes.Repeat([]byte{fill}, KeySize))
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	return key
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	key := testKey(t, 1)
+	var keyring Keyring
+	keyring.Add(key)
+
+	sealed := key.Encrypt("title", []byte("Inbox (3)"))
+	if !IsEncrypted(sealed) || strings.Contains(sealed, "Inbox") {
+		t.Fatalf("Expected an encrypted value, got %q", sealed)
+	}
+	if id, ok := KeyIDOf(sealed); !ok || id != key.ID() {
+		t.Errorf("Expected key id %s, got %s", key.ID(), id)
+	}
+	if !strings.HasPrefix(sealed, key.Prefix()) {
+		t.Errorf("Expected %q to start with %q", sealed, key.Prefix())
+	}
+
+	plain, err := keyring.Decrypt("title", sealed)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if plain != "Inbox (3)" {
+		t.Errorf("Expected 'Inbox (3)', got %q", plain)
+	}
+
+	// Random nonces make repeated encryptions differ
+	if key.Encrypt("title", []byte("Inbox (3)")) == sealed {
+		t.Error("Expected random encryption to differ between calls")
+	}
+
+	// Plaintext passes through unchanged
+	if plain, err := keyring.Decrypt("title", "plain title"); err != nil || plain != "plain title" {
+		t.Errorf("Expected plaintext passthrough, got %q, %v", plain, err)
+	}
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	key := testKey(t, 1)
+
+	first := key.EncryptDeterministic("url", []byte("https://example.com"))
+	second := key.EncryptDeterministic("url", []byte("https://example.com"))
+	if first != second {
+		t.Error("Expected equal ciphertexts for equal plaintexts")
+	}
+	if key.EncryptDeterministic("url", []byte("https://example.org")) == first {
+		t.Error("Expected different ciphertexts for different plaintexts")
+	}
+	if key.EncryptDeterministic("title", []byte("https://example.com")) == first {
+		t.Error("Expected the field to change the ciphertext")
+	}
+	if testKey(t, 2).EncryptDeterministic("url", []byte("https://example.com")) == first {
+		t.Error("Expected different keys to give different ciphertexts")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key := testKey(t, 1)
+	var keyring Keyring
+
+	sealed := key.Encrypt("data", []byte("{}"))
+	if _, err := keyring.Decrypt("data", sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Expected ErrUnknownKey, got %v", err)
+	}
+
+	keyring.Add(key)
+	if _, err := keyring.Decrypt("url", sealed); err == nil {
+		t.Error("Expected error when decrypting as another field")
+	}
+	if _, err := keyring.Decrypt("data", key.Prefix()+"!!!"); !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrMalformed, got %v", err)
+	}
+}
+
+func TestLoadingKeys(t *testing.T) {
+	encoded := GenerateKey()
+	parsed, err := ParseKey(encoded + "\n")
+	if err != nil {
+		t.Fatalf("ParseKey failed: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte(encoded), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	fromFile, err := ReadKeyFile(path)
+	if err != nil {
+		t.Fatalf("ReadKeyFile failed: %v", err)
+	}
+	if fromFile.ID() != parsed.ID() {
+		t.Errorf("Expected the same key from env and file, got %s and %s", parsed.ID(), fromFile.ID())
+	}
+
+	if _, err := ParseKey("c2hvcnQ="); err == nil {
+		t.Error("Expected error for a key of the wrong length")
+	}
+	if _, err := ParseKey("not base64!"); err == nil {
+		t.Error("Expected error for a key that is not base64")
+	}
+
+	salt := []byte("0123456789abcdef")
+	derived, err := DeriveKey("correct horse", salt)
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	again, _ := DeriveKey("correct horse", salt)
+	other, _ := DeriveKey("correct horse", []byte("fedcba9876543210"))
+	if derived.ID() != again.ID() || derived.ID() == other.ID() {
+		t.Error("Expected passphrase keys to depend on passphrase and salt only")
+	}
+	if _, err := DeriveKey("", salt); err == nil {
+		t.Error("Expected error for an empty passphrase")
+	}
+}
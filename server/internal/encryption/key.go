@@ -0,0 +1,135 @@
+package encryption
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// KeySize is the length in bytes of a raw key
+const KeySize = 32
+
+// passphraseIterations follows the current OWASP recommendation for PBKDF2-HMAC-SHA256
+const passphraseIterations = 600_000
+
+// Key encrypts and decrypts event fields. Two subkeys are derived from the
+// secret: one for AES-256-GCM and one for the synthetic nonces used by
+// deterministic encryption.
+type Key struct {
+	id   string
+	aead cipher.AEAD
+	mac  []byte
+}
+
+// NewKey derives a Key from a 32-byte secret
+func NewKey(secret []byte) (*Key, error) {
+	if len(secret) != KeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", KeySize, len(secret))
+	}
+
+	dataKey, err := hkdf.Key(sha256.New, secret, nil, "browsetrace data key", KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive data key: %w", err)
+	}
+	macKey, err := hkdf.Key(sha256.New, secret, nil, "browsetrace nonce key", KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive nonce key: %w", err)
+	}
+	id, err := hkdf.Key(sha256.New, secret, nil, "browsetrace key id", 4)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key id: %w", err)
+	}
+
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return &Key{id: hex.EncodeToString(id), aead: aead, mac: macKey}, nil
+}
+
+// ID is a short fingerprint stored with every ciphertext, so the right key
+// can be picked during rotation. It reveals nothing about the key itself.
+func (k *Key) ID() string {
+	return k.id
+}
+
+// ParseKey reads a base64-encoded 32-byte key, as printed by GenerateKey
+func ParseKey(encoded string) (*Key, error) {
+	encoded = strings.TrimSpace(encoded)
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if secret, err := encoding.DecodeString(encoded); err == nil {
+			return NewKey(secret)
+		}
+	}
+	return nil, fmt.Errorf("encryption key must be base64 encoded")
+}
+
+// ReadKeyFile reads a key file holding either the base64 key or the raw 32 bytes
+func ReadKeyFile(path string) (*Key, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if len(contents) == KeySize {
+		return NewKey(contents)
+	}
+	return ParseKey(string(contents))
+}
+
+// DeriveKey stretches a passphrase into a key. The salt must be stored
+// alongside the data so the same key can be derived again.
+func DeriveKey(passphrase string, salt []byte) (*Key, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+	secret, err := pbkdf2.Key(sha256.New, passphrase, salt, passphraseIterations, KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+	return NewKey(secret)
+}
+
+// GenerateKey returns a new random key in the format ParseKey accepts
+func GenerateKey() string {
+	secret := make([]byte, KeySize)
+	rand.Read(secret)
+	return base64.StdEncoding.EncodeToString(secret)
+}
+
+// PromptPassphrase asks for a passphrase on stderr. Input is hidden when stdin
+// is a terminal; otherwise a single line is read, so it can be piped in.
+func PromptPassphrase(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return string(passphrase), nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,194 @@
+// Package export encodes events one at a time in the formats GET /export
+// and the export command offer: NDJSON for moving history between machines,
+// CSV and Parquet for loading it into pandas, duckdb and the like.
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Format is an export file format
+type Format string
+
+const (
+	NDJSON  Format = "ndjson"
+	CSV     Format = "csv"
+	Parquet Format = "parquet"
+)
+
+// ErrNoParquet is returned for Parquet by builds without the parquet tag
+var ErrNoParquet = errors.New("parquet export is not built in: rebuild with -tags parquet")
+
+// ParseFormat reads a format name; empty means NDJSON
+func ParseFormat(name string) (Format, error) {
+	switch Format(name) {
+	case "":
+		return NDJSON, nil
+	case NDJSON, CSV:
+		return Format(name), nil
+	case Parquet:
+		if !parquetSupported {
+			return "", ErrNoParquet
+		}
+		return Parquet, nil
+	default:
+		return "", fmt.Errorf("invalid export format %q: must be ndjson, csv or parquet", name)
+	}
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case Parquet:
+		return "application/vnd.apache.parquet"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// Extension is the file name extension, with its dot
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// Encoder writes events as they come. Close flushes what it buffered, which
+// for Parquet means the last row group and the footer; it does not close the
+// underlying writer.
+type Encoder interface {
+	Encode(event models.Event) error
+	Close() error
+}
+
+func NewEncoder(format Format, w io.Writer) (Encoder, error) {
+	switch format {
+	case NDJSON:
+		return ndjsonEncoder{json.NewEncoder(w)}, nil
+	case CSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvEncoder{writer: writer, record: make([]string, 0, len(columns))}, nil
+	case Parquet:
+		return newParquetEncoder(w)
+	default:
+		return nil, fmt.Errorf("invalid export format %q", format)
+	}
+}
+
+// record is an event flattened for CSV and Parquet. Data fields common
+// enough to get their own column are strings, nil when missing; the data
+// column keeps the whole data object as JSON, so nothing is lost to flattening.
+type record struct {
+	ID        int64   `parquet:"id"`
+	TSUTC     int64   `parquet:"ts_utc,timestamp(millisecond)"`
+	TSISO     string  `parquet:"ts_iso"`
+	Type      string  `parquet:"type"`
+	URL       string  `parquet:"url"`
+	RawURL    *string `parquet:"raw_url"`
+	Title     *string `parquet:"title"`
+	SessionID *string `parquet:"session_id"`
+	FieldID   *string `parquet:"field_id"`
+	Selector  *string `parquet:"selector"`
+	Text      *string `parquet:"text"`
+	Value     *string `parquet:"value"`
+	From      *string `parquet:"from"`
+	To        *string `parquet:"to"`
+	Data      string  `parquet:"data"`
+}
+
+// columns names the record fields in order, as the CSV header
+var columns = []string{"id", "ts_utc", "ts_iso", "type", "url", "raw_url", "title", "session_id", "field_id",
+	"selector", "text", "value", "from", "to", "data"}
+
+func flatten(event models.Event) (record, error) {
+	row := record{ID: event.ID, TSUTC: event.TSUTC, TSISO: event.TSISO, Type: event.Type, URL: event.URL,
+		RawURL: event.RawURL, Title: event.Title, SessionID: event.SessionID, FieldID: event.FieldID}
+
+	var err error
+	for field, value := range map[string]**string{
+		"selector": &row.Selector, "text": &row.Text, "value": &row.Value, "from": &row.From, "to": &row.To,
+	} {
+		if *value, err = dataField(event.Data, field); err != nil {
+			return record{}, err
+		}
+	}
+
+	data := event.Data
+	if data == nil {
+		data = map[string]any{}
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return record{}, fmt.Errorf("failed to marshal event data: %w", err)
+	}
+	row.Data = string(encoded)
+	return row, nil
+}
+
+// dataField returns a data field as text: strings as they are, numbers,
+// booleans and nested values in their JSON form, nil when missing
+func dataField(data map[string]any, field string) (*string, error) {
+	switch value := data[field].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return &value, nil
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal data field %s: %w", field, err)
+		}
+		text := string(encoded)
+		return &text, nil
+	}
+}
+
+type ndjsonEncoder struct {
+	encoder *json.Encoder
+}
+
+func (e ndjsonEncoder) Encode(event models.Event) error {
+	return e.encoder.Encode(event)
+}
+
+func (e ndjsonEncoder) Close() error {
+	return nil
+}
+
+type csvEncoder struct {
+	writer *csv.Writer
+	record []string
+}
+
+func (e *csvEncoder) Encode(event models.Event) error {
+	row, err := flatten(event)
+	if err != nil {
+		return err
+	}
+	e.record = append(e.record[:0], strconv.FormatInt(row.ID, 10), strconv.FormatInt(row.TSUTC, 10),
+		row.TSISO, row.Type, row.URL)
+	for _, value := range []*string{row.RawURL, row.Title, row.SessionID, row.FieldID,
+		row.Selector, row.Text, row.Value, row.From, row.To} {
+		if value == nil {
+			e.record = append(e.record, "")
+		} else {
+			e.record = append(e.record, *value)
+		}
+	}
+	e.record = append(e.record, row.Data)
+	return e.writer.Write(e.record)
+}
+
+func (e *csvEncoder) Close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
@@ -0,0 +1,82 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestParseFormat(t *testing.T) {
+	// Parquet is only there in builds with the parquet tag
+	parquetFormat := Format("")
+	if parquetSupported {
+		parquetFormat = Parquet
+	}
+	tests := []struct {
+		name    string
+		format  Format
+		wantErr bool
+	}{
+		{"", NDJSON, false},
+		{"ndjson", NDJSON, false},
+		{"csv", CSV, false},
+		{"parquet", parquetFormat, !parquetSupported},
+		{"json", "", true},
+	}
+	for _, tt := range tests {
+		format, err := ParseFormat(tt.name)
+		if (err != nil) != tt.wantErr || format != tt.format {
+			t.Errorf("ParseFormat(%q) = %q, %v", tt.name, format, err)
+		}
+	}
+}
+
+func TestCSVFlattensData(t *testing.T) {
+	title := "Checkout"
+	events := []models.Event{
+		{ID: 1, TSUTC: 1000, TSISO: "2024-01-01T00:00:00Z", URL: "https://example.com", Type: "click",
+			Title: &title, Data: map[string]any{"selector": "#buy", "text": "Buy, now"}},
+		{ID: 2, TSUTC: 2000, TSISO: "2024-01-01T00:00:01Z", URL: "https://example.com", Type: "scroll",
+			Data: map[string]any{"from": 0.0, "to": 480.5}},
+	}
+
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(CSV, &buf)
+	if err != nil {
+		t.Fatalf("Failed to create encoder: %v", err)
+	}
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got %d records", len(records))
+	}
+	row := map[string]string{}
+	for i, name := range records[0] {
+		row[name] = records[1][i]
+	}
+	if row["ts_utc"] != "1000" || row["title"] != "Checkout" || row["selector"] != "#buy" || row["text"] != "Buy, now" || row["value"] != "" {
+		t.Errorf("Unexpected click row: %v", row)
+	}
+	if row["data"] != `{"selector":"#buy","text":"Buy, now"}` {
+		t.Errorf("Expected the data column to hold the JSON object, got %q", row["data"])
+	}
+	for i, name := range records[0] {
+		row[name] = records[2][i]
+	}
+	if row["from"] != "0" || row["to"] != "480.5" || row["session_id"] != "" {
+		t.Errorf("Unexpected scroll row: %v", row)
+	}
+}
@@ -0,0 +1,56 @@
+//go:build parquet
+
+package export
+
+import (
+	"io"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+const parquetSupported = true
+
+// rowGroupRows bounds the rows buffered before a row group is written out
+const rowGroupRows = 10000
+
+type parquetEncoder struct {
+	writer *parquet.GenericWriter[record]
+	rows   []record
+}
+
+func newParquetEncoder(w io.Writer) (Encoder, error) {
+	writer := parquet.NewGenericWriter[record](w)
+	return &parquetEncoder{writer: writer, rows: make([]record, 0, rowGroupRows)}, nil
+}
+
+func (e *parquetEncoder) Encode(event models.Event) error {
+	row, err := flatten(event)
+	if err != nil {
+		return err
+	}
+	e.rows = append(e.rows, row)
+	if len(e.rows) < rowGroupRows {
+		return nil
+	}
+	return e.flush()
+}
+
+// flush writes the buffered rows as one row group
+func (e *parquetEncoder) flush() error {
+	if _, err := e.writer.Write(e.rows); err != nil {
+		return err
+	}
+	e.rows = e.rows[:0]
+	return e.writer.Flush()
+}
+
+func (e *parquetEncoder) Close() error {
+	if len(e.rows) > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	return e.writer.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !parquet
+
+package export
+
+import "io"
+
+// parquetSupported is false unless built with -tags parquet, which pulls in
+// github.com/parquet-go/parquet-go
+const parquetSupported = false
+
+func newParquetEncoder(io.Writer) (Encoder, error) {
+	return nil, ErrNoParquet
+}
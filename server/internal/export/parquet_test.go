@@ -0,0 +1,52 @@
+//go:build parquet
+
+package export
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestParquetRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(Parquet, &buf)
+	if err != nil {
+		t.Fatalf("Failed to create encoder: %v", err)
+	}
+	total := rowGroupRows + 5
+	for i := 0; i < total; i++ {
+		event := models.Event{ID: int64(i + 1), TSUTC: int64(1000 * i), TSISO: "2024-01-01T00:00:00Z",
+			URL: "https://example.com", Type: "scroll", Data: map[string]any{"to": float64(i)}}
+		if err := encoder.Encode(event); err != nil {
+			t.Fatalf("Failed to encode: %v", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	file, err := parquet.OpenFile(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to open the Parquet file: %v", err)
+	}
+	if groups := len(file.RowGroups()); groups != 2 {
+		t.Errorf("Expected 2 row groups, got %d", groups)
+	}
+
+	rows, err := parquet.Read[record](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read the Parquet file: %v", err)
+	}
+	if len(rows) != total {
+		t.Fatalf("Expected %d rows, got %d", total, len(rows))
+	}
+	last := rows[total-1]
+	if last.ID != int64(total) || last.TSUTC != int64(1000*(total-1)) || last.Title != nil ||
+		last.To == nil || *last.To != "10004" {
+		t.Errorf("Unexpected last row: %+v", last)
+	}
+}
@@ -0,0 +1,129 @@
+// Package jsonschema validates decoded JSON values against event data schemas
+// with github.com/santhosh-tekuri/jsonschema, and reports the failures as
+// violations whose messages never include the offending value. Schemas
+// without $schema follow draft 2020-12; $ref may only point inside the schema.
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	validator "github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaURL names the schema being compiled; nothing is loaded from it
+const schemaURL = "schema.json"
+
+// Violation is one way a value fails its schema. Messages never include the
+// offending value, since event data may be sensitive.
+type Violation struct {
+	Path    string `json:"path"` // JSON Pointer into the value, "" for the value itself
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Path == "" {
+		return v.Message
+	}
+	return v.Path + ": " + v.Message
+}
+
+// Schema is a compiled schema, safe for concurrent use
+type Schema struct {
+	schema *validator.Schema
+}
+
+// Compile parses a schema document
+func Compile(raw []byte) (*Schema, error) {
+	var document any
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+	}
+	switch document.(type) {
+	case map[string]any, bool:
+	default:
+		return nil, errors.New("schema must be an object or a boolean")
+	}
+
+	compiler := validator.NewCompiler()
+	compiler.Draft = validator.Draft2020
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external reference %s is not allowed", url)
+	}
+	if err := compiler.AddResource(schemaURL, bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	schema, err := compiler.Compile(schemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &Schema{schema: schema}, nil
+}
+
+// Validate returns the ways value, as decoded by encoding/json, fails the
+// schema, ordered by path, or nil if it matches
+func (s *Schema) Validate(value any) []Violation {
+	err := s.schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+	var failure *validator.ValidationError
+	if !errors.As(err, &failure) {
+		return []Violation{{Message: err.Error()}}
+	}
+
+	var violations []Violation
+	collect(failure, &violations)
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return violations
+}
+
+// collect appends the innermost failures under failure. A failed anyOf or
+// oneOf is reported as a whole, since listing why every alternative failed
+// says little about what the data should have been.
+func collect(failure *validator.ValidationError, violations *[]Violation) {
+	keyword := keywordOf(failure.KeywordLocation)
+	if len(failure.Causes) == 0 || keyword == "anyOf" || keyword == "oneOf" {
+		if failure.Message != "" {
+			*violations = append(*violations, Violation{
+				Path:    failure.InstanceLocation,
+				Message: withoutValue(keyword, failure.Message),
+			})
+		}
+		return
+	}
+	for _, cause := range failure.Causes {
+		collect(cause, violations)
+	}
+}
+
+// keywordOf returns the last keyword of a keyword location such as
+// "/properties/count/minimum"
+func keywordOf(location string) string {
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+// withoutValue drops the instance value from the messages of the keywords
+// whose messages quote it
+func withoutValue(keyword, message string) string {
+	switch keyword {
+	case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+		message, _, _ = strings.Cut(message, " but found ")
+	case "multipleOf":
+		if _, divisor, ok := strings.Cut(message, " not multipleOf "); ok {
+			message = "must be a multiple of " + divisor
+		}
+	case "format":
+		if _, format, ok := strings.Cut(message, " is not valid "); ok {
+			message = "is not valid " + format
+		}
+	}
+	return message
+}
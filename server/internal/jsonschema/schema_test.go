@@ -0,0 +1,142 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func decode(t *testing.T, text string) any {
+	t.Helper()
+	var value any
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		t.Fatalf("Failed to decode %s: %v", text, err)
+	}
+	return value
+}
+
+func TestValidate(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"type": "object",
+		"required": ["selector", "count"],
+		"additionalProperties": false,
+		"properties": {
+			"selector": {"type": "string", "minLength": 1},
+			"from": {"type": ["string", "null"]},
+			"count": {"type": "integer", "minimum": 0},
+			"kind": {"enum": ["a", "b"]},
+			"tags": {"type": "array", "maxItems": 2, "items": {"type": "string", "pattern": "^[a-z]+$"}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"valid", `{"selector":"#a","from":null,"count":3,"kind":"a","tags":["x"]}`, nil},
+		{"missing required", `{"selector":"#a"}`, []string{"missing properties: 'count'"}},
+		{"wrong types", `{"selector":5,"count":1.5}`, []string{"/count: expected integer, but got number", "/selector: expected string, but got number"}},
+		{"bounds", `{"selector":"","count":-1}`, []string{"/count: must be >= 0", "/selector: length must be >= 1, but got 0"}},
+		{"enum", `{"selector":"#a","count":0,"kind":"c"}`, []string{`/kind: value must be one of "a", "b"`}},
+		{"items", `{"selector":"#a","count":0,"tags":["ok","NO","x"]}`, []string{"/tags: maximum 2 items required, but found 3 items", "/tags/1: does not match pattern '^[a-z]+$'"}},
+		{"additional", `{"selector":"#a","count":0,"extra/key":1}`, []string{"additionalProperties 'extra/key' not allowed"}},
+		{"not an object", `[]`, []string{"expected object, but got array"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, violation := range schema.Validate(decode(t, tt.value)) {
+				got = append(got, violation.String())
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestViolationsOmitValues(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"properties": {
+			"value": {"type": "string", "maxLength": 3, "const": "abc", "format": "email"},
+			"pin": {"type": "number", "maximum": 10, "multipleOf": 7}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	violations := schema.Validate(decode(t, `{"value":"hunter2","pin":4321}`))
+	if len(violations) != 5 {
+		t.Errorf("Expected 5 violations, got %v", violations)
+	}
+	for _, violation := range violations {
+		if strings.Contains(violation.Message, "hunter2") || strings.Contains(violation.Message, "4321") {
+			t.Errorf("Expected the value to be left out of %q", violation.Message)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	schemas := []string{
+		`not json`,
+		`"string"`,
+		`{"type":"text"}`,
+		`{"required":"selector"}`,
+		`{"properties":{"a":{"minLength":-1}}}`,
+		`{"pattern":"("}`,
+		`{"additionalProperties":5}`,
+		`{"$ref":"https://example.com/schema.json"}`,
+	}
+
+	for _, raw := range schemas {
+		if _, err := Compile([]byte(raw)); err == nil {
+			t.Errorf("Expected error for schema %s", raw)
+		}
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	schema, err := Compile([]byte(`{
+		"$defs": {"id": {"type": "string", "minLength": 1}},
+		"properties": {
+			"id": {"$ref": "#/$defs/id"},
+			"target": {"oneOf": [{"type": "string"}, {"type": "integer"}]}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if violations := schema.Validate(decode(t, `{"id":"a","target":3}`)); len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+	var got []string
+	for _, violation := range schema.Validate(decode(t, `{"id":"","target":[]}`)) {
+		got = append(got, violation.String())
+	}
+	want := []string{"/id: length must be >= 1, but got 0", "/target: oneOf failed"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestBooleanSchemas(t *testing.T) {
+	schema, err := Compile([]byte(`{"properties":{"anything":true,"nothing":false}}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	if violations := schema.Validate(decode(t, `{"anything":[1,{}]}`)); len(violations) != 0 {
+		t.Errorf("Expected true to accept any value, got %v", violations)
+	}
+	if violations := schema.Validate(decode(t, `{"nothing":null}`)); len(violations) != 1 {
+		t.Errorf("Expected false to reject any value, got %v", violations)
+	}
+}
@@ -1,16 +1,223 @@
 package models
 
+import "encoding/json"
+
 type Event struct {
+	ID        int64          `json:"id,omitempty"` // database row id, set on events read back
 	TSUTC     int64          `json:"ts_utc"`
 	TSISO     string         `json:"ts_iso"`
-	URL       string         `json:"url"`
-	Title     *string        `json:"title"`      // nullable
-	Type      string         `json:"type"`       // navigate|visible_text|click|input|focus
-	Data      map[string]any `json:"data"`       // arbitrary JSON
-	SessionID *string        `json:"session_id"` // nullable, set for all events
-	FieldID   *string        `json:"field_id"`   // nullable, only for input events
+	URL       string         `json:"url"`               // canonical, when ingestion canonicalizes URLs
+	RawURL    *string        `json:"raw_url,omitempty"` // as captured with secrets masked, when it differs from URL
+	Title     *string        `json:"title"`             // nullable
+	Type      string         `json:"type"`              // a registered EventType name
+	Data      map[string]any `json:"data"`              // arbitrary JSON
+	SessionID *string        `json:"session_id"`        // nullable, set for all events
+	FieldID   *string        `json:"field_id"`          // nullable, only for input events
+
+	// TabID and WindowID say which browser tab and window the event came from.
+	// They are recorded on the event's session rather than stored with it, so
+	// events read back from the database never have them.
+	TabID    *int64 `json:"tab_id,omitempty"`
+	WindowID *int64 `json:"window_id,omitempty"`
+
+	// SchemaViolations lists how Data fails its type's schema. Only events
+	// stored in lenient validation mode have any.
+	SchemaViolations []string `json:"schema_violations,omitempty"`
 }
 
 type Batch struct {
-	Events []Event `json:"events"`
-}
\ No newline at end of file
+	Events     []Event `json:"events"`
+	NextCursor string  `json:"next_cursor,omitempty"` // set on GET when more events remain
+}
+
+type DayCount struct {
+	Day   string `json:"day"` // YYYY-MM-DD, UTC
+	Count int64  `json:"count"`
+}
+
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int64  `json:"count"`
+}
+
+type Stats struct {
+	TotalEvents       int64            `json:"total_events"`
+	EventsByType      map[string]int64 `json:"events_by_type"`
+	EventsByDay       []DayCount       `json:"events_by_day"`
+	TopDomains        []DomainCount    `json:"top_domains"`
+	DistinctSessions  int64            `json:"distinct_sessions"`
+	DatabaseSizeBytes int64            `json:"database_size_bytes"`
+	OldestTSUTC       *int64           `json:"oldest_ts_utc"` // nullable, nil when no events match
+	NewestTSUTC       *int64           `json:"newest_ts_utc"` // nullable, nil when no events match
+	Retention         *RetentionReport `json:"retention,omitempty"`
+}
+
+type SearchResult struct {
+	Event
+	Snippet string  `json:"snippet"` // matched text with hits wrapped in <mark></mark>
+	Rank    float64 `json:"rank"`    // bm25 score, lower is more relevant
+}
+
+type SearchResults struct {
+	Results []SearchResult `json:"results"`
+}
+
+type RetentionReport struct {
+	LastRunUTC  *int64           `json:"last_run_utc"` // nullable, nil before the first run
+	LastPruned  map[string]int64 `json:"last_pruned"`  // rows deleted in the last run, keyed by rule
+	TotalPruned int64            `json:"total_pruned"` // rows deleted since the agent started
+}
+
+// DomainRule decides what is stored for events whose URL host matches Pattern
+type DomainRule struct {
+	ID         int64  `json:"id"`
+	Pattern    string `json:"pattern"`  // e.g. "*.mybank.com" or "^hr\.corp\.example$"
+	Match      string `json:"match"`    // glob|regex
+	Action     string `json:"action"`   // allow|drop|navigate_only|strip_data
+	Priority   int    `json:"priority"` // lower runs first; the first matching rule wins
+	CreatedUTC int64  `json:"created_utc"`
+}
+
+type DomainRules struct {
+	Rules []DomainRule `json:"rules"`
+}
+
+// EventType is an entry in the event type registry. The extension can register
+// new types at runtime instead of waiting for a server release.
+type EventType struct {
+	Name           string          `json:"name"`
+	Schema         json.RawMessage `json:"schema"`          // JSON Schema for Data; {} accepts any object
+	DedupKey       []string        `json:"dedup_key"`       // url|session_id|field_id; empty means every event is a new row
+	RetentionClass string          `json:"retention_class"` // retention rules can target a class with "@<class>"
+	Builtin        bool            `json:"builtin"`         // built-in types cannot be deleted
+	CreatedUTC     int64           `json:"created_utc"`
+}
+
+type EventTypes struct {
+	Types []EventType `json:"event_types"`
+}
+
+// SchemaViolation is one way an event in a posted batch fails its type's schema
+type SchemaViolation struct {
+	EventIndex int    `json:"event_index"` // position in the posted batch
+	Type       string `json:"type"`
+	Path       string `json:"path"` // JSON Pointer into data, "" for data itself
+	Message    string `json:"message"`
+}
+
+// ValidationError is the 400 response body for batches rejected by schema validation
+type ValidationError struct {
+	Error      string            `json:"error"`
+	Violations []SchemaViolation `json:"violations"`
+}
+
+// RejectedEvent is an event quarantined by partial ingestion instead of stored
+type RejectedEvent struct {
+	ID          int64           `json:"id"`
+	ReceivedUTC int64           `json:"received_utc"`
+	EventIndex  int             `json:"event_index"` // position in the posted batch
+	Reason      string          `json:"reason"`
+	Event       json.RawMessage `json:"event"` // the event as posted, after redaction
+}
+
+type RejectedEvents struct {
+	Events []RejectedEvent `json:"rejected_events"`
+}
+
+// IngestError explains why one event of a posted batch was rejected
+type IngestError struct {
+	EventIndex int    `json:"event_index"`
+	Reason     string `json:"reason"`
+}
+
+// ImportSummary is the response to POST /import
+type ImportSummary struct {
+	Imported   int           `json:"imported"`   // stored, including upserts
+	Upserted   int           `json:"upserted"`   // imported events that replaced a stored one
+	Duplicates int           `json:"duplicates"` // already stored, skipped
+	Rejected   int           `json:"rejected"`   // invalid lines, not stored
+	Dropped    int           `json:"dropped"`    // discarded by domain rules or redaction
+	Errors     []ImportError `json:"errors"`     // the first rejected lines
+}
+
+// ImportError explains why one line of an import was rejected
+type ImportError struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// IngestSummary is the response to a batch posted in partial mode
+type IngestSummary struct {
+	Accepted int           `json:"accepted"` // stored, including upserts
+	Upserted int           `json:"upserted"` // accepted events that replaced a stored one
+	Rejected int           `json:"rejected"` // quarantined in rejected_events
+	Dropped  int           `json:"dropped"`  // discarded by domain rules or redaction
+	Errors   []IngestError `json:"errors"`
+}
+
+// Backup describes one backup file of the database
+type Backup struct {
+	Name       string `json:"name"`
+	Path       string `json:"path"`
+	SizeBytes  int64  `json:"size_bytes"`
+	CreatedUTC int64  `json:"created_utc"` // Unix milliseconds, from the file name
+}
+
+type Backups struct {
+	Backups []Backup `json:"backups"`
+}
+
+// Session summarises the events of one page session, as recorded on ingestion
+type Session struct {
+	ID           string `json:"id"`
+	FirstSeenUTC int64  `json:"first_seen_utc"` // timestamp of the earliest event
+	LastSeenUTC  int64  `json:"last_seen_utc"`  // timestamp of the latest event
+	StartURL     string `json:"start_url"`      // URL of the earliest event
+	EventCount   int64  `json:"event_count"`    // events stored, not counting upserts
+	TabID        *int64 `json:"tab_id"`         // nullable, set when the extension reports it
+	WindowID     *int64 `json:"window_id"`      // nullable, set when the extension reports it
+}
+
+type Sessions struct {
+	Sessions   []Session `json:"sessions"`
+	NextCursor string    `json:"next_cursor,omitempty"` // set when more sessions remain
+}
+
+// Page aggregates the visits to one normalized URL. The visit fields cover
+// the visits in the requested time window.
+type Page struct {
+	URL           string      `json:"url"`   // normalized, see urlnorm.Normalize
+	Title         *string     `json:"title"` // nullable, the most recently seen title
+	Titles        []PageTitle `json:"titles"`
+	FirstVisitUTC int64       `json:"first_visit_utc"`
+	LastVisitUTC  int64       `json:"last_visit_utc"`
+	VisitCount    int64       `json:"visit_count"`
+	DwellMS       int64       `json:"dwell_ms"` // time spent on the page, in milliseconds
+}
+
+// PageTitle is one title a page has had
+type PageTitle struct {
+	Title        string `json:"title"`
+	FirstSeenUTC int64  `json:"first_seen_utc"`
+	LastSeenUTC  int64  `json:"last_seen_utc"`
+}
+
+type Pages struct {
+	Pages []Page `json:"pages"`
+}
+
+// TimeReportRow is the active time in one bucket, for one domain when the
+// report is grouped by domain
+type TimeReportRow struct {
+	BucketStartUTC int64   `json:"bucket_start_utc"`
+	Domain         *string `json:"domain,omitempty"`
+	ActiveMS       int64   `json:"active_ms"`   // time between activity events no further apart than the idle threshold
+	EventCount     int64   `json:"event_count"` // navigate, click, input and focus events
+}
+
+type TimeReport struct {
+	Group           string          `json:"group"`  // domain or total
+	Bucket          string          `json:"bucket"` // hour, day or week
+	IdleThresholdMS int64           `json:"idle_threshold_ms"`
+	Rows            []TimeReportRow `json:"rows"`
+}
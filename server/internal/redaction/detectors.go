@@ -0,0 +1,208 @@
+package redaction
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Detector finds sensitive text inside one string value of an event's data.
+// key is the data field the value came from, which lets field-level detectors
+// flag a whole value based on context rather than content.
+type Detector interface {
+	Name() string
+	// Find returns the [start, end) byte ranges of sensitive text in value
+	Find(event *models.Event, key, value string) [][]int
+}
+
+// DefaultDetectors returns the built-in detectors in priority order.
+// When matches overlap, the detector listed first wins.
+func DefaultDetectors() []Detector {
+	return []Detector{
+		passwordDetector{},
+		jwtDetector,
+		apiKeyDetector,
+		creditCardDetector{},
+		ssnDetector{},
+		emailDetector,
+		phoneDetector,
+	}
+}
+
+// regexDetector flags every match of a pattern
+type regexDetector struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+func (d regexDetector) Name() string { return d.name }
+
+func (d regexDetector) Find(_ *models.Event, _, value string) [][]int {
+	return d.pattern.FindAllStringIndex(value, -1)
+}
+
+var (
+	emailDetector = regexDetector{
+		name:    "email",
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	}
+
+	// North American and international numbers with separators, e.g.
+	// (555) 123-4567, 555.123.4567, +44 20 7946 0958
+	phoneDetector = regexDetector{
+		name:    "phone",
+		pattern: regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,4}\)|\b\d{2,4})[ .-]\d{3,4}[ .-]\d{4}\b`),
+	}
+
+	jwtDetector = regexDetector{
+		name:    "jwt",
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]{10,}`),
+	}
+
+	// Well-known token prefixes from common providers
+	apiKeyDetector = regexDetector{
+		name: "api_key",
+		pattern: regexp.MustCompile(`\b(?:` +
+			`sk-[A-Za-z0-9_-]{20,}` + // OpenAI, Anthropic style secret keys
+			`|(?:sk|rk|pk)_(?:live|test)_[A-Za-z0-9]{16,}` + // Stripe
+			`|gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,}` + // GitHub
+			`|AKIA[0-9A-Z]{16}` + // AWS access key id
+			`|AIza[0-9A-Za-z_-]{35}` + // Google API key
+			`|xox[abposr]-[A-Za-z0-9-]{10,}` + // Slack
+			`)`),
+	}
+
+	creditCardCandidate = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	ssnCandidate        = regexp.MustCompile(`\b(\d{3})-(\d{2})-(\d{4})\b`)
+)
+
+// creditCardDetector flags 13-19 digit numbers that pass the Luhn check
+type creditCardDetector struct{}
+
+func (creditCardDetector) Name() string { return "credit_card" }
+
+func (creditCardDetector) Find(_ *models.Event, _, value string) [][]int {
+	var matches [][]int
+	for _, match := range creditCardCandidate.FindAllStringIndex(value, -1) {
+		if luhnValid(value[match[0]:match[1]]) {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// luhnValid reports whether the digits in number (separators ignored) pass the Luhn checksum
+func luhnValid(number string) bool {
+	sum := 0
+	digits := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		digit := int(c - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+		double = !double
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// ssnDetector flags US social security numbers, skipping ranges the SSA never issues
+type ssnDetector struct{}
+
+func (ssnDetector) Name() string { return "ssn" }
+
+func (ssnDetector) Find(_ *models.Event, _, value string) [][]int {
+	var matches [][]int
+	for _, match := range ssnCandidate.FindAllStringSubmatchIndex(value, -1) {
+		area := value[match[2]:match[3]]
+		group := value[match[4]:match[5]]
+		serial := value[match[6]:match[7]]
+		if area == "000" || area == "666" || area[0] == '9' || group == "00" || serial == "0000" {
+			continue
+		}
+		matches = append(matches, match[:2])
+	}
+	return matches
+}
+
+// passwordFieldHints are substrings of selectors and field ids that mark a
+// form field as holding a secret. Short hints must appear as a separate word
+// so that "pin" matches "#card-pin" or "#cardPin" but not "#shopping".
+var (
+	passwordFieldHints = []string{"password", "passwd", "passcode", "secret"}
+	passwordFieldWords = []string{"pwd", "otp", "cvv", "cvc", "pin"}
+)
+
+// passwordDetector flags the whole value of fields that look like passwords,
+// whatever the value contains
+type passwordDetector struct{}
+
+func (passwordDetector) Name() string { return "password" }
+
+func (passwordDetector) Find(event *models.Event, key, value string) [][]int {
+	if key != "value" || value == "" {
+		return nil
+	}
+
+	context := []string{}
+	if selector, ok := event.Data["selector"].(string); ok {
+		context = append(context, selector)
+	}
+	if event.FieldID != nil {
+		context = append(context, *event.FieldID)
+	}
+
+	for _, text := range context {
+		lower := strings.ToLower(text)
+		for _, hint := range passwordFieldHints {
+			if strings.Contains(lower, hint) {
+				return [][]int{{0, len(value)}}
+			}
+		}
+		for _, word := range passwordFieldWords {
+			if containsWord(text, word) {
+				return [][]int{{0, len(value)}}
+			}
+		}
+	}
+	return nil
+}
+
+// containsWord reports whether word appears in text, ignoring case, as a
+// separate identifier segment delimited by punctuation or a camelCase hump
+func containsWord(text, word string) bool {
+	lower := strings.ToLower(text)
+	for start := 0; ; {
+		index := strings.Index(lower[start:], word)
+		if index < 0 {
+			return false
+		}
+		index += start
+		end := index + len(word)
+
+		startsWord := index == 0 || !isAlnum(text[index-1]) || (isUpper(text[index]) && !isUpper(text[index-1]))
+		endsWord := end == len(text) || !isAlnum(text[end]) || (isUpper(text[end]) && !isUpper(text[end-1]))
+		if startsWord && endsWord {
+			return true
+		}
+		start = index + 1
+	}
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || isUpper(c) || c >= '0' && c <= '9'
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,255 @@
+package redaction
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// KeyFileName is the file next to events.db holding the per-install key of
+// the hash action
+const KeyFileName = "redaction-key"
+
+// Action is what happens to text a detector flags
+type Action string
+
+const (
+	ActionKeep Action = "keep" // store unchanged
+	ActionMask Action = "mask" // replace with [REDACTED:<detector>]
+	ActionHash Action = "hash" // replace with a short HMAC-SHA-256 digest, so repeats can still be correlated
+	ActionDrop Action = "drop" // discard the whole event
+)
+
+// Config picks an action per detector, optionally overridden per event type
+type Config struct {
+	Default     Action                       // used for detectors without an entry in Actions
+	Actions     map[string]Action            // by detector name
+	TypeActions map[string]map[string]Action // by event type, then detector name
+	// HashKey keys the digests of the hash action. Without a secret key,
+	// guessable values such as phone numbers could be recovered by hashing
+	// every candidate, so it should be a per-install secret.
+	HashKey []byte
+}
+
+// DefaultConfig masks everything the built-in detectors find
+func DefaultConfig() Config {
+	return Config{Default: ActionMask}
+}
+
+// Disabled reports whether every detector is set to keep, so the stage can be skipped
+func (c Config) Disabled() bool {
+	if c.Default != ActionKeep {
+		return false
+	}
+	for _, action := range c.Actions {
+		if action != ActionKeep {
+			return false
+		}
+	}
+	for _, actions := range c.TypeActions {
+		for _, action := range actions {
+			if action != ActionKeep {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (c Config) action(eventType, detector string) Action {
+	if action, ok := c.TypeActions[eventType][detector]; ok {
+		return action
+	}
+	if action, ok := c.Actions[detector]; ok {
+		return action
+	}
+	if c.Default == "" {
+		return ActionMask
+	}
+	return c.Default
+}
+
+// ParseConfig reads a semicolon-separated spec on top of DefaultConfig, e.g.
+//
+//	email=hash;phone=keep;visible_text:email=keep;input:credit_card=drop
+//
+// "off" keeps everything and "default=<action>" changes the fallback action.
+// Entries prefixed with an event type apply only to that type.
+func ParseConfig(spec string) (Config, error) {
+	config := DefaultConfig()
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "off" {
+			config.Default = ActionKeep
+			continue
+		}
+
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("invalid redaction entry %q: expected detector=action", entry)
+		}
+		action := Action(strings.TrimSpace(value))
+		switch action {
+		case ActionKeep, ActionMask, ActionHash, ActionDrop:
+		default:
+			return Config{}, fmt.Errorf("invalid redaction action %q: must be keep, mask, hash or drop", value)
+		}
+
+		key = strings.TrimSpace(key)
+		if key == "default" {
+			config.Default = action
+			continue
+		}
+
+		eventType, detector, typed := strings.Cut(key, ":")
+		if !typed {
+			detector = eventType
+		}
+		if !knownDetector(detector) {
+			return Config{}, fmt.Errorf("unknown redaction detector %q", detector)
+		}
+
+		if typed {
+			if config.TypeActions == nil {
+				config.TypeActions = map[string]map[string]Action{}
+			}
+			if config.TypeActions[eventType] == nil {
+				config.TypeActions[eventType] = map[string]Action{}
+			}
+			config.TypeActions[eventType][detector] = action
+		} else {
+			if config.Actions == nil {
+				config.Actions = map[string]Action{}
+			}
+			config.Actions[detector] = action
+		}
+	}
+
+	return config, nil
+}
+
+func knownDetector(name string) bool {
+	for _, detector := range DefaultDetectors() {
+		if detector.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Redactor rewrites event data so that detected secrets are never stored
+type Redactor struct {
+	config    Config
+	detectors []Detector
+}
+
+// NewRedactor uses the built-in detectors unless others are given
+func NewRedactor(config Config, detectors ...Detector) *Redactor {
+	if len(detectors) == 0 {
+		detectors = DefaultDetectors()
+	}
+	return &Redactor{config: config, detectors: detectors}
+}
+
+// Redact rewrites the title and string values in event.Data in place and
+// returns false if the event must be dropped instead of stored
+func (r *Redactor) Redact(event *models.Event) bool {
+	keep := true
+	if event.Title != nil {
+		title := r.redactString(event, "title", *event.Title, &keep)
+		event.Title = &title
+	}
+	for key, value := range event.Data {
+		event.Data[key] = r.redactValue(event, key, value, &keep)
+	}
+	return keep
+}
+
+// redactValue walks nested objects and arrays; array elements inherit the key
+// of the field that holds the array
+func (r *Redactor) redactValue(event *models.Event, key string, value any, keep *bool) any {
+	switch v := value.(type) {
+	case string:
+		return r.redactString(event, key, v, keep)
+	case map[string]any:
+		for nestedKey, nested := range v {
+			v[nestedKey] = r.redactValue(event, nestedKey, nested, keep)
+		}
+		return v
+	case []any:
+		for i, nested := range v {
+			v[i] = r.redactValue(event, key, nested, keep)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+type match struct {
+	start, end int
+	detector   string
+	action     Action
+}
+
+func (r *Redactor) redactString(event *models.Event, key, value string, keep *bool) string {
+	// Detectors run in priority order, so a match that overlaps an earlier
+	// detector's match is discarded
+	var matches []match
+	for _, detector := range r.detectors {
+		action := r.config.action(event.Type, detector.Name())
+		if action == ActionKeep {
+			continue
+		}
+		for _, span := range detector.Find(event, key, value) {
+			if !overlapsAny(matches, span[0], span[1]) {
+				matches = append(matches, match{start: span[0], end: span[1], detector: detector.Name(), action: action})
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return value
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].start < matches[j].start })
+
+	var builder strings.Builder
+	position := 0
+	for _, m := range matches {
+		if m.action == ActionDrop {
+			*keep = false
+		}
+		builder.WriteString(value[position:m.start])
+		builder.WriteString(r.replacement(m, value[m.start:m.end]))
+		position = m.end
+	}
+	builder.WriteString(value[position:])
+	return builder.String()
+}
+
+func overlapsAny(matches []match, start, end int) bool {
+	for _, m := range matches {
+		if start < m.end && m.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) replacement(m match, secret string) string {
+	if m.action == ActionHash {
+		mac := hmac.New(sha256.New, r.config.HashKey)
+		mac.Write([]byte(secret))
+		return "[HASH:" + hex.EncodeToString(mac.Sum(nil)[:6]) + "]"
+	}
+	return "[REDACTED:" + m.detector + "]"
+}
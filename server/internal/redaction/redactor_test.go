@@ -0,0 +1,127 @@
+package redaction
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestRedactMasksByDefault(t *testing.T) {
+	redactor := NewRedactor(DefaultConfig())
+
+	title := "Inbox - jane@example.com"
+	event := models.Event{
+		Type:  "visible_text",
+		Title: &title,
+		Data: map[string]any{
+			"text": "Pay with 4111 1111 1111 1111 or call 555-123-4567",
+			"nested": map[string]any{
+				"emails": []any{"bob@example.org", 42},
+			},
+		},
+	}
+
+	if !redactor.Redact(&event) {
+		t.Fatal("Expected event to be kept")
+	}
+
+	text := event.Data["text"].(string)
+	if text != "Pay with [REDACTED:credit_card] or call [REDACTED:phone]" {
+		t.Errorf("Unexpected redacted text: %q", text)
+	}
+	if *event.Title != "Inbox - [REDACTED:email]" {
+		t.Errorf("Unexpected redacted title: %q", *event.Title)
+	}
+	emails := event.Data["nested"].(map[string]any)["emails"].([]any)
+	if emails[0] != "[REDACTED:email]" || emails[1] != 42 {
+		t.Errorf("Unexpected nested values: %v", emails)
+	}
+}
+
+func TestRedactPerTypeActions(t *testing.T) {
+	config, err := ParseConfig("email=hash;visible_text:email=keep;input:credit_card=drop")
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	config.HashKey = []byte("install-1")
+	redactor := NewRedactor(config)
+
+	visible := models.Event{Type: "visible_text", Data: map[string]any{"text": "jane@example.com"}}
+	redactor.Redact(&visible)
+	if visible.Data["text"] != "jane@example.com" {
+		t.Errorf("Expected email kept for visible_text, got %v", visible.Data["text"])
+	}
+
+	click := models.Event{Type: "click", Data: map[string]any{"text": "jane@example.com"}}
+	redactor.Redact(&click)
+	hashed := click.Data["text"].(string)
+	if !strings.HasPrefix(hashed, "[HASH:") || strings.Contains(hashed, "jane") {
+		t.Errorf("Expected hashed email for click, got %q", hashed)
+	}
+
+	// The same value hashes the same way so it can still be correlated
+	again := models.Event{Type: "click", Data: map[string]any{"text": "jane@example.com"}}
+	redactor.Redact(&again)
+	if again.Data["text"] != hashed {
+		t.Errorf("Expected stable hash, got %v and %v", again.Data["text"], hashed)
+	}
+
+	// Another install's key gives another digest
+	config.HashKey = []byte("install-2")
+	other := models.Event{Type: "click", Data: map[string]any{"text": "jane@example.com"}}
+	NewRedactor(config).Redact(&other)
+	if other.Data["text"] == hashed {
+		t.Errorf("Expected the digest to depend on the hash key, got %v for both", hashed)
+	}
+
+	input := models.Event{Type: "input", Data: map[string]any{"selector": "#card", "value": "4111111111111111"}}
+	if redactor.Redact(&input) {
+		t.Error("Expected input with card number to be dropped")
+	}
+}
+
+func TestRedactPasswordField(t *testing.T) {
+	redactor := NewRedactor(DefaultConfig())
+
+	event := models.Event{Type: "input", Data: map[string]any{"selector": "#password", "value": "correct horse battery staple"}}
+	redactor.Redact(&event)
+
+	if event.Data["value"] != "[REDACTED:password]" {
+		t.Errorf("Expected password value masked, got %v", event.Data["value"])
+	}
+	if event.Data["selector"] != "#password" {
+		t.Errorf("Expected selector untouched, got %v", event.Data["selector"])
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig("off")
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if !config.Disabled() {
+		t.Error("Expected 'off' to disable redaction")
+	}
+
+	config, err = ParseConfig("off;ssn=mask")
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	if config.Disabled() {
+		t.Error("Expected a single enabled detector to keep redaction on")
+	}
+	if config.action("click", "email") != ActionKeep || config.action("click", "ssn") != ActionMask {
+		t.Errorf("Unexpected actions: %+v", config)
+	}
+
+	if DefaultConfig().Disabled() {
+		t.Error("Expected default config to be enabled")
+	}
+
+	for _, spec := range []string{"email", "email=shred", "fingerprint=mask", "click:fingerprint=mask"} {
+		if _, err := ParseConfig(spec); err == nil {
+			t.Errorf("Expected error for spec %q", spec)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package retention
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule bounds how long, how many and how much of the events of one type are kept.
+// EventType "*" applies the limits to all events together, and "@<class>"
+// to every type registered with that retention class.
+type Rule struct {
+	EventType string
+	MaxAge    time.Duration // zero means no age limit
+	MaxRows   int64         // zero means no row limit
+	MaxBytes  int64         // bytes the events' fields may hold, zero means no size limit
+}
+
+type Policy struct {
+	Rules            []Rule
+	MaxDatabaseBytes int64         // zero means no size limit
+	Interval         time.Duration // time between prune runs
+	BatchSize        int           // rows deleted per statement
+}
+
+// AllTypes is the Rule.EventType that matches every event
+const AllTypes = "*"
+
+// ClassPrefix marks a Rule.EventType that names a retention class
+const ClassPrefix = "@"
+
+const (
+	defaultInterval  = time.Hour
+	defaultBatchSize = 1000
+)
+
+// Empty reports whether the policy never prunes anything
+func (p Policy) Empty() bool {
+	return len(p.Rules) == 0 && p.MaxDatabaseBytes == 0
+}
+
+// String formats the policy in the syntax accepted by ParsePolicy
+func (p Policy) String() string {
+	var parts []string
+	for _, rule := range p.Rules {
+		var limits []string
+		if rule.MaxAge > 0 {
+			limits = append(limits, "max_age="+formatDuration(rule.MaxAge))
+		}
+		if rule.MaxRows > 0 {
+			limits = append(limits, "max_rows="+strconv.FormatInt(rule.MaxRows, 10))
+		}
+		if rule.MaxBytes > 0 {
+			limits = append(limits, "max_db_size="+strconv.FormatInt(rule.MaxBytes, 10))
+		}
+		parts = append(parts, rule.EventType+":"+strings.Join(limits, ","))
+	}
+	if p.MaxDatabaseBytes > 0 {
+		parts = append(parts, "max_db_size="+strconv.FormatInt(p.MaxDatabaseBytes, 10))
+	}
+	if p.Interval > 0 && p.Interval != defaultInterval {
+		parts = append(parts, "interval="+formatDuration(p.Interval))
+	}
+	if p.BatchSize > 0 && p.BatchSize != defaultBatchSize {
+		parts = append(parts, "batch_size="+strconv.Itoa(p.BatchSize))
+	}
+	return strings.Join(parts, ";")
+}
+
+// ParsePolicy reads a policy from a semicolon-separated spec such as
+//
+//	visible_text:max_age=7d,max_db_size=500MB;@history:max_age=365d;*:max_rows=1000000;max_db_size=2GB;interval=30m
+//
+// Entries of the form type:key=value[,key=value] add a rule for that type,
+// or for a retention class when the type is written as @class. A rule's
+// max_db_size bounds the bytes the fields of its events hold, indexes aside.
+// Bare key=value entries set max_db_size for the whole database file,
+// interval or batch_size. Durations accept Go syntax plus a "d" suffix for
+// days; sizes accept KB, MB and GB.
+func ParsePolicy(spec string) (Policy, error) {
+	policy := Policy{Interval: defaultInterval, BatchSize: defaultBatchSize}
+
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		eventType, limits, isRule := strings.Cut(entry, ":")
+		if !isRule {
+			if err := policy.setOption(entry); err != nil {
+				return Policy{}, err
+			}
+			continue
+		}
+
+		rule := Rule{EventType: strings.TrimSpace(eventType)}
+		if rule.EventType == "" || rule.EventType == ClassPrefix {
+			return Policy{}, fmt.Errorf("retention rule %q has no event type", entry)
+		}
+		for _, limit := range strings.Split(limits, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(limit), "=")
+			if !ok {
+				return Policy{}, fmt.Errorf("invalid retention limit %q: expected key=value", limit)
+			}
+			switch key {
+			case "max_age":
+				age, err := parseDuration(value)
+				if err != nil {
+					return Policy{}, fmt.Errorf("invalid max_age for %s: %w", rule.EventType, err)
+				}
+				rule.MaxAge = age
+			case "max_rows":
+				rows, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || rows <= 0 {
+					return Policy{}, fmt.Errorf("invalid max_rows for %s: must be positive integer", rule.EventType)
+				}
+				rule.MaxRows = rows
+			case "max_db_size":
+				size, err := parseSize(value)
+				if err != nil {
+					return Policy{}, fmt.Errorf("invalid max_db_size for %s: %w", rule.EventType, err)
+				}
+				rule.MaxBytes = size
+			default:
+				return Policy{}, fmt.Errorf("unknown retention limit %q", key)
+			}
+		}
+		policy.Rules = append(policy.Rules, rule)
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) setOption(entry string) error {
+	key, value, ok := strings.Cut(entry, "=")
+	if !ok {
+		return fmt.Errorf("invalid retention option %q: expected key=value", entry)
+	}
+
+	switch key {
+	case "max_db_size":
+		size, err := parseSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid max_db_size: %w", err)
+		}
+		p.MaxDatabaseBytes = size
+	case "interval":
+		interval, err := parseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %w", err)
+		}
+		p.Interval = interval
+	case "batch_size":
+		batchSize, err := strconv.Atoi(value)
+		if err != nil || batchSize <= 0 {
+			return fmt.Errorf("invalid batch_size: must be positive integer")
+		}
+		p.BatchSize = batchSize
+	default:
+		return fmt.Errorf("unknown retention option %q", key)
+	}
+	return nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%q is not a positive number of days", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if duration <= 0 {
+		return 0, fmt.Errorf("%q must be positive", value)
+	}
+	return duration, nil
+}
+
+func formatDuration(duration time.Duration) string {
+	day := 24 * time.Hour
+	if duration%day == 0 {
+		return strconv.FormatInt(int64(duration/day), 10) + "d"
+	}
+	return duration.String()
+}
+
+func parseSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		bytes  int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(upper, unit.suffix); ok {
+			upper = strings.TrimSpace(number)
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(upper, 10, 64)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("%q is not a positive size", value)
+	}
+	return size * multiplier, nil
+}
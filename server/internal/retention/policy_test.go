@@ -0,0 +1,81 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy, err := ParsePolicy("visible_text:max_age=7d,max_db_size=500MB; navigate:max_age=8760h,max_rows=1000 ;*:max_rows=50000;max_db_size=2GB;interval=30m;batch_size=200")
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+
+	if len(policy.Rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %d", len(policy.Rules))
+	}
+	if policy.Rules[0].EventType != "visible_text" || policy.Rules[0].MaxAge != 7*24*time.Hour || policy.Rules[0].MaxBytes != 500<<20 {
+		t.Errorf("Unexpected visible_text rule: %+v", policy.Rules[0])
+	}
+	if policy.Rules[1].MaxAge != 8760*time.Hour || policy.Rules[1].MaxRows != 1000 {
+		t.Errorf("Unexpected navigate rule: %+v", policy.Rules[1])
+	}
+	if policy.Rules[2].EventType != AllTypes || policy.Rules[2].MaxRows != 50000 {
+		t.Errorf("Unexpected catch-all rule: %+v", policy.Rules[2])
+	}
+	if policy.MaxDatabaseBytes != 2<<30 {
+		t.Errorf("Expected 2GB size limit, got %d", policy.MaxDatabaseBytes)
+	}
+	if policy.Interval != 30*time.Minute {
+		t.Errorf("Expected 30m interval, got %s", policy.Interval)
+	}
+	if policy.BatchSize != 200 {
+		t.Errorf("Expected batch size 200, got %d", policy.BatchSize)
+	}
+
+	// String produces a spec that parses back to the same policy
+	reparsed, err := ParsePolicy(policy.String())
+	if err != nil {
+		t.Fatalf("ParsePolicy(String()) failed: %v", err)
+	}
+	if reparsed.String() != policy.String() {
+		t.Errorf("Round trip mismatch: %q vs %q", reparsed.String(), policy.String())
+	}
+}
+
+func TestParsePolicyDefaults(t *testing.T) {
+	policy, err := ParsePolicy("")
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	if !policy.Empty() {
+		t.Error("Expected empty policy")
+	}
+	if policy.Interval != defaultInterval || policy.BatchSize != defaultBatchSize {
+		t.Errorf("Unexpected defaults: %+v", policy)
+	}
+}
+
+func TestParsePolicyErrors(t *testing.T) {
+	specs := []string{
+		"click:max_age=soon",
+		"click:max_age=-1h",
+		"click:max_rows=0",
+		"click:max_rows",
+		"click:max_db_size=0",
+		"click:keep=forever",
+		":max_age=1d",
+		"@:max_age=1d",
+		"max_db_size=lots",
+		"interval=0d",
+		"batch_size=-5",
+		"unknown=1",
+		"garbage",
+	}
+
+	for _, spec := range specs {
+		if _, err := ParsePolicy(spec); err == nil {
+			t.Errorf("Expected error for spec %q", spec)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Pruner applies a Policy to the database, once or on a schedule
+type Pruner struct {
+	db     *database.Database
+	policy Policy
+	now    func() time.Time
+
+	mu     sync.Mutex
+	report models.RetentionReport
+}
+
+func NewPruner(db *database.Database, policy Policy) *Pruner {
+	if policy.Interval <= 0 {
+		policy.Interval = defaultInterval
+	}
+	if policy.BatchSize <= 0 {
+		policy.BatchSize = defaultBatchSize
+	}
+	return &Pruner{
+		db:     db,
+		policy: policy,
+		now:    time.Now,
+		report: models.RetentionReport{LastPruned: map[string]int64{}},
+	}
+}
+
+// Run prunes immediately and then every policy interval until ctx is cancelled
+func (p *Pruner) Run(ctx context.Context) {
+	if err := p.db.EnableIncrementalVacuum(); err != nil {
+		log.Printf("Retention: failed to enable incremental vacuum: %v", err)
+	}
+
+	ticker := time.NewTicker(p.policy.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.PruneOnce(); err != nil {
+			log.Printf("Retention: prune failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PruneOnce applies every rule and returns the rows deleted, keyed by rule
+// description. Rules run in the order they were written, each applying
+// max_age, then max_rows, then max_db_size to the events it covers, oldest
+// first. The database-wide max_db_size runs last and deletes the oldest
+// events whatever their type, so per-type limits decide which types give
+// way first.
+func (p *Pruner) PruneOnce() (map[string]int64, error) {
+	pruned := map[string]int64{}
+	var total int64
+
+	record := func(key string, count int64) {
+		if count > 0 {
+			pruned[key] += count
+			total += count
+		}
+	}
+	// Whatever was deleted before a failure is still reported
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		runUTC := p.now().UnixMilli()
+		p.report.LastRunUTC = &runUTC
+		p.report.LastPruned = pruned
+		p.report.TotalPruned += total
+		if total > 0 {
+			log.Printf("Retention: pruned %d events %v", total, pruned)
+		}
+	}()
+
+	for _, rule := range p.policy.Rules {
+		// Classes are resolved on every run, so types registered since the
+		// last run are covered
+		var eventTypes []string
+		if class, ok := strings.CutPrefix(rule.EventType, ClassPrefix); ok {
+			eventTypes = p.db.EventTypesInClass(class)
+			if len(eventTypes) == 0 {
+				continue
+			}
+		} else if rule.EventType != AllTypes {
+			eventTypes = []string{rule.EventType}
+		}
+
+		if rule.MaxAge > 0 {
+			cutoff := p.now().Add(-rule.MaxAge).UnixMilli()
+			count, err := p.db.PruneOlderThan(eventTypes, cutoff, p.policy.BatchSize)
+			record(rule.EventType+":max_age", count)
+			if err != nil {
+				return pruned, fmt.Errorf("max_age for %s: %w", rule.EventType, err)
+			}
+		}
+
+		if rule.MaxRows > 0 {
+			count, err := p.db.PruneExcessRows(eventTypes, rule.MaxRows, p.policy.BatchSize)
+			record(rule.EventType+":max_rows", count)
+			if err != nil {
+				return pruned, fmt.Errorf("max_rows for %s: %w", rule.EventType, err)
+			}
+		}
+
+		if rule.MaxBytes > 0 {
+			count, err := p.db.PruneExcessBytes(eventTypes, rule.MaxBytes, p.policy.BatchSize)
+			record(rule.EventType+":max_db_size", count)
+			if err != nil {
+				return pruned, fmt.Errorf("max_db_size for %s: %w", rule.EventType, err)
+			}
+		}
+	}
+
+	if p.policy.MaxDatabaseBytes > 0 {
+		count, err := p.db.PruneToSize(p.policy.MaxDatabaseBytes, p.policy.BatchSize)
+		record("max_db_size", count)
+		if err != nil {
+			return pruned, fmt.Errorf("max_db_size: %w", err)
+		}
+	}
+
+	if total > 0 {
+		if err := p.db.IncrementalVacuum(); err != nil {
+			return pruned, err
+		}
+	}
+
+	return pruned, nil
+}
+
+// Report returns a snapshot of what the pruner has deleted so far
+func (p *Pruner) Report() models.RetentionReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	report := p.report
+	report.LastPruned = make(map[string]int64, len(p.report.LastPruned))
+	for key, count := range p.report.LastPruned {
+		report.LastPruned[key] = count
+	}
+	return report
+}
@@ -0,0 +1,229 @@
+package retention
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func setupTestDB(t *testing.T) (*database.Database, func()) {
+	t.Helper()
+
+	tmpDir, err := os.MkdirTemp("", "browsetrace-retention-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	db, err := database.NewDatabase(filepath.Join(tmpDir, "test.db"))
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+
+	cleanup := func() {
+		db.Close()
+		os.RemoveAll(tmpDir)
+	}
+
+	return db, cleanup
+}
+
+func TestPruneTypeSize(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	var events []models.Event
+	for i := range 5 {
+		events = append(events, models.Event{
+			TSUTC: int64(1000 + i),
+			TSISO: time.UnixMilli(int64(1000 + i)).UTC().Format(time.RFC3339),
+			URL:   "https://example.com",
+			Type:  "visible_text",
+			Data:  map[string]any{"text": strings.Repeat("a", 1000)},
+		})
+	}
+	events = append(events, models.Event{
+		TSUTC: 500,
+		TSISO: "1970-01-01T00:00:00Z",
+		URL:   "https://example.com",
+		Type:  "click",
+		Data:  map[string]any{"selector": "#a", "text": strings.Repeat("b", 5000)},
+	})
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	// Each visible_text row holds a little over 1KB, so the two newest fit
+	policy, err := ParsePolicy("visible_text:max_db_size=2500B")
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	pruned, err := NewPruner(db, policy).PruneOnce()
+	if err != nil {
+		t.Fatalf("PruneOnce failed: %v", err)
+	}
+	if pruned["visible_text:max_db_size"] != 3 {
+		t.Errorf("Expected 3 visible_text pruned by size, got %v", pruned)
+	}
+
+	remaining, err := db.GetEvents(database.EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	kept := map[int64]bool{}
+	for _, event := range remaining {
+		kept[event.TSUTC] = true
+	}
+	if len(remaining) != 3 || !kept[1003] || !kept[1004] || !kept[500] {
+		t.Errorf("Expected the two newest visible_text and the older click to remain, got %v", kept)
+	}
+}
+
+func TestPruneOnce(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	now := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	event := func(eventType string, age time.Duration) models.Event {
+		return models.Event{
+			TSUTC: now.Add(-age).UnixMilli(),
+			TSISO: now.Add(-age).Format(time.RFC3339),
+			URL:   "https://example.com",
+			Type:  eventType,
+			Data:  map[string]any{},
+		}
+	}
+	events := []models.Event{
+		event("visible_text", 10*day),
+		event("visible_text", 8*day),
+		event("visible_text", 1*day),
+		event("navigate", 30*day),
+		event("navigate", 20*day),
+		event("navigate", 10*day),
+		event("click", 5*day),
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	policy, err := ParsePolicy("visible_text:max_age=7d;navigate:max_rows=1")
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	pruner := NewPruner(db, policy)
+	pruner.now = func() time.Time { return now }
+
+	pruned, err := pruner.PruneOnce()
+	if err != nil {
+		t.Fatalf("PruneOnce failed: %v", err)
+	}
+	if pruned["visible_text:max_age"] != 2 {
+		t.Errorf("Expected 2 visible_text pruned by age, got %d", pruned["visible_text:max_age"])
+	}
+	if pruned["navigate:max_rows"] != 2 {
+		t.Errorf("Expected 2 navigate pruned by rows, got %d", pruned["navigate:max_rows"])
+	}
+
+	remaining, err := db.CountEvents(database.EventFilter{})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if remaining != 3 {
+		t.Errorf("Expected 3 remaining events, got %d", remaining)
+	}
+
+	report := pruner.Report()
+	if report.TotalPruned != 4 {
+		t.Errorf("Expected 4 total pruned, got %d", report.TotalPruned)
+	}
+	if report.LastRunUTC == nil || *report.LastRunUTC != now.UnixMilli() {
+		t.Errorf("Unexpected last run: %v", report.LastRunUTC)
+	}
+
+	// A second run has nothing left to do but keeps the running total
+	if _, err := pruner.PruneOnce(); err != nil {
+		t.Fatalf("PruneOnce failed: %v", err)
+	}
+	report = pruner.Report()
+	if report.TotalPruned != 4 || len(report.LastPruned) != 0 {
+		t.Errorf("Unexpected report after idle run: %+v", report)
+	}
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	pruner := NewPruner(db, Policy{Rules: []Rule{{EventType: AllTypes, MaxRows: 10}}, Interval: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pruner.Run(ctx)
+		close(done)
+	}()
+
+	// Wait for the initial run before cancelling
+	deadline := time.Now().Add(5 * time.Second)
+	for pruner.Report().LastRunUTC == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+	if pruner.Report().LastRunUTC == nil {
+		t.Error("Expected an initial prune run")
+	}
+}
+
+func TestPruneOnceRetentionClass(t *testing.T) {
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	now := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	if _, err := db.CreateEventType(models.EventType{Name: "scroll", RetentionClass: "interaction"}); err != nil {
+		t.Fatalf("CreateEventType failed: %v", err)
+	}
+	var events []models.Event
+	for _, eventType := range []string{"click", "focus", "scroll", "navigate"} {
+		old := now.Add(-10 * 24 * time.Hour)
+		events = append(events, models.Event{TSUTC: old.UnixMilli(), TSISO: old.Format(time.RFC3339), URL: "https://example.com", Type: eventType, Data: map[string]any{}})
+	}
+	if err := db.InsertEvents(events); err != nil {
+		t.Fatalf("Failed to insert events: %v", err)
+	}
+
+	policy, err := ParsePolicy("@interaction:max_age=7d;@unused:max_rows=1")
+	if err != nil {
+		t.Fatalf("ParsePolicy failed: %v", err)
+	}
+	pruner := NewPruner(db, policy)
+	pruner.now = func() time.Time { return now }
+
+	pruned, err := pruner.PruneOnce()
+	if err != nil {
+		t.Fatalf("PruneOnce failed: %v", err)
+	}
+	if pruned["@interaction:max_age"] != 3 {
+		t.Errorf("Expected 3 interaction events pruned, got %v", pruned)
+	}
+
+	remaining, err := db.GetEvents(database.EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(remaining) != 1 || remaining[0].Type != "navigate" {
+		t.Errorf("Expected only the navigate event to remain, got %+v", remaining)
+	}
+}
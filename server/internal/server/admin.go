@@ -0,0 +1,55 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// handleBackup writes a backup of the database on POST and lists the
+// backups in the backup directory, newest first, on GET
+func (s *Server) handleBackup(w http.ResponseWriter, req *http.Request) {
+	if s.backups == nil {
+		http.Error(w, "Backups are not configured", http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		backups, err := s.backups.List()
+		if err != nil {
+			log.Printf("Backup error: %v", err)
+			http.Error(w, "Failed to list backups", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(models.Backups{Backups: backups}); err != nil {
+			log.Printf("JSON encoding error: %v", err)
+		}
+
+	case http.MethodPost:
+		// Large databases take longer than the server's WriteTimeout to copy;
+		// recorders in tests don't support this
+		controller := http.NewResponseController(w)
+		_ = controller.SetWriteDeadline(time.Time{})
+
+		backup, err := s.backups.Create()
+		if err != nil {
+			log.Printf("Backup error: %v", err)
+			http.Error(w, "Failed to back up database", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Backup: wrote %s (%d bytes)", backup.Name, backup.SizeBytes)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(backup); err != nil {
+			log.Printf("JSON encoding error: %v", err)
+		}
+
+	default:
+		http.Error(w, "GET or POST only", http.StatusMethodNotAllowed)
+	}
+}
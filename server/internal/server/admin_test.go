@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/backup"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestHandleBackup(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	routes := server.setupRoutes()
+
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/backup", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without backups configured, got %d", w.Code)
+	}
+
+	server.SetBackups(backup.NewManager(server.db, t.TempDir(), 1), 0)
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/backup", nil))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var created models.Backup
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode backup: %v", err)
+	}
+	if created.Name == "" || created.SizeBytes == 0 {
+		t.Errorf("Unexpected backup: %+v", created)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+	var list models.Backups
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode backups: %v", err)
+	}
+	if len(list.Backups) != 1 || list.Backups[0].Name != created.Name {
+		t.Errorf("Expected the new backup to be listed, got %+v", list.Backups)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/backup", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
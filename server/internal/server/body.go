@@ -0,0 +1,228 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DefaultMaxBodyBytes bounds a posted batch after decompression
+	DefaultMaxBodyBytes = 32 << 20
+	// DefaultMaxBatchEvents bounds the number of events in a posted batch
+	DefaultMaxBatchEvents = 10000
+)
+
+var (
+	errBodyTooLarge        = errors.New("request body too large")
+	errTooManyEvents       = errors.New("too many events in batch")
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// compressionError is a failure to decompress the body, as opposed to a
+// failure to parse what it holds
+type compressionError struct {
+	err error
+}
+
+func (e compressionError) Error() string {
+	return "invalid compressed body: " + e.err.Error()
+}
+
+func (e compressionError) Unwrap() error {
+	return e.err
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// SetBodyLimits changes the largest batch POST /events accepts, in bytes
+// after decompression and in events. Larger batches get a 413.
+func (s *Server) SetBodyLimits(maxBytes int64, maxEvents int) {
+	s.maxBodyBytes = maxBytes
+	s.maxBatchEvents = maxEvents
+}
+
+// openBody returns the request body decompressed as Content-Encoding says,
+// failing with errBodyTooLarge past limit bytes, or never if limit is 0.
+// Without the header, for clients that compress but cannot set it, gzip and
+// zstd are recognized by their magic bytes.
+func (s *Server) openBody(w http.ResponseWriter, req *http.Request, limit int64) (io.ReadCloser, error) {
+	source := req.Body
+	if limit > 0 {
+		// The compressed size cannot exceed the decompressed limit either
+		source = http.MaxBytesReader(w, req.Body, limit)
+	}
+	body := bufio.NewReader(source)
+
+	encoding := strings.ToLower(strings.TrimSpace(req.Header.Get("Content-Encoding")))
+	if encoding == "" {
+		if magic, _ := body.Peek(len(zstdMagic)); bytes.HasPrefix(magic, zstdMagic) {
+			encoding = "zstd"
+		} else if bytes.HasPrefix(magic, gzipMagic) {
+			encoding = "gzip"
+		}
+	}
+
+	limited := &limitedBody{closer: req.Body, limited: limit > 0, remaining: limit, compressed: true}
+	switch encoding {
+	case "", "identity":
+		limited.src, limited.compressed = body, false
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, compressionError{err}
+		}
+		limited.src = reader
+	case "zstd":
+		// Decoded synchronously, without the decoder's background goroutines,
+		// and never allocating more than the body may decompress to
+		options := []zstd.DOption{zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true)}
+		if limit > 0 {
+			options = append(options, zstd.WithDecoderMaxMemory(uint64(limit)+1))
+		}
+		decoder, err := zstd.NewReader(body, options...)
+		if err != nil {
+			return nil, compressionError{err}
+		}
+		limited.src, limited.decoder = decoder, decoder
+	default:
+		return nil, errUnsupportedEncoding
+	}
+	return limited, nil
+}
+
+// limitedBody fails with errBodyTooLarge once more than the limit was read,
+// which also stops compressed bodies that expand without bound
+type limitedBody struct {
+	src        io.Reader
+	closer     io.Closer
+	decoder    *zstd.Decoder // released on Close, nil unless the body is zstd
+	limited    bool
+	remaining  int64
+	compressed bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.limited {
+		if b.remaining < 0 {
+			return 0, errBodyTooLarge
+		}
+		// Read one byte past the limit to tell a body of exactly the limit from a larger one
+		if int64(len(p)) > b.remaining+1 {
+			p = p[:b.remaining+1]
+		}
+	}
+	n, err := b.src.Read(p)
+	b.remaining -= int64(n)
+	if b.limited && b.remaining < 0 {
+		return n, errBodyTooLarge
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return n, errBodyTooLarge
+	}
+	// zstd frames may declare their size up front, past the decoder's limit
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return n, errBodyTooLarge
+	}
+	if err != nil && err != io.EOF && b.compressed {
+		return n, compressionError{err}
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	if b.decoder != nil {
+		b.decoder.Close()
+	}
+	return b.closer.Close()
+}
+
+// decodeEvents streams the events array of a posted batch, calling handle with
+// the decoder positioned on each event in turn, so the batch is never held in
+// memory as raw JSON. Other keys of the batch object are skipped.
+func (s *Server) decodeEvents(body io.Reader, handle func(dec *json.Decoder) error) error {
+	dec := json.NewDecoder(body)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		// encoding/json matches keys case-insensitively, and so did Batch
+		if key, _ := token.(string); !strings.EqualFold(key, "events") {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		token, err = dec.Token()
+		if err != nil {
+			return err
+		}
+		if token == nil {
+			continue
+		}
+		if token != json.Delim('[') {
+			return fmt.Errorf("events must be an array")
+		}
+		for count := 0; dec.More(); count++ {
+			if count == s.maxBatchEvents {
+				return errTooManyEvents
+			}
+			if err := handle(dec); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v", delim)
+	}
+	return nil
+}
+
+// writeBodyError answers a batch that could not be read with the matching status
+func (s *Server) writeBodyError(w http.ResponseWriter, err error) {
+	var compressionErr compressionError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errBodyTooLarge), errors.As(err, &maxBytesErr):
+		http.Error(w, fmt.Sprintf("Request body too large: limit is %d bytes", s.maxBodyBytes), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errTooManyEvents):
+		http.Error(w, fmt.Sprintf("Too many events in batch: limit is %d", s.maxBatchEvents), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, errUnsupportedEncoding):
+		http.Error(w, "Unsupported Content-Encoding: use gzip or zstd", http.StatusUnsupportedMediaType)
+	case errors.As(err, &compressionErr):
+		http.Error(w, "Invalid compressed body", http.StatusBadRequest)
+	default:
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vincentbai/browsetrace-server/internal/database"
+)
+
+func clickBatch(count int) []byte {
+	var events []string
+	for i := 0; i < count; i++ {
+		events = append(events, fmt.Sprintf(`{"ts_utc":%d,"ts_iso":"2024-01-01T00:00:00Z","url":"https://example.com","type":"click","data":{"selector":"#a","text":"A"}}`, 1000+i))
+	}
+	return []byte(`{"events":[` + strings.Join(events, ",") + `]}`)
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	writer.Close()
+	return buf.Bytes()
+}
+
+func zstdFrame(data []byte) []byte {
+	encoder, _ := zstd.NewWriter(nil)
+	defer encoder.Close()
+	return encoder.EncodeAll(data, nil)
+}
+
+func TestHandlePostEventsCompressed(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		body     []byte
+		encoding string
+	}{
+		{"gzip", gzipped(t, clickBatch(2)), "gzip"},
+		{"sniffed gzip", gzipped(t, clickBatch(2)), ""},
+		{"sniffed zstd", zstdFrame(clickBatch(2)), ""},
+		{"zstd", zstdFrame(clickBatch(2)), "zstd"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(tt.body))
+		// What browsers send for a string body without headers
+		req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+		if tt.encoding != "" {
+			req.Header.Set("Content-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		server.handleEvents(w, req)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("%s: expected status 204, got %d: %s", tt.name, w.Code, w.Body.String())
+		}
+	}
+
+	count, err := server.db.CountEvents(database.EventFilter{})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if count != int64(2*len(tests)) {
+		t.Errorf("Expected %d stored events, got %d", 2*len(tests), count)
+	}
+}
+
+func TestHandlePostEventsBodyLimits(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.SetBodyLimits(4096, 3)
+
+	// Compresses to a few hundred bytes but expands past the limit
+	padding := []byte(`{"events":[],"padding":"` + strings.Repeat(" ", 1<<20) + `"}`)
+	bomb := gzipped(t, padding)
+
+	tests := []struct {
+		name     string
+		path     string
+		body     []byte
+		encoding string
+		status   int
+	}{
+		{"within limits", "/events", clickBatch(3), "", http.StatusNoContent},
+		{"too many events", "/events", clickBatch(4), "", http.StatusRequestEntityTooLarge},
+		{"too many events, partial", "/events?mode=partial", clickBatch(4), "", http.StatusRequestEntityTooLarge},
+		{"too large", "/events", []byte(`{"events":[],"padding":"` + strings.Repeat("x", 8192) + `"}`), "", http.StatusRequestEntityTooLarge},
+		{"decompresses too large", "/events", bomb, "", http.StatusRequestEntityTooLarge},
+		{"zstd decompresses too large", "/events", zstdFrame(padding), "", http.StatusRequestEntityTooLarge},
+		{"unsupported encoding", "/events", clickBatch(1), "br", http.StatusUnsupportedMediaType},
+		{"corrupt gzip", "/events", []byte{0x1f, 0x8b, 0x08, 0x00, 0x01, 0x02}, "", http.StatusBadRequest},
+		{"corrupt zstd", "/events", zstdFrame(clickBatch(1))[:40], "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(tt.body))
+		if tt.encoding != "" {
+			req.Header.Set("Content-Encoding", tt.encoding)
+		}
+		w := httptest.NewRecorder()
+		server.handleEvents(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, w.Code, w.Body.String())
+		}
+	}
+}
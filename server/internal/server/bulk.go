@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/export"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+const (
+	// importChunkSize is how many events of an import go into one transaction
+	importChunkSize = 500
+	// maxImportErrors bounds the rejected lines listed in an import summary
+	maxImportErrors = 100
+)
+
+// handleExport streams the events matching the GET /events filters, oldest
+// first, as newline-delimited JSON in the format POST /import reads, or as
+// CSV or Parquet with format=csv or format=parquet
+func (s *Server) handleExport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	filter, err := s.parseEventFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limitParam := query.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid 'limit' parameter: must be positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	format, err := export.ParseFormat(query.Get("format"))
+	if errors.Is(err, export.ErrNoParquet) {
+		http.Error(w, "Parquet export is not built into this server", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid 'format' parameter: must be ndjson, csv or parquet", http.StatusBadRequest)
+		return
+	}
+
+	// Exports outlive the server's WriteTimeout; recorders in tests don't support this
+	controller := http.NewResponseController(w)
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="browsetrace-export`+format.Extension()+`"`)
+
+	exported := 0
+	encoder, err := export.NewEncoder(format, w)
+	if err == nil {
+		err = s.db.EachEvent(filter, func(event models.Event) error {
+			exported++
+			return encoder.Encode(event)
+		})
+	}
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err != nil {
+		log.Printf("Export error: %v", err)
+		// Once rows were written the status is sent, and the truncated body is all the client sees
+		if exported == 0 {
+			http.Error(w, "Failed to export events", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("Exported %d events", exported)
+}
+
+// handleImport loads newline-delimited JSON events, as GET /export writes
+// them, and answers with the import summary
+func (s *Server) handleImport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Imports outlive the server's timeouts; recorders in tests don't support this
+	controller := http.NewResponseController(w)
+	_ = controller.SetReadDeadline(time.Time{})
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	// Only one line at a time is held in memory, so the body itself is unbounded
+	body, err := s.openBody(w, req, 0)
+	if err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	defer body.Close()
+
+	summary, err := s.Import(body)
+	var tooLong lineTooLongError
+	var storeErr storeError
+	switch {
+	case errors.As(err, &tooLong):
+		http.Error(w, fmt.Sprintf("Line %d too long: limit is %d bytes", tooLong.line, tooLong.limit), http.StatusRequestEntityTooLarge)
+		return
+	case errors.As(err, &storeErr):
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to store events", http.StatusInternalServerError)
+		return
+	case err != nil:
+		s.writeBodyError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+// lineTooLongError is returned by Import for a line over the body size limit
+type lineTooLongError struct {
+	line  int
+	limit int64
+}
+
+func (e lineTooLongError) Error() string {
+	return fmt.Sprintf("line %d too long: limit is %d bytes", e.line, e.limit)
+}
+
+// storeError wraps database failures during an import, as opposed to bad input
+type storeError struct {
+	err error
+}
+
+func (e storeError) Error() string {
+	return e.err.Error()
+}
+
+func (e storeError) Unwrap() error {
+	return e.err
+}
+
+// Import reads newline-delimited JSON events through the same validation,
+// domain rules and redaction as POST /events, keeping the raw_url they were
+// exported with, and publishes the stored ones to /events/stream subscribers
+// like POST /events does. Events already stored are skipped, so an import
+// that failed half way can simply be repeated. Invalid lines are listed in
+// the summary rather than failing the import. On error the summary covers
+// what was stored before it.
+func (s *Server) Import(r io.Reader) (models.ImportSummary, error) {
+	summary := models.ImportSummary{Errors: []models.ImportError{}}
+	reject := func(line int, reason string) {
+		summary.Rejected++
+		if len(summary.Errors) < maxImportErrors {
+			summary.Errors = append(summary.Errors, models.ImportError{Line: line, Reason: reason})
+		}
+	}
+
+	chunk := make([]models.Event, 0, importChunkSize)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		result, err := s.db.ImportEvents(chunk)
+		if err != nil {
+			return storeError{err}
+		}
+		summary.Imported += result.Imported
+		summary.Upserted += result.Upserted
+		summary.Duplicates += result.Duplicates
+		s.hub.Publish(result.Events)
+		chunk = chunk[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	// The larger of the buffer's capacity and the maximum bounds a line
+	maxLine := int(s.maxBodyBytes)
+	scanner.Buffer(make([]byte, 0, min(64<<10, maxLine)), maxLine)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var event models.Event
+		if err := json.Unmarshal(text, &event); err != nil {
+			reject(line, "invalid JSON: "+err.Error())
+			continue
+		}
+		event.ID = 0
+
+		// Exported URLs are already canonical, so canonicalizing them again
+		// would lose the raw_url they were captured with
+		rawURL := event.RawURL
+		reason := s.rejectionReason(&event)
+		switch s.screenEvent(&event) {
+		case dropBlocked, dropRedacted:
+			summary.Dropped++
+			continue
+		}
+		if rawURL != nil && *rawURL != event.URL {
+			if s.canonicalizer != nil {
+				scrubbed := s.canonicalizer.Scrub(*rawURL)
+				rawURL = &scrubbed
+			}
+			event.RawURL = rawURL
+		}
+		if reason != "" {
+			reject(line, reason)
+			continue
+		}
+
+		chunk = append(chunk, event)
+		if len(chunk) == importChunkSize {
+			if err := flush(); err != nil {
+				return summary, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return summary, lineTooLongError{line + 1, s.maxBodyBytes}
+		}
+		return summary, err
+	}
+	if err := flush(); err != nil {
+		return summary, err
+	}
+
+	log.Printf("Imported %d events, skipped %d duplicates, rejected %d lines",
+		summary.Imported, summary.Duplicates, summary.Rejected)
+	return summary, nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+	"github.com/vincentbai/browsetrace-server/internal/urlnorm"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	source, cleanupSource := setupTestServer(t)
+	defer cleanupSource()
+
+	postW := httptest.NewRecorder()
+	source.handleEvents(postW, httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(clickBatch(3))))
+	if postW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", postW.Code)
+	}
+
+	w := httptest.NewRecorder()
+	source.setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?type=click", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("Expected application/x-ndjson, got %q", contentType)
+	}
+	export := w.Body.Bytes()
+
+	var lines []models.Event
+	scanner := bufio.NewScanner(bytes.NewReader(export))
+	for scanner.Scan() {
+		var event models.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Failed to decode line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, event)
+	}
+	if len(lines) != 3 || lines[0].TSUTC != 1000 || lines[2].TSUTC != 1002 {
+		t.Fatalf("Expected 3 clicks oldest first, got %+v", lines)
+	}
+
+	target, cleanupTarget := setupTestServer(t)
+	defer cleanupTarget()
+	routes := target.setupRoutes()
+
+	importFile := func(body []byte) models.ImportSummary {
+		t.Helper()
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/import", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var summary models.ImportSummary
+		if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+			t.Fatalf("Failed to decode summary: %v", err)
+		}
+		return summary
+	}
+
+	// A bad line is reported without failing the rest
+	withBadLine := append(append([]byte{}, export...), []byte("\n{not json}\n"+`{"ts_utc":5,"ts_iso":"x","url":"https://example.com","type":"tab_switch","data":{}}`+"\n")...)
+	summary := importFile(withBadLine)
+	if summary.Imported != 3 || summary.Rejected != 2 || len(summary.Errors) != 2 || summary.Errors[0].Line != 5 {
+		t.Errorf("Unexpected first import: %+v", summary)
+	}
+
+	summary = importFile(export)
+	if summary.Imported != 0 || summary.Duplicates != 3 {
+		t.Errorf("Expected re-import to skip every event, got %+v", summary)
+	}
+
+	count, err := target.db.CountEvents(database.EventFilter{})
+	if err != nil {
+		t.Fatalf("CountEvents failed: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 imported events, got %d", count)
+	}
+}
+
+func TestImportKeepsRawURLAndPublishes(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.SetCanonicalizer(urlnorm.NewCanonicalizer(urlnorm.DefaultConfig()))
+
+	subscription := server.hub.Subscribe(func(models.Event) bool { return true })
+	defer server.hub.Unsubscribe(subscription)
+
+	line := `{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com/docs","raw_url":"https://example.com/docs?utm_source=mail&token=abc","type":"click","data":{"selector":"#a","text":"A"}}`
+	summary, err := server.Import(strings.NewReader(line + "\n"))
+	if err != nil || summary.Imported != 1 {
+		t.Fatalf("Expected one imported event, got %+v, %v", summary, err)
+	}
+
+	events, err := server.db.GetEvents(database.EventFilter{})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected one stored event, got %d, %v", len(events), err)
+	}
+	if raw := events[0].RawURL; raw == nil || *raw != "https://example.com/docs?utm_source=mail&token=REDACTED" {
+		t.Errorf("Expected the imported raw_url with its token masked, got %v", raw)
+	}
+
+	select {
+	case event := <-subscription.Events():
+		if event.ID != events[0].ID {
+			t.Errorf("Expected the stored event to be published, got id %d", event.ID)
+		}
+	default:
+		t.Error("Expected the imported event to be published")
+	}
+}
+
+func TestExportFormats(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	postW := httptest.NewRecorder()
+	server.handleEvents(postW, httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(clickBatch(2))))
+	if postW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", postW.Code)
+	}
+	routes := server.setupRoutes()
+
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export?format=csv", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, ".csv") {
+		t.Errorf("Expected a .csv filename, got %q", disposition)
+	}
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,ts_utc,") {
+		t.Fatalf("Expected a header and 2 rows, got %q", w.Body.String())
+	}
+	if !strings.Contains(lines[1], ",1000,") || !strings.Contains(lines[1], ",#a,A,") {
+		t.Errorf("Expected flattened click columns, got %q", lines[1])
+	}
+}
+
+func TestExportImportErrors(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	routes := server.setupRoutes()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/export", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/export?limit=0", "", http.StatusBadRequest},
+		{http.MethodGet, "/export?since=yesterday", "", http.StatusBadRequest},
+		{http.MethodGet, "/export?format=xlsx", "", http.StatusBadRequest},
+		{http.MethodGet, "/import", "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		routes.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, w.Code)
+		}
+	}
+
+	server.SetBodyLimits(64, DefaultMaxBatchEvents)
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/import", bytes.NewReader(clickBatch(1))))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for an overlong line, got %d", w.Code)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/domainrules"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Domain rules are managed by the extension popup and the desktop app.
+// Every change is applied to ingestion immediately, without a restart.
+
+func (s *Server) handleDomainRules(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		s.handleListDomainRules(w)
+	case http.MethodPost:
+		s.handleCreateDomainRule(w, req)
+	default:
+		http.Error(w, "GET or POST only", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleDomainRule(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid rule id: must be positive integer", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		s.handleGetDomainRule(w, id)
+	case http.MethodPut:
+		s.handleUpdateDomainRule(w, req, id)
+	case http.MethodDelete:
+		s.handleDeleteDomainRule(w, id)
+	default:
+		http.Error(w, "GET, PUT, or DELETE only", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleListDomainRules(w http.ResponseWriter) {
+	rules, err := s.db.ListDomainRules()
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to retrieve domain rules", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := models.DomainRules{Rules: rules}
+	if rules == nil {
+		response.Rules = []models.DomainRule{}
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+// decodeDomainRule reads and validates a rule from the request body,
+// writing a 400 response and returning false if it is invalid
+func decodeDomainRule(w http.ResponseWriter, req *http.Request) (models.DomainRule, bool) {
+	var rule models.DomainRule
+	if err := json.NewDecoder(req.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return rule, false
+	}
+
+	rule, err := domainrules.Normalize(rule)
+	if err != nil {
+		http.Error(w, "Invalid domain rule: "+err.Error(), http.StatusBadRequest)
+		return rule, false
+	}
+	return rule, true
+}
+
+func (s *Server) handleCreateDomainRule(w http.ResponseWriter, req *http.Request) {
+	rule, ok := decodeDomainRule(w, req)
+	if !ok {
+		return
+	}
+
+	created, err := s.db.CreateDomainRule(rule)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to create domain rule", http.StatusInternalServerError)
+		return
+	}
+	s.reloadDomainRulesAfterChange()
+
+	log.Printf("Created domain rule %d: %s %s", created.ID, created.Action, created.Pattern)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleGetDomainRule(w http.ResponseWriter, id int64) {
+	rule, err := s.db.GetDomainRule(id)
+	if errors.Is(err, database.ErrDomainRuleNotFound) {
+		http.Error(w, "Domain rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to retrieve domain rule", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rule); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleUpdateDomainRule(w http.ResponseWriter, req *http.Request, id int64) {
+	rule, ok := decodeDomainRule(w, req)
+	if !ok {
+		return
+	}
+	rule.ID = id
+
+	updated, err := s.db.UpdateDomainRule(rule)
+	if errors.Is(err, database.ErrDomainRuleNotFound) {
+		http.Error(w, "Domain rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to update domain rule", http.StatusInternalServerError)
+		return
+	}
+	s.reloadDomainRulesAfterChange()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleDeleteDomainRule(w http.ResponseWriter, id int64) {
+	err := s.db.DeleteDomainRule(id)
+	if errors.Is(err, database.ErrDomainRuleNotFound) {
+		http.Error(w, "Domain rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to delete domain rule", http.StatusInternalServerError)
+		return
+	}
+	s.reloadDomainRulesAfterChange()
+
+	log.Printf("Deleted domain rule %d", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reloadDomainRulesAfterChange only logs failures: the change itself is
+// stored, and the previous rules stay active until the next reload succeeds
+func (s *Server) reloadDomainRulesAfterChange() {
+	if err := s.ReloadDomainRules(); err != nil {
+		log.Printf("Failed to reload domain rules: %v", err)
+	}
+}
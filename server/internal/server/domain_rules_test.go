@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestDomainRulesRoutes(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	mux := server.setupRoutes()
+
+	createW := httptest.NewRecorder()
+	mux.ServeHTTP(createW, httptest.NewRequest(http.MethodPost, "/domain-rules", strings.NewReader(`{"pattern":"*.bank.com","action":"drop"}`)))
+	if createW.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", createW.Code, createW.Body.String())
+	}
+	var created models.DomainRule
+	if err := json.NewDecoder(createW.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if created.ID == 0 || created.Match != "glob" {
+		t.Errorf("Unexpected created rule: %+v", created)
+	}
+	path := "/domain-rules/" + strconv.FormatInt(created.ID, 10)
+
+	listW := httptest.NewRecorder()
+	mux.ServeHTTP(listW, httptest.NewRequest(http.MethodGet, "/domain-rules", nil))
+	var list models.DomainRules
+	if err := json.NewDecoder(listW.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(list.Rules) != 1 || list.Rules[0].ID != created.ID {
+		t.Errorf("Unexpected rule list: %+v", list.Rules)
+	}
+
+	updateW := httptest.NewRecorder()
+	mux.ServeHTTP(updateW, httptest.NewRequest(http.MethodPut, path, strings.NewReader(`{"pattern":"*.bank.com","action":"navigate_only","priority":5}`)))
+	if updateW.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", updateW.Code, updateW.Body.String())
+	}
+	var updated models.DomainRule
+	if err := json.NewDecoder(updateW.Body).Decode(&updated); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if updated.Action != "navigate_only" || updated.Priority != 5 {
+		t.Errorf("Unexpected updated rule: %+v", updated)
+	}
+
+	getW := httptest.NewRecorder()
+	mux.ServeHTTP(getW, httptest.NewRequest(http.MethodGet, path, nil))
+	if getW.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", getW.Code)
+	}
+
+	deleteW := httptest.NewRecorder()
+	mux.ServeHTTP(deleteW, httptest.NewRequest(http.MethodDelete, path, nil))
+	if deleteW.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", deleteW.Code)
+	}
+
+	missingW := httptest.NewRecorder()
+	mux.ServeHTTP(missingW, httptest.NewRequest(http.MethodGet, path, nil))
+	if missingW.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", missingW.Code)
+	}
+}
+
+func TestDomainRulesInvalidRequests(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	mux := server.setupRoutes()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{http.MethodPost, "/domain-rules", `{"pattern":"bank.com","action":"block"}`, http.StatusBadRequest},
+		{http.MethodPost, "/domain-rules", `{"pattern":"(","match":"regex","action":"drop"}`, http.StatusBadRequest},
+		{http.MethodPost, "/domain-rules", `not json`, http.StatusBadRequest},
+		{http.MethodPut, "/domain-rules/abc", `{}`, http.StatusBadRequest},
+		{http.MethodPut, "/domain-rules/999", `{"pattern":"bank.com","action":"drop"}`, http.StatusNotFound},
+		{http.MethodDelete, "/domain-rules/999", ``, http.StatusNotFound},
+		{http.MethodPatch, "/domain-rules", ``, http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.want, w.Code)
+		}
+	}
+}
+
+func TestDomainRulesAppliedOnIngestion(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	mux := server.setupRoutes()
+
+	for _, body := range []string{
+		`{"pattern":"*.bank.com","action":"drop"}`,
+		`{"pattern":"clinic.example","action":"strip_data"}`,
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/domain-rules", strings.NewReader(body)))
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", w.Code)
+		}
+	}
+
+	batch := models.Batch{
+		Events: []models.Event{
+			{TSUTC: 1000, TSISO: "1970-01-01T00:00:01Z", URL: "https://www.bank.com/login", Type: "navigate", Data: map[string]any{"from": nil, "to": "https://www.bank.com/login"}},
+			{TSUTC: 2000, TSISO: "1970-01-01T00:00:02Z", URL: "https://clinic.example/visit", Type: "click", Data: map[string]any{"selector": "#results", "text": "Results"}},
+			{TSUTC: 3000, TSISO: "1970-01-01T00:00:03Z", URL: "https://example.com/", Type: "click", Data: map[string]any{"selector": "#home", "text": "Home"}},
+		},
+	}
+	jsonData, _ := json.Marshal(batch)
+	postW := httptest.NewRecorder()
+	mux.ServeHTTP(postW, httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData)))
+	if postW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", postW.Code)
+	}
+
+	events, err := server.db.GetEvents(database.EventFilter{})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 stored events, got %d", len(events))
+	}
+	for _, event := range events {
+		if strings.Contains(event.URL, "bank.com") {
+			t.Errorf("Expected bank.com event to be dropped")
+		}
+		if event.URL == "https://clinic.example/visit" && len(event.Data) != 0 {
+			t.Errorf("Expected clinic.example data to be stripped, got %v", event.Data)
+		}
+		if event.URL == "https://example.com/" && event.Data["text"] != "Home" {
+			t.Errorf("Expected unmatched event to be unchanged, got %v", event.Data)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Event types are registered by the extension, so that it can send new kinds
+// of events without a server release. Registrations apply immediately.
+
+func (s *Server) handleEventTypes(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		s.handleListEventTypes(w)
+	case http.MethodPost:
+		s.handleCreateEventType(w, req)
+	default:
+		http.Error(w, "GET or POST only", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleEventType(w http.ResponseWriter, req *http.Request) {
+	name := req.PathValue("name")
+
+	switch req.Method {
+	case http.MethodGet:
+		s.handleGetEventType(w, name)
+	case http.MethodPut:
+		s.handleUpdateEventType(w, req, name)
+	case http.MethodDelete:
+		s.handleDeleteEventType(w, name)
+	default:
+		http.Error(w, "GET, PUT, or DELETE only", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleListEventTypes(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	response := models.EventTypes{Types: s.db.ListEventTypes()}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+// writeEventTypeError maps registry errors to responses and reports whether err was nil
+func writeEventTypeError(w http.ResponseWriter, err error, action string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, database.ErrInvalidEventType):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, database.ErrEventTypeNotFound):
+		http.Error(w, "Event type not found", http.StatusNotFound)
+	case errors.Is(err, database.ErrEventTypeExists), errors.Is(err, database.ErrBuiltinEventType):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to "+action+" event type", http.StatusInternalServerError)
+	}
+	return false
+}
+
+func (s *Server) handleCreateEventType(w http.ResponseWriter, req *http.Request) {
+	var eventType models.EventType
+	if err := json.NewDecoder(req.Body).Decode(&eventType); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	created, err := s.db.CreateEventType(eventType)
+	if !writeEventTypeError(w, err, "create") {
+		return
+	}
+
+	log.Printf("Registered event type %s", created.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(created); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleGetEventType(w http.ResponseWriter, name string) {
+	eventType, err := s.db.GetEventType(name)
+	if !writeEventTypeError(w, err, "retrieve") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(eventType); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleUpdateEventType(w http.ResponseWriter, req *http.Request, name string) {
+	var eventType models.EventType
+	if err := json.NewDecoder(req.Body).Decode(&eventType); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	eventType.Name = name
+
+	updated, err := s.db.UpdateEventType(eventType)
+	if !writeEventTypeError(w, err, "update") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(updated); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleDeleteEventType(w http.ResponseWriter, name string) {
+	if !writeEventTypeError(w, s.db.DeleteEventType(name), "delete") {
+		return
+	}
+
+	log.Printf("Deleted event type %s", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestEventTypesRoutes(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	mux := server.setupRoutes()
+
+	listW := httptest.NewRecorder()
+	mux.ServeHTTP(listW, httptest.NewRequest(http.MethodGet, "/event-types", nil))
+	var list models.EventTypes
+	if err := json.NewDecoder(listW.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(list.Types) != 5 {
+		t.Errorf("Expected 5 built-in types, got %d", len(list.Types))
+	}
+
+	body := `{"name":"tab_switch","schema":{"type":"object"},"dedup_key":["session_id"],"retention_class":"interaction"}`
+	createW := httptest.NewRecorder()
+	mux.ServeHTTP(createW, httptest.NewRequest(http.MethodPost, "/event-types", strings.NewReader(body)))
+	if createW.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", createW.Code, createW.Body.String())
+	}
+
+	duplicateW := httptest.NewRecorder()
+	mux.ServeHTTP(duplicateW, httptest.NewRequest(http.MethodPost, "/event-types", strings.NewReader(body)))
+	if duplicateW.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a duplicate, got %d", duplicateW.Code)
+	}
+
+	// The new type is accepted by ingestion straight away
+	events := `{"events":[{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com","type":"tab_switch","data":{}}]}`
+	postW := httptest.NewRecorder()
+	mux.ServeHTTP(postW, httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString(events)))
+	if postW.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204 for the registered type, got %d: %s", postW.Code, postW.Body.String())
+	}
+
+	updateW := httptest.NewRecorder()
+	mux.ServeHTTP(updateW, httptest.NewRequest(http.MethodPut, "/event-types/tab_switch", strings.NewReader(`{"dedup_key":["title"]}`)))
+	if updateW.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid dedup key, got %d", updateW.Code)
+	}
+
+	builtinW := httptest.NewRecorder()
+	mux.ServeHTTP(builtinW, httptest.NewRequest(http.MethodDelete, "/event-types/navigate", nil))
+	if builtinW.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a built-in type, got %d", builtinW.Code)
+	}
+
+	deleteW := httptest.NewRecorder()
+	mux.ServeHTTP(deleteW, httptest.NewRequest(http.MethodDelete, "/event-types/tab_switch", nil))
+	if deleteW.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", deleteW.Code)
+	}
+
+	getW := httptest.NewRecorder()
+	mux.ServeHTTP(getW, httptest.NewRequest(http.MethodGet, "/event-types/tab_switch", nil))
+	if getW.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after delete, got %d", getW.Code)
+	}
+}
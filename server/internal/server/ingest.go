@@ -0,0 +1,213 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// IngestMode decides what happens to a posted batch that holds invalid events
+type IngestMode string
+
+const (
+	IngestAtomic  IngestMode = "atomic"  // reject the whole batch
+	IngestPartial IngestMode = "partial" // store the valid events and quarantine the rest
+)
+
+// ParseIngestMode reads a mode name; empty means atomic
+func ParseIngestMode(mode string) (IngestMode, error) {
+	switch IngestMode(mode) {
+	case "":
+		return IngestAtomic, nil
+	case IngestAtomic, IngestPartial:
+		return IngestMode(mode), nil
+	default:
+		return "", fmt.Errorf("invalid ingest mode %q: must be atomic or partial", mode)
+	}
+}
+
+// SetIngestMode changes the mode used by POST /events requests without a mode parameter
+func (s *Server) SetIngestMode(mode IngestMode) {
+	s.ingestMode = mode
+}
+
+// handlePostEventsPartial stores every valid event of a batch, quarantines the
+// invalid ones in rejected_events and answers with a summary. Events are
+// decoded one at a time, so a malformed event does not fail the others.
+func (s *Server) handlePostEventsPartial(w http.ResponseWriter, req *http.Request) {
+	body, err := s.openBody(w, req, s.maxBodyBytes)
+	if err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	defer body.Close()
+
+	summary := models.IngestSummary{Errors: []models.IngestError{}}
+	var accepted []models.Event
+	var rejected []models.RejectedEvent
+	reject := func(index int, reason string, payload json.RawMessage) {
+		rejected = append(rejected, models.RejectedEvent{EventIndex: index, Reason: reason, Event: payload})
+		summary.Errors = append(summary.Errors, models.IngestError{EventIndex: index, Reason: reason})
+	}
+
+	posted := 0
+	err = s.decodeEvents(body, func(dec *json.Decoder) error {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		index := posted
+		posted++
+
+		var event models.Event
+		if err := json.Unmarshal(raw, &event); err != nil {
+			reject(index, "invalid JSON: "+err.Error(), s.redactRaw(raw))
+			return nil
+		}
+
+		// Validation sees the event as posted, before strip_data empties it
+		reason := s.rejectionReason(&event)
+		switch s.screenEvent(&event) {
+		case dropBlocked, dropRedacted:
+			summary.Dropped++
+			return nil
+		}
+
+		if reason != "" {
+			payload, err := json.Marshal(event)
+			if err != nil {
+				payload = json.RawMessage("null")
+			}
+			reject(index, reason, payload)
+			return nil
+		}
+		accepted = append(accepted, event)
+		return nil
+	})
+	if err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+
+	upserted, err := s.db.StoreBatch(accepted, rejected)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to store events", http.StatusInternalServerError)
+		return
+	}
+	s.hub.Publish(accepted)
+
+	summary.Accepted = len(accepted)
+	summary.Upserted = upserted
+	summary.Rejected = len(rejected)
+	if summary.Rejected > 0 {
+		log.Printf("Quarantined %d of %d posted events", summary.Rejected, posted)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+// rejectionReason returns why an event cannot be stored, or "" if it can.
+// In lenient schema validation the violations are recorded on the event instead.
+func (s *Server) rejectionReason(event *models.Event) string {
+	// Only the server may flag events
+	event.SchemaViolations = nil
+
+	if err := s.db.ValidateEvent(*event); err != nil {
+		return err.Error()
+	}
+	if s.schemaValidation == SchemaOff {
+		return ""
+	}
+
+	violations := violationMessages(s.db.CheckEventData(*event))
+	if len(violations) == 0 {
+		return ""
+	}
+	if s.schemaValidation == SchemaLenient {
+		event.SchemaViolations = violations
+		return ""
+	}
+	return "data does not match its schema: " + strings.Join(violations, "; ")
+}
+
+// redactRaw runs redaction over an event that could not be decoded, so that
+// secrets in it are not quarantined in plaintext
+func (s *Server) redactRaw(raw json.RawMessage) json.RawMessage {
+	if s.redactor == nil {
+		return raw
+	}
+
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return json.RawMessage("null")
+	}
+	holder := models.Event{Data: map[string]any{"event": value}}
+	if !s.redactor.Redact(&holder) {
+		return json.RawMessage("null")
+	}
+	redacted, err := json.Marshal(holder.Data["event"])
+	if err != nil {
+		return json.RawMessage("null")
+	}
+	return redacted
+}
+
+func (s *Server) handleRejectedEvents(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		s.handleListRejectedEvents(w, req)
+	case http.MethodDelete:
+		s.handleDeleteRejectedEvents(w)
+	default:
+		http.Error(w, "GET or DELETE only", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleListRejectedEvents(w http.ResponseWriter, req *http.Request) {
+	limit := 100
+	if limitParam := req.URL.Query().Get("limit"); limitParam != "" {
+		var err error
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid 'limit' parameter: must be positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rejected, err := s.db.ListRejectedEvents(limit)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to retrieve rejected events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := models.RejectedEvents{Events: rejected}
+	if rejected == nil {
+		response.Events = []models.RejectedEvent{}
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleDeleteRejectedEvents(w http.ResponseWriter) {
+	count, err := s.db.DeleteRejectedEvents()
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to delete rejected events", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted %d rejected events", count)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+	"github.com/vincentbai/browsetrace-server/internal/redaction"
+)
+
+func TestHandlePostEventsPartial(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	routes := server.setupRoutes()
+
+	config, err := redaction.ParseConfig("input:credit_card=mask")
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	server.SetRedactor(redaction.NewRedactor(config))
+
+	body := `{"events":[
+		{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com","type":"click","data":{"selector":"#a","text":"A"}},
+		{"ts_utc":2000,"ts_iso":"1970-01-01T00:00:02Z","url":"https://example.com","type":"tab_switch","data":{}},
+		{"ts_utc":"soon","type":"input","data":{"value":"4111 1111 1111 1111"}},
+		{"ts_utc":3000,"ts_iso":"1970-01-01T00:00:03Z","url":"https://example.com","type":"click","data":{"text":"B"}}
+	]}`
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events?mode=partial", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var summary models.IngestSummary
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	if summary.Accepted != 1 || summary.Rejected != 3 || summary.Upserted != 0 || len(summary.Errors) != 3 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	for i, index := range []int{1, 2, 3} {
+		if i < len(summary.Errors) && summary.Errors[i].EventIndex != index {
+			t.Errorf("Expected error %d for event %d, got %+v", i, index, summary.Errors[i])
+		}
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rejected-events", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "4111 1111 1111 1111") {
+		t.Error("Expected the undecodable event to be redacted before quarantine")
+	}
+	var rejected models.RejectedEvents
+	if err := json.NewDecoder(w.Body).Decode(&rejected); err != nil {
+		t.Fatalf("Failed to decode rejected events: %v", err)
+	}
+	if len(rejected.Events) != 3 || rejected.Events[0].EventIndex != 3 || !strings.Contains(rejected.Events[0].Reason, "schema") {
+		t.Errorf("Unexpected rejected events: %+v", rejected.Events)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/rejected-events", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+}
+
+func TestHandlePostEventsPartialLenient(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.SetSchemaValidation(SchemaLenient)
+	server.SetIngestMode(IngestPartial)
+
+	body := `{"events":[{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com","type":"click","data":{"text":"B"}}]}`
+	w := httptest.NewRecorder()
+	server.setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+
+	var summary models.IngestSummary
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	if summary.Accepted != 1 || summary.Rejected != 0 {
+		t.Errorf("Expected the event to be stored flagged, got %+v", summary)
+	}
+
+	flagged := true
+	events, err := server.db.GetEvents(database.EventFilter{Flagged: &flagged})
+	if err != nil {
+		t.Fatalf("GetEvents failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected 1 flagged event, got %d", len(events))
+	}
+}
+
+func TestHandlePostEventsInvalidMode(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	server.setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events?mode=best_effort", strings.NewReader(`{"events":[]}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
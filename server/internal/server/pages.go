@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// handlePages lists pages by time spent, visit count or last visit, with
+// sort=time (default), visits or recent. since and until select the visits
+// counted; domain narrows the pages as on GET /events.
+func (s *Server) handlePages(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	filter := database.PageFilter{Sort: database.SortByTime, Limit: s.defaultLimit}
+
+	switch sort := database.PageSort(query.Get("sort")); sort {
+	case "":
+	case database.SortByTime, database.SortByVisits, database.SortByRecent:
+		filter.Sort = sort
+	default:
+		http.Error(w, "Invalid 'sort' parameter: must be time, visits or recent", http.StatusBadRequest)
+		return
+	}
+
+	if sinceParam := query.Get("since"); sinceParam != "" {
+		since, err := strconv.ParseInt(sinceParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'since' parameter: must be Unix timestamp in milliseconds", http.StatusBadRequest)
+			return
+		}
+		filter.SinceUTC = &since
+	}
+
+	if untilParam := query.Get("until"); untilParam != "" {
+		until, err := strconv.ParseInt(untilParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'until' parameter: must be Unix timestamp in milliseconds", http.StatusBadRequest)
+			return
+		}
+		filter.UntilUTC = &until
+	}
+
+	if domainParam := query.Get("domain"); domainParam != "" {
+		filter.Domain = &domainParam
+	}
+
+	if limitParam := query.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid 'limit' parameter: must be positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	pages, err := s.db.ListPages(filter)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to retrieve pages", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := models.Pages{Pages: pages}
+	if pages == nil {
+		response.Pages = []models.Page{}
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestHandlePages(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	routes := server.setupRoutes()
+
+	body := `{"events":[
+		{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com/a?utm_source=x","title":"A","type":"navigate","data":{"from":null,"to":"https://example.com/a"},"session_id":"s1"},
+		{"ts_utc":4000,"ts_iso":"1970-01-01T00:00:04Z","url":"https://example.com/b","title":"B","type":"navigate","data":{"from":"https://example.com/a","to":"https://example.com/b"},"session_id":"s1"},
+		{"ts_utc":5000,"ts_iso":"1970-01-01T00:00:05Z","url":"https://example.com/a","title":"A","type":"navigate","data":{"from":"https://example.com/b","to":"https://example.com/a"},"session_id":"s1"},
+		{"ts_utc":6000,"ts_iso":"1970-01-01T00:00:06Z","url":"https://example.com/a","title":null,"type":"click","data":{"selector":"a","text":"A"},"session_id":"s1"}
+	]}`
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString(body)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pages", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var response models.Pages
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode pages: %v", err)
+	}
+	if len(response.Pages) != 2 {
+		t.Fatalf("Expected 2 pages, got %+v", response.Pages)
+	}
+	first := response.Pages[0]
+	if first.URL != "https://example.com/a" || first.VisitCount != 2 || first.DwellMS != 4000 {
+		t.Errorf("Expected page a first with 2 visits and 4000ms, got %+v", first)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pages?sort=recent&limit=1", nil))
+	response = models.Pages{}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode pages: %v", err)
+	}
+	if len(response.Pages) != 1 || response.Pages[0].URL != "https://example.com/a" {
+		t.Errorf("Expected the last visited page, got %+v", response.Pages)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pages?sort=size", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid sort, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/pages", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
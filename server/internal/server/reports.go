@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// maxUTCOffset bounds utc_offset to the offsets in use, UTC-12:00 to UTC+14:00
+const maxUTCOffset = 14 * 60 // minutes
+
+// handleTimeReport reports active time per bucket=hour, day (default) or
+// week, for each domain with group=domain (default) or in total with
+// group=total. since, until and domain select the activity as on GET
+// /events; utc_offset, in minutes east of UTC, starts days and weeks at local
+// midnight.
+func (s *Server) handleTimeReport(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	report := models.TimeReport{Group: "domain", Bucket: string(database.BucketDay)}
+	filter := database.ReportFilter{Bucket: database.BucketDay, ByDomain: true}
+
+	switch group := query.Get("group"); group {
+	case "", "domain":
+	case "total":
+		report.Group, filter.ByDomain = group, false
+	default:
+		http.Error(w, "Invalid 'group' parameter: must be domain or total", http.StatusBadRequest)
+		return
+	}
+
+	switch bucket := database.ReportBucket(query.Get("bucket")); bucket {
+	case "":
+	case database.BucketHour, database.BucketDay, database.BucketWeek:
+		report.Bucket, filter.Bucket = string(bucket), bucket
+	default:
+		http.Error(w, "Invalid 'bucket' parameter: must be hour, day or week", http.StatusBadRequest)
+		return
+	}
+
+	if sinceParam := query.Get("since"); sinceParam != "" {
+		since, err := strconv.ParseInt(sinceParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'since' parameter: must be Unix timestamp in milliseconds", http.StatusBadRequest)
+			return
+		}
+		filter.SinceUTC = &since
+	}
+
+	if untilParam := query.Get("until"); untilParam != "" {
+		until, err := strconv.ParseInt(untilParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'until' parameter: must be Unix timestamp in milliseconds", http.StatusBadRequest)
+			return
+		}
+		filter.UntilUTC = &until
+	}
+
+	if domainParam := query.Get("domain"); domainParam != "" {
+		filter.Domain = &domainParam
+	}
+
+	if offsetParam := query.Get("utc_offset"); offsetParam != "" {
+		offset, err := strconv.Atoi(offsetParam)
+		if err != nil || offset < -maxUTCOffset || offset > maxUTCOffset {
+			http.Error(w, "Invalid 'utc_offset' parameter: must be minutes between -840 and 840", http.StatusBadRequest)
+			return
+		}
+		filter.UTCOffset = time.Duration(offset) * time.Minute
+	}
+
+	rows, err := s.db.TimeReport(filter)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to compute time report", http.StatusInternalServerError)
+		return
+	}
+
+	report.IdleThresholdMS = s.db.IdleThreshold().Milliseconds()
+	report.Rows = rows
+	if rows == nil {
+		report.Rows = []models.TimeReportRow{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
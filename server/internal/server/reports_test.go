@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestHandleTimeReport(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	routes := server.setupRoutes()
+
+	// From midnight UTC on 1970-01-11, with a long break before 00:59
+	body := `{"events":[
+		{"ts_utc":864000000,"ts_iso":"1970-01-11T00:00:00Z","url":"https://example.com/","title":"A","type":"navigate","data":{"from":null,"to":"https://example.com/"},"session_id":"s1"},
+		{"ts_utc":864060000,"ts_iso":"1970-01-11T00:01:00Z","url":"https://example.org/","title":null,"type":"click","data":{"selector":"a","text":"A"},"session_id":"s2"},
+		{"ts_utc":867540000,"ts_iso":"1970-01-11T00:59:00Z","url":"https://example.org/","title":null,"type":"click","data":{"selector":"a","text":"A"},"session_id":"s2"},
+		{"ts_utc":867660000,"ts_iso":"1970-01-11T01:01:00Z","url":"https://example.org/","title":null,"type":"click","data":{"selector":"a","text":"A"},"session_id":"s2"}
+	]}`
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString(body)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reports/time?group=domain&bucket=day", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report models.TimeReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.IdleThresholdMS != 300000 || len(report.Rows) != 2 {
+		t.Fatalf("Expected a row per domain, got %+v", report)
+	}
+	// The 58 minutes between the first two clicks are idle
+	if row := report.Rows[0]; *row.Domain != "example.org" || row.ActiveMS != 120000 || row.EventCount != 3 {
+		t.Errorf("Expected two minutes on example.org first, got %+v", row)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reports/time?group=total&bucket=hour&utc_offset=-60", nil))
+	report = models.TimeReport{}
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(report.Rows) != 2 || report.Rows[1].BucketStartUTC != 867600000 || report.Rows[1].ActiveMS != 60000 || report.Rows[1].Domain != nil {
+		t.Errorf("Expected two hours in total, got %+v", report.Rows)
+	}
+
+	for _, query := range []string{"group=url", "bucket=month", "since=yesterday", "utc_offset=900"} {
+		w = httptest.NewRecorder()
+		routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reports/time?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", query, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reports/time", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
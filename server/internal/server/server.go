@@ -3,37 +3,220 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/vincentbai/browsetrace-server/internal/auth"
+	"github.com/vincentbai/browsetrace-server/internal/backup"
 	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/domainrules"
 	"github.com/vincentbai/browsetrace-server/internal/models"
+	"github.com/vincentbai/browsetrace-server/internal/redaction"
+	"github.com/vincentbai/browsetrace-server/internal/retention"
+	"github.com/vincentbai/browsetrace-server/internal/stream"
+	"github.com/vincentbai/browsetrace-server/internal/urlnorm"
 )
 
+// DefaultLimit is how many events GET /events returns without a limit parameter
+const DefaultLimit = 100
+
 type Server struct {
-	db      *database.Database
-	address string
-	server  *http.Server
+	db             *database.Database
+	address        string
+	server         *http.Server
+	authToken      string                 // bearer token required on every route but /healthz; empty disables auth
+	allowedOrigins []string               // browser origins allowed by CORS, see auth.OriginAllowed
+	domainRules    *domainrules.Filter    // loaded from the database, reloaded on every change
+	hub            *stream.Hub            // publishes stored events to /events/stream subscribers
+	pruner         *retention.Pruner      // optional, runs alongside the HTTP server
+	backups        *backup.Manager        // optional, serves /admin/backup
+	backupInterval time.Duration          // scheduled backups, 0 for none
+	redactor       *redaction.Redactor    // optional, applied to every ingested event
+	canonicalizer  *urlnorm.Canonicalizer // optional, rewrites the URL of every ingested event
+
+	schemaValidation SchemaValidation // what to do with events whose data fails its schema
+	ingestMode       IngestMode       // default for POST /events without a mode parameter
+	maxBodyBytes     int64            // largest decompressed POST /events body
+	maxBatchEvents   int              // most events in one posted batch
+	defaultLimit     int              // events returned by GET /events without a limit parameter
+
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	shutdownTimeout time.Duration // how long in-flight requests get to finish on shutdown
 }
 
 func NewServer(db *database.Database, address string) *Server {
 	return &Server{
-		db:      db,
-		address: address,
+		db:             db,
+		address:        address,
+		domainRules:    domainrules.NewFilter(),
+		hub:            stream.NewHub(stream.DefaultBufferSize),
+		allowedOrigins: auth.DefaultOrigins,
+
+		schemaValidation: SchemaStrict,
+		ingestMode:       IngestAtomic,
+		maxBodyBytes:     DefaultMaxBodyBytes,
+		maxBatchEvents:   DefaultMaxBatchEvents,
+		defaultLimit:     DefaultLimit,
+
+		readTimeout:     5 * time.Second,
+		writeTimeout:    5 * time.Second,
+		shutdownTimeout: 30 * time.Second,
+	}
+}
+
+// SetTimeouts changes the HTTP read and write timeouts, 0 meaning none, and
+// how long shutdown waits for requests in flight
+func (s *Server) SetTimeouts(read, write, shutdown time.Duration) {
+	s.readTimeout = read
+	s.writeTimeout = write
+	s.shutdownTimeout = shutdown
+}
+
+// SetDefaultLimit changes how many events GET /events returns without a limit parameter
+func (s *Server) SetDefaultLimit(limit int) {
+	s.defaultLimit = limit
+}
+
+// SetAuthToken requires the token as a bearer token on every route but /healthz
+func (s *Server) SetAuthToken(token string) {
+	s.authToken = token
+}
+
+// SetAllowedOrigins replaces the browser origins allowed to call the API
+func (s *Server) SetAllowedOrigins(origins []string) {
+	s.allowedOrigins = origins
+}
+
+// SetPruner enables background retention pruning while the server runs
+func (s *Server) SetPruner(pruner *retention.Pruner) {
+	s.pruner = pruner
+}
+
+// SetBackups enables /admin/backup and, with a positive interval, a backup
+// on that schedule while the server runs
+func (s *Server) SetBackups(manager *backup.Manager, interval time.Duration) {
+	s.backups = manager
+	s.backupInterval = interval
+}
+
+// SetRedactor enables redaction of sensitive data before events are stored
+func (s *Server) SetRedactor(redactor *redaction.Redactor) {
+	s.redactor = redactor
+}
+
+// SetCanonicalizer enables canonicalization of event URLs before events are
+// stored. GET /events?url= and the other filters then match canonical URLs.
+func (s *Server) SetCanonicalizer(canonicalizer *urlnorm.Canonicalizer) {
+	s.canonicalizer = canonicalizer
+}
+
+// ReloadDomainRules reads the domain rules from the database and makes them
+// active for events ingested from now on
+func (s *Server) ReloadDomainRules() error {
+	rules, err := s.db.ListDomainRules()
+	if err != nil {
+		return err
+	}
+	return s.domainRules.Load(rules)
+}
+
+// prepareEvents runs the ingestion stages that must happen before events
+// reach the database and returns the events that should be stored, with the
+// index each of them had in events.
+// URLs are canonicalized first so that domain rules match the canonical host,
+// then domain rules run so that dropped events are never inspected further.
+func (s *Server) prepareEvents(events []models.Event) (kept []models.Event, indexes []int) {
+	kept = events[:0]
+	blocked, redacted := 0, 0
+	for i, event := range events {
+		switch s.screenEvent(&event) {
+		case dropBlocked:
+			blocked++
+		case dropRedacted:
+			redacted++
+		default:
+			kept = append(kept, event)
+			indexes = append(indexes, i)
+		}
+	}
+	if blocked > 0 {
+		log.Printf("Domain rules dropped %d events", blocked)
+	}
+	if redacted > 0 {
+		log.Printf("Redaction dropped %d events", redacted)
+	}
+	return kept, indexes
+}
+
+// dropReason says which ingestion stage discarded an event
+type dropReason int
+
+const (
+	dropNone     dropReason = iota
+	dropBlocked             // a domain rule matched
+	dropRedacted            // redaction found a secret set to drop
+)
+
+// screenEvent applies canonicalization, domain rules and redaction to one event in place
+func (s *Server) screenEvent(event *models.Event) dropReason {
+	if s.canonicalizer != nil {
+		s.canonicalizeEvent(event)
+	}
+	if !s.domainRules.Apply(event) {
+		return dropBlocked
+	}
+	if s.redactor != nil && !s.redactor.Redact(event) {
+		return dropRedacted
+	}
+	return dropNone
+}
+
+// canonicalizeEvent stores the canonical form of an event's URL in URL and the
+// URL as captured, secrets masked, in RawURL when the two differ. Navigation
+// data repeats URLs, so their secrets are masked there too.
+func (s *Server) canonicalizeEvent(event *models.Event) {
+	canonical, captured := s.canonicalizer.Canonicalize(event.URL)
+	event.URL, event.RawURL = canonical, nil
+	if captured != canonical {
+		event.RawURL = &captured
+	}
+
+	if event.Type != "navigate" {
+		return
+	}
+	for _, key := range []string{"from", "to"} {
+		if value, ok := event.Data[key].(string); ok {
+			event.Data[key] = s.canonicalizer.Scrub(value)
+		}
 	}
 }
 
 func (s *Server) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Allow requests from Electron app (localhost with any port)
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		// Only the extension and the Electron app may call the API from a
+		// browser; other pages are refused outright, even for simple requests.
+		// Requests without an Origin (curl, the MCP server) are not from a browser.
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !auth.OriginAllowed(origin, s.allowedOrigins) {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+		}
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
 		// Handle preflight requests
 		if r.Method == http.MethodOptions {
@@ -45,6 +228,29 @@ func (s *Server) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireToken(next, auth.Authorized)
+}
+
+// streamAuthMiddleware also accepts the token as the access_token query
+// parameter, since browser EventSource connections cannot set headers
+func (s *Server) streamAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireToken(next, func(r *http.Request, token string) bool {
+		return auth.Authorized(r, token) || auth.AuthorizedByQuery(r, token)
+	})
+}
+
+func (s *Server) requireToken(next http.HandlerFunc, authorized func(*http.Request, string) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.authToken != "" && !authorized(r, s.authToken) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
 func (s *Server) handleHealthz(w http.ResponseWriter, _ *http.Request) {
 	w.Write([]byte("ok"))
 }
@@ -63,11 +269,44 @@ func (s *Server) handleEvents(w http.ResponseWriter, req *http.Request) {
 }
 
 func (s *Server) handlePostEvents(w http.ResponseWriter, req *http.Request) {
+	mode := s.ingestMode
+	if modeParam := req.URL.Query().Get("mode"); modeParam != "" {
+		var err error
+		if mode, err = ParseIngestMode(modeParam); err != nil {
+			http.Error(w, "Invalid 'mode' parameter: must be atomic or partial", http.StatusBadRequest)
+			return
+		}
+	}
+	if mode == IngestPartial {
+		s.handlePostEventsPartial(w, req)
+		return
+	}
+
+	body, err := s.openBody(w, req, s.maxBodyBytes)
+	if err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	defer body.Close()
+
 	var batch models.Batch
-	if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	err = s.decodeEvents(body, func(dec *json.Decoder) error {
+		var event models.Event
+		if err := dec.Decode(&event); err != nil {
+			return err
+		}
+		batch.Events = append(batch.Events, event)
+		return nil
+	})
+	if err != nil {
+		s.writeBodyError(w, err)
+		return
+	}
+	events, ok := s.screenBatch(w, batch.Events)
+	if !ok {
 		return
 	}
+	batch.Events = events
 	if len(batch.Events) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
@@ -77,24 +316,29 @@ func (s *Server) handlePostEvents(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, "Failed to store events", http.StatusInternalServerError)
 		return
 	}
+	s.hub.Publish(batch.Events)
 	w.WriteHeader(http.StatusNoContent) // success, no body
 }
 
-func (s *Server) handleGetEvents(w http.ResponseWriter, req *http.Request) {
-	query := req.URL.Query()
-	filter := database.EventFilter{
-		Limit: 100, // default limit
-	}
+// eventFilterParams are the query parameters parseEventFilter reads
+var eventFilterParams = []string{"type", "since", "until", "url", "domain", "session_id", "flagged"}
+
+// parseEventFilter reads the filter query parameters shared by the read and
+// delete endpoints. The returned error message is safe to send to the client.
+func (s *Server) parseEventFilter(query url.Values) (database.EventFilter, error) {
+	var filter database.EventFilter
 
 	if typeParam := query.Get("type"); typeParam != "" {
+		if _, err := s.db.GetEventType(typeParam); err != nil {
+			return filter, fmt.Errorf("Invalid 'type' parameter: %q is not a registered event type", typeParam)
+		}
 		filter.EventType = &typeParam
 	}
 
 	if sinceParam := query.Get("since"); sinceParam != "" {
 		since, err := strconv.ParseInt(sinceParam, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid 'since' parameter: must be Unix timestamp in milliseconds", http.StatusBadRequest)
-			return
+			return filter, errors.New("Invalid 'since' parameter: must be Unix timestamp in milliseconds")
 		}
 		filter.SinceUTC = &since
 	}
@@ -102,12 +346,54 @@ func (s *Server) handleGetEvents(w http.ResponseWriter, req *http.Request) {
 	if untilParam := query.Get("until"); untilParam != "" {
 		until, err := strconv.ParseInt(untilParam, 10, 64)
 		if err != nil {
-			http.Error(w, "Invalid 'until' parameter: must be Unix timestamp in milliseconds", http.StatusBadRequest)
-			return
+			return filter, errors.New("Invalid 'until' parameter: must be Unix timestamp in milliseconds")
 		}
 		filter.UntilUTC = &until
 	}
 
+	if urlParam := query.Get("url"); urlParam != "" {
+		filter.URL = &urlParam
+		if s.canonicalizer != nil {
+			// Events stored before canonicalization keep the URL they were captured with
+			canonical, _ := s.canonicalizer.Canonicalize(urlParam)
+			filter.URL, filter.URLAlias = &canonical, &urlParam
+		}
+	}
+
+	if domainParam := query.Get("domain"); domainParam != "" {
+		filter.Domain = &domainParam
+	}
+
+	if sessionParam := query.Get("session_id"); sessionParam != "" {
+		filter.SessionID = &sessionParam
+	}
+
+	if flaggedParam := query.Get("flagged"); flaggedParam != "" {
+		flagged, err := strconv.ParseBool(flaggedParam)
+		if err != nil {
+			return filter, errors.New("Invalid 'flagged' parameter: must be true or false")
+		}
+		filter.Flagged = &flagged
+	}
+
+	return filter, nil
+}
+
+func (s *Server) handleGetEvents(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	filter, err := s.parseEventFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.writeEventsPage(w, query, filter)
+}
+
+// writeEventsPage answers with one page of the events matching filter, paged
+// by the limit and cursor query parameters
+func (s *Server) writeEventsPage(w http.ResponseWriter, query url.Values, filter database.EventFilter) {
+	filter.Limit = s.defaultLimit
+
 	if limitParam := query.Get("limit"); limitParam != "" {
 		limit, err := strconv.Atoi(limitParam)
 		if err != nil || limit <= 0 {
@@ -117,7 +403,16 @@ func (s *Server) handleGetEvents(w http.ResponseWriter, req *http.Request) {
 		filter.Limit = limit
 	}
 
-	events, err := s.db.GetEvents(filter)
+	if cursorParam := query.Get("cursor"); cursorParam != "" {
+		cursor, err := database.DecodeCursor(cursorParam)
+		if err != nil {
+			http.Error(w, "Invalid 'cursor' parameter: use next_cursor from a previous response", http.StatusBadRequest)
+			return
+		}
+		filter.Cursor = &cursor
+	}
+
+	events, next, err := s.db.GetEventsPage(filter)
 	if err != nil {
 		log.Printf("Database error: %v", err)
 		http.Error(w, "Failed to retrieve events", http.StatusInternalServerError)
@@ -129,16 +424,91 @@ func (s *Server) handleGetEvents(w http.ResponseWriter, req *http.Request) {
 	if events == nil {
 		response.Events = []models.Event{}
 	}
+	if next != nil {
+		response.NextCursor = next.Encode()
+	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("JSON encoding error: %v", err)
 	}
 }
 
+// handleDeleteEvents deletes the events matching the same filters as GET.
+// Without any filter it clears the whole database. dry_run=true reports the
+// number of matching events without deleting anything.
 func (s *Server) handleDeleteEvents(w http.ResponseWriter, req *http.Request) {
-	log.Println("Deleting all events...")
+	query := req.URL.Query()
+
+	// A parameter that is not a filter, such as limit or a misspelled one,
+	// would otherwise widen the delete to every event
+	for name := range query {
+		if name != "dry_run" && name != "all" && !slices.Contains(eventFilterParams, name) {
+			http.Error(w, fmt.Sprintf("Unknown parameter '%s': DELETE /events takes %s, dry_run and all", name, strings.Join(eventFilterParams, ", ")), http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, err := s.parseEventFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := false
+	if dryRunParam := query.Get("dry_run"); dryRunParam != "" {
+		dryRun, err = strconv.ParseBool(dryRunParam)
+		if err != nil {
+			http.Error(w, "Invalid 'dry_run' parameter: must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+
+	all := false
+	if allParam := query.Get("all"); allParam != "" {
+		all, err = strconv.ParseBool(allParam)
+		if err != nil {
+			http.Error(w, "Invalid 'all' parameter: must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	if all && filter.HasConditions() {
+		http.Error(w, "'all' cannot be combined with filters", http.StatusBadRequest)
+		return
+	}
+	if !all && !filter.HasConditions() {
+		http.Error(w, "No filters given: pass all=true to delete every event", http.StatusBadRequest)
+		return
+	}
+
+	if dryRun {
+		count, err := s.db.CountEvents(filter)
+		if err != nil {
+			log.Printf("Database error during dry run: %v", err)
+			http.Error(w, "Failed to count events", http.StatusInternalServerError)
+			return
+		}
 
-	// Delete all events
-	count, err := s.db.DeleteAllEvents()
+		w.Header().Set("Content-Type", "application/json")
+		response := map[string]interface{}{
+			"matched_count": count,
+			"dry_run":       true,
+			"message":       "Dry run: no events deleted",
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			log.Printf("JSON encoding error: %v", err)
+		}
+		return
+	}
+
+	var count int64
+	message := "All events deleted successfully"
+	if filter.HasConditions() {
+		log.Println("Deleting matching events...")
+		count, err = s.db.DeleteEvents(filter)
+		message = "Matching events deleted successfully"
+	} else {
+		log.Println("Deleting all events...")
+		count, err = s.db.DeleteAllEvents()
+	}
 	if err != nil {
 		log.Printf("Database error during deletion: %v", err)
 		http.Error(w, "Failed to delete events", http.StatusInternalServerError)
@@ -147,20 +517,197 @@ func (s *Server) handleDeleteEvents(w http.ResponseWriter, req *http.Request) {
 
 	log.Printf("Deleted %d events", count)
 
-	// Run VACUUM to reclaim disk space
-	log.Println("Running VACUUM to reclaim disk space...")
-	if err := s.db.VacuumDatabase(); err != nil {
-		log.Printf("Warning: VACUUM failed: %v", err)
-		// Don't fail the request if VACUUM fails, deletion was successful
-	} else {
-		log.Println("VACUUM completed successfully")
+	// Run VACUUM to reclaim disk space and drop deleted content from free pages
+	if count > 0 {
+		log.Println("Running VACUUM to reclaim disk space...")
+		if err := s.db.VacuumDatabase(); err != nil {
+			log.Printf("Warning: VACUUM failed: %v", err)
+			// Don't fail the request if VACUUM fails, deletion was successful
+		} else {
+			log.Println("VACUUM completed successfully")
+		}
 	}
 
 	// Return success response with count
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
 		"deleted_count": count,
-		"message":       "All events deleted successfully",
+		"message":       message,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleEvent(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.ParseInt(req.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid event id: must be positive integer", http.StatusBadRequest)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		s.handleGetEvent(w, id)
+	case http.MethodPatch:
+		s.handlePatchEvent(w, req, id)
+	case http.MethodDelete:
+		s.handleDeleteEvent(w, id)
+	default:
+		http.Error(w, "GET, PATCH, or DELETE only", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleGetEvent(w http.ResponseWriter, id int64) {
+	event, err := s.db.GetEvent(id)
+	if errors.Is(err, database.ErrEventNotFound) {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to retrieve event", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(event); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+// handlePatchEvent merges {"data": {...}} into the stored event data.
+// Setting a key to null removes it, which is how clients redact a field.
+func (s *Server) handlePatchEvent(w http.ResponseWriter, req *http.Request, id int64) {
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+	if body.Data == nil {
+		http.Error(w, "Missing 'data' object", http.StatusBadRequest)
+		return
+	}
+
+	event, err := s.db.PatchEventData(id, body.Data)
+	if errors.Is(err, database.ErrEventNotFound) {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to update event", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(event); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleDeleteEvent(w http.ResponseWriter, id int64) {
+	err := s.db.DeleteEvent(id)
+	if errors.Is(err, database.ErrEventNotFound) {
+		http.Error(w, "Event not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to delete event", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted event %d", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	filter, err := s.parseEventFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	topDomains := 10 // default number of domains
+	if topParam := query.Get("top"); topParam != "" {
+		top, err := strconv.Atoi(topParam)
+		if err != nil || top <= 0 {
+			http.Error(w, "Invalid 'top' parameter: must be positive integer", http.StatusBadRequest)
+			return
+		}
+		topDomains = top
+	}
+
+	stats, err := s.db.GetStats(filter, topDomains)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to compute stats", http.StatusInternalServerError)
+		return
+	}
+	if s.pruner != nil {
+		report := s.pruner.Report()
+		stats.Retention = &report
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	text := strings.TrimSpace(query.Get("q"))
+	if text == "" {
+		http.Error(w, "Missing 'q' parameter", http.StatusBadRequest)
+		return
+	}
+
+	filter, err := s.parseEventFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Limit = 50 // default limit
+
+	if limitParam := query.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid 'limit' parameter: must be positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	results, err := s.db.SearchEvents(text, filter)
+	if errors.Is(err, database.ErrSearchUnavailable) {
+		http.Error(w, "Search is unavailable while encryption at rest is enabled", http.StatusNotImplemented)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to search events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := models.SearchResults{Results: results}
+	if results == nil {
+		response.Results = []models.SearchResult{}
 	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		log.Printf("JSON encoding error: %v", err)
@@ -170,23 +717,73 @@ func (s *Server) handleDeleteEvents(w http.ResponseWriter, req *http.Request) {
 func (s *Server) setupRoutes() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", s.corsMiddleware(s.handleHealthz))
-	mux.HandleFunc("/events", s.corsMiddleware(s.handleEvents))
+	mux.HandleFunc("/events", s.corsMiddleware(s.authMiddleware(s.handleEvents)))
+	mux.HandleFunc("/events/{id}", s.corsMiddleware(s.authMiddleware(s.handleEvent)))
+	mux.HandleFunc("/events/stream", s.corsMiddleware(s.streamAuthMiddleware(s.handleEventStream)))
+	mux.HandleFunc("/stats", s.corsMiddleware(s.authMiddleware(s.handleStats)))
+	mux.HandleFunc("/search", s.corsMiddleware(s.authMiddleware(s.handleSearch)))
+	mux.HandleFunc("/domain-rules", s.corsMiddleware(s.authMiddleware(s.handleDomainRules)))
+	mux.HandleFunc("/domain-rules/{id}", s.corsMiddleware(s.authMiddleware(s.handleDomainRule)))
+	mux.HandleFunc("/event-types", s.corsMiddleware(s.authMiddleware(s.handleEventTypes)))
+	mux.HandleFunc("/event-types/{name}", s.corsMiddleware(s.authMiddleware(s.handleEventType)))
+	mux.HandleFunc("/rejected-events", s.corsMiddleware(s.authMiddleware(s.handleRejectedEvents)))
+	mux.HandleFunc("/export", s.corsMiddleware(s.authMiddleware(s.handleExport)))
+	mux.HandleFunc("/import", s.corsMiddleware(s.authMiddleware(s.handleImport)))
+	mux.HandleFunc("/sessions", s.corsMiddleware(s.authMiddleware(s.handleSessions)))
+	mux.HandleFunc("/sessions/{id}/events", s.corsMiddleware(s.authMiddleware(s.handleSessionEvents)))
+	mux.HandleFunc("/pages", s.corsMiddleware(s.authMiddleware(s.handlePages)))
+	mux.HandleFunc("/reports/time", s.corsMiddleware(s.authMiddleware(s.handleTimeReport)))
+	mux.HandleFunc("/admin/backup", s.corsMiddleware(s.authMiddleware(s.handleBackup)))
 	return mux
 }
 
 func (s *Server) Start() error {
+	if err := s.ReloadDomainRules(); err != nil {
+		return fmt.Errorf("failed to load domain rules: %w", err)
+	}
+
 	mux := s.setupRoutes()
 	s.server = &http.Server{
 		Addr:         s.address,
 		Handler:      mux,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 5 * time.Second,
+		ReadTimeout:  s.readTimeout,
+		WriteTimeout: s.writeTimeout,
 	}
+	// End event streams so that Shutdown does not wait for them
+	s.server.RegisterOnShutdown(s.hub.Close)
 
 	// Graceful shutdown
 	shutdownChannel := make(chan os.Signal, 1)
 	signal.Notify(shutdownChannel, syscall.SIGINT, syscall.SIGTERM)
 
+	backgroundContext, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	if s.pruner != nil {
+		go s.pruner.Run(backgroundContext)
+	}
+	if s.backups != nil && s.backupInterval > 0 {
+		go s.backups.Run(backgroundContext, s.backupInterval)
+	}
+
+	// SIGHUP reloads domain rules edited directly in the database
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	defer signal.Stop(reloadChannel)
+	go func() {
+		for {
+			select {
+			case <-backgroundContext.Done():
+				return
+			case <-reloadChannel:
+				if err := s.ReloadDomainRules(); err != nil {
+					log.Printf("Failed to reload domain rules: %v", err)
+				} else {
+					log.Println("Domain rules reloaded")
+				}
+			}
+		}
+	}()
+
 	go func() {
 		log.Printf("BrowserTrace agent listening on %s", s.address)
 		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -196,8 +793,9 @@ func (s *Server) Start() error {
 
 	<-shutdownChannel
 	log.Println("Shutting down server...")
+	stopBackground()
 
-	shutdownContext, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownContext, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
 	defer cancel()
 
 	if err := s.server.Shutdown(shutdownContext); err != nil {
@@ -5,12 +5,19 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/vincentbai/browsetrace-server/internal/auth"
 	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/encryption"
 	"github.com/vincentbai/browsetrace-server/internal/models"
+	"github.com/vincentbai/browsetrace-server/internal/redaction"
+	"github.com/vincentbai/browsetrace-server/internal/urlnorm"
 )
 
 func setupTestServer(t *testing.T) (*Server, func()) {
@@ -87,7 +94,7 @@ func TestHandleEventsSuccess(t *testing.T) {
 				URL:   "https://example.com",
 				Title: &title,
 				Type:  "navigate",
-				Data:  map[string]any{"referrer": "https://google.com"},
+				Data:  map[string]any{"from": nil, "to": "https://example.com", "referrer": "https://google.com"},
 			},
 		},
 	}
@@ -167,7 +174,7 @@ func TestHandleEventsInvalidEvent(t *testing.T) {
 				TSISO: "2009-02-13T23:31:30Z",
 				URL:   "", // Invalid: empty URL
 				Type:  "navigate",
-				Data:  map[string]any{},
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
 			},
 		},
 	}
@@ -199,7 +206,7 @@ func TestHandleEventsMultipleEvents(t *testing.T) {
 				URL:   "https://example.com",
 				Title: &title1,
 				Type:  "navigate",
-				Data:  map[string]any{},
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
 			},
 			{
 				TSUTC: 1234567891,
@@ -207,7 +214,7 @@ func TestHandleEventsMultipleEvents(t *testing.T) {
 				URL:   "https://example.com/page2",
 				Title: &title2,
 				Type:  "click",
-				Data:  map[string]any{"x": 100, "y": 200},
+				Data:  map[string]any{"selector": "#button", "text": "Submit", "x": 100, "y": 200},
 			},
 			{
 				TSUTC: 1234567892,
@@ -215,7 +222,7 @@ func TestHandleEventsMultipleEvents(t *testing.T) {
 				URL:   "https://example.com/page3",
 				Title: nil,
 				Type:  "focus",
-				Data:  map[string]any{"element": "input"},
+				Data:  map[string]any{"selector": "#button", "value": "", "element": "input"},
 			},
 		},
 	}
@@ -288,7 +295,7 @@ func TestHandleEventsContentType(t *testing.T) {
 				URL:   "https://example.com",
 				Title: &title,
 				Type:  "navigate",
-				Data:  map[string]any{},
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
 			},
 		},
 	}
@@ -345,14 +352,14 @@ func TestHandleGetEventsWithData(t *testing.T) {
 				URL:   "https://example.com",
 				Title: &title,
 				Type:  "navigate",
-				Data:  map[string]any{"foo": "bar"},
+				Data:  map[string]any{"from": nil, "to": "https://example.com", "foo": "bar"},
 			},
 			{
 				TSUTC: 2000000000000,
 				TSISO: "2033-05-18T03:33:20Z",
 				URL:   "https://example.com",
 				Type:  "click",
-				Data:  map[string]any{"x": 100},
+				Data:  map[string]any{"selector": "#button", "text": "Submit", "x": 100},
 			},
 		},
 	}
@@ -399,21 +406,21 @@ func TestHandleGetEventsByType(t *testing.T) {
 				TSISO: "2001-09-09T01:46:40Z",
 				URL:   "https://example.com",
 				Type:  "navigate",
-				Data:  map[string]any{},
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
 			},
 			{
 				TSUTC: 2000000000000,
 				TSISO: "2033-05-18T03:33:20Z",
 				URL:   "https://example.com",
 				Type:  "click",
-				Data:  map[string]any{},
+				Data:  map[string]any{"selector": "#button", "text": "Submit"},
 			},
 			{
 				TSUTC: 3000000000000,
 				TSISO: "2065-01-24T05:20:00Z",
 				URL:   "https://example.com",
 				Type:  "click",
-				Data:  map[string]any{},
+				Data:  map[string]any{"selector": "#button", "text": "Submit"},
 			},
 		},
 	}
@@ -461,21 +468,21 @@ func TestHandleGetEventsBySince(t *testing.T) {
 				TSISO: "2001-09-09T01:46:40Z",
 				URL:   "https://example.com",
 				Type:  "navigate",
-				Data:  map[string]any{},
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
 			},
 			{
 				TSUTC: 2000000000000,
 				TSISO: "2033-05-18T03:33:20Z",
 				URL:   "https://example.com",
 				Type:  "click",
-				Data:  map[string]any{},
+				Data:  map[string]any{"selector": "#button", "text": "Submit"},
 			},
 			{
 				TSUTC: 3000000000000,
 				TSISO: "2065-01-24T05:20:00Z",
 				URL:   "https://example.com",
 				Type:  "focus",
-				Data:  map[string]any{},
+				Data:  map[string]any{"selector": "#button", "value": ""},
 			},
 		},
 	}
@@ -523,7 +530,7 @@ func TestHandleGetEventsWithLimit(t *testing.T) {
 			TSISO: "2001-09-09T01:46:40Z",
 			URL:   "https://example.com",
 			Type:  "navigate",
-			Data:  map[string]any{},
+			Data:  map[string]any{"from": nil, "to": "https://example.com"},
 		})
 	}
 	insertBatch := models.Batch{Events: events}
@@ -551,6 +558,18 @@ func TestHandleGetEventsWithLimit(t *testing.T) {
 	if len(batch.Events) != 3 {
 		t.Errorf("Expected 3 events with limit, got %d", len(batch.Events))
 	}
+
+	// Without a limit parameter the configured default applies
+	server.SetDefaultLimit(2)
+	getW = httptest.NewRecorder()
+	server.handleEvents(getW, httptest.NewRequest(http.MethodGet, "/events", nil))
+	batch = models.Batch{}
+	if err := json.NewDecoder(getW.Body).Decode(&batch); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(batch.Events) != 2 {
+		t.Errorf("Expected 2 events with the default limit, got %d", len(batch.Events))
+	}
 }
 
 func TestHandleGetEventsInvalidSince(t *testing.T) {
@@ -607,28 +626,28 @@ func TestHandleGetEventsCombinedFilters(t *testing.T) {
 				TSISO: "2001-09-09T01:46:40Z",
 				URL:   "https://example.com",
 				Type:  "navigate",
-				Data:  map[string]any{},
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
 			},
 			{
 				TSUTC: 2000000000000,
 				TSISO: "2033-05-18T03:33:20Z",
 				URL:   "https://example.com",
 				Type:  "click",
-				Data:  map[string]any{},
+				Data:  map[string]any{"selector": "#button", "text": "Submit"},
 			},
 			{
 				TSUTC: 2500000000000,
 				TSISO: "2049-03-11T17:06:40Z",
 				URL:   "https://example.com",
 				Type:  "click",
-				Data:  map[string]any{},
+				Data:  map[string]any{"selector": "#button", "text": "Submit"},
 			},
 			{
 				TSUTC: 3000000000000,
 				TSISO: "2065-01-24T05:20:00Z",
 				URL:   "https://example.com",
 				Type:  "click",
-				Data:  map[string]any{},
+				Data:  map[string]any{"selector": "#button", "text": "Submit"},
 			},
 		},
 	}
@@ -666,3 +685,665 @@ func TestHandleGetEventsCombinedFilters(t *testing.T) {
 		}
 	}
 }
+
+func TestHandleStats(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	insertBatch := models.Batch{
+		Events: []models.Event{
+			{
+				TSUTC: 1000000000000,
+				TSISO: "2001-09-09T01:46:40Z",
+				URL:   "https://example.com",
+				Type:  "navigate",
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
+			},
+			{
+				TSUTC: 2000000000000,
+				TSISO: "2033-05-18T03:33:20Z",
+				URL:   "https://example.com/page",
+				Type:  "click",
+				Data:  map[string]any{"selector": "#button", "text": "Submit"},
+			},
+		},
+	}
+
+	jsonData, _ := json.Marshal(insertBatch)
+	postReq := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+	postW := httptest.NewRecorder()
+	server.handleEvents(postW, postReq)
+
+	req := httptest.NewRequest(http.MethodGet, "/stats?since=1500000000000", nil)
+	w := httptest.NewRecorder()
+	server.handleStats(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var stats models.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if stats.TotalEvents != 1 {
+		t.Errorf("Expected 1 event since timestamp, got %d", stats.TotalEvents)
+	}
+	if stats.EventsByType["click"] != 1 {
+		t.Errorf("Expected 1 click event, got %d", stats.EventsByType["click"])
+	}
+	if len(stats.TopDomains) != 1 || stats.TopDomains[0].Domain != "example.com" {
+		t.Errorf("Unexpected top domains: %+v", stats.TopDomains)
+	}
+}
+
+func TestHandleStatsInvalidParams(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	for _, path := range []string{"/stats?since=invalid", "/stats?until=invalid", "/stats?top=0"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		server.handleStats(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", path, w.Code)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/stats", nil)
+	w := httptest.NewRecorder()
+	server.handleStats(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", w.Code)
+	}
+}
+
+func TestHandleSearch(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	insertBatch := models.Batch{
+		Events: []models.Event{
+			{
+				TSUTC: 1000000000000,
+				TSISO: "2001-09-09T01:46:40Z",
+				URL:   "https://example.com",
+				Type:  "visible_text",
+				Data:  map[string]any{"text": "Reading about sourdough starters"},
+			},
+			{
+				TSUTC: 2000000000000,
+				TSISO: "2033-05-18T03:33:20Z",
+				URL:   "https://example.com/other",
+				Type:  "visible_text",
+				Data:  map[string]any{"text": "Nothing relevant here"},
+			},
+		},
+	}
+
+	jsonData, _ := json.Marshal(insertBatch)
+	postReq := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+	postW := httptest.NewRecorder()
+	server.handleEvents(postW, postReq)
+
+	req := httptest.NewRequest(http.MethodGet, "/search?q=sourdough", nil)
+	w := httptest.NewRecorder()
+	server.handleSearch(w, req)
+
+	resp := w.Result()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	var results models.SearchResults
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(results.Results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results.Results))
+	}
+	if results.Results[0].URL != "https://example.com" {
+		t.Errorf("Unexpected result URL: %s", results.Results[0].URL)
+	}
+	if results.Results[0].Snippet == "" {
+		t.Error("Expected non-empty snippet")
+	}
+}
+
+func TestHandleSearchMissingQuery(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/search", nil)
+	w := httptest.NewRecorder()
+	server.handleSearch(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleGetEventsCursorPagination(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	events := []models.Event{}
+	for i := 0; i < 5; i++ {
+		events = append(events, models.Event{
+			TSUTC: 1000000000000, // identical timestamps
+			TSISO: "2001-09-09T01:46:40Z",
+			URL:   "https://example.com",
+			Type:  "click",
+			Data:  map[string]any{"selector": "#button", "text": "Submit", "i": i},
+		})
+	}
+	jsonData, _ := json.Marshal(models.Batch{Events: events})
+	postReq := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+	postW := httptest.NewRecorder()
+	server.handleEvents(postW, postReq)
+
+	total := 0
+	path := "/events?limit=2"
+	for requests := 0; requests < 10; requests++ {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		server.handleEvents(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		var batch models.Batch
+		if err := json.NewDecoder(w.Body).Decode(&batch); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		total += len(batch.Events)
+
+		if batch.NextCursor == "" {
+			break
+		}
+		path = "/events?limit=2&cursor=" + batch.NextCursor
+	}
+
+	if total != 5 {
+		t.Errorf("Expected to page through 5 events, got %d", total)
+	}
+}
+
+func TestHandleGetEventsInvalidCursor(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/events?cursor=not-a-cursor", nil)
+	w := httptest.NewRecorder()
+	server.handleEvents(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid cursor, got %d", w.Code)
+	}
+}
+
+func TestEventByIDRoutes(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	mux := server.setupRoutes()
+
+	insertBatch := models.Batch{
+		Events: []models.Event{
+			{
+				TSUTC: 1000000000000,
+				TSISO: "2001-09-09T01:46:40Z",
+				URL:   "https://embarrassing.example.com",
+				Type:  "input",
+				Data:  map[string]any{"selector": "#q", "value": "secret"},
+			},
+		},
+	}
+	jsonData, _ := json.Marshal(insertBatch)
+	postReq := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+	mux.ServeHTTP(httptest.NewRecorder(), postReq)
+
+	// IDs are exposed in the list response
+	listW := httptest.NewRecorder()
+	mux.ServeHTTP(listW, httptest.NewRequest(http.MethodGet, "/events", nil))
+	var batch models.Batch
+	if err := json.NewDecoder(listW.Body).Decode(&batch); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(batch.Events) != 1 || batch.Events[0].ID == 0 {
+		t.Fatalf("Expected 1 event with an id, got %+v", batch.Events)
+	}
+	path := "/events/" + strconv.FormatInt(batch.Events[0].ID, 10)
+
+	getW := httptest.NewRecorder()
+	mux.ServeHTTP(getW, httptest.NewRequest(http.MethodGet, path, nil))
+	if getW.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for GET, got %d", getW.Code)
+	}
+
+	patchW := httptest.NewRecorder()
+	patchBody := []byte(`{"data": {"value": null}}`)
+	mux.ServeHTTP(patchW, httptest.NewRequest(http.MethodPatch, path, bytes.NewReader(patchBody)))
+	if patchW.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for PATCH, got %d", patchW.Code)
+	}
+	var patched models.Event
+	if err := json.NewDecoder(patchW.Body).Decode(&patched); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if _, ok := patched.Data["value"]; ok {
+		t.Error("Expected value to be removed")
+	}
+
+	deleteW := httptest.NewRecorder()
+	mux.ServeHTTP(deleteW, httptest.NewRequest(http.MethodDelete, path, nil))
+	if deleteW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 for DELETE, got %d", deleteW.Code)
+	}
+
+	missingW := httptest.NewRecorder()
+	mux.ServeHTTP(missingW, httptest.NewRequest(http.MethodGet, path, nil))
+	if missingW.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 after delete, got %d", missingW.Code)
+	}
+}
+
+func TestEventByIDInvalidRequests(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	mux := server.setupRoutes()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "/events/abc", "", http.StatusBadRequest},
+		{http.MethodGet, "/events/0", "", http.StatusBadRequest},
+		{http.MethodPut, "/events/1", "", http.StatusMethodNotAllowed},
+		{http.MethodPatch, "/events/1", "not json", http.StatusBadRequest},
+		{http.MethodPatch, "/events/1", `{}`, http.StatusBadRequest},
+		{http.MethodPatch, "/events/1", `{"data": {}}`, http.StatusNotFound},
+		{http.MethodDelete, "/events/1", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, bytes.NewReader([]byte(tt.body))))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, w.Code)
+		}
+	}
+}
+
+func TestHandleDeleteEventsFiltered(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	insertBatch := models.Batch{
+		Events: []models.Event{
+			{
+				TSUTC: 1000000000000,
+				TSISO: "2001-09-09T01:46:40Z",
+				URL:   "https://bank.example.com/account",
+				Type:  "navigate",
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
+			},
+			{
+				TSUTC: 2000000000000,
+				TSISO: "2033-05-18T03:33:20Z",
+				URL:   "https://example.com",
+				Type:  "navigate",
+				Data:  map[string]any{"from": nil, "to": "https://example.com"},
+			},
+		},
+	}
+	jsonData, _ := json.Marshal(insertBatch)
+	postReq := httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData))
+	server.handleEvents(httptest.NewRecorder(), postReq)
+
+	// Dry run reports without deleting
+	dryW := httptest.NewRecorder()
+	server.handleEvents(dryW, httptest.NewRequest(http.MethodDelete, "/events?domain=bank.example.com&dry_run=true", nil))
+	if dryW.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for dry run, got %d", dryW.Code)
+	}
+	var dryRun map[string]any
+	if err := json.NewDecoder(dryW.Body).Decode(&dryRun); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if dryRun["matched_count"] != float64(1) {
+		t.Errorf("Expected matched_count 1, got %v", dryRun["matched_count"])
+	}
+
+	deleteW := httptest.NewRecorder()
+	server.handleEvents(deleteW, httptest.NewRequest(http.MethodDelete, "/events?domain=bank.example.com", nil))
+	if deleteW.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for delete, got %d", deleteW.Code)
+	}
+	var deleted map[string]any
+	if err := json.NewDecoder(deleteW.Body).Decode(&deleted); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if deleted["deleted_count"] != float64(1) {
+		t.Errorf("Expected deleted_count 1, got %v", deleted["deleted_count"])
+	}
+
+	getW := httptest.NewRecorder()
+	server.handleEvents(getW, httptest.NewRequest(http.MethodGet, "/events", nil))
+	var batch models.Batch
+	if err := json.NewDecoder(getW.Body).Decode(&batch); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(batch.Events) != 1 || batch.Events[0].URL != "https://example.com" {
+		t.Errorf("Unexpected remaining events: %+v", batch.Events)
+	}
+}
+
+func TestHandleDeleteEventsInvalidParams(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	postW := httptest.NewRecorder()
+	server.handleEvents(postW, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(
+		`{"events":[{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com","type":"navigate","data":{"from":null,"to":"https://example.com"}}]}`,
+	)))
+	if postW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", postW.Code)
+	}
+
+	paths := []string{
+		"/events?dry_run=maybe",
+		"/events?since=yesterday",
+		"/events?type=nonexistent",
+		"/events",
+		"/events?dry_run=true",
+		"/events?limit=10",
+		"/events?cursor=abc",
+		"/events?host=example.com",
+		"/events?all=yes",
+		"/events?all=true&domain=example.com",
+	}
+	for _, path := range paths {
+		w := httptest.NewRecorder()
+		server.handleEvents(w, httptest.NewRequest(http.MethodDelete, path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", path, w.Code)
+		}
+	}
+
+	count, err := server.db.CountEvents(database.EventFilter{})
+	if err != nil || count != 1 {
+		t.Fatalf("Expected the event to survive refused deletes, got %d, %v", count, err)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleEvents(w, httptest.NewRequest(http.MethodDelete, "/events?all=true", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for all=true, got %d", w.Code)
+	}
+	if count, _ := server.db.CountEvents(database.EventFilter{}); count != 0 {
+		t.Errorf("Expected every event deleted, got %d left", count)
+	}
+}
+
+func TestHandlePostEventsRedaction(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	config, err := redaction.ParseConfig("input:credit_card=drop")
+	if err != nil {
+		t.Fatalf("ParseConfig failed: %v", err)
+	}
+	server.SetRedactor(redaction.NewRedactor(config))
+
+	insertBatch := models.Batch{
+		Events: []models.Event{
+			{
+				TSUTC: 1000000000000,
+				TSISO: "2001-09-09T01:46:40Z",
+				URL:   "https://example.com",
+				Type:  "visible_text",
+				Data:  map[string]any{"text": "Email me at jane@example.com"},
+			},
+			{
+				TSUTC: 2000000000000,
+				TSISO: "2033-05-18T03:33:20Z",
+				URL:   "https://shop.example.com/checkout",
+				Type:  "input",
+				Data:  map[string]any{"selector": "#card", "value": "4111 1111 1111 1111"},
+			},
+		},
+	}
+	jsonData, _ := json.Marshal(insertBatch)
+	postW := httptest.NewRecorder()
+	server.handleEvents(postW, httptest.NewRequest(http.MethodPost, "/events", bytes.NewReader(jsonData)))
+	if postW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", postW.Code)
+	}
+
+	getW := httptest.NewRecorder()
+	server.handleEvents(getW, httptest.NewRequest(http.MethodGet, "/events", nil))
+	var batch models.Batch
+	if err := json.NewDecoder(getW.Body).Decode(&batch); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(batch.Events) != 1 {
+		t.Fatalf("Expected the card input to be dropped, got %d events", len(batch.Events))
+	}
+	if batch.Events[0].Data["text"] != "Email me at [REDACTED:email]" {
+		t.Errorf("Expected masked email, got %v", batch.Events[0].Data["text"])
+	}
+}
+
+func TestHandleSearchEncrypted(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	key, err := encryption.ParseKey(encryption.GenerateKey())
+	if err != nil {
+		t.Fatalf("ParseKey failed: %v", err)
+	}
+	if err := server.db.EnableEncryption(key); err != nil {
+		t.Fatalf("EnableEncryption failed: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	server.handleSearch(w, httptest.NewRequest(http.MethodGet, "/search?q=anything", nil))
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status 501, got %d", w.Code)
+	}
+}
+
+func TestAuthAndCORS(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.SetAuthToken("secret")
+	mux := server.setupRoutes()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		origin string
+		token  string
+		want   int
+	}{
+		{"healthz without token", http.MethodGet, "/healthz", "", "", http.StatusOK},
+		{"events without token", http.MethodGet, "/events", "", "", http.StatusUnauthorized},
+		{"events with wrong token", http.MethodGet, "/events", "", "wrong", http.StatusUnauthorized},
+		{"events with token", http.MethodGet, "/events", "", "secret", http.StatusOK},
+		{"delete without token", http.MethodDelete, "/events", "", "", http.StatusUnauthorized},
+		{"events with query token", http.MethodGet, "/events?access_token=secret", "", "", http.StatusUnauthorized},
+		{"extension origin", http.MethodGet, "/stats", "chrome-extension://" + auth.ExtensionID, "secret", http.StatusOK},
+		{"other extension origin", http.MethodGet, "/stats", "chrome-extension://abcdef", "secret", http.StatusForbidden},
+		{"web page origin", http.MethodGet, "/events", "https://evil.example", "secret", http.StatusForbidden},
+		{"web page healthz", http.MethodGet, "/healthz", "https://evil.example", "", http.StatusForbidden},
+		{"preflight without token", http.MethodOptions, "/events", "file://", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.want, w.Code)
+		}
+		if tt.want != http.StatusForbidden && tt.origin != "" && w.Header().Get("Access-Control-Allow-Origin") != tt.origin {
+			t.Errorf("%s: expected origin to be echoed, got %q", tt.name, w.Header().Get("Access-Control-Allow-Origin"))
+		}
+		if tt.want == http.StatusForbidden && w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("%s: expected no CORS header for a refused origin", tt.name)
+		}
+	}
+}
+
+func TestHandlePostEventsSchemaValidation(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	mux := server.setupRoutes()
+
+	// The second event is a click without a selector, and claims to be clean
+	body := `{"events":[
+		{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com","type":"navigate","data":{"from":null,"to":"https://example.com"}},
+		{"ts_utc":2000,"ts_iso":"1970-01-01T00:00:02Z","url":"https://example.com","type":"click","data":{"text":5},"schema_violations":[]}
+	]}`
+
+	strictW := httptest.NewRecorder()
+	mux.ServeHTTP(strictW, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	if strictW.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", strictW.Code)
+	}
+	var response models.ValidationError
+	if err := json.NewDecoder(strictW.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Violations) != 2 {
+		t.Fatalf("Expected 2 violations, got %+v", response.Violations)
+	}
+	for _, violation := range response.Violations {
+		if violation.EventIndex != 1 || violation.Type != "click" {
+			t.Errorf("Expected violations for event 1, got %+v", violation)
+		}
+	}
+	if count, _ := server.db.CountEvents(database.EventFilter{}); count != 0 {
+		t.Errorf("Expected the rejected batch not to be stored, got %d events", count)
+	}
+
+	server.SetSchemaValidation(SchemaLenient)
+	lenientW := httptest.NewRecorder()
+	mux.ServeHTTP(lenientW, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	if lenientW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", lenientW.Code, lenientW.Body.String())
+	}
+
+	flaggedW := httptest.NewRecorder()
+	mux.ServeHTTP(flaggedW, httptest.NewRequest(http.MethodGet, "/events?flagged=true", nil))
+	var batch models.Batch
+	if err := json.NewDecoder(flaggedW.Body).Decode(&batch); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(batch.Events) != 1 || batch.Events[0].Type != "click" {
+		t.Fatalf("Expected only the click to be flagged, got %+v", batch.Events)
+	}
+	want := []string{"missing properties: 'selector'", "/text: expected string, but got number"}
+	if strings.Join(batch.Events[0].SchemaViolations, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected violations %v, got %v", want, batch.Events[0].SchemaViolations)
+	}
+
+	badW := httptest.NewRecorder()
+	mux.ServeHTTP(badW, httptest.NewRequest(http.MethodGet, "/events?flagged=maybe", nil))
+	if badW.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid flagged parameter, got %d", badW.Code)
+	}
+}
+
+func TestDroppedEventsSkipSchemaValidation(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	mux := server.setupRoutes()
+
+	ruleW := httptest.NewRecorder()
+	mux.ServeHTTP(ruleW, httptest.NewRequest(http.MethodPost, "/domain-rules", strings.NewReader(`{"pattern":"*.bank.com","action":"drop"}`)))
+	if ruleW.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", ruleW.Code, ruleW.Body.String())
+	}
+
+	// The invalid click is dropped by the domain rule, so the batch is stored
+	body := `{"events":[
+		{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com","type":"click","data":{"selector":"#a","text":"A"}},
+		{"ts_utc":2000,"ts_iso":"1970-01-01T00:00:02Z","url":"https://www.bank.com","type":"click","data":{"text":5}}
+	]}`
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if count, _ := server.db.CountEvents(database.EventFilter{}); count != 1 {
+		t.Errorf("Expected only the kept event to be stored, got %d events", count)
+	}
+}
+
+func TestCanonicalizeOnIngestion(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	// Stored before canonicalization was enabled
+	legacy := `{"events":[{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://Example.com/docs?utm_source=mail","title":null,"type":"click","data":{"selector":"a","text":"A"},"session_id":"s1"}]}`
+	postW := httptest.NewRecorder()
+	server.handleEvents(postW, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(legacy)))
+	if postW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", postW.Code, postW.Body.String())
+	}
+
+	server.SetCanonicalizer(urlnorm.NewCanonicalizer(urlnorm.DefaultConfig()))
+	body := `{"events":[
+		{"ts_utc":2000,"ts_iso":"1970-01-01T00:00:02Z","url":"https://example.com/docs?token=abc123&utm_source=mail#intro","title":"Docs","type":"navigate","data":{"from":"https://example.com/login?session=s3cr3t","to":"https://example.com/docs?token=abc123&utm_source=mail#intro"},"session_id":"s2"},
+		{"ts_utc":3000,"ts_iso":"1970-01-01T00:00:03Z","url":"https://example.com/docs","title":null,"type":"visible_text","data":{"text":"Docs"},"session_id":"s2"},
+		{"ts_utc":4000,"ts_iso":"1970-01-01T00:00:04Z","url":"https://example.com/docs?fbclid=x","title":null,"type":"visible_text","data":{"text":"Docs, updated"},"session_id":"s2"}
+	]}`
+	postW = httptest.NewRecorder()
+	server.handleEvents(postW, httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body)))
+	if postW.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", postW.Code, postW.Body.String())
+	}
+
+	getW := httptest.NewRecorder()
+	server.handleEvents(getW, httptest.NewRequest(http.MethodGet, "/events?url="+url.QueryEscape("https://Example.com/docs?utm_source=mail"), nil))
+	var batch models.Batch
+	if err := json.NewDecoder(getW.Body).Decode(&batch); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// The two visible_text events share a canonical URL, so the second replaced the first
+	if len(batch.Events) != 3 {
+		t.Fatalf("Expected the legacy event and two canonical ones, got %+v", batch.Events)
+	}
+	text, navigate, click := batch.Events[0], batch.Events[1], batch.Events[2]
+	if text.URL != "https://example.com/docs" || text.Data["text"] != "Docs, updated" {
+		t.Errorf("Expected the visible_text event deduplicated on its canonical URL, got %+v", text)
+	}
+	if navigate.URL != "https://example.com/docs" || navigate.RawURL == nil ||
+		*navigate.RawURL != "https://example.com/docs?token=REDACTED&utm_source=mail#intro" {
+		t.Errorf("Expected a canonical URL and the captured one with its token masked, got %q, %v", navigate.URL, navigate.RawURL)
+	}
+	if navigate.Data["from"] != "https://example.com/login?session=REDACTED" {
+		t.Errorf("Expected the secret masked in the navigation data, got %v", navigate.Data["from"])
+	}
+	if click.URL != "https://Example.com/docs?utm_source=mail" || click.RawURL != nil {
+		t.Errorf("Expected the legacy event unchanged, got %+v", click)
+	}
+}
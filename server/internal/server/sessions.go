@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// Sessions are maintained by the database as events are stored; these
+// endpoints only read them.
+
+// handleSessions lists sessions most recently active first. since and until
+// select the sessions active in that window; limit and cursor page as on
+// GET /events.
+func (s *Server) handleSessions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := req.URL.Query()
+	filter := database.SessionFilter{Limit: s.defaultLimit}
+
+	if sinceParam := query.Get("since"); sinceParam != "" {
+		since, err := strconv.ParseInt(sinceParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'since' parameter: must be Unix timestamp in milliseconds", http.StatusBadRequest)
+			return
+		}
+		filter.SinceUTC = &since
+	}
+
+	if untilParam := query.Get("until"); untilParam != "" {
+		until, err := strconv.ParseInt(untilParam, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid 'until' parameter: must be Unix timestamp in milliseconds", http.StatusBadRequest)
+			return
+		}
+		filter.UntilUTC = &until
+	}
+
+	if limitParam := query.Get("limit"); limitParam != "" {
+		limit, err := strconv.Atoi(limitParam)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid 'limit' parameter: must be positive integer", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	if cursorParam := query.Get("cursor"); cursorParam != "" {
+		cursor, err := database.DecodeCursor(cursorParam)
+		if err != nil {
+			http.Error(w, "Invalid 'cursor' parameter: use next_cursor from a previous response", http.StatusBadRequest)
+			return
+		}
+		filter.Cursor = &cursor
+	}
+
+	sessions, next, err := s.db.ListSessions(filter)
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to retrieve sessions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := models.Sessions{Sessions: sessions}
+	if sessions == nil {
+		response.Sessions = []models.Session{}
+	}
+	if next != nil {
+		response.NextCursor = next.Encode()
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+}
+
+// handleSessionEvents returns the events of one session, newest first, with
+// the filters and paging of GET /events
+func (s *Server) handleSessionEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := req.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if errors.Is(err, database.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Database error: %v", err)
+		http.Error(w, "Failed to retrieve session", http.StatusInternalServerError)
+		return
+	}
+
+	query := req.URL.Query()
+	filter, err := s.parseEventFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.SessionID = &id
+	s.writeEventsPage(w, query, filter)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestHandleSessions(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	routes := server.setupRoutes()
+
+	body := `{"events":[
+		{"ts_utc":1000,"ts_iso":"1970-01-01T00:00:01Z","url":"https://example.com/","title":null,"type":"navigate","data":{"from":null,"to":"https://example.com/"},"session_id":"s1","tab_id":3,"window_id":1},
+		{"ts_utc":2000,"ts_iso":"1970-01-01T00:00:02Z","url":"https://example.com/a","title":null,"type":"click","data":{"selector":"a","text":"A"},"session_id":"s1"},
+		{"ts_utc":3000,"ts_iso":"1970-01-01T00:00:03Z","url":"https://other.org/","title":null,"type":"navigate","data":{"from":null,"to":"https://other.org/"},"session_id":"s2"},
+		{"ts_utc":4000,"ts_iso":"1970-01-01T00:00:04Z","url":"https://other.org/","title":null,"type":"navigate","data":{"from":"https://example.com/","to":"https://other.org/"},"session_id":"tab-switch"}
+	]}`
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/events", bytes.NewBufferString(body)))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions?limit=1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var page models.Sessions
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode sessions: %v", err)
+	}
+	if len(page.Sessions) != 1 || page.Sessions[0].ID != "s2" || page.NextCursor == "" {
+		t.Fatalf("Expected s2 first with a next cursor, got %+v", page)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions?limit=1&cursor="+page.NextCursor, nil))
+	page = models.Sessions{}
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatalf("Failed to decode sessions: %v", err)
+	}
+	if len(page.Sessions) != 1 || page.NextCursor != "" {
+		t.Fatalf("Expected the last session without a next cursor, got %+v", page)
+	}
+	s1 := page.Sessions[0]
+	if s1.ID != "s1" || s1.EventCount != 2 || s1.StartURL != "https://example.com/" {
+		t.Errorf("Unexpected session: %+v", s1)
+	}
+	if s1.TabID == nil || *s1.TabID != 3 || s1.WindowID == nil || *s1.WindowID != 1 {
+		t.Errorf("Expected tab 3 in window 1, got %v, %v", s1.TabID, s1.WindowID)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions/s1/events?type=click", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var batch models.Batch
+	if err := json.NewDecoder(w.Body).Decode(&batch); err != nil {
+		t.Fatalf("Failed to decode events: %v", err)
+	}
+	if len(batch.Events) != 1 || batch.Events[0].URL != "https://example.com/a" {
+		t.Errorf("Expected the session's click event, got %+v", batch.Events)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions/tab-switch/events", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for the tab switch marker, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sessions?since=soon", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid since, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	routes.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sessions", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", w.Code)
+	}
+}
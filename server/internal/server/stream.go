@@ -0,0 +1,127 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// streamHeartbeat keeps idle streams alive through proxies and lets the
+// server notice clients that went away
+const streamHeartbeat = 15 * time.Second
+
+// handleEventStream pushes events to the client as Server-Sent Events as soon
+// as they are stored, including upserted input and visible_text events. It
+// accepts the same filters as GET /events. Each event is sent as
+//
+//	id: <event id>
+//	data: <event JSON>
+//
+// A client that falls too far behind receives "event: dropped" with the number
+// of events it missed, and should catch up with GET /events.
+func (s *Server) handleEventStream(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := s.parseEventFilter(req.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Streams outlive the server's WriteTimeout; recorders in tests don't support this
+	controller := http.NewResponseController(w)
+	_ = controller.SetWriteDeadline(time.Time{})
+
+	sub := s.hub.Subscribe(func(event models.Event) bool { return eventMatches(filter, event) })
+	defer s.hub.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	if err := controller.Flush(); err != nil {
+		log.Printf("Stream error: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event, ok := <-sub.Events():
+			if !ok {
+				return // server shutting down
+			}
+			if err := writeStreamEvent(w, event); err != nil {
+				log.Printf("JSON encoding error: %v", err)
+				return
+			}
+		}
+
+		if dropped := sub.TakeDropped(); dropped > 0 {
+			fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+		}
+		if err := controller.Flush(); err != nil {
+			return // client went away
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, event models.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.ID, data)
+	return err
+}
+
+// eventMatches applies the GET /events filters to a single event in memory
+func eventMatches(filter database.EventFilter, event models.Event) bool {
+	if filter.EventType != nil && event.Type != *filter.EventType {
+		return false
+	}
+	if filter.SinceUTC != nil && event.TSUTC < *filter.SinceUTC {
+		return false
+	}
+	if filter.UntilUTC != nil && event.TSUTC > *filter.UntilUTC {
+		return false
+	}
+	if filter.URL != nil && event.URL != *filter.URL {
+		return false
+	}
+	if filter.SessionID != nil && (event.SessionID == nil || *event.SessionID != *filter.SessionID) {
+		return false
+	}
+	if filter.Flagged != nil && *filter.Flagged != (len(event.SchemaViolations) > 0) {
+		return false
+	}
+	if filter.Domain != nil {
+		parsed, err := url.Parse(event.URL)
+		if err != nil {
+			return false
+		}
+		host := strings.ToLower(parsed.Hostname())
+		domain := strings.ToLower(*filter.Domain)
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vincentbai/browsetrace-server/internal/database"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// readStreamEvent returns the next "data:" payload from an SSE stream
+func readStreamEvent(t *testing.T, reader *bufio.Reader) (id string, data string) {
+	t.Helper()
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			return id, data
+		}
+	}
+}
+
+func TestHandleEventStream(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.SetAuthToken("secret")
+
+	httpServer := httptest.NewServer(server.setupRoutes())
+	defer httpServer.Close()
+	defer server.hub.Close()
+
+	// EventSource cannot send headers, so the token goes in the query
+	resp, err := http.Get(httpServer.URL + "/events/stream?type=input&access_token=secret")
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", contentType)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for server.hub.SubscriberCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	session := "session-1"
+	field := "search"
+	post := func(ts int64, eventType string, data map[string]any) {
+		batch := models.Batch{Events: []models.Event{{
+			TSUTC: ts, TSISO: "2023-11-14T22:13:20Z", URL: "https://example.com", Type: eventType,
+			Data: data, SessionID: &session, FieldID: &field,
+		}}}
+		body, _ := json.Marshal(batch)
+		req, _ := http.NewRequest(http.MethodPost, httpServer.URL+"/events", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		postResp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to post events: %v", err)
+		}
+		postResp.Body.Close()
+	}
+
+	post(1000, "navigate", map[string]any{"from": nil, "to": "https://example.com"})
+	post(2000, "input", map[string]any{"selector": "#search", "value": "hel"})
+	post(3000, "input", map[string]any{"selector": "#search", "value": "hello"}) // upsert of the same field
+
+	reader := bufio.NewReader(resp.Body)
+	firstID, first := readStreamEvent(t, reader)
+	secondID, second := readStreamEvent(t, reader)
+
+	var event models.Event
+	if err := json.Unmarshal([]byte(first), &event); err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	if event.Type != "input" || event.Data["value"] != "hel" {
+		t.Errorf("Expected the first input event, got %+v", event)
+	}
+	if err := json.Unmarshal([]byte(second), &event); err != nil {
+		t.Fatalf("Failed to decode event: %v", err)
+	}
+	if event.Data["value"] != "hello" {
+		t.Errorf("Expected the upserted value, got %v", event.Data["value"])
+	}
+	if firstID == "" || firstID == "0" || firstID != secondID {
+		t.Errorf("Expected the upsert to keep id %s, got %s", firstID, secondID)
+	}
+}
+
+func TestHandleEventStreamUnauthorized(t *testing.T) {
+	server, cleanup := setupTestServer(t)
+	defer cleanup()
+	server.SetAuthToken("secret")
+
+	w := httptest.NewRecorder()
+	server.setupRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/stream?access_token=wrong", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestEventMatches(t *testing.T) {
+	session := "s1"
+	event := models.Event{TSUTC: 2000, URL: "https://www.Example.com/a", Type: "click", SessionID: &session}
+
+	eventType, otherType := "click", "input"
+	since, until := int64(1000), int64(1500)
+	domain, otherDomain := "example.com", "ample.com"
+	otherSession := "s2"
+
+	tests := []struct {
+		name   string
+		filter database.EventFilter
+		want   bool
+	}{
+		{"no filter", database.EventFilter{}, true},
+		{"type", database.EventFilter{EventType: &eventType}, true},
+		{"other type", database.EventFilter{EventType: &otherType}, false},
+		{"since", database.EventFilter{SinceUTC: &since}, true},
+		{"until", database.EventFilter{UntilUTC: &until}, false},
+		{"domain", database.EventFilter{Domain: &domain}, true},
+		{"partial domain", database.EventFilter{Domain: &otherDomain}, false},
+		{"other session", database.EventFilter{SessionID: &otherSession}, false},
+	}
+
+	for _, tt := range tests {
+		if got := eventMatches(tt.filter, event); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
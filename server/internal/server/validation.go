@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/vincentbai/browsetrace-server/internal/jsonschema"
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// SchemaValidation decides what happens to events whose data does not match
+// the schema registered for their type
+type SchemaValidation string
+
+const (
+	SchemaStrict  SchemaValidation = "strict"  // reject the whole batch with 400
+	SchemaLenient SchemaValidation = "lenient" // store the event with its violations recorded
+	SchemaOff     SchemaValidation = "off"     // store without checking
+)
+
+// ParseSchemaValidation reads a mode name; empty means strict
+func ParseSchemaValidation(mode string) (SchemaValidation, error) {
+	switch SchemaValidation(mode) {
+	case "":
+		return SchemaStrict, nil
+	case SchemaStrict, SchemaLenient, SchemaOff:
+		return SchemaValidation(mode), nil
+	default:
+		return "", fmt.Errorf("invalid schema validation mode %q: must be strict, lenient or off", mode)
+	}
+}
+
+// SetSchemaValidation changes how ingestion treats data that fails its schema
+func (s *Server) SetSchemaValidation(mode SchemaValidation) {
+	s.schemaValidation = mode
+}
+
+// screenBatch screens a posted batch as prepareEvents does and validates the
+// data of the events it keeps. Data is checked as posted, before strip_data
+// empties it, but an event that domain rules or redaction drop never fails
+// the batch. In strict mode it writes a 400 listing every violation and
+// returns false; in lenient mode it records the violations on the events
+// instead. Indexes in the 400 refer to the batch as posted.
+func (s *Server) screenBatch(w http.ResponseWriter, events []models.Event) ([]models.Event, bool) {
+	found := make([][]jsonschema.Violation, len(events))
+	for i := range events {
+		// Only the server may flag events
+		events[i].SchemaViolations = nil
+		if s.schemaValidation != SchemaOff {
+			found[i] = s.db.CheckEventData(events[i])
+		}
+	}
+	kept, indexes := s.prepareEvents(events)
+
+	var violations []models.SchemaViolation
+	flagged := 0
+	for k, i := range indexes {
+		if len(found[i]) == 0 {
+			continue
+		}
+		if s.schemaValidation == SchemaLenient {
+			kept[k].SchemaViolations = violationMessages(found[i])
+			flagged++
+			continue
+		}
+		for _, violation := range found[i] {
+			violations = append(violations, models.SchemaViolation{
+				EventIndex: i,
+				Type:       kept[k].Type,
+				Path:       violation.Path,
+				Message:    violation.Message,
+			})
+		}
+	}
+
+	if flagged > 0 {
+		log.Printf("Schema validation flagged %d events", flagged)
+	}
+	if len(violations) == 0 {
+		return kept, true
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	response := models.ValidationError{Error: "event data does not match its schema", Violations: violations}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("JSON encoding error: %v", err)
+	}
+	return nil, false
+}
+
+// violationMessages formats violations as they are stored on flagged events
+func violationMessages(violations []jsonschema.Violation) []string {
+	var messages []string
+	for _, violation := range violations {
+		messages = append(messages, violation.String())
+	}
+	return messages
+}
@@ -0,0 +1,113 @@
+package stream
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+// DefaultBufferSize is how many events a subscriber may fall behind before
+// events are dropped for it
+const DefaultBufferSize = 256
+
+// Subscription receives the published events its match function accepts
+type Subscription struct {
+	events  chan models.Event
+	match   func(models.Event) bool
+	dropped atomic.Int64
+}
+
+// Events is closed when the subscription ends or the hub is closed
+func (s *Subscription) Events() <-chan models.Event {
+	return s.events
+}
+
+// TakeDropped returns how many events were dropped because the subscriber
+// fell behind since the last call, and resets the count
+func (s *Subscription) TakeDropped() int64 {
+	return s.dropped.Swap(0)
+}
+
+// Hub fans out published events to every subscriber. Publishing never
+// blocks: a subscriber whose buffer is full misses the event instead of
+// stalling ingestion, and learns about it through TakeDropped.
+type Hub struct {
+	bufferSize int
+
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+func NewHub(bufferSize int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Hub{
+		bufferSize:  bufferSize,
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Subscribe registers a subscriber for events accepted by match, or all
+// events if match is nil. Call Unsubscribe when done.
+func (h *Hub) Subscribe(match func(models.Event) bool) *Subscription {
+	sub := &Subscription{events: make(chan models.Event, h.bufferSize), match: match}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(sub.events)
+		return sub
+	}
+	h.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes sub and closes its channel. It is safe to call more than once.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// Publish delivers events to every matching subscriber, in order
+func (h *Hub) Publish(events []models.Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for sub := range h.subscribers {
+		for _, event := range events {
+			if sub.match != nil && !sub.match(event) {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				sub.dropped.Add(1)
+			}
+		}
+	}
+}
+
+// SubscriberCount returns the number of active subscribers
+func (h *Hub) SubscriberCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subscribers)
+}
+
+// Close ends every subscription; later subscriptions are closed immediately
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		close(sub.events)
+	}
+	h.subscribers = map[*Subscription]struct{}{}
+	h.closed = true
+}
@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/vincentbai/browsetrace-server/internal/models"
+)
+
+func TestHubPublishToMatchingSubscribers(t *testing.T) {
+	hub := NewHub(10)
+
+	all := hub.Subscribe(nil)
+	clicks := hub.Subscribe(func(event models.Event) bool { return event.Type == "click" })
+	if hub.SubscriberCount() != 2 {
+		t.Fatalf("Expected 2 subscribers, got %d", hub.SubscriberCount())
+	}
+
+	hub.Publish([]models.Event{{ID: 1, Type: "navigate"}, {ID: 2, Type: "click"}})
+
+	if event := <-all.Events(); event.ID != 1 {
+		t.Errorf("Expected event 1 first, got %d", event.ID)
+	}
+	if event := <-all.Events(); event.ID != 2 {
+		t.Errorf("Expected event 2 second, got %d", event.ID)
+	}
+	if event := <-clicks.Events(); event.ID != 2 {
+		t.Errorf("Expected only the click, got %d", event.ID)
+	}
+	select {
+	case event := <-clicks.Events():
+		t.Errorf("Expected no more events, got %d", event.ID)
+	default:
+	}
+
+	hub.Unsubscribe(clicks)
+	hub.Unsubscribe(clicks)
+	if _, ok := <-clicks.Events(); ok {
+		t.Error("Expected channel to be closed after Unsubscribe")
+	}
+	if hub.SubscriberCount() != 1 {
+		t.Errorf("Expected 1 subscriber, got %d", hub.SubscriberCount())
+	}
+}
+
+func TestHubDropsForSlowSubscribers(t *testing.T) {
+	hub := NewHub(2)
+	slow := hub.Subscribe(nil)
+
+	// Publishing must not block even though nobody is reading
+	hub.Publish([]models.Event{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}, {ID: 5}})
+
+	if dropped := slow.TakeDropped(); dropped != 3 {
+		t.Errorf("Expected 3 dropped events, got %d", dropped)
+	}
+	if dropped := slow.TakeDropped(); dropped != 0 {
+		t.Errorf("Expected count to reset, got %d", dropped)
+	}
+	if event := <-slow.Events(); event.ID != 1 {
+		t.Errorf("Expected the oldest buffered event, got %d", event.ID)
+	}
+}
+
+func TestHubClose(t *testing.T) {
+	hub := NewHub(0)
+	sub := hub.Subscribe(nil)
+
+	hub.Close()
+	if _, ok := <-sub.Events(); ok {
+		t.Error("Expected channel to be closed by Close")
+	}
+	hub.Unsubscribe(sub) // must not panic on a closed channel
+
+	late := hub.Subscribe(nil)
+	if _, ok := <-late.Events(); ok {
+		t.Error("Expected subscriptions after Close to be closed")
+	}
+	hub.Publish([]models.Event{{ID: 1}})
+}
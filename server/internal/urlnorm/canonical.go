@@ -0,0 +1,164 @@
+package urlnorm
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SecretParams are query parameters that commonly carry credentials or
+// session tokens. A leading or trailing * matches any prefix or suffix.
+var SecretParams = []string{
+	"token", "*_token", "*-token", "auth", "authtoken", "auth_key", "key", "api_key", "apikey",
+	"session", "sessionid", "session_id", "sid", "jsessionid", "phpsessid", "aspsessionid",
+	"password", "passwd", "pwd", "secret", "client_secret", "code", "signature", "sig",
+	"x-amz-credential", "x-amz-signature",
+}
+
+// Masked replaces the value of a secret parameter in a URL kept as captured
+const Masked = "REDACTED"
+
+// Rule overrides how the URLs of one domain, and its subdomains, are canonicalized
+type Rule struct {
+	Domain   string
+	Keep     []string // when set, the only query parameters kept, secrets aside
+	Strip    []string // removed on top of Config.Strip
+	Fragment bool     // keep the fragment, for sites that route on it
+}
+
+// Config lists the query parameters canonicalization removes
+type Config struct {
+	Strip   []string // removed from canonical URLs
+	Secrets []string // removed from canonical URLs and masked in captured ones
+	Rules   []Rule
+}
+
+// DefaultConfig strips TrackingParams and SecretParams, with no domain rules
+func DefaultConfig() Config {
+	return Config{Strip: TrackingParams, Secrets: SecretParams}
+}
+
+// ParseRules reads semicolon-separated domain rules, e.g.
+//
+//	youtube.com keep=v,t; app.example.com fragment; shop.example.com strip=ref,sort
+//
+// Each rule is a domain followed by options: keep= and strip= take
+// comma-separated parameter names, fragment keeps the fragment.
+func ParseRules(spec string) ([]Rule, error) {
+	var rules []Rule
+	for _, entry := range strings.Split(spec, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule := Rule{Domain: strings.ToLower(fields[0])}
+		if strings.ContainsAny(rule.Domain, "=/:") {
+			return nil, fmt.Errorf("invalid URL rule %q: must start with a domain", strings.TrimSpace(entry))
+		}
+		for _, option := range fields[1:] {
+			name, value, _ := strings.Cut(option, "=")
+			switch {
+			case name == "fragment" && value == "":
+				rule.Fragment = true
+			case name == "keep" && value != "":
+				rule.Keep = append(rule.Keep, strings.Split(value, ",")...)
+			case name == "strip" && value != "":
+				rule.Strip = append(rule.Strip, strings.Split(value, ",")...)
+			default:
+				return nil, fmt.Errorf("invalid URL rule option %q for %s: must be keep=, strip= or fragment", option, rule.Domain)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Canonicalizer rewrites URLs as they are ingested
+type Canonicalizer struct {
+	config Config
+}
+
+func NewCanonicalizer(config Config) *Canonicalizer {
+	return &Canonicalizer{config: config}
+}
+
+// Canonicalize returns the canonical form of rawURL, normalized as by
+// Normalize with the configured parameters removed, and rawURL with the
+// values of secret parameters, in its query or fragment, masked
+func (c *Canonicalizer) Canonicalize(rawURL string) (canonical, captured string) {
+	captured = c.Scrub(rawURL)
+
+	rule := c.rule(rawURL)
+	drop := func(name string) bool {
+		if matchParam(c.config.Secrets, name) || matchParam(c.config.Strip, name) {
+			return true
+		}
+		if rule == nil {
+			return false
+		}
+		return matchParam(rule.Strip, name) || (rule.Keep != nil && !matchParam(rule.Keep, name))
+	}
+	return normalize(captured, drop, rule != nil && rule.Fragment), captured
+}
+
+// Scrub masks the values of secret parameters in rawURL, leaving the rest of
+// it as it was
+func (c *Canonicalizer) Scrub(rawURL string) string {
+	base, fragment, hasFragment := strings.Cut(rawURL, "#")
+	path, query, hasQuery := strings.Cut(base, "?")
+
+	scrubbed := path
+	if hasQuery {
+		scrubbed += "?" + c.scrubQuery(query)
+	}
+	if hasFragment {
+		// Fragments such as #access_token=... or #/callback?code=... carry
+		// tokens too; ones without parameters are left alone
+		if route, params, ok := strings.Cut(fragment, "?"); ok {
+			fragment = route + "?" + c.scrubQuery(params)
+		} else if strings.Contains(fragment, "=") {
+			fragment = c.scrubQuery(fragment)
+		}
+		scrubbed += "#" + fragment
+	}
+	return scrubbed
+}
+
+func (c *Canonicalizer) scrubQuery(query string) string {
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || value == "" {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if matchParam(c.config.Secrets, name) {
+			pairs[i] = pair[:len(pair)-len(value)] + Masked
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// rule returns the rule for the most specific domain rawURL is on, or nil
+func (c *Canonicalizer) rule(rawURL string) *Rule {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	var best *Rule
+	for i := range c.config.Rules {
+		rule := &c.config.Rules[i]
+		if host != rule.Domain && !strings.HasSuffix(host, "."+rule.Domain) {
+			continue
+		}
+		if best == nil || len(rule.Domain) > len(best.Domain) {
+			best = rule
+		}
+	}
+	return best
+}
@@ -0,0 +1,57 @@
+package urlnorm
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	rules, err := ParseRules("youtube.com keep=v,t; mail.example.com fragment; shop.example.com strip=ref,sort")
+	if err != nil {
+		t.Fatalf("ParseRules failed: %v", err)
+	}
+	canonicalizer := NewCanonicalizer(Config{Strip: TrackingParams, Secrets: SecretParams, Rules: rules})
+
+	tests := []struct {
+		raw       string
+		canonical string
+		captured  string
+	}{
+		{"https://Example.com/a?utm_source=x&b=1#top", "https://example.com/a?b=1", "https://Example.com/a?utm_source=x&b=1#top"},
+		{"https://example.com/reset?token=abc123&step=2", "https://example.com/reset?step=2", "https://example.com/reset?token=REDACTED&step=2"},
+		{"https://example.com/cb#access_token=abc&expires_in=60", "https://example.com/cb", "https://example.com/cb#access_token=REDACTED&expires_in=60"},
+		{"https://example.com/#/login?code=xyz", "https://example.com/", "https://example.com/#/login?code=REDACTED"},
+		{"https://www.youtube.com/watch?v=abc&list=PL1&t=30&si=share", "https://www.youtube.com/watch?t=30&v=abc", "https://www.youtube.com/watch?v=abc&list=PL1&t=30&si=share"},
+		{"https://mail.example.com/?sid=42#inbox", "https://mail.example.com/#inbox", "https://mail.example.com/?sid=REDACTED#inbox"},
+		{"https://shop.example.com/list?sort=price&page=2&ref=home", "https://shop.example.com/list?page=2", "https://shop.example.com/list?sort=price&page=2&ref=home"},
+		{"https://example.com/a?session=", "https://example.com/a", "https://example.com/a?session="},
+		{"about:blank", "about:blank", "about:blank"},
+	}
+
+	for _, tt := range tests {
+		canonical, captured := canonicalizer.Canonicalize(tt.raw)
+		if canonical != tt.canonical || captured != tt.captured {
+			t.Errorf("Canonicalize(%q) = %q, %q, expected %q, %q", tt.raw, canonical, captured, tt.canonical, tt.captured)
+		}
+	}
+}
+
+func TestCanonicalizeConfiguredParams(t *testing.T) {
+	canonicalizer := NewCanonicalizer(Config{Strip: []string{"ref"}, Secrets: []string{"*_key"}})
+
+	canonical, captured := canonicalizer.Canonicalize("https://example.com/?utm_source=x&ref=a&signing_key=s")
+	if canonical != "https://example.com/?utm_source=x" {
+		t.Errorf("Expected only the configured parameters stripped, got %q", canonical)
+	}
+	if captured != "https://example.com/?utm_source=x&ref=a&signing_key=REDACTED" {
+		t.Errorf("Expected the configured secret masked, got %q", captured)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, spec := range []string{"youtube.com keep", "youtube.com drop=v", "keep=v", "https://example.com fragment"} {
+		if _, err := ParseRules(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+	if rules, err := ParseRules(" ; "); err != nil || len(rules) != 0 {
+		t.Errorf("Expected no rules from an empty spec, got %v, %v", rules, err)
+	}
+}
@@ -0,0 +1,94 @@
+// Package urlnorm reduces the many spellings of a page's URL to one, so that
+// visits to the same page can be counted together, and masks the secrets
+// URLs sometimes carry.
+package urlnorm
+
+import (
+	"net/url"
+	"strings"
+)
+
+// TrackingParams are query parameters that say how a visitor arrived rather
+// than which page they are on. A trailing * matches any suffix.
+var TrackingParams = []string{
+	"utm_*", "fbclid", "gclid", "gclsrc", "dclid", "gbraid", "wbraid", "msclkid",
+	"mc_cid", "mc_eid", "igshid", "yclid", "twclid", "ttclid", "_ga", "_gl",
+	"_hsenc", "_hsmi", "mkt_tok", "oly_anon_id", "oly_enc_id", "vero_id", "ref_src",
+}
+
+// Normalize returns the normalized form of rawURL: scheme and host lowercased,
+// default ports and tracking parameters removed, the remaining query
+// parameters sorted, an empty path written as "/" and, unless keepFragment is
+// set, the fragment dropped. URLs that do not parse are returned unchanged.
+func Normalize(rawURL string, keepFragment bool) string {
+	return normalize(rawURL, IsTrackingParam, keepFragment)
+}
+
+// normalize does what Normalize does, removing the query parameters drop reports
+func normalize(rawURL string, drop func(name string) bool, keepFragment bool) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return rawURL
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	host := strings.ToLower(parsed.Hostname())
+	if port := parsed.Port(); port != "" && !isDefaultPort(parsed.Scheme, port) {
+		host += ":" + port
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]" // IPv6 literal
+	}
+	parsed.Host = host
+
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+
+	if parsed.RawQuery != "" {
+		query := parsed.Query()
+		for name := range query {
+			if drop(name) {
+				query.Del(name)
+			}
+		}
+		// Encode sorts by key, so parameter order no longer matters
+		parsed.RawQuery = query.Encode()
+	}
+	parsed.ForceQuery = false
+
+	if !keepFragment {
+		parsed.Fragment = ""
+		parsed.RawFragment = ""
+	}
+	return parsed.String()
+}
+
+// IsTrackingParam reports whether a query parameter is listed in TrackingParams
+func IsTrackingParam(name string) bool {
+	return matchParam(TrackingParams, name)
+}
+
+// matchParam reports whether name matches one of patterns, ignoring case. A
+// leading or trailing * in a pattern matches any prefix or suffix.
+func matchParam(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(name, suffix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func isDefaultPort(scheme, port string) bool {
+	return (scheme == "http" && port == "80") || (scheme == "https" && port == "443")
+}
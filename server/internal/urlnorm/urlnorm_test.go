@@ -0,0 +1,42 @@
+package urlnorm
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		raw          string
+		keepFragment bool
+		want         string
+	}{
+		{"https://Example.COM", false, "https://example.com/"},
+		{"HTTPS://example.com:443/a", false, "https://example.com/a"},
+		{"http://example.com:8080/a", false, "http://example.com:8080/a"},
+		{"https://example.com/a?utm_source=x&b=2&a=1&fbclid=y", false, "https://example.com/a?a=1&b=2"},
+		{"https://example.com/a?UTM_Medium=x", false, "https://example.com/a"},
+		{"https://example.com/a?", false, "https://example.com/a"},
+		{"https://example.com/a#section", false, "https://example.com/a"},
+		{"https://example.com/a#section", true, "https://example.com/a#section"},
+		{"https://[::1]:443/", false, "https://[::1]/"},
+		{"not a url", false, "not a url"},
+		{"about:blank", false, "about:blank"},
+	}
+
+	for _, tt := range tests {
+		if got := Normalize(tt.raw, tt.keepFragment); got != tt.want {
+			t.Errorf("Normalize(%q, %v) = %q, expected %q", tt.raw, tt.keepFragment, got, tt.want)
+		}
+	}
+}
+
+func TestIsTrackingParam(t *testing.T) {
+	for _, name := range []string{"utm_campaign", "gclid", "_ga"} {
+		if !IsTrackingParam(name) {
+			t.Errorf("Expected %s to be a tracking parameter", name)
+		}
+	}
+	for _, name := range []string{"q", "id", "utm"} {
+		if IsTrackingParam(name) {
+			t.Errorf("Expected %s not to be a tracking parameter", name)
+		}
+	}
+}